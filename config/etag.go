@@ -0,0 +1,129 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"kickof/models"
+	"kickof/services"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ETag is the entity tag of a document at a version.
+func ETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// SetETag tags the response with the version of the document it carries.
+func SetETag(c *gin.Context, version int64) {
+	c.Header("ETag", ETag(version))
+}
+
+// matchesETag tells whether a If-Match or If-None-Match header lists the tag.
+// Weak tags only match when weak is set.
+func matchesETag(header string, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+			tag = strings.TrimPrefix(tag, "W/")
+		} else if strings.HasPrefix(candidate, "W/") || strings.HasPrefix(tag, "W/") {
+			continue
+		}
+
+		if candidate == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IfMatch answers 412 and returns false when the request has an If-Match
+// header that does not name the current version of the document.
+func IfMatch(c *gin.Context, version int64) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" || matchesETag(header, ETag(version), false) {
+		return true
+	}
+
+	c.AbortWithStatusJSON(http.StatusPreconditionFailed, models.Response{Data: services.ErrVersionConflict.Error()})
+
+	return false
+}
+
+// bufferedWriter holds the response back so its tag can be computed first.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.body.Len() > 0
+}
+
+// ConditionalGet tags successful GET responses and answers 304 when the
+// If-None-Match header has the tag already. Handlers tag documents with their
+// version, other responses get a weak tag hashed from the body.
+func ConditionalGet() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+
+		writer := &bufferedWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if writer.status == http.StatusOK {
+			tag := writer.Header().Get("ETag")
+			if tag == "" {
+				sum := sha1.Sum(writer.body.Bytes())
+				tag = `W/"` + hex.EncodeToString(sum[:]) + `"`
+				writer.Header().Set("ETag", tag)
+			}
+
+			header := c.GetHeader("If-None-Match")
+			if header != "" && matchesETag(header, tag, true) {
+				c.Writer.WriteHeader(http.StatusNotModified)
+				c.Writer.WriteHeaderNow()
+				return
+			}
+		}
+
+		c.Writer.WriteHeader(writer.status)
+		_, _ = c.Writer.Write(writer.body.Bytes())
+	}
+}
@@ -4,13 +4,16 @@ import (
 	"errors"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"kickof/models"
+	"kickof/services"
 	"net/http"
 	"os"
 	"strings"
 )
 
-func CheckToken(tokenString string) (string, error) {
+func CheckToken(tokenString string) (*models.Claims, error) {
 	claims := &models.Claims{}
 
 	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
@@ -18,10 +21,30 @@ func CheckToken(tokenString string) (string, error) {
 	})
 
 	if err != nil {
-		return "", errors.New("token invalid")
+		return nil, errors.New("token invalid")
 	}
 
-	return claims.Email, nil
+	return claims, nil
+}
+
+func authenticateSession(c *gin.Context, token string) (*models.User, error) {
+	claims, err := CheckToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if !services.IsSessionActive(claims.SessionId) {
+		return nil, errors.New("token invalid")
+	}
+
+	user := services.GetUser(bson.M{"email": claims.Email}, options.FindOne().SetProjection(bson.M{"password": 0}))
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+
+	c.Set(ContextSessionId, claims.SessionId)
+
+	return user, nil
 }
 
 func AuthMiddleware() gin.HandlerFunc {
@@ -49,11 +72,90 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		_, err := CheckToken(split[1])
+		var user *models.User
+		var accessToken *models.AccessToken
+		var err error
+
+		if strings.HasPrefix(split[1], models.AccessTokenPrefix) {
+			accessToken, user, err = services.AuthenticateAccessToken(split[1])
+		} else {
+			user, err = authenticateSession(c, split[1])
+		}
+
 		if err != nil {
 			c.Abort()
 			c.Writer.WriteHeader(http.StatusUnauthorized)
-			_, err := c.Writer.Write([]byte("token invalid"))
+			_, err := c.Writer.Write([]byte(err.Error()))
+			if err != nil {
+				return
+			}
+			return
+		}
+
+		c.Set(ContextUser, user)
+
+		route, ok := GetRoutePermission(c)
+
+		if accessToken != nil {
+			c.Set(ContextAccessToken, accessToken)
+
+			if !ok || SessionOnlyRoutes[c.Request.Method+" "+c.FullPath()] || !accessToken.HasScope(models.PermissionScopes[route.Permission]) {
+				c.Abort()
+				c.Writer.WriteHeader(http.StatusForbidden)
+				_, err := c.Writer.Write([]byte("insufficient scope"))
+				if err != nil {
+					return
+				}
+				return
+			}
+		}
+
+		if !ok || route.Resource == "" {
+			return
+		}
+
+		trash := TrashRoutes[c.Request.Method+" "+c.FullPath()]
+
+		var workspaceId string
+		if trash {
+			workspaceId = services.GetTrashResourceWorkspaceId(route.Resource, c.Param(route.Param))
+		} else {
+			workspaceId = services.GetResourceWorkspaceId(route.Resource, c.Param(route.Param))
+		}
+		if workspaceId == "" {
+			if KeyRoutes[c.Request.Method+" "+c.FullPath()] {
+				return
+			}
+
+			c.AbortWithStatusJSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+			return
+		}
+
+		if trash {
+			err = services.CheckTrashPermission(workspaceId, *user, route.Permission)
+		} else {
+			err = services.CheckPermission(workspaceId, *user, route.Permission)
+		}
+		if err != nil {
+			c.Abort()
+			c.Writer.WriteHeader(http.StatusForbidden)
+			_, err := c.Writer.Write([]byte(strings.ToLower(err.Error())))
+			if err != nil {
+				return
+			}
+			return
+		}
+	}
+}
+
+// AdminMiddleware must run after AuthMiddleware.
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		if user == nil || !services.IsAdmin(*user) {
+			c.Abort()
+			c.Writer.WriteHeader(http.StatusForbidden)
+			_, err := c.Writer.Write([]byte("forbidden"))
 			if err != nil {
 				return
 			}
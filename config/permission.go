@@ -0,0 +1,216 @@
+package config
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"kickof/models"
+	"kickof/services"
+	"net/http"
+	"slices"
+)
+
+const (
+	ContextUser        = "user"
+	ContextSessionId   = "sessionId"
+	ContextAccessToken = "accessToken"
+)
+
+type RoutePermission struct {
+	Permission models.Permission
+	Resource   string // Collection of the resource addressed by Param
+	Param      string
+}
+
+// RoutePermissions maps "METHOD /full/path" to the workspace permission the
+// caller needs. When Resource is set the middleware resolves the workspace
+// from the path; otherwise the controller checks it against the request body.
+var RoutePermissions = map[string]RoutePermission{
+	"GET /api/profile":    {models.PermissionRead, "", ""},
+	"GET /api/workspace":  {models.PermissionRead, "", ""},
+	"GET /api/project":    {models.PermissionRead, "", ""},
+	"GET /api/state":      {models.PermissionRead, "", ""},
+	"GET /api/task":       {models.PermissionRead, "", ""},
+	"GET /api/task-label": {models.PermissionRead, "", ""},
+	"GET /api/view":       {models.PermissionRead, "", ""},
+	"GET /api/search":     {models.PermissionRead, "", ""},
+
+	"GET /api/workspace/:id":                  {models.PermissionRead, services.WorkspaceCollection, "id"},
+	"GET /api/workspace/members/:workspaceId": {models.PermissionRead, services.WorkspaceCollection, "workspaceId"},
+	"PATCH /api/workspace/:id":                {models.PermissionManage, services.WorkspaceCollection, "id"},
+	"DELETE /api/workspace/:id":               {models.PermissionDelete, services.WorkspaceCollection, "id"},
+	"GET /api/workspace/:id/sso":              {models.PermissionManage, services.WorkspaceCollection, "id"},
+	"PATCH /api/workspace/:id/sso":            {models.PermissionManage, services.WorkspaceCollection, "id"},
+	"DELETE /api/workspace/:id/sso":           {models.PermissionManage, services.WorkspaceCollection, "id"},
+
+	"GET /api/workspace/:id/invitations":                       {models.PermissionManage, services.WorkspaceCollection, "id"},
+	"POST /api/workspace/:id/invitations":                      {models.PermissionManage, services.WorkspaceCollection, "id"},
+	"POST /api/workspace/:id/invitations/:invitationId/resend": {models.PermissionManage, services.WorkspaceCollection, "id"},
+	"DELETE /api/workspace/:id/invitations/:invitationId":      {models.PermissionManage, services.WorkspaceCollection, "id"},
+	"DELETE /api/workspace/:id/members/:userId":                {models.PermissionManage, services.WorkspaceCollection, "id"},
+	"POST /api/workspace/:id/leave":                            {models.PermissionRead, services.WorkspaceCollection, "id"},
+
+	"GET /api/workspace/:id/links":                             {models.PermissionManage, services.WorkspaceCollection, "id"},
+	"POST /api/workspace/:id/links":                            {models.PermissionManage, services.WorkspaceCollection, "id"},
+	"DELETE /api/workspace/:id/links/:linkId":                  {models.PermissionManage, services.WorkspaceCollection, "id"},
+	"GET /api/workspace/:id/join-requests":                     {models.PermissionManage, services.WorkspaceCollection, "id"},
+	"POST /api/workspace/:id/join-requests/:requestId/approve": {models.PermissionManage, services.WorkspaceCollection, "id"},
+	"POST /api/workspace/:id/join-requests/:requestId/reject":  {models.PermissionManage, services.WorkspaceCollection, "id"},
+
+	"GET /api/workspace/:id/api-keys":           {models.PermissionManage, services.WorkspaceCollection, "id"},
+	"POST /api/workspace/:id/api-keys":          {models.PermissionManage, services.WorkspaceCollection, "id"},
+	"DELETE /api/workspace/:id/api-keys/:keyId": {models.PermissionManage, services.WorkspaceCollection, "id"},
+
+	"POST /api/project":       {models.PermissionManage, "", ""},
+	"GET /api/project/:id":    {models.PermissionRead, services.ProjectCollection, "id"},
+	"PATCH /api/project/:id":  {models.PermissionManage, services.ProjectCollection, "id"},
+	"DELETE /api/project/:id": {models.PermissionManage, services.ProjectCollection, "id"},
+
+	"POST /api/state":       {models.PermissionWrite, "", ""},
+	"GET /api/state/:id":    {models.PermissionRead, services.StateCollection, "id"},
+	"PATCH /api/state/:id":  {models.PermissionWrite, services.StateCollection, "id"},
+	"DELETE /api/state/:id": {models.PermissionManage, services.StateCollection, "id"},
+
+	"POST /api/task":       {models.PermissionWrite, "", ""},
+	"GET /api/task/:id":    {models.PermissionRead, services.TaskCollection, "id"},
+	"PATCH /api/task/:id":  {models.PermissionWrite, services.TaskCollection, "id"},
+	"DELETE /api/task/:id": {models.PermissionWrite, services.TaskCollection, "id"},
+	"POST /api/task/move":  {models.PermissionWrite, "", ""},
+	"POST /api/task/bulk":  {models.PermissionWrite, "", ""},
+
+	"POST /api/task-label":       {models.PermissionWrite, "", ""},
+	"GET /api/task-label/:id":    {models.PermissionRead, services.TaskLabelCollection, "id"},
+	"PATCH /api/task-label/:id":  {models.PermissionWrite, services.TaskLabelCollection, "id"},
+	"DELETE /api/task-label/:id": {models.PermissionWrite, services.TaskLabelCollection, "id"},
+
+	"GET /api/workspace/:id/trash":     {models.PermissionRead, services.WorkspaceCollection, "id"},
+	"POST /api/workspace/:id/restore":  {models.PermissionDelete, services.WorkspaceCollection, "id"},
+	"POST /api/project/:id/restore":    {models.PermissionManage, services.ProjectCollection, "id"},
+	"POST /api/state/:id/restore":      {models.PermissionManage, services.StateCollection, "id"},
+	"POST /api/task/:id/restore":       {models.PermissionWrite, services.TaskCollection, "id"},
+	"POST /api/task-label/:id/restore": {models.PermissionWrite, services.TaskLabelCollection, "id"},
+
+	// Owners change their views, managers the shared ones, see
+	// controllers.UpdateView
+	"POST /api/view":       {models.PermissionRead, "", ""},
+	"GET /api/view/:id":    {models.PermissionRead, services.ViewCollection, "id"},
+	"PATCH /api/view/:id":  {models.PermissionRead, services.ViewCollection, "id"},
+	"DELETE /api/view/:id": {models.PermissionRead, services.ViewCollection, "id"},
+}
+
+// TrashRoutes work on deleted resources, or on a workspace in the trash, so
+// the middleware resolves their workspace including the trash.
+var TrashRoutes = map[string]bool{
+	"GET /api/workspace/:id/trash":     true,
+	"POST /api/workspace/:id/restore":  true,
+	"POST /api/project/:id/restore":    true,
+	"POST /api/state/:id/restore":      true,
+	"POST /api/task/:id/restore":       true,
+	"POST /api/task-label/:id/restore": true,
+}
+
+// KeyRoutes also find their resource by a key, in the workspaces of the
+// user, and authorize it themselves when the id does not resolve.
+var KeyRoutes = map[string]bool{
+	"GET /api/task/:id": true,
+}
+
+// SessionOnlyRoutes cannot be called with an access token, whatever its
+// scopes, so a leaked token cannot mint new credentials.
+var SessionOnlyRoutes = map[string]bool{
+	"GET /api/workspace/:id/sso":                true,
+	"PATCH /api/workspace/:id/sso":              true,
+	"DELETE /api/workspace/:id/sso":             true,
+	"GET /api/workspace/:id/api-keys":           true,
+	"POST /api/workspace/:id/api-keys":          true,
+	"DELETE /api/workspace/:id/api-keys/:keyId": true,
+	"GET /api/workspace/:id/links":              true,
+	"POST /api/workspace/:id/links":             true,
+	"DELETE /api/workspace/:id/links/:linkId":   true,
+}
+
+func GetRoutePermission(c *gin.Context) (RoutePermission, bool) {
+	route, ok := RoutePermissions[c.Request.Method+" "+c.FullPath()]
+	return route, ok
+}
+
+// CurrentUser returns the user loaded by AuthMiddleware.
+func CurrentUser(c *gin.Context) *models.User {
+	value, ok := c.Get(ContextUser)
+	if !ok {
+		return nil
+	}
+
+	user, _ := value.(*models.User)
+	return user
+}
+
+func CurrentUserId(c *gin.Context) string {
+	user := CurrentUser(c)
+	if user == nil {
+		return ""
+	}
+
+	return user.Id
+}
+
+func CurrentSessionId(c *gin.Context) string {
+	return c.GetString(ContextSessionId)
+}
+
+// Authorize checks the permission of the current route against a workspace
+// taken from the request body. It writes a 403 response when it fails.
+func Authorize(c *gin.Context, workspaceId string) bool {
+	route, ok := GetRoutePermission(c)
+	if !ok {
+		return true
+	}
+
+	user := CurrentUser(c)
+	if user == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, models.Response{Data: "Unauthorized"})
+		return false
+	}
+
+	err := services.CheckPermission(workspaceId, *user, route.Permission)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, models.Response{Data: err.Error()})
+		return false
+	}
+
+	return true
+}
+
+// ScopedWorkspaceIds lists the workspaces a list or search of the current
+// user covers: the requested one, or all of the user's when none is. A
+// requested workspace the user is not part of is forbidden.
+func ScopedWorkspaceIds(c *gin.Context, workspaceId string) ([]string, bool) {
+	workspaceIds := services.GetUserWorkspaceIds(*CurrentUser(c))
+
+	if workspaceId == "" {
+		return workspaceIds, true
+	}
+
+	if !slices.Contains(workspaceIds, workspaceId) {
+		c.AbortWithStatusJSON(http.StatusForbidden, models.Response{Data: "Forbidden"})
+		return nil, false
+	}
+
+	return []string{workspaceId}, true
+}
+
+// ScopeWorkspaces restricts list filters to the workspaces the current user
+// belongs to, see ScopedWorkspaceIds.
+func ScopeWorkspaces(c *gin.Context, filters bson.M, workspaceId string) bool {
+	workspaceIds, ok := ScopedWorkspaceIds(c, workspaceId)
+	if !ok {
+		return false
+	}
+
+	if workspaceId == "" {
+		filters["workspaceId"] = bson.M{"$in": workspaceIds}
+		return true
+	}
+
+	filters["workspaceId"] = workspaceId
+	return true
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"kickof/config"
+	"kickof/models"
+	"kickof/services"
+	"net/http"
+)
+
+func GetAccessTokens(c *gin.Context) {
+	filters := bson.M{"userId": config.CurrentUserId(c), "type": models.TokenTypePersonal, "revokedAt": nil}
+
+	results := services.GetAccessTokens(filters, options.Find().SetSort(bson.M{"createdAt": -1}))
+
+	c.JSON(http.StatusOK, models.Response{Data: results})
+}
+
+func CreateAccessToken(c *gin.Context) {
+	var request models.AccessTokenRequest
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	result, err := services.CreatePersonalAccessToken(*config.CurrentUser(c), request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func DeleteAccessToken(c *gin.Context) {
+	id := c.Param("id")
+
+	token := services.GetAccessToken(bson.M{"id": id, "userId": config.CurrentUserId(c), "type": models.TokenTypePersonal})
+	if token == nil {
+		c.JSON(http.StatusNotFound, models.Response{Data: "Data Not Found"})
+		return
+	}
+
+	err := services.RevokeAccessToken(*token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: "Failed Delete Data"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
+
+func GetWorkspaceApiKeys(c *gin.Context) {
+	filters := bson.M{"workspaceId": c.Param("id"), "type": models.TokenTypeService, "revokedAt": nil}
+
+	results := services.GetAccessTokens(filters, options.Find().SetSort(bson.M{"createdAt": -1}))
+
+	c.JSON(http.StatusOK, models.Response{Data: results})
+}
+
+func CreateWorkspaceApiKey(c *gin.Context) {
+	var request models.AccessTokenRequest
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	result, err := services.CreateWorkspaceApiKey(c.Param("id"), *config.CurrentUser(c), request)
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func DeleteWorkspaceApiKey(c *gin.Context) {
+	token := services.GetAccessToken(bson.M{"id": c.Param("keyId"), "workspaceId": c.Param("id"), "type": models.TokenTypeService})
+	if token == nil {
+		c.JSON(http.StatusNotFound, models.Response{Data: "Data Not Found"})
+		return
+	}
+
+	err := services.RevokeAccessToken(*token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: "Failed Delete Data"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
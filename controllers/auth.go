@@ -1,9 +1,9 @@
 package controllers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"kickof/config"
 	"kickof/models"
 	"kickof/services"
 	"net/http"
@@ -21,25 +21,23 @@ func SignUp(c *gin.Context) {
 	}
 
 	params := models.Register{
-		Name:     request.Name,
-		Email:    request.Email,
-		Password: request.Password,
+		Name:        request.Name,
+		Email:       request.Email,
+		Password:    request.Password,
+		InviteToken: request.InviteToken,
 	}
 
 	url := os.Getenv("FRONTEND_URL") + "/activate/"
-	token, err := services.Register(params, url)
+	user, err := services.Register(params, url)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
 		return
 	}
 
-	user := services.GetUser(bson.M{"email": request.Email}, nil)
-
-	result := models.AuthResult{
-		Token: *token,
-		Id:    user.Id,
-		Name:  user.Name,
-		Email: user.Email,
+	result, err := services.CreateSession(*user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
 	}
 
 	c.JSON(http.StatusOK, models.Response{Data: result})
@@ -55,54 +53,110 @@ func SignIn(c *gin.Context) {
 		return
 	}
 
-	token, err := services.SignIn(request)
+	user, err := services.SignIn(request, c.ClientIP())
+	if errors.Is(err, services.ErrLoginThrottled) {
+		c.JSON(http.StatusTooManyRequests, models.Response{Data: err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
 		return
 	}
 
-	user := services.GetUser(bson.M{"email": request.Email}, nil)
+	if user.MfaEnabled {
+		result, err := services.CreateMfaChallenge(*user)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+			return
+		}
 
-	result := models.AuthResult{
-		Token: *token,
-		Id:    user.Id,
-		Name:  user.Name,
-		Email: user.Email,
+		c.JSON(http.StatusOK, models.Response{Data: result})
+		return
+	}
+
+	result, err := services.CreateSession(*user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
 	}
 
 	c.JSON(http.StatusOK, models.Response{Data: result})
 	return
 }
 
+func SignInMfa(c *gin.Context) {
+	var request models.MfaLoginRequest
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	user, err := services.CompleteMfaChallenge(request, c.ClientIP())
+	if errors.Is(err, services.ErrLoginThrottled) {
+		c.JSON(http.StatusTooManyRequests, models.Response{Data: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	result, err := services.CreateSession(*user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
 func RefreshToken(c *gin.Context) {
-	token, err := services.CheckHeader(c.Request)
+	var request models.RefreshTokenRequest
+
+	err := c.ShouldBindJSON(&request)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
 		return
 	}
 
-	email, err := services.VerifyToken(token)
+	result, err := services.RefreshSession(request.RefreshToken, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
-		newToken, errGenerate := services.GenerateToken(email)
-		if errGenerate != nil {
-			c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
-			return
-		}
+		c.JSON(http.StatusUnauthorized, models.Response{Data: err.Error()})
+		return
+	}
 
-		token = *newToken
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func Logout(c *gin.Context) {
+	err := services.RevokeSession(config.CurrentSessionId(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
 	}
 
-	user := services.GetUser(bson.M{"email": email}, options.FindOne().SetProjection(bson.M{"password": 0}))
-	if user == nil {
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
+
+func LogoutAll(c *gin.Context) {
+	err := services.RevokeUserSessions(config.CurrentUserId(c))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, models.Response{Data: token})
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
 }
 
 func Activate(c *gin.Context) {
 	token := c.Param("token")
+	if token == "" {
+		var request models.TokenRequest
+		_ = c.ShouldBindJSON(&request)
+		token = request.Token
+	}
 
 	_, err := services.Activate(token)
 	if err != nil {
@@ -154,7 +208,7 @@ func UpdatePassword(c *gin.Context) {
 }
 
 func GetProfile(c *gin.Context) {
-	result := services.GetCurrentUser(c.Request)
+	result := config.CurrentUser(c)
 
 	if result == nil {
 		c.JSON(http.StatusNotFound, models.Response{Data: "User Not Found"})
@@ -166,7 +220,7 @@ func GetProfile(c *gin.Context) {
 }
 
 func UpdateProfile(c *gin.Context) {
-	res := services.GetCurrentUser(c.Request)
+	res := config.CurrentUser(c)
 	if res == nil {
 		c.JSON(http.StatusNotFound, models.Response{Data: "User Not Found"})
 		return
@@ -181,6 +235,7 @@ func UpdateProfile(c *gin.Context) {
 	}
 
 	request.Id = res.Id
+	request.Admin = res.Admin
 	request.LastActive = time.Now()
 
 	_, err = services.UpdateUser(res.Id, request)
@@ -192,3 +247,58 @@ func UpdateProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, models.Response{Data: "Success"})
 	return
 }
+
+func RequestEmailChange(c *gin.Context) {
+	var request models.EmailChangeRequest
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	url := os.Getenv("FRONTEND_URL") + "/confirm-email/"
+	_, err = services.RequestEmailChange(*config.CurrentUser(c), request.Email, url)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Confirmation email has been sent"})
+}
+
+func ConfirmEmailChange(c *gin.Context) {
+	var request models.TokenRequest
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	_, err = services.ConfirmEmailChange(request.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
+
+func UnlockAccount(c *gin.Context) {
+	var request models.TokenRequest
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	_, err = services.UnlockAccount(request.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Account unlocked"})
+}
@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"kickof/config"
+	"kickof/models"
+	"kickof/services"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func GetWorkspaceInvitations(c *gin.Context) {
+	filters := bson.M{"workspaceId": c.Param("id")}
+	if c.Query("status") != "" {
+		filters["status"] = c.Query("status")
+	}
+
+	results := services.GetInvitations(filters, options.Find().SetSort(bson.M{"createdAt": -1}))
+
+	c.JSON(http.StatusOK, models.Response{Data: results})
+}
+
+func CreateInvitation(c *gin.Context) {
+	var request models.InvitationRequest
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	result, err := services.CreateInvitation(c.Param("id"), *config.CurrentUser(c), request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func ResendInvitation(c *gin.Context) {
+	invitation := services.GetInvitation(bson.M{"id": c.Param("invitationId"), "workspaceId": c.Param("id")})
+	if invitation == nil {
+		c.JSON(http.StatusNotFound, models.Response{Data: "Data Not Found"})
+		return
+	}
+
+	result, err := services.ResendInvitation(*invitation, *config.CurrentUser(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func RevokeInvitation(c *gin.Context) {
+	invitation := services.GetInvitation(bson.M{"id": c.Param("invitationId"), "workspaceId": c.Param("id")})
+	if invitation == nil {
+		c.JSON(http.StatusNotFound, models.Response{Data: "Data Not Found"})
+		return
+	}
+
+	err := services.RevokeInvitation(*invitation)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
+
+func GetInvitationByToken(c *gin.Context) {
+	result, err := services.GetInvitationByToken(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func AcceptInvitationToken(c *gin.Context) {
+	var request models.TokenRequest
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	result, err := services.AcceptInvitationToken(request.Token, *config.CurrentUser(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func DeclineInvitationToken(c *gin.Context) {
+	var request models.TokenRequest
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	err = services.DeclineInvitationToken(request.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
+
+func GetMyInvitations(c *gin.Context) {
+	user := config.CurrentUser(c)
+	if !user.Active {
+		c.JSON(http.StatusOK, models.Response{Data: []models.Invitation{}})
+		return
+	}
+
+	filters := bson.M{
+		"email":     strings.ToLower(user.Email),
+		"status":    models.InvitationPending,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+
+	results := services.GetInvitations(filters, options.Find().SetSort(bson.M{"createdAt": -1}))
+	for i := range results {
+		results[i].Workspace = services.GetWorkspace(bson.M{"id": results[i].WorkspaceId}, options.FindOne().SetProjection(bson.M{"id": 1, "name": 1, "code": 1}))
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: results})
+}
+
+func AcceptInvitation(c *gin.Context) {
+	err := services.RespondToInvitation(c.Param("id"), *config.CurrentUser(c), true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
+
+func DeclineInvitation(c *gin.Context) {
+	err := services.RespondToInvitation(c.Param("id"), *config.CurrentUser(c), false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
+
+func RemoveWorkspaceMember(c *gin.Context) {
+	err := services.RemoveMember(c.Param("id"), config.CurrentUserId(c), c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
+
+func LeaveWorkspace(c *gin.Context) {
+	err := services.LeaveWorkspace(c.Param("id"), config.CurrentUserId(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
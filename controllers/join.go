@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"kickof/config"
+	"kickof/models"
+	"kickof/services"
+	"net/http"
+)
+
+func GetJoinableWorkspaces(c *gin.Context) {
+	results := services.GetJoinableWorkspaces(*config.CurrentUser(c))
+
+	c.JSON(http.StatusOK, models.Response{Data: results})
+}
+
+func JoinWorkspace(c *gin.Context) {
+	joined, err := services.JoinWorkspaceByDomain(c.Param("id"), *config.CurrentUser(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	if !joined {
+		c.JSON(http.StatusAccepted, models.Response{Data: "Join request sent"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
+
+func GetJoinRequests(c *gin.Context) {
+	filters := bson.M{"workspaceId": c.Param("id")}
+	if c.Query("status") != "" {
+		filters["status"] = c.Query("status")
+	}
+
+	results := services.GetJoinRequests(filters, options.Find().SetSort(bson.M{"createdAt": -1}))
+	for i := range results {
+		results[i].User = services.GetUser(bson.M{"id": results[i].UserId}, options.FindOne().SetProjection(bson.M{"id": 1, "name": 1, "email": 1}))
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: results})
+}
+
+func decideJoinRequest(c *gin.Context, approve bool) {
+	request := services.GetJoinRequest(bson.M{"id": c.Param("requestId"), "workspaceId": c.Param("id")})
+	if request == nil {
+		c.JSON(http.StatusNotFound, models.Response{Data: "Data Not Found"})
+		return
+	}
+
+	err := services.DecideJoinRequest(*request, config.CurrentUserId(c), approve)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
+
+func ApproveJoinRequest(c *gin.Context) {
+	decideJoinRequest(c, true)
+}
+
+func RejectJoinRequest(c *gin.Context) {
+	decideJoinRequest(c, false)
+}
+
+func GetInviteLinks(c *gin.Context) {
+	results := services.GetInviteLinks(bson.M{"workspaceId": c.Param("id")}, options.Find().SetSort(bson.M{"createdAt": -1}))
+
+	c.JSON(http.StatusOK, models.Response{Data: results})
+}
+
+func CreateInviteLink(c *gin.Context) {
+	var request models.InviteLinkRequest
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	result, err := services.CreateInviteLink(c.Param("id"), *config.CurrentUser(c), request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func RevokeInviteLink(c *gin.Context) {
+	link := services.GetInviteLink(bson.M{"id": c.Param("linkId"), "workspaceId": c.Param("id")})
+	if link == nil {
+		c.JSON(http.StatusNotFound, models.Response{Data: "Data Not Found"})
+		return
+	}
+
+	err := services.RevokeInviteLink(*link)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
+
+func GetInviteLinkByToken(c *gin.Context) {
+	result, err := services.GetInviteLinkByToken(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func JoinWithInviteLink(c *gin.Context) {
+	result, err := services.JoinWithInviteLink(c.Param("token"), *config.CurrentUser(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
@@ -0,0 +1,26 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"kickof/models"
+	"kickof/services"
+	"net/http"
+)
+
+func GetLockouts(c *gin.Context) {
+	results := services.GetLockouts()
+
+	c.JSON(http.StatusOK, models.Response{Data: results})
+}
+
+func DeleteLockout(c *gin.Context) {
+	key := c.Param("key")
+
+	err := services.ClearLockout(key)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: "Failed Delete Data"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"kickof/config"
+	"kickof/models"
+	"kickof/services"
+	"net/http"
+)
+
+func EnrollMfa(c *gin.Context) {
+	result, err := services.EnrollMfa(*config.CurrentUser(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func ConfirmMfa(c *gin.Context) {
+	var request models.MfaCodeRequest
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	result, err := services.ConfirmMfa(*config.CurrentUser(c), request.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func DisableMfa(c *gin.Context) {
+	var request models.MfaCodeRequest
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	_, err = services.DisableMfa(*config.CurrentUser(c), request.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
+
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var request models.MfaCodeRequest
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	result, err := services.RegenerateRecoveryCodes(*config.CurrentUser(c), request.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"io"
+	"kickof/models"
+	"kickof/utils"
+	"net/http"
+)
+
+const jsonPatchContentType = "application/json-patch+json"
+
+// bindPatch applies the patch in the request body to current and decodes
+// the result into patched. The body is a JSON patch when sent as such and a
+// merge patch otherwise, so fields it leaves out keep their value. It answers
+// 400 and returns false when the patch does not apply.
+func bindPatch(c *gin.Context, current interface{}, patched interface{}) bool {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return false
+	}
+
+	document, err := json.Marshal(current)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return false
+	}
+
+	if c.ContentType() == jsonPatchContentType {
+		document, err = utils.JSONPatch(document, body)
+	} else {
+		document, err = utils.MergePatch(document, body)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return false
+	}
+
+	err = json.Unmarshal(document, patched)
+	if err == nil {
+		err = binding.Validator.ValidateStruct(patched)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return false
+	}
+
+	return true
+}
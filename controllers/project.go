@@ -1,9 +1,11 @@
 package controllers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
+	"kickof/config"
 	"kickof/models"
 	"kickof/services"
 	"net/http"
@@ -19,17 +21,33 @@ func GetProjects(c *gin.Context) {
 		return
 	}
 
-	filters := query.GetQueryFind()
+	filters, err := query.GetQueryFind(models.ProjectFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	if !config.ScopeWorkspaces(c, filters, query.WorkspaceId) {
+		return
+	}
 
 	if query.UserId != "" {
-		filters["userIds"] = bson.M{
+		filters["userids"] = bson.M{
 			"$in": []string{query.UserId},
 		}
 	}
 
-	opts := query.GetOptions()
+	opts, err := query.GetOptions(models.ProjectFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
 
-	results := services.GetProjectsWithPagination(filters, opts, query)
+	results, err := services.GetProjectsWithPagination(filters, opts, query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, models.Response{Data: results})
 	return
@@ -44,7 +62,14 @@ func CreateProject(c *gin.Context) {
 		return
 	}
 
+	request.SoftDelete = models.SoftDelete{}
+
+	if !config.Authorize(c, request.WorkspaceId) {
+		return
+	}
+
 	request.Id = uuid.New().String()
+	request.Version = 1
 	request.CreatedAt = time.Now()
 	request.UpdatedAt = time.Now()
 
@@ -70,6 +95,7 @@ func GetProjectByIdOrCode(c *gin.Context) {
 		}
 	}
 
+	config.SetETag(c, result.Version)
 	c.JSON(http.StatusOK, models.Response{Data: result})
 }
 
@@ -82,27 +108,57 @@ func UpdateProject(c *gin.Context) {
 		return
 	}
 
+	if !config.IfMatch(c, data.Version) {
+		return
+	}
+
 	var request models.Project
+	if !bindPatch(c, data, &request) {
+		return
+	}
 
-	err := c.ShouldBindJSON(&request)
+	err := services.UpdateProject(*data, request)
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, models.Response{Data: err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
 		return
 	}
 
-	_, err = services.UpdateProject(id, request)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+	result := services.GetProject(bson.M{"id": id}, nil)
+	if result == nil {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
 		return
 	}
 
-	c.JSON(200, models.Response{Data: request})
+	config.SetETag(c, result.Version)
+	c.JSON(http.StatusOK, models.Response{Data: result})
 }
 
 func DeleteProject(c *gin.Context) {
 	id := c.Param("id")
 
-	_, err := services.DeleteProject(id)
+	data := services.GetProject(bson.M{"id": id}, nil)
+	if data == nil {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+
+	if !config.IfMatch(c, data.Version) {
+		return
+	}
+
+	err := services.DeleteProject(id, data.Version, config.CurrentUserId(c))
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, models.Response{Data: err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Data: "Failed Delete Data"})
 		return
@@ -0,0 +1,27 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"kickof/config"
+	"kickof/models"
+	"kickof/services"
+	"net/http"
+)
+
+func Search(c *gin.Context) {
+	var query models.SearchQuery
+
+	err := c.ShouldBindQuery(&query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	workspaceIds, ok := config.ScopedWorkspaceIds(c, query.WorkspaceId)
+	if !ok {
+		return
+	}
+	query.WorkspaceIds = workspaceIds
+
+	c.JSON(http.StatusOK, models.Response{Data: services.Search(query)})
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"kickof/config"
+	"kickof/models"
+	"kickof/services"
+	"net/http"
+)
+
+func GetSessions(c *gin.Context) {
+	results := services.GetActiveSessions(config.CurrentUserId(c))
+	for i := range results {
+		results[i].Current = results[i].Id == config.CurrentSessionId(c)
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: results})
+}
+
+func DeleteSession(c *gin.Context) {
+	id := c.Param("id")
+
+	session := services.GetSession(bson.M{"id": id, "userId": config.CurrentUserId(c)})
+	if session == nil {
+		c.JSON(http.StatusNotFound, models.Response{Data: "Data Not Found"})
+		return
+	}
+
+	err := services.RevokeSession(session.Id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: "Failed Delete Data"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
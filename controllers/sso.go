@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"kickof/config"
+	"kickof/models"
+	"kickof/services"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+func getSsoRedirectUri(c *gin.Context) string {
+	if os.Getenv("OIDC_REDIRECT_URL") != "" {
+		return os.Getenv("OIDC_REDIRECT_URL")
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	return scheme + "://" + c.Request.Host + "/api/sso/callback"
+}
+
+func SsoLogin(c *gin.Context) {
+	provider := c.Param("provider")
+
+	location, err := services.StartSsoLogin(provider, getSsoRedirectUri(c), "")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusFound, location)
+}
+
+// LinkSso starts a flow linking an identity to the signed in user, and
+// returns the provider url to send the browser to.
+func LinkSso(c *gin.Context) {
+	provider := c.Param("provider")
+
+	location, err := services.StartSsoLogin(provider, getSsoRedirectUri(c), config.CurrentUserId(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: location})
+}
+
+func SsoCallback(c *gin.Context) {
+	frontend := os.Getenv("FRONTEND_URL") + "/sso?"
+
+	if c.Query("error") != "" {
+		c.Redirect(http.StatusFound, frontend+url.Values{"error": {c.Query("error")}}.Encode())
+		return
+	}
+
+	token, err := services.CompleteSsoLogin(c.Query("state"), c.Query("code"))
+	if err != nil {
+		c.Redirect(http.StatusFound, frontend+url.Values{"error": {err.Error()}}.Encode())
+		return
+	}
+
+	c.Redirect(http.StatusFound, frontend+url.Values{"token": {*token}}.Encode())
+}
+
+func SsoExchange(c *gin.Context) {
+	var request models.TokenRequest
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	user, err := services.ExchangeSsoToken(request.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	// The provider proves the password factor, not the second one
+	if user.MfaEnabled {
+		challenge, err := services.CreateMfaChallenge(*user)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.Response{Data: challenge})
+		return
+	}
+
+	result, err := services.CreateSession(*user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func GetWorkspaceSso(c *gin.Context) {
+	id := c.Param("id")
+
+	result := services.GetSsoProvider(id)
+	if result == nil {
+		c.JSON(http.StatusNotFound, models.Response{Data: "Data Not Found"})
+		return
+	}
+
+	result.ClientSecret = ""
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func UpdateWorkspaceSso(c *gin.Context) {
+	id := c.Param("id")
+
+	var request models.SsoProvider
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	result, err := services.SaveWorkspaceSsoProvider(id, request)
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	result.ClientSecret = ""
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func DeleteWorkspaceSso(c *gin.Context) {
+	id := c.Param("id")
+
+	err := services.DeleteWorkspaceSsoProvider(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: "Failed Delete Data"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
@@ -1,9 +1,11 @@
 package controllers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
+	"kickof/config"
 	"kickof/models"
 	"kickof/services"
 	"net/http"
@@ -18,10 +20,27 @@ func GetStates(c *gin.Context) {
 		return
 	}
 
-	filters := query.GetQueryFind()
-	opts := query.GetOptions()
+	filters, err := query.GetQueryFind(models.StateFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	if !config.ScopeWorkspaces(c, filters, query.WorkspaceId) {
+		return
+	}
 
-	results := services.GetStatesWithPagination(filters, opts, query)
+	opts, err := query.GetOptions(models.StateFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	results, err := services.GetStatesWithPagination(filters, opts, query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, models.Response{Data: results})
 	return
@@ -36,7 +55,18 @@ func CreateState(c *gin.Context) {
 		return
 	}
 
+	request.SoftDelete = models.SoftDelete{}
+
+	if request.ProjectId != "" {
+		request.WorkspaceId = services.GetResourceWorkspaceId(services.ProjectCollection, request.ProjectId)
+	}
+
+	if !config.Authorize(c, request.WorkspaceId) {
+		return
+	}
+
 	request.Id = uuid.New().String()
+	request.Version = 1
 	request.CreatedAt = time.Now()
 	request.UpdatedAt = time.Now()
 
@@ -59,6 +89,7 @@ func GetStateById(c *gin.Context) {
 		return
 	}
 
+	config.SetETag(c, result.Version)
 	c.JSON(http.StatusOK, models.Response{Data: result})
 }
 
@@ -71,27 +102,68 @@ func UpdateState(c *gin.Context) {
 		return
 	}
 
+	if !config.IfMatch(c, data.Version) {
+		return
+	}
+
 	var request models.State
+	if !bindPatch(c, data, &request) {
+		return
+	}
 
-	err := c.ShouldBindJSON(&request)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+	if request.ProjectId != "" && services.GetResourceWorkspaceId(services.ProjectCollection, request.ProjectId) != data.WorkspaceId {
+		c.JSON(http.StatusBadRequest, models.Response{Data: "Project does not belong to the workspace"})
 		return
 	}
 
-	_, err = services.UpdateState(id, request)
+	err := services.UpdateState(*data, request)
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, models.Response{Data: err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
 		return
 	}
 
-	c.JSON(200, models.Response{Data: request})
+	result := services.GetState(bson.M{"id": id}, nil)
+	if result == nil {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+
+	config.SetETag(c, result.Version)
+	c.JSON(http.StatusOK, models.Response{Data: result})
 }
 
+// DeleteState moves the tasks of the state to the targetStateId query
+// parameter, which is required when the state has tasks.
 func DeleteState(c *gin.Context) {
 	id := c.Param("id")
 
-	_, err := services.DeleteState(id)
+	data := services.GetState(bson.M{"id": id}, nil)
+	if data == nil {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+
+	if !config.IfMatch(c, data.Version) {
+		return
+	}
+
+	err := services.DeleteState(id, data.Version, c.Query("targetStateId"), config.CurrentUserId(c))
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, models.Response{Data: err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrStateHasTasks) || errors.Is(err, services.ErrInvalidTarget) {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Data: "Failed Delete Data"})
 		return
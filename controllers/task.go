@@ -1,14 +1,14 @@
 package controllers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
+	"kickof/config"
 	"kickof/models"
 	"kickof/services"
-	"log"
 	"net/http"
-	"slices"
 	"time"
 )
 
@@ -21,33 +21,61 @@ func GetTasks(c *gin.Context) {
 		return
 	}
 
-	filters := query.GetQueryFind()
+	var view *models.View
+	if query.View != "" {
+		view = services.GetView(bson.M{"id": query.View}, nil)
+		if view == nil || !view.IsVisibleTo(config.CurrentUserId(c)) {
+			c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+			return
+		}
+
+		// Shared views are only shared with their own workspace
+		if !config.Authorize(c, view.WorkspaceId) {
+			return
+		}
+
+		query = view.Apply(query)
+	}
+
+	filters, err := query.GetQueryFind(models.TaskFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
 
+	if !config.ScopeWorkspaces(c, filters, query.WorkspaceId) {
+		return
+	}
+
+	// AssigneeIds has no bson tag, so the driver stores it lower cased
 	if query.UserId != "" {
-		filters["userIds"] = bson.M{
+		filters["assigneeids"] = bson.M{
 			"$in": []string{query.UserId},
 		}
 	}
 
 	if query.Assigned == "true" {
-		filters["assigneeIds"] = bson.M{
-			"$exists": true,
-			"$not": bson.M{
-				"$size": 0,
-			},
-		}
+		// Tasks without assignees store null, which $exists accepts
+		filters["assigneeids.0"] = bson.M{"$exists": true}
 	}
 
 	if query.Completed == "true" {
-		state := services.GetState(bson.M{"name": "Done"}, nil)
-		if state != nil {
-			filters["stateId"] = state.Id
-		}
+		stateIds := services.GetStateIds(bson.M{"name": "Done", "workspaceId": filters["workspaceId"]})
+		filters["stateId"] = bson.M{"$in": stateIds}
 	}
 
-	opts := query.GetOptions()
+	opts, err := query.GetOptions(models.TaskFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
 
-	results := services.GetTasksWithPagination(filters, opts, query)
+	results, err := services.GetTasksWithPagination(filters, opts, query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+	results.View = view
 
 	c.JSON(http.StatusOK, models.Response{Data: results})
 	return
@@ -62,30 +90,22 @@ func CreateTask(c *gin.Context) {
 		return
 	}
 
-	request.Id = uuid.New().String()
-	request.CreatedAt = time.Now()
-	request.UpdatedAt = time.Now()
+	request.SoftDelete = models.SoftDelete{}
 
-	for _, val := range request.Labels {
-		if val.Id == "" {
-			val.Id = uuid.New().String()
-			val.WorkspaceId = request.WorkspaceId
-			val.ProjectId = request.ProjectId
-			val.CreatedAt = time.Now()
-			val.UpdatedAt = time.Now()
-			_, err = services.CreateTaskLabel(val)
-			if err != nil {
-				log.Println("Error create task label", err.Error())
-			}
-		}
-		request.LabelIds = append(request.LabelIds, val.Id)
+	if request.ProjectId != "" {
+		request.WorkspaceId = services.GetResourceWorkspaceId(services.ProjectCollection, request.ProjectId)
 	}
 
-	for _, val := range request.Assignees {
-		request.AssigneeIds = append(request.AssigneeIds, val.Id)
+	if !config.Authorize(c, request.WorkspaceId) {
+		return
 	}
 
-	_, err = services.CreateTask(request)
+	request.Id = uuid.New().String()
+	request.Version = 1
+	request.CreatedAt = time.Now()
+	request.UpdatedAt = time.Now()
+
+	err = services.CreateTaskWithLabels(&request)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
 		return
@@ -98,15 +118,71 @@ func CreateTask(c *gin.Context) {
 func GetTaskById(c *gin.Context) {
 	id := c.Param("id")
 
+	var query models.Query
+	err := c.ShouldBindQuery(&query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
 	result := services.GetTask(bson.M{"id": id}, nil)
 	if result == nil {
-		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		result = getTaskByKey(c, id)
+		if result == nil {
+			return
+		}
+	}
+
+	// The rendered description changes with the mentioned users and linked
+	// tasks too, so it is tagged from the body rather than by version
+	if query.Render == "html" {
+		tasks := []models.Task{*result}
+		services.RenderTaskDescriptions(tasks)
+		c.JSON(http.StatusOK, models.Response{Data: tasks[0]})
 		return
 	}
 
+	config.SetETag(c, result.Version)
 	c.JSON(http.StatusOK, models.Response{Data: result})
 }
 
+// getTaskByKey finds a task by its key, e.g. WEB-12, in the workspaces of the
+// user. A key the task had before it moved redirects to its current one.
+func getTaskByKey(c *gin.Context, key string) *models.Task {
+	workspaceIds, ok := config.ScopedWorkspaceIds(c, c.Query("workspace"))
+	if !ok {
+		return nil
+	}
+
+	result, moved, err := services.GetTaskByKey(key, workspaceIds)
+	if errors.Is(err, services.ErrAmbiguousTaskKey) {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return nil
+	}
+
+	if moved {
+		location := "/api/task/" + result.Id
+		if result.Code != "" {
+			location = "/api/task/" + result.Code
+		}
+		if c.Request.URL.RawQuery != "" {
+			location += "?" + c.Request.URL.RawQuery
+		}
+		c.Redirect(http.StatusMovedPermanently, location)
+		return nil
+	}
+
+	if !config.Authorize(c, result.WorkspaceId) {
+		return nil
+	}
+
+	return result
+}
+
 func UpdateTask(c *gin.Context) {
 	id := c.Param("id")
 
@@ -116,53 +192,130 @@ func UpdateTask(c *gin.Context) {
 		return
 	}
 
+	if !config.IfMatch(c, data.Version) {
+		return
+	}
+
 	var request models.Task
-	err := c.ShouldBindJSON(&request)
+	if !bindPatch(c, data, &request) {
+		return
+	}
+
+	if request.ProjectId != "" && services.GetResourceWorkspaceId(services.ProjectCollection, request.ProjectId) != data.WorkspaceId {
+		c.JSON(http.StatusBadRequest, models.Response{Data: "Project does not belong to the workspace"})
+		return
+	}
+
+	err := services.UpdateTaskWithLabels(*data, &request)
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, models.Response{Data: err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
 		return
 	}
 
-	for _, val := range request.Labels {
-		if val.Id == "" {
-			val.Id = uuid.New().String()
-			val.WorkspaceId = request.WorkspaceId
-			val.ProjectId = request.ProjectId
-			val.CreatedAt = time.Now()
-			val.UpdatedAt = time.Now()
-			_, err = services.CreateTaskLabel(val)
-			if err != nil {
-				log.Println("Error create task label", err.Error())
-			}
-		}
+	result := services.GetTask(bson.M{"id": id}, nil)
+	if result == nil {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+
+	config.SetETag(c, result.Version)
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func DeleteTask(c *gin.Context) {
+	id := c.Param("id")
 
-		request.LabelIds = append(request.LabelIds, val.Id)
+	data := services.GetTask(bson.M{"id": id}, nil)
+	if data == nil {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
 	}
 
-	for _, val := range request.Assignees {
-		request.AssigneeIds = append(request.AssigneeIds, val.Id)
+	if !config.IfMatch(c, data.Version) {
+		return
 	}
 
-	request.UpdatedAt = time.Now()
-	slices.Sort(request.LabelIds)
-	slices.Compact(request.LabelIds)
-	slices.Sort(request.AssigneeIds)
-	slices.Compact(request.AssigneeIds)
-	_, err = services.UpdateTask(id, request)
+	err := services.DeleteTask(id, data.Version, config.CurrentUserId(c))
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, models.Response{Data: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: "Failed Delete Data"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
+
+// taskWorkError answers the error of a task unit of work.
+func taskWorkError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+
+	c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+}
+
+func MoveTasks(c *gin.Context) {
+	var request models.TaskMoveRequest
+
+	err := c.ShouldBindJSON(&request)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
 		return
 	}
 
-	c.JSON(200, models.Response{Data: request})
+	workspaceId := services.GetTasksWorkspaceId(request.TaskIds)
+	if workspaceId == "" {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+
+	if !config.Authorize(c, workspaceId) {
+		return
+	}
+
+	err = services.MoveTasks(request)
+	if err != nil {
+		taskWorkError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
 }
 
-func DeleteTask(c *gin.Context) {
-	id := c.Param("id")
+func BulkUpdateTasks(c *gin.Context) {
+	var request models.TaskBulkRequest
 
-	_, err := services.DeleteTask(id)
+	err := c.ShouldBindJSON(&request)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.Response{Data: "Failed Delete Data"})
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	workspaceId := services.GetTasksWorkspaceId(request.TaskIds)
+	if workspaceId == "" {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+
+	if !config.Authorize(c, workspaceId) {
+		return
+	}
+
+	err = services.BulkUpdateTasks(request)
+	if err != nil {
+		taskWorkError(c, err)
 		return
 	}
 
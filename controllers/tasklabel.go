@@ -1,9 +1,11 @@
 package controllers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
+	"kickof/config"
 	"kickof/models"
 	"kickof/services"
 	"net/http"
@@ -19,7 +21,15 @@ func GetTaskLabels(c *gin.Context) {
 		return
 	}
 
-	filters := query.GetQueryFind()
+	filters, err := query.GetQueryFind(models.TaskLabelFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	if !config.ScopeWorkspaces(c, filters, query.WorkspaceId) {
+		return
+	}
 
 	if query.UserId != "" {
 		filters["userIds"] = bson.M{
@@ -27,9 +37,17 @@ func GetTaskLabels(c *gin.Context) {
 		}
 	}
 
-	opts := query.GetOptions()
+	opts, err := query.GetOptions(models.TaskLabelFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
 
-	results := services.GetTaskLabelsWithPagination(filters, opts, query)
+	results, err := services.GetTaskLabelsWithPagination(filters, opts, query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, models.Response{Data: results})
 	return
@@ -44,7 +62,18 @@ func CreateTaskLabel(c *gin.Context) {
 		return
 	}
 
+	request.SoftDelete = models.SoftDelete{}
+
+	if request.ProjectId != "" {
+		request.WorkspaceId = services.GetResourceWorkspaceId(services.ProjectCollection, request.ProjectId)
+	}
+
+	if !config.Authorize(c, request.WorkspaceId) {
+		return
+	}
+
 	request.Id = uuid.New().String()
+	request.Version = 1
 	request.CreatedAt = time.Now()
 	request.UpdatedAt = time.Now()
 
@@ -67,6 +96,7 @@ func GetTaskLabelById(c *gin.Context) {
 		return
 	}
 
+	config.SetETag(c, result.Version)
 	c.JSON(http.StatusOK, models.Response{Data: result})
 }
 
@@ -79,27 +109,62 @@ func UpdateTaskLabel(c *gin.Context) {
 		return
 	}
 
+	if !config.IfMatch(c, data.Version) {
+		return
+	}
+
 	var request models.TaskLabel
+	if !bindPatch(c, data, &request) {
+		return
+	}
 
-	err := c.ShouldBindJSON(&request)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+	if request.ProjectId != "" && services.GetResourceWorkspaceId(services.ProjectCollection, request.ProjectId) != data.WorkspaceId {
+		c.JSON(http.StatusBadRequest, models.Response{Data: "Project does not belong to the workspace"})
 		return
 	}
 
-	_, err = services.UpdateTaskLabel(id, request)
+	err := services.UpdateTaskLabel(*data, request)
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, models.Response{Data: err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
 		return
 	}
 
-	c.JSON(200, models.Response{Data: request})
+	result := services.GetTaskLabel(bson.M{"id": id}, nil)
+	if result == nil {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+
+	config.SetETag(c, result.Version)
+	c.JSON(http.StatusOK, models.Response{Data: result})
 }
 
 func DeleteTaskLabel(c *gin.Context) {
 	id := c.Param("id")
 
-	_, err := services.DeleteTaskLabel(id)
+	data := services.GetTaskLabel(bson.M{"id": id}, nil)
+	if data == nil {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+
+	if !config.IfMatch(c, data.Version) {
+		return
+	}
+
+	err := services.DeleteTaskLabel(id, data.Version, config.CurrentUserId(c))
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, models.Response{Data: err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Data: "Failed Delete Data"})
 		return
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"kickof/models"
+	"kickof/services"
+	"net/http"
+)
+
+func GetTrash(c *gin.Context) {
+	result := services.GetTrash(c.Param("id"))
+
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func restore(c *gin.Context, restore func(id string) error) {
+	err := restore(c.Param("id"))
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
+
+func RestoreWorkspace(c *gin.Context) {
+	restore(c, services.RestoreWorkspace)
+}
+
+func RestoreProject(c *gin.Context) {
+	restore(c, services.RestoreProject)
+}
+
+func RestoreState(c *gin.Context) {
+	restore(c, services.RestoreState)
+}
+
+func RestoreTask(c *gin.Context) {
+	restore(c, services.RestoreTask)
+}
+
+func RestoreTaskLabel(c *gin.Context) {
+	restore(c, services.RestoreTaskLabel)
+}
@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"kickof/config"
+	"kickof/models"
+	"kickof/services"
+	"net/http"
+	"time"
+)
+
+// canChangeView tells whether the current user may change a view: its owner
+// may, and so may the managers of the workspace when it is shared.
+func canChangeView(c *gin.Context, view models.View) bool {
+	user := config.CurrentUser(c)
+	if view.UserId == user.Id {
+		return true
+	}
+
+	return view.Shared && services.CheckPermission(view.WorkspaceId, *user, models.PermissionManage) == nil
+}
+
+// canShareView tells whether the current user may share views with the
+// workspace, which takes the permission to write in it.
+func canShareView(c *gin.Context, workspaceId string) bool {
+	return services.CheckPermission(workspaceId, *config.CurrentUser(c), models.PermissionWrite) == nil
+}
+
+func GetViews(c *gin.Context) {
+	var query models.Query
+
+	err := c.ShouldBindQuery(&query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	filters, err := query.GetQueryFind(models.ViewFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	if !config.ScopeWorkspaces(c, filters, query.WorkspaceId) {
+		return
+	}
+
+	filters = services.VisibleViews(filters, config.CurrentUserId(c))
+
+	opts, err := query.GetOptions(models.ViewFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	results, err := services.GetViewsWithPagination(filters, opts, query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: results})
+}
+
+func CreateView(c *gin.Context) {
+	var request models.View
+
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	if request.ProjectId != "" {
+		request.WorkspaceId = services.GetResourceWorkspaceId(services.ProjectCollection, request.ProjectId)
+	}
+
+	if !config.Authorize(c, request.WorkspaceId) {
+		return
+	}
+
+	if request.Shared && !canShareView(c, request.WorkspaceId) {
+		c.JSON(http.StatusForbidden, models.Response{Data: services.ErrForbidden.Error()})
+		return
+	}
+
+	err = request.Validate()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	request.Id = uuid.New().String()
+	request.UserId = config.CurrentUserId(c)
+	request.Version = 1
+	request.CreatedAt = time.Now()
+	request.UpdatedAt = time.Now()
+
+	_, err = services.CreateView(request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: request})
+}
+
+func GetViewById(c *gin.Context) {
+	id := c.Param("id")
+
+	result := services.GetView(bson.M{"id": id}, nil)
+	if result == nil || !result.IsVisibleTo(config.CurrentUserId(c)) {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+
+	config.SetETag(c, result.Version)
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func UpdateView(c *gin.Context) {
+	id := c.Param("id")
+
+	data := services.GetView(bson.M{"id": id}, nil)
+	if data == nil || !data.IsVisibleTo(config.CurrentUserId(c)) {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+
+	if !canChangeView(c, *data) {
+		c.JSON(http.StatusForbidden, models.Response{Data: services.ErrForbidden.Error()})
+		return
+	}
+
+	if !config.IfMatch(c, data.Version) {
+		return
+	}
+
+	var request models.View
+	if !bindPatch(c, data, &request) {
+		return
+	}
+
+	if request.UserId != data.UserId {
+		c.JSON(http.StatusBadRequest, models.Response{Data: "The owner of a view cannot change"})
+		return
+	}
+
+	if request.ProjectId != "" && services.GetResourceWorkspaceId(services.ProjectCollection, request.ProjectId) != data.WorkspaceId {
+		c.JSON(http.StatusBadRequest, models.Response{Data: "Project does not belong to the workspace"})
+		return
+	}
+
+	if request.Shared && !data.Shared && !canShareView(c, data.WorkspaceId) {
+		c.JSON(http.StatusForbidden, models.Response{Data: services.ErrForbidden.Error()})
+		return
+	}
+
+	err := request.Validate()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	err = services.UpdateView(*data, request)
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, models.Response{Data: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+
+	result := services.GetView(bson.M{"id": id}, nil)
+	if result == nil {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+
+	config.SetETag(c, result.Version)
+	c.JSON(http.StatusOK, models.Response{Data: result})
+}
+
+func DeleteView(c *gin.Context) {
+	id := c.Param("id")
+
+	data := services.GetView(bson.M{"id": id}, nil)
+	if data == nil || !data.IsVisibleTo(config.CurrentUserId(c)) {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+
+	if !canChangeView(c, *data) {
+		c.JSON(http.StatusForbidden, models.Response{Data: services.ErrForbidden.Error()})
+		return
+	}
+
+	if !config.IfMatch(c, data.Version) {
+		return
+	}
+
+	err := services.DeleteView(id, data.Version)
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, models.Response{Data: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: "Failed Delete Data"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Data: "Success"})
+}
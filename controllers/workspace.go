@@ -1,13 +1,16 @@
 package controllers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"kickof/config"
 	"kickof/models"
 	"kickof/services"
 	"net/http"
+	"slices"
 	"time"
 )
 
@@ -19,17 +22,30 @@ func GetWorkspaces(c *gin.Context) {
 		return
 	}
 
-	filters := query.GetQueryFind()
+	filters, err := query.GetQueryFind(models.WorkspaceFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
+	filters["userids"] = config.CurrentUserId(c)
 
 	if query.UserId != "" {
-		filters["userIds"] = bson.M{
-			"$in": []string{query.UserId},
+		filters["userids"] = bson.M{
+			"$all": []string{config.CurrentUserId(c), query.UserId},
 		}
 	}
 
-	opts := query.GetOptions()
+	opts, err := query.GetOptions(models.WorkspaceFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
 
-	results := services.GetWorkspacesWithPagination(filters, opts, query)
+	results, err := services.GetWorkspacesWithPagination(filters, opts, query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, models.Response{Data: results})
 	return
@@ -44,7 +60,18 @@ func CreateWorkspace(c *gin.Context) {
 		return
 	}
 
+	request.SoftDelete = models.SoftDelete{}
+
+	userId := config.CurrentUserId(c)
+
 	request.Id = uuid.New().String()
+	if !slices.Contains(request.UserIds, userId) {
+		request.UserIds = append(request.UserIds, userId)
+	}
+	request.Roles = map[string]string{userId: models.RoleOwner}
+	request.SyncRoles()
+	request.NormalizeDomains()
+	request.Version = 1
 	request.CreatedAt = time.Now()
 	request.UpdatedAt = time.Now()
 
@@ -67,6 +94,7 @@ func GetWorkspaceById(c *gin.Context) {
 		return
 	}
 
+	config.SetETag(c, result.Version)
 	c.JSON(http.StatusOK, models.Response{Data: result})
 }
 
@@ -79,27 +107,91 @@ func UpdateWorkspace(c *gin.Context) {
 		return
 	}
 
+	if !config.IfMatch(c, data.Version) {
+		return
+	}
+
 	var request models.Workspace
+	if !bindPatch(c, data, &request) {
+		return
+	}
 
-	err := c.ShouldBindJSON(&request)
+	if request.UserIds == nil {
+		request.UserIds = data.UserIds
+	}
+	if request.Roles == nil {
+		request.Roles = map[string]string{}
+		for userId, role := range data.Roles {
+			request.Roles[userId] = role
+		}
+	}
+	request.SyncRoles()
+	if request.AllowedDomains == nil {
+		request.AllowedDomains = data.AllowedDomains
+	}
+	request.NormalizeDomains()
+
+	if request.RequireMfa != data.RequireMfa {
+		user := config.CurrentUser(c)
+		if data.GetRole(user.Id) != models.RoleOwner {
+			c.JSON(http.StatusForbidden, models.Response{Data: "Only owners can change the two-factor requirement"})
+			return
+		}
+
+		if request.RequireMfa && !user.MfaEnabled {
+			c.JSON(http.StatusBadRequest, models.Response{Data: "Enable two-factor authentication on your account first"})
+			return
+		}
+	}
+
+	err := services.ValidateRoleChange(*data, config.CurrentUserId(c), request.Roles)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
+		c.JSON(http.StatusForbidden, models.Response{Data: err.Error()})
 		return
 	}
 
-	_, err = services.UpdateWorkspace(id, request)
+	err = services.UpdateWorkspace(*data, request)
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, models.Response{Data: err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Data: err.Error()})
 		return
 	}
 
-	c.JSON(200, models.Response{Data: request})
+	result := services.GetWorkspace(bson.M{"id": id}, nil)
+	if result == nil {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+
+	config.SetETag(c, result.Version)
+	c.JSON(http.StatusOK, models.Response{Data: result})
 }
 
 func DeleteWorkspace(c *gin.Context) {
 	id := c.Param("id")
 
-	_, err := services.DeleteWorkspace(id)
+	data := services.GetWorkspace(bson.M{"id": id}, nil)
+	if data == nil {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+
+	if !config.IfMatch(c, data.Version) {
+		return
+	}
+
+	err := services.DeleteWorkspace(id, data.Version, config.CurrentUserId(c))
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.Result{Data: "Data Not Found"})
+		return
+	}
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, models.Response{Data: err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Data: "Failed Delete Data"})
 		return
@@ -1,62 +1,53 @@
 package database
 
 import (
-	"context"
-	"crypto/tls"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"log"
-	"os"
 )
 
-var db *mongo.Database
-
-func Init() bool {
-	uri := os.Getenv("MONGODB_URI")
-	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
-	opts := options.Client().ApplyURI(uri).SetServerAPIOptions(serverAPI)
-	opts.SetTLSConfig(&tls.Config{})
-	client, err := mongo.Connect(context.Background(), opts)
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-
-	err = client.Ping(context.Background(), readpref.Primary())
-	if err != nil {
-		log.Fatal("Unable to connect to DB: " + err.Error())
-		return false
-	}
+// Store is a document database. Filters, sorts, projections and update
+// documents use the MongoDB query language whatever the implementation.
+type Store interface {
+	Find(collection string, filters bson.M, opt *options.FindOptions) (*mongo.Cursor, error)
+	FindOne(collection string, filters bson.M, opt *options.FindOneOptions) *mongo.SingleResult
+	Count(collection string, filters bson.M) (int64, error)
+	InsertOne(collection string, object interface{}) (*mongo.InsertOneResult, error)
+	UpdateOne(collection string, filters bson.M, update bson.M, opt *options.UpdateOptions) (*mongo.UpdateResult, error)
+	UpdateMany(collection string, filters bson.M, update bson.M, opt *options.UpdateOptions) (*mongo.UpdateResult, error)
+	FindOneAndUpdate(collection string, filters bson.M, update bson.M, opt *options.FindOneAndUpdateOptions) *mongo.SingleResult
+	DeleteOne(collection string, filters bson.M) (*mongo.DeleteResult, error)
+	DeleteMany(collection string, filters bson.M) (*mongo.DeleteResult, error)
+
+	// Transaction runs fn with a store bound to one transaction. Inside fn,
+	// only tx sees the uncommitted writes.
+	Transaction(fn func(tx Store) error) error
+}
 
-	log.Printf("Connected to MongoDB URI", uri)
+var store Store
 
-	dbs, err := client.ListDatabaseNames(context.Background(), bson.D{})
-	if err != nil {
-		log.Println(err)
-		log.Fatal("Unable to connect to DB")
-		return false
-	}
+// Use replaces the store behind the package functions.
+func Use(s Store) {
+	store = s
+}
 
-	for i := range dbs {
-		if dbs[i] == os.Getenv("MONGODB_DATABASE") {
-			log.Println("Found " + os.Getenv("MONGODB_DATABASE") + " DB")
-		}
-	}
+// Current returns the store behind the package functions.
+func Current() Store {
+	return store
+}
 
-	db = client.Database(os.Getenv("MONGODB_DATABASE"))
+// UseMemory switches the package to a fresh in-memory store, for tests and
+// demos that run without a database server.
+func UseMemory() *MemoryStore {
+	memory := NewMemoryStore()
+	Use(memory)
 
-	return true
+	return memory
 }
 
 func Find(collection string, filters bson.M, opt *options.FindOptions) *mongo.Cursor {
-	option := options.Find()
-	if opt != nil {
-		option = opt
-	}
-
-	cur, err := db.Collection(collection).Find(context.Background(), filters, option)
+	cur, err := store.Find(collection, filters, opt)
 
 	if err != nil {
 		log.Println(err)
@@ -67,21 +58,11 @@ func Find(collection string, filters bson.M, opt *options.FindOptions) *mongo.Cu
 }
 
 func FindOne(collection string, filters bson.M, opt *options.FindOneOptions) *mongo.SingleResult {
-	if opt == nil {
-		opt = options.FindOne()
-	}
-
-	cur := db.Collection(collection).FindOne(context.Background(), filters, opt)
-
-	if cur == nil {
-		return nil
-	}
-
-	return cur
+	return store.FindOne(collection, filters, opt)
 }
 
 func Count(collection string, filters bson.M) int64 {
-	count, err := db.Collection(collection).CountDocuments(context.Background(), filters)
+	count, err := store.Count(collection, filters)
 
 	if err != nil {
 		log.Println(err)
@@ -92,33 +73,35 @@ func Count(collection string, filters bson.M) int64 {
 }
 
 func InsertOne(collection string, object interface{}) (*mongo.InsertOneResult, error) {
-	res, err := db.Collection(collection).InsertOne(context.Background(), object, options.InsertOne())
-
-	if err != nil {
-		return nil, err
-	}
-
-	return res, nil
+	return store.InsertOne(collection, object)
 }
 
 func UpdateOne(collection string, filters bson.M, object interface{}) (*mongo.UpdateResult, error) {
-	data := bson.M{"$set": object}
+	return store.UpdateOne(collection, filters, bson.M{"$set": object}, options.Update())
+}
 
-	res, err := db.Collection(collection).UpdateOne(context.Background(), filters, data, options.Update())
+func UpdateMany(collection string, filters bson.M, object interface{}) (*mongo.UpdateResult, error) {
+	return store.UpdateMany(collection, filters, bson.M{"$set": object}, options.Update())
+}
 
-	if err != nil {
-		return nil, err
+func UpsertOne(collection string, filters bson.M, object interface{}) (*mongo.UpdateResult, error) {
+	return store.UpdateOne(collection, filters, bson.M{"$set": object}, options.Update().SetUpsert(true))
+}
+
+// FindOneAndUpdate applies a raw update document ($inc, $set, ...) and
+// returns the matching document.
+func FindOneAndUpdate(collection string, filters bson.M, update bson.M, opt *options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	if opt == nil {
+		opt = options.FindOneAndUpdate()
 	}
 
-	return res, nil
+	return store.FindOneAndUpdate(collection, filters, update, opt)
 }
 
 func DeleteOne(collection string, filter bson.M) (*mongo.DeleteResult, error) {
-	res, err := db.Collection(collection).DeleteOne(context.Background(), filter, options.Delete())
-
-	if err != nil {
-		return nil, err
-	}
+	return store.DeleteOne(collection, filter)
+}
 
-	return res, nil
+func DeleteMany(collection string, filter bson.M) (*mongo.DeleteResult, error) {
+	return store.DeleteMany(collection, filter)
 }
@@ -0,0 +1,404 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ErrDuplicateKey is the error of the memory store when a write breaks one
+// of its unique keys.
+var ErrDuplicateKey = errors.New("duplicate key error")
+
+// memoryUniqueKeys mirrors the unique indexes of the other stores. Documents
+// missing any of the fields, or with it empty, are left out as the partial
+// indexes do.
+var memoryUniqueKeys = map[string][][]string{
+	"users":    {{"email"}},
+	"projects": {{"workspaceId", "code"}},
+	"tasks":    {{"workspaceId", "code"}},
+}
+
+// MemoryStore keeps every collection in memory. It understands the subset of
+// the MongoDB query language the services use, so the API can run in tests
+// and demos without a database server.
+type MemoryStore struct {
+	mu          sync.RWMutex
+	collections map[string][]bson.M
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{collections: map[string][]bson.M{}}
+}
+
+// toDocument turns a struct or map into the document the store keeps, with
+// values normalized the way the Mongo driver encodes them.
+func toDocument(object interface{}) (bson.M, error) {
+	data, err := bson.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+
+	var document bson.M
+	err = bson.Unmarshal(data, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
+
+func uniqueKey(document bson.M, fields []string) ([]interface{}, bool) {
+	key := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		value := document[field]
+		if value == nil || value == "" {
+			return nil, false
+		}
+		key = append(key, value)
+	}
+
+	return key, true
+}
+
+// checkUnique fails with ErrDuplicateKey when document shares a unique key
+// with another document of the collection than the one at position self, -1
+// for a new document.
+func (s *MemoryStore) checkUnique(collection string, document bson.M, self int) error {
+	for _, fields := range memoryUniqueKeys[collection] {
+		key, ok := uniqueKey(document, fields)
+		if !ok {
+			continue
+		}
+
+		for i, existing := range s.collections[collection] {
+			other, ok := uniqueKey(existing, fields)
+			if i != self && ok && reflect.DeepEqual(key, other) {
+				return fmt.Errorf("%w: %s %s", ErrDuplicateKey, collection, strings.Join(fields, ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+func cloneDocument(document bson.M) bson.M {
+	clone, err := toDocument(document)
+	if err != nil {
+		return bson.M{}
+	}
+
+	return clone
+}
+
+func (s *MemoryStore) find(collection string, filters bson.M, sortBy interface{}, skip int64, limit int64) ([]int, error) {
+	return selectDocuments(s.collections[collection], filters, sortBy, skip, limit)
+}
+
+func (s *MemoryStore) Find(collection string, filters bson.M, opt *options.FindOptions) (*mongo.Cursor, error) {
+	if opt == nil {
+		opt = options.Find()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var skip, limit int64
+	if opt.Skip != nil {
+		skip = *opt.Skip
+	}
+	if opt.Limit != nil {
+		limit = *opt.Limit
+	}
+
+	indexes, err := s.find(collection, filters, opt.Sort, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]interface{}, 0, len(indexes))
+	for _, i := range indexes {
+		document, err := project(s.collections[collection][i], opt.Projection)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, document)
+	}
+
+	return mongo.NewCursorFromDocuments(results, nil, nil)
+}
+
+func (s *MemoryStore) FindOne(collection string, filters bson.M, opt *options.FindOneOptions) *mongo.SingleResult {
+	if opt == nil {
+		opt = options.FindOne()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var skip int64
+	if opt.Skip != nil {
+		skip = *opt.Skip
+	}
+
+	indexes, err := s.find(collection, filters, opt.Sort, skip, 1)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+
+	if len(indexes) == 0 {
+		return mongo.NewSingleResultFromDocument(bson.M{}, mongo.ErrNoDocuments, nil)
+	}
+
+	document, err := project(s.collections[collection][indexes[0]], opt.Projection)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+
+	return mongo.NewSingleResultFromDocument(document, nil, nil)
+}
+
+func (s *MemoryStore) Count(collection string, filters bson.M) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	indexes, err := s.find(collection, filters, nil, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(indexes)), nil
+}
+
+func (s *MemoryStore) InsertOne(collection string, object interface{}) (*mongo.InsertOneResult, error) {
+	document, err := toDocument(object)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := document["_id"]; !ok {
+		document["_id"] = primitive.NewObjectID()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.collections[collection] {
+		if compareValues(existing["_id"], document["_id"]) == 0 {
+			return nil, fmt.Errorf("%w: _id", ErrDuplicateKey)
+		}
+	}
+
+	err = s.checkUnique(collection, document, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	s.collections[collection] = append(s.collections[collection], document)
+
+	return &mongo.InsertOneResult{InsertedID: document["_id"]}, nil
+}
+
+func (s *MemoryStore) update(collection string, filters bson.M, update bson.M, upsert bool, many bool) (*mongo.UpdateResult, []int, error) {
+	indexes, err := s.find(collection, filters, nil, 0, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes, err := toDocument(update)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !many && len(indexes) > 1 {
+		indexes = indexes[:1]
+	}
+
+	result := &mongo.UpdateResult{MatchedCount: int64(len(indexes))}
+
+	for _, i := range indexes {
+		document := s.collections[collection][i]
+		updated := cloneDocument(document)
+
+		err = applyUpdate(updated, changes, false)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !reflect.DeepEqual(document, updated) {
+			err = s.checkUnique(collection, updated, i)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			s.collections[collection][i] = updated
+			result.ModifiedCount++
+		}
+	}
+
+	if len(indexes) > 0 || !upsert {
+		return result, indexes, nil
+	}
+
+	filter, err := toDocument(filters)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	document := upsertDocument(filter)
+	err = applyUpdate(document, changes, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, ok := document["_id"]; !ok {
+		document["_id"] = primitive.NewObjectID()
+	}
+
+	err = s.checkUnique(collection, document, -1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.collections[collection] = append(s.collections[collection], document)
+
+	result.UpsertedCount = 1
+	result.UpsertedID = document["_id"]
+
+	return result, []int{len(s.collections[collection]) - 1}, nil
+}
+
+func (s *MemoryStore) UpdateOne(collection string, filters bson.M, update bson.M, opt *options.UpdateOptions) (*mongo.UpdateResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upsert := opt != nil && opt.Upsert != nil && *opt.Upsert
+
+	result, _, err := s.update(collection, filters, update, upsert, false)
+
+	return result, err
+}
+
+func (s *MemoryStore) UpdateMany(collection string, filters bson.M, update bson.M, opt *options.UpdateOptions) (*mongo.UpdateResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upsert := opt != nil && opt.Upsert != nil && *opt.Upsert
+
+	result, _, err := s.update(collection, filters, update, upsert, true)
+
+	return result, err
+}
+
+func (s *MemoryStore) FindOneAndUpdate(collection string, filters bson.M, update bson.M, opt *options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	if opt == nil {
+		opt = options.FindOneAndUpdate()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var before bson.M
+	if opt.ReturnDocument == nil || *opt.ReturnDocument == options.Before {
+		indexes, err := s.find(collection, filters, opt.Sort, 0, 1)
+		if err != nil {
+			return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+		}
+		if len(indexes) > 0 {
+			before = cloneDocument(s.collections[collection][indexes[0]])
+		}
+	}
+
+	upsert := opt.Upsert != nil && *opt.Upsert
+
+	_, indexes, err := s.update(collection, filters, update, upsert, false)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+
+	if len(indexes) == 0 {
+		return mongo.NewSingleResultFromDocument(bson.M{}, mongo.ErrNoDocuments, nil)
+	}
+
+	document := s.collections[collection][indexes[0]]
+	if opt.ReturnDocument == nil || *opt.ReturnDocument == options.Before {
+		// An upserted document did not exist before the update
+		if before == nil {
+			return mongo.NewSingleResultFromDocument(bson.M{}, mongo.ErrNoDocuments, nil)
+		}
+		document = before
+	}
+
+	document, err = project(document, opt.Projection)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+
+	return mongo.NewSingleResultFromDocument(document, nil, nil)
+}
+
+func (s *MemoryStore) delete(collection string, filters bson.M, many bool) (*mongo.DeleteResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	indexes, err := s.find(collection, filters, nil, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if !many && len(indexes) > 1 {
+		indexes = indexes[:1]
+	}
+
+	removed := map[int]bool{}
+	for _, i := range indexes {
+		removed[i] = true
+	}
+
+	documents := s.collections[collection]
+	kept := make([]bson.M, 0, len(documents)-len(indexes))
+	for i, document := range documents {
+		if !removed[i] {
+			kept = append(kept, document)
+		}
+	}
+	s.collections[collection] = kept
+
+	return &mongo.DeleteResult{DeletedCount: int64(len(indexes))}, nil
+}
+
+func (s *MemoryStore) DeleteOne(collection string, filters bson.M) (*mongo.DeleteResult, error) {
+	return s.delete(collection, filters, false)
+}
+
+func (s *MemoryStore) DeleteMany(collection string, filters bson.M) (*mongo.DeleteResult, error) {
+	return s.delete(collection, filters, true)
+}
+
+// Transaction undoes the writes of fn when it fails. Concurrent requests may
+// see them in the meantime, which is fine for tests and demos.
+func (s *MemoryStore) Transaction(fn func(tx Store) error) error {
+	return runJournaled(s, fn)
+}
+
+// Drop removes every document of a collection, or of every collection when
+// none is given.
+func (s *MemoryStore) Drop(collections ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(collections) == 0 {
+		s.collections = map[string][]bson.M{}
+		return
+	}
+
+	for _, collection := range collections {
+		delete(s.collections, collection)
+	}
+}
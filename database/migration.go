@@ -0,0 +1,297 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"os"
+	"slices"
+	"time"
+)
+
+const MigrationCollection = "migrations"
+
+// Migrator applies and rolls back the versioned migrations of a database.
+type Migrator interface {
+	Up() error
+	Down(steps int) error
+	Status() ([]MigrationStatus, error)
+}
+
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt"` // Nil while pending
+}
+
+// Migration changes the indexes or the documents of the Mongo database. A
+// nil Down means there is nothing to undo besides forgetting the version.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+	Down    func(ctx context.Context, db *mongo.Database) error
+}
+
+type migrationRecord struct {
+	Version   int       `bson:"version"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"appliedAt"`
+}
+
+// migrationLockTimeout releases the lock of a migrator that died midway.
+const migrationLockTimeout = 10 * time.Minute
+
+type MongoMigrator struct {
+	db         *mongo.Database
+	migrations []Migration
+}
+
+func NewMongoMigrator(db *mongo.Database) *MongoMigrator {
+	return &MongoMigrator{db: db, migrations: MongoMigrations}
+}
+
+// NewMigrator connects to the database selected by DATABASE_DRIVER without
+// applying anything.
+func NewMigrator(driver string) (Migrator, error) {
+	switch driver {
+	case "memory":
+		return nil, errors.New("The in-memory database has no migrations")
+	case "postgres", "sqlite":
+		return ConnectSQL(driver, sqlDSN(driver))
+	}
+
+	db, err := Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMongoMigrator(db), nil
+}
+
+func (m *MongoMigrator) applied(ctx context.Context) (map[int]migrationRecord, error) {
+	cur, err := m.db.Collection(MigrationCollection).Find(ctx, bson.M{"version": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []migrationRecord
+	err = cur.All(ctx, &records)
+	if err != nil {
+		return nil, err
+	}
+
+	results := map[int]migrationRecord{}
+	for _, record := range records {
+		results[record.Version] = record
+	}
+
+	return results, nil
+}
+
+// lock keeps two instances starting together from running the same
+// migration twice.
+func (m *MongoMigrator) lock(ctx context.Context) error {
+	collection := m.db.Collection(MigrationCollection)
+
+	for attempt := 0; ; attempt++ {
+		_, err := collection.DeleteOne(ctx, bson.M{"_id": "lock", "lockedAt": bson.M{"$lt": time.Now().Add(-migrationLockTimeout)}})
+		if err != nil {
+			return err
+		}
+
+		_, err = collection.InsertOne(ctx, bson.M{"_id": "lock", "lockedAt": time.Now()})
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+
+		if attempt == 0 {
+			log.Println("Waiting for another instance to finish migrating")
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (m *MongoMigrator) unlock(ctx context.Context) {
+	_, err := m.db.Collection(MigrationCollection).DeleteOne(ctx, bson.M{"_id": "lock"})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Up applies the pending migrations in order.
+func (m *MongoMigrator) Up() error {
+	ctx := context.Background()
+
+	err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.unlock(ctx)
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err = migration.Up(ctx, m.db)
+		if err != nil {
+			return errors.Join(errors.New("Migration "+migration.Name+" failed"), err)
+		}
+
+		_, err = m.db.Collection(MigrationCollection).InsertOne(ctx, migrationRecord{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Applied migration %d: %s", migration.Version, migration.Name)
+	}
+
+	return nil
+}
+
+// Down rolls back the given number of applied migrations, latest first.
+func (m *MongoMigrator) Down(steps int) error {
+	ctx := context.Background()
+
+	err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.unlock(ctx)
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	migrations := slices.Clone(m.migrations)
+	slices.Reverse(migrations)
+
+	for _, migration := range migrations {
+		if steps <= 0 {
+			break
+		}
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		if migration.Down != nil {
+			err = migration.Down(ctx, m.db)
+			if err != nil {
+				return errors.Join(errors.New("Rollback of "+migration.Name+" failed"), err)
+			}
+		}
+
+		_, err = m.db.Collection(MigrationCollection).DeleteOne(ctx, bson.M{"version": migration.Version})
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Rolled back migration %d: %s", migration.Version, migration.Name)
+		steps--
+	}
+
+	return nil
+}
+
+func (m *MongoMigrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if record, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &record.AppliedAt
+		}
+		results = append(results, status)
+	}
+
+	return results, nil
+}
+
+// index describes an index of a collection with a stable name, so a
+// migration can drop exactly what it created.
+type index struct {
+	Collection string
+	Name       string
+	Keys       bson.D
+	Unique     bool
+	Partial    bson.M // Only documents matching it are indexed
+}
+
+func createIndexes(ctx context.Context, db *mongo.Database, indexes []index) error {
+	for _, i := range indexes {
+		opts := options.Index().SetName(i.Name)
+		if i.Unique {
+			opts.SetUnique(true)
+		}
+		if i.Partial != nil {
+			opts.SetPartialFilterExpression(i.Partial)
+		}
+
+		_, err := db.Collection(i.Collection).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: i.Keys, Options: opts})
+		if err != nil {
+			return errors.Join(errors.New("Index "+i.Collection+"."+i.Name), err)
+		}
+	}
+
+	return nil
+}
+
+func dropIndexes(ctx context.Context, db *mongo.Database, indexes []index) error {
+	for _, i := range indexes {
+		_, err := db.Collection(i.Collection).Indexes().DropOne(ctx, i.Name)
+		if err != nil && !isNamespaceNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isNamespaceNotFound(err error) bool {
+	var commandError mongo.CommandError
+	if errors.As(err, &commandError) {
+		return commandError.Code == 26 || commandError.Code == 27 // NamespaceNotFound, IndexNotFound
+	}
+
+	return false
+}
+
+// IsDuplicateKey tells whether a write failed on a unique index, whatever
+// the store.
+func IsDuplicateKey(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	return mongo.IsDuplicateKeyError(err) || isSQLUniqueViolation(err) || errors.Is(err, ErrDuplicateKey)
+}
+
+func sqlDSN(driver string) string {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" && driver == "sqlite" {
+		dsn = "kickof.db"
+	}
+
+	return dsn
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MongoMigrations are applied in order and recorded in the migrations
+// collection. Never edit an applied migration, add a new one instead.
+var MongoMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create indexes",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndexes(ctx, db, initialIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, initialIndexes)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "trash indexes",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndexes(ctx, db, trashIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, trashIndexes)
+		},
+	},
+	{
+		// Task.Code held the description, the description moves to its own
+		// field and Code holds the key. Rolling back drops the keys.
+		Version: 3,
+		Name:    "task keys",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("tasks").UpdateMany(ctx,
+				bson.M{"description": bson.M{"$exists": false}},
+				bson.M{"$rename": bson.M{"code": "description"}})
+			if err != nil {
+				return err
+			}
+
+			return createIndexes(ctx, db, taskKeyIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			err := dropIndexes(ctx, db, taskKeyIndexes)
+			if err != nil {
+				return err
+			}
+
+			tasks := db.Collection("tasks")
+
+			_, err = tasks.UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"code": "", "previousKeys": ""}})
+			if err != nil {
+				return err
+			}
+
+			_, err = tasks.UpdateMany(ctx,
+				bson.M{"description": bson.M{"$exists": true}},
+				bson.M{"$rename": bson.M{"description": "code"}})
+			return err
+		},
+	},
+}
+
+// taskKeyIndexes keep task keys unique in a workspace, find tasks by the
+// keys they had before they moved, and keep one counter per key.
+var taskKeyIndexes = []index{
+	{Collection: "tasks", Name: "workspace_code_unique", Keys: bson.D{{Key: "workspaceId", Value: 1}, {Key: "code", Value: 1}}, Unique: true,
+		Partial: bson.M{"code": bson.M{"$gt": ""}}},
+	{Collection: "tasks", Name: "previousKeys", Keys: bson.D{{Key: "previousKeys", Value: 1}}},
+	{Collection: "counters", Name: "key_unique", Keys: bson.D{{Key: "key", Value: 1}}, Unique: true},
+}
+
+// trashIndexes serve the trash views and the purge, only the documents in
+// the trash are indexed.
+var trashIndexes = []index{
+	{Collection: "workspaces", Name: "trash", Keys: bson.D{{Key: "deletedAt", Value: 1}},
+		Partial: bson.M{"deletedAt": bson.M{"$type": "date"}}},
+	trashIndex("projects"),
+	trashIndex("states"),
+	trashIndex("tasklabels"),
+	trashIndex("tasks"),
+}
+
+func trashIndex(collection string) index {
+	return index{Collection: collection, Name: "trash", Keys: bson.D{{Key: "workspaceId", Value: 1}, {Key: "deletedAt", Value: 1}},
+		Partial: bson.M{"deletedAt": bson.M{"$type": "date"}}}
+}
+
+func uniqueId(collection string) index {
+	return index{Collection: collection, Name: "id_unique", Keys: bson.D{{Key: "id", Value: 1}}, Unique: true}
+}
+
+var initialIndexes = []index{
+	uniqueId("users"),
+	{Collection: "users", Name: "email_unique", Keys: bson.D{{Key: "email", Value: 1}}, Unique: true,
+		Partial: bson.M{"email": bson.M{"$gt": ""}}},
+	{Collection: "users", Name: "identities", Keys: bson.D{{Key: "identities.issuer", Value: 1}, {Key: "identities.subject", Value: 1}}},
+
+	uniqueId("workspaces"),
+	{Collection: "workspaces", Name: "code", Keys: bson.D{{Key: "code", Value: 1}}},
+	{Collection: "workspaces", Name: "userids", Keys: bson.D{{Key: "userids", Value: 1}}},
+
+	uniqueId("projects"),
+	{Collection: "projects", Name: "workspace_code_unique", Keys: bson.D{{Key: "workspaceId", Value: 1}, {Key: "code", Value: 1}}, Unique: true,
+		Partial: bson.M{"code": bson.M{"$gt": ""}}},
+	{Collection: "projects", Name: "userids", Keys: bson.D{{Key: "userids", Value: 1}}},
+
+	uniqueId("states"),
+	{Collection: "states", Name: "workspace_project", Keys: bson.D{{Key: "workspaceId", Value: 1}, {Key: "projectId", Value: 1}}},
+
+	uniqueId("tasklabels"),
+	{Collection: "tasklabels", Name: "workspace_project", Keys: bson.D{{Key: "workspaceId", Value: 1}, {Key: "projectId", Value: 1}}},
+
+	uniqueId("tasks"),
+	{Collection: "tasks", Name: "workspace_state", Keys: bson.D{{Key: "workspaceId", Value: 1}, {Key: "stateId", Value: 1}}},
+	{Collection: "tasks", Name: "project_state", Keys: bson.D{{Key: "projectId", Value: 1}, {Key: "stateId", Value: 1}}},
+	{Collection: "tasks", Name: "assigneeids", Keys: bson.D{{Key: "assigneeids", Value: 1}}},
+	{Collection: "tasks", Name: "labelIds", Keys: bson.D{{Key: "labelIds", Value: 1}}},
+
+	uniqueId("sessions"),
+	{Collection: "sessions", Name: "tokenHash", Keys: bson.D{{Key: "tokenHash", Value: 1}}},
+	{Collection: "sessions", Name: "previousHashes", Keys: bson.D{{Key: "previousHashes", Value: 1}}},
+	{Collection: "sessions", Name: "userId", Keys: bson.D{{Key: "userId", Value: 1}}},
+
+	uniqueId("accesstokens"),
+	{Collection: "accesstokens", Name: "hash", Keys: bson.D{{Key: "hash", Value: 1}}},
+	{Collection: "accesstokens", Name: "workspaceId", Keys: bson.D{{Key: "workspaceId", Value: 1}}},
+	{Collection: "accesstokens", Name: "userId", Keys: bson.D{{Key: "userId", Value: 1}}},
+
+	uniqueId("verificationtokens"),
+	{Collection: "verificationtokens", Name: "email_purpose", Keys: bson.D{{Key: "email", Value: 1}, {Key: "purpose", Value: 1}}},
+
+	uniqueId("invitations"),
+	{Collection: "invitations", Name: "workspace_status", Keys: bson.D{{Key: "workspaceId", Value: 1}, {Key: "status", Value: 1}}},
+	{Collection: "invitations", Name: "email", Keys: bson.D{{Key: "email", Value: 1}}},
+
+	uniqueId("joinrequests"),
+	{Collection: "joinrequests", Name: "workspace_status", Keys: bson.D{{Key: "workspaceId", Value: 1}, {Key: "status", Value: 1}}},
+
+	uniqueId("invitelinks"),
+	{Collection: "invitelinks", Name: "hash", Keys: bson.D{{Key: "hash", Value: 1}}},
+	{Collection: "invitelinks", Name: "workspaceId", Keys: bson.D{{Key: "workspaceId", Value: 1}}},
+
+	uniqueId("ssoproviders"),
+	{Collection: "ssoproviders", Name: "workspaceId", Keys: bson.D{{Key: "workspaceId", Value: 1}}},
+
+	{Collection: "lockouts", Name: "key_unique", Keys: bson.D{{Key: "key", Value: 1}}, Unique: true},
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"log"
+	"os"
+	"sync"
+)
+
+type MongoStore struct {
+	db            *mongo.Database
+	ctx           context.Context // Carries the session inside a transaction
+	inTransaction bool
+
+	topology   sync.Once
+	standalone bool
+}
+
+func NewMongoStore(db *mongo.Database) *MongoStore {
+	return &MongoStore{db: db, ctx: context.Background()}
+}
+
+// Connect opens the MONGODB_DATABASE database of MONGODB_URI.
+func Connect() (*mongo.Database, error) {
+	uri := os.Getenv("MONGODB_URI")
+	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
+	opts := options.Client().ApplyURI(uri).SetServerAPIOptions(serverAPI)
+	opts.SetTLSConfig(&tls.Config{})
+	client, err := mongo.Connect(context.Background(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Ping(context.Background(), readpref.Primary())
+	if err != nil {
+		return nil, errors.New("Unable to connect to DB: " + err.Error())
+	}
+
+	log.Println("Connected to MongoDB URI", uri)
+
+	dbs, err := client.ListDatabaseNames(context.Background(), bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range dbs {
+		if dbs[i] == os.Getenv("MONGODB_DATABASE") {
+			log.Println("Found " + os.Getenv("MONGODB_DATABASE") + " DB")
+		}
+	}
+
+	return client.Database(os.Getenv("MONGODB_DATABASE")), nil
+}
+
+// Init connects to MongoDB, applies the pending migrations and makes it the
+// store behind the package functions.
+func Init() bool {
+	db, err := Connect()
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	err = NewMongoMigrator(db).Up()
+	if err != nil {
+		log.Println("Unable to migrate DB: " + err.Error())
+		return false
+	}
+
+	Use(NewMongoStore(db))
+
+	return true
+}
+
+// isStandalone tells whether the server lacks transactions, which need a
+// replica set or a sharded cluster.
+func (s *MongoStore) isStandalone() bool {
+	s.topology.Do(func() {
+		var hello bson.M
+		err := s.db.RunCommand(context.Background(), bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		if err != nil {
+			log.Println(err)
+			s.standalone = true
+			return
+		}
+
+		_, replicaSet := hello["setName"]
+		s.standalone = !replicaSet && hello["msg"] != "isdbgrid"
+		if s.standalone {
+			log.Println("MongoDB is standalone, transactions are emulated")
+		}
+	})
+
+	return s.standalone
+}
+
+// Transaction runs fn in a session transaction, retried by the driver on
+// transient errors. Standalone servers fall back to undoing the writes of a
+// failed fn.
+func (s *MongoStore) Transaction(fn func(tx Store) error) error {
+	if s.inTransaction {
+		return fn(s)
+	}
+
+	if s.isStandalone() {
+		return runJournaled(s, fn)
+	}
+
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.Background())
+
+	_, err = session.WithTransaction(context.Background(), func(ctx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(&MongoStore{db: s.db, ctx: ctx, inTransaction: true})
+	})
+
+	return err
+}
+
+func (s *MongoStore) Find(collection string, filters bson.M, opt *options.FindOptions) (*mongo.Cursor, error) {
+	if opt == nil {
+		opt = options.Find()
+	}
+
+	return s.db.Collection(collection).Find(s.ctx, filters, opt)
+}
+
+func (s *MongoStore) FindOne(collection string, filters bson.M, opt *options.FindOneOptions) *mongo.SingleResult {
+	if opt == nil {
+		opt = options.FindOne()
+	}
+
+	return s.db.Collection(collection).FindOne(s.ctx, filters, opt)
+}
+
+func (s *MongoStore) Count(collection string, filters bson.M) (int64, error) {
+	return s.db.Collection(collection).CountDocuments(s.ctx, filters)
+}
+
+func (s *MongoStore) InsertOne(collection string, object interface{}) (*mongo.InsertOneResult, error) {
+	return s.db.Collection(collection).InsertOne(s.ctx, object, options.InsertOne())
+}
+
+func (s *MongoStore) UpdateOne(collection string, filters bson.M, update bson.M, opt *options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return s.db.Collection(collection).UpdateOne(s.ctx, filters, update, opt)
+}
+
+func (s *MongoStore) UpdateMany(collection string, filters bson.M, update bson.M, opt *options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return s.db.Collection(collection).UpdateMany(s.ctx, filters, update, opt)
+}
+
+func (s *MongoStore) FindOneAndUpdate(collection string, filters bson.M, update bson.M, opt *options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	return s.db.Collection(collection).FindOneAndUpdate(s.ctx, filters, update, opt)
+}
+
+func (s *MongoStore) DeleteOne(collection string, filters bson.M) (*mongo.DeleteResult, error) {
+	return s.db.Collection(collection).DeleteOne(s.ctx, filters, options.Delete())
+}
+
+func (s *MongoStore) DeleteMany(collection string, filters bson.M) (*mongo.DeleteResult, error) {
+	return s.db.Collection(collection).DeleteMany(s.ctx, filters, options.Delete())
+}
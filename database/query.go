@@ -0,0 +1,844 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// This file evaluates MongoDB filters, sorts, projections and update
+// documents against documents decoded into bson.M, for the memory store.
+
+var errUnsupported = errors.New("unsupported query operator")
+
+func isOperatorDocument(value interface{}) (bson.M, bool) {
+	document, ok := value.(bson.M)
+	if !ok || len(document) == 0 {
+		return nil, false
+	}
+
+	for key := range document {
+		if !strings.HasPrefix(key, "$") {
+			return nil, false
+		}
+	}
+
+	return document, true
+}
+
+func matchDocument(document bson.M, filter bson.M) (bool, error) {
+	for key, condition := range filter {
+		var ok bool
+		var err error
+
+		switch key {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(document, key, condition)
+		case "$comment":
+			ok = true
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("%w %s", errUnsupported, key)
+			}
+			ok, err = matchField(document, key, condition)
+		}
+
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func matchLogical(document bson.M, operator string, condition interface{}) (bool, error) {
+	filters, ok := condition.(primitive.A)
+	if !ok || len(filters) == 0 {
+		return false, fmt.Errorf("%s needs a non empty array", operator)
+	}
+
+	for _, item := range filters {
+		filter, ok := item.(bson.M)
+		if !ok {
+			return false, fmt.Errorf("%s needs an array of documents", operator)
+		}
+
+		matched, err := matchDocument(document, filter)
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case operator == "$and" && !matched:
+			return false, nil
+		case operator == "$or" && matched:
+			return true, nil
+		case operator == "$nor" && matched:
+			return false, nil
+		}
+	}
+
+	return operator != "$or", nil
+}
+
+// lookup returns the values found at a dotted path. Arrays met half way are
+// traversed, as MongoDB does, so "labels.name" matches any label's name.
+func lookup(value interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{value}
+	}
+
+	switch current := value.(type) {
+	case bson.M:
+		next, ok := current[path[0]]
+		if !ok {
+			return nil
+		}
+		return lookup(next, path[1:])
+	case primitive.A:
+		if index, err := strconv.Atoi(path[0]); err == nil {
+			if index < 0 || index >= len(current) {
+				return nil
+			}
+			return lookup(current[index], path[1:])
+		}
+
+		results := make([]interface{}, 0)
+		for _, item := range current {
+			if _, ok := item.(bson.M); ok {
+				results = append(results, lookup(item, path)...)
+			}
+		}
+		return results
+	}
+
+	return nil
+}
+
+// expand adds the elements of array values to the candidates, since a
+// condition on an array field matches when any element satisfies it.
+func expand(values []interface{}) []interface{} {
+	results := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		results = append(results, value)
+		if array, ok := value.(primitive.A); ok {
+			results = append(results, array...)
+		}
+	}
+
+	return results
+}
+
+func matchField(document bson.M, key string, condition interface{}) (bool, error) {
+	values := lookup(document, strings.Split(key, "."))
+
+	if operators, ok := isOperatorDocument(condition); ok {
+		return matchOperators(values, operators)
+	}
+
+	return matchEqual(values, condition), nil
+}
+
+func matchEqual(values []interface{}, condition interface{}) bool {
+	if condition == nil {
+		if len(values) == 0 {
+			return true
+		}
+		for _, value := range values {
+			if value == nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	if regex, ok := condition.(primitive.Regex); ok {
+		return matchRegex(values, regex.Pattern, regex.Options)
+	}
+
+	for _, value := range expand(values) {
+		if valuesEqual(value, condition) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchRegex(values []interface{}, pattern string, flags string) bool {
+	prefix := ""
+	for _, flag := range flags {
+		if strings.ContainsRune("imsx", flag) {
+			prefix += string(flag)
+		}
+	}
+	if prefix != "" {
+		pattern = "(?" + prefix + ")" + pattern
+	}
+
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return false
+	}
+
+	for _, value := range expand(values) {
+		if text, ok := value.(string); ok && regex.MatchString(text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchOperators(values []interface{}, operators bson.M) (bool, error) {
+	for operator, argument := range operators {
+		ok, err := matchOperator(values, operator, argument, operators)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func matchOperator(values []interface{}, operator string, argument interface{}, operators bson.M) (bool, error) {
+	switch operator {
+	case "$eq":
+		return matchEqual(values, argument), nil
+	case "$ne":
+		return !matchEqual(values, argument), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		for _, value := range expand(values) {
+			if typeOrder(value) != typeOrder(argument) {
+				continue
+			}
+
+			comparison := compareValues(value, argument)
+			if operator == "$gt" && comparison > 0 ||
+				operator == "$gte" && comparison >= 0 ||
+				operator == "$lt" && comparison < 0 ||
+				operator == "$lte" && comparison <= 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$in", "$nin":
+		items, ok := argument.(primitive.A)
+		if !ok {
+			return false, fmt.Errorf("%s needs an array", operator)
+		}
+
+		found := false
+		for _, item := range items {
+			if matchEqual(values, item) {
+				found = true
+				break
+			}
+		}
+		return found == (operator == "$in"), nil
+	case "$all":
+		items, ok := argument.(primitive.A)
+		if !ok {
+			return false, errors.New("$all needs an array")
+		}
+
+		for _, item := range items {
+			if !matchEqual(values, item) {
+				return false, nil
+			}
+		}
+		return len(items) > 0, nil
+	case "$exists":
+		return (len(values) > 0) == truthy(argument), nil
+	case "$size":
+		size, ok := toFloat(argument)
+		if !ok {
+			return false, errors.New("$size needs a number")
+		}
+
+		for _, value := range values {
+			if array, ok := value.(primitive.A); ok && float64(len(array)) == size {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$regex":
+		flags, _ := operators["$options"].(string)
+		switch pattern := argument.(type) {
+		case string:
+			return matchRegex(values, pattern, flags), nil
+		case primitive.Regex:
+			if flags == "" {
+				flags = pattern.Options
+			}
+			return matchRegex(values, pattern.Pattern, flags), nil
+		}
+		return false, errors.New("$regex needs a string")
+	case "$options":
+		return true, nil
+	case "$not":
+		var ok bool
+		var err error
+		if regex, isRegex := argument.(primitive.Regex); isRegex {
+			ok = matchRegex(values, regex.Pattern, regex.Options)
+		} else if sub, isOperators := isOperatorDocument(argument); isOperators {
+			ok, err = matchOperators(values, sub)
+		} else {
+			return false, errors.New("$not needs an operator document or a regex")
+		}
+		return !ok, err
+	case "$elemMatch":
+		condition, ok := argument.(bson.M)
+		if !ok {
+			return false, errors.New("$elemMatch needs a document")
+		}
+
+		for _, value := range values {
+			array, ok := value.(primitive.A)
+			if !ok {
+				continue
+			}
+
+			for _, item := range array {
+				var matched bool
+				var err error
+				if sub, isOperators := isOperatorDocument(condition); isOperators {
+					matched, err = matchOperators([]interface{}{item}, sub)
+				} else if document, isDocument := item.(bson.M); isDocument {
+					matched, err = matchDocument(document, condition)
+				}
+				if err != nil {
+					return false, err
+				}
+				if matched {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+
+	return false, fmt.Errorf("%w %s", errUnsupported, operator)
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+
+	if number, ok := toFloat(value); ok {
+		return number != 0
+	}
+
+	return true
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case primitive.Decimal128:
+		f, err := strconv.ParseFloat(v.String(), 64)
+		return f, err == nil
+	}
+
+	return 0, false
+}
+
+// typeOrder ranks BSON types the way MongoDB sorts them.
+func typeOrder(value interface{}) int {
+	switch value.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return 1
+	case int, int32, int64, float64, primitive.Decimal128:
+		return 2
+	case string, primitive.Symbol:
+		return 3
+	case bson.M, bson.D:
+		return 4
+	case primitive.A:
+		return 5
+	case primitive.Binary:
+		return 6
+	case primitive.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case primitive.DateTime:
+		return 9
+	case primitive.Timestamp:
+		return 10
+	case primitive.Regex:
+		return 11
+	}
+
+	return 12
+}
+
+func compareValues(a interface{}, b interface{}) int {
+	orderA, orderB := typeOrder(a), typeOrder(b)
+	if orderA != orderB {
+		return orderA - orderB
+	}
+
+	switch orderA {
+	case 1:
+		return 0
+	case 2:
+		x, _ := toFloat(a)
+		y, _ := toFloat(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case 3:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	case 5:
+		x, y := a.(primitive.A), b.(primitive.A)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if comparison := compareValues(x[i], y[i]); comparison != 0 {
+				return comparison
+			}
+		}
+		return len(x) - len(y)
+	case 7:
+		x, y := a.(primitive.ObjectID), b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case 8:
+		x, y := a.(bool), b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case 9:
+		x, y := a.(primitive.DateTime), b.(primitive.DateTime)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return 0
+	}
+
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func valuesEqual(a interface{}, b interface{}) bool {
+	if typeOrder(a) != typeOrder(b) {
+		return false
+	}
+
+	if typeOrder(a) == 4 {
+		return reflect.DeepEqual(a, b)
+	}
+
+	return compareValues(a, b) == 0
+}
+
+type sortKey struct {
+	path      []string
+	direction int
+}
+
+func sortKeys(spec interface{}) ([]sortKey, error) {
+	keys := make([]sortKey, 0)
+
+	add := func(key string, value interface{}) error {
+		direction, ok := toFloat(value)
+		if !ok || direction == 0 {
+			return fmt.Errorf("invalid sort direction for %s", key)
+		}
+		keys = append(keys, sortKey{strings.Split(key, "."), int(math.Copysign(1, direction))})
+		return nil
+	}
+
+	switch s := spec.(type) {
+	case bson.D:
+		for _, e := range s {
+			if err := add(e.Key, e.Value); err != nil {
+				return nil, err
+			}
+		}
+	case bson.M, map[string]int:
+		// Maps have no order: only single key sorts are deterministic
+		value := reflect.ValueOf(s)
+		names := make([]string, 0, value.Len())
+		for _, key := range value.MapKeys() {
+			names = append(names, key.String())
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := add(name, value.MapIndex(reflect.ValueOf(name)).Interface()); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported sort %T", spec)
+	}
+
+	return keys, nil
+}
+
+func sortValue(document bson.M, path []string, direction int) interface{} {
+	values := lookup(document, path)
+	if len(values) == 0 {
+		return nil
+	}
+
+	// Arrays sort by their smallest element ascending, largest descending
+	candidates := values
+	if array, ok := values[0].(primitive.A); ok && len(values) == 1 && len(array) > 0 {
+		candidates = array
+	}
+
+	result := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if compareValues(candidate, result)*direction < 0 {
+			result = candidate
+		}
+	}
+
+	return result
+}
+
+func sortDocuments(documents []bson.M, indexes []int, keys []sortKey) {
+	sort.SliceStable(indexes, func(i, j int) bool {
+		for _, key := range keys {
+			a := sortValue(documents[indexes[i]], key.path, key.direction)
+			b := sortValue(documents[indexes[j]], key.path, key.direction)
+			if comparison := compareValues(a, b); comparison != 0 {
+				return comparison*key.direction < 0
+			}
+		}
+		return false
+	})
+}
+
+func project(document bson.M, projection interface{}) (bson.M, error) {
+	if projection == nil {
+		return cloneDocument(document), nil
+	}
+
+	spec, err := toDocument(projection)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(spec) == 0 {
+		return cloneDocument(document), nil
+	}
+
+	include := false
+	for key, value := range spec {
+		if key != "_id" && truthy(value) {
+			include = true
+		}
+	}
+
+	if !include {
+		result := cloneDocument(document)
+		for key := range spec {
+			unsetPath(result, strings.Split(key, "."))
+		}
+		return result, nil
+	}
+
+	result := bson.M{}
+	if value, ok := spec["_id"]; !ok || truthy(value) {
+		if id, ok := document["_id"]; ok {
+			result["_id"] = id
+		}
+	}
+
+	for key, value := range spec {
+		if key == "_id" || !truthy(value) {
+			continue
+		}
+
+		values := lookup(document, strings.Split(key, "."))
+		if len(values) == 1 {
+			err = setPath(result, strings.Split(key, "."), values[0])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return cloneDocument(result), nil
+}
+
+func setPath(document bson.M, path []string, value interface{}) error {
+	for _, key := range path[:len(path)-1] {
+		next, ok := document[key]
+		if !ok || next == nil {
+			child := bson.M{}
+			document[key] = child
+			document = child
+			continue
+		}
+
+		child, ok := next.(bson.M)
+		if !ok {
+			return fmt.Errorf("cannot create field %s in a non document value", key)
+		}
+		document = child
+	}
+
+	document[path[len(path)-1]] = value
+
+	return nil
+}
+
+func unsetPath(document bson.M, path []string) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := document[key].(bson.M)
+		if !ok {
+			return
+		}
+		document = child
+	}
+
+	delete(document, path[len(path)-1])
+}
+
+func getPath(document bson.M, path []string) (interface{}, bool) {
+	var current interface{} = document
+	for _, key := range path {
+		child, ok := current.(bson.M)
+		if !ok {
+			return nil, false
+		}
+
+		current, ok = child[key]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// upsertDocument seeds a document inserted by an upsert with the equality
+// conditions of its filter.
+func upsertDocument(filter bson.M) bson.M {
+	document := bson.M{}
+
+	for key, condition := range filter {
+		if strings.HasPrefix(key, "$") {
+			continue
+		}
+
+		if operators, ok := isOperatorDocument(condition); ok {
+			value, hasEq := operators["$eq"]
+			if !hasEq {
+				continue
+			}
+			condition = value
+		}
+
+		_ = setPath(document, strings.Split(key, "."), condition)
+	}
+
+	return document
+}
+
+func applyUpdate(document bson.M, update bson.M, inserting bool) error {
+	for operator, argument := range update {
+		fields, ok := argument.(bson.M)
+		if !ok {
+			return fmt.Errorf("%s needs a document", operator)
+		}
+
+		for key, value := range fields {
+			path := strings.Split(key, ".")
+
+			var err error
+			switch operator {
+			case "$set":
+				err = setPath(document, path, value)
+			case "$setOnInsert":
+				if inserting {
+					err = setPath(document, path, value)
+				}
+			case "$unset":
+				unsetPath(document, path)
+			case "$inc":
+				current, _ := getPath(document, path)
+				err = incrementPath(document, path, current, value)
+			case "$push", "$addToSet", "$pull":
+				err = updateArray(document, path, operator, value)
+			default:
+				return fmt.Errorf("%w %s", errUnsupported, operator)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func incrementPath(document bson.M, path []string, current interface{}, value interface{}) error {
+	increment, ok := toFloat(value)
+	if !ok {
+		return errors.New("$inc needs a number")
+	}
+
+	if current == nil {
+		return setPath(document, path, value)
+	}
+
+	switch v := current.(type) {
+	case int32:
+		if _, isInt := value.(int32); isInt {
+			return setPath(document, path, v+value.(int32))
+		}
+		if _, isFloat := value.(float64); !isFloat {
+			return setPath(document, path, int64(v)+int64(increment))
+		}
+	case int64:
+		if _, isFloat := value.(float64); !isFloat {
+			return setPath(document, path, v+int64(increment))
+		}
+	}
+
+	number, ok := toFloat(current)
+	if !ok {
+		return errors.New("$inc needs a numeric field")
+	}
+
+	return setPath(document, path, number+increment)
+}
+
+func updateArray(document bson.M, path []string, operator string, value interface{}) error {
+	current, exists := getPath(document, path)
+
+	array, ok := current.(primitive.A)
+	if exists && current != nil && !ok {
+		return fmt.Errorf("%s needs an array field", operator)
+	}
+
+	if operator == "$pull" {
+		kept := primitive.A{}
+		for _, item := range array {
+			var matched bool
+			var err error
+			if operators, isOperators := isOperatorDocument(value); isOperators {
+				matched, err = matchOperators([]interface{}{item}, operators)
+			} else if condition, isDocument := value.(bson.M); isDocument {
+				if element, isElement := item.(bson.M); isElement {
+					matched, err = matchDocument(element, condition)
+				}
+			} else {
+				matched = valuesEqual(item, value)
+			}
+			if err != nil {
+				return err
+			}
+			if !matched {
+				kept = append(kept, item)
+			}
+		}
+		return setPath(document, path, kept)
+	}
+
+	items := primitive.A{value}
+	if modifiers, ok := value.(bson.M); ok {
+		if each, ok := modifiers["$each"].(primitive.A); ok {
+			items = each
+		}
+	}
+
+	result := append(primitive.A{}, array...)
+	for _, item := range items {
+		if operator == "$addToSet" {
+			duplicate := false
+			for _, existing := range result {
+				if valuesEqual(existing, item) {
+					duplicate = true
+					break
+				}
+			}
+			if duplicate {
+				continue
+			}
+		}
+		result = append(result, item)
+	}
+
+	return setPath(document, path, result)
+}
+
+// selectDocuments returns the positions of the documents matching filters,
+// sorted, skipped and limited the way a MongoDB find would.
+func selectDocuments(documents []bson.M, filters bson.M, sortBy interface{}, skip int64, limit int64) ([]int, error) {
+	filter, err := toDocument(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make([]int, 0)
+	for i, document := range documents {
+		ok, err := matchDocument(document, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			indexes = append(indexes, i)
+		}
+	}
+
+	if sortBy != nil {
+		keys, err := sortKeys(sortBy)
+		if err != nil {
+			return nil, err
+		}
+		sortDocuments(documents, indexes, keys)
+	}
+
+	if skip > 0 {
+		if skip >= int64(len(indexes)) {
+			return []int{}, nil
+		}
+		indexes = indexes[skip:]
+	}
+
+	if limit < 0 {
+		limit = -limit
+	}
+	if limit > 0 && limit < int64(len(indexes)) {
+		indexes = indexes[:limit]
+	}
+
+	return indexes, nil
+}
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Repository reads and writes one aggregate. FindOne returns
+// mongo.ErrNoDocuments when nothing matches; updates use $set semantics.
+type Repository[T any] interface {
+	Find(filters bson.M, opt *options.FindOptions) ([]T, error)
+	FindDocuments(filters bson.M, opt *options.FindOptions) ([]bson.M, error)
+	FindOne(filters bson.M, opt *options.FindOneOptions) (*T, error)
+	Count(filters bson.M) (int64, error)
+	InsertOne(document T) error
+	UpdateOne(filters bson.M, object interface{}) (*mongo.UpdateResult, error)
+	UpdateMany(filters bson.M, object interface{}) (*mongo.UpdateResult, error)
+	DeleteOne(filters bson.M) (*mongo.DeleteResult, error)
+	DeleteMany(filters bson.M) (*mongo.DeleteResult, error)
+}
+
+// StoreRepository keeps an aggregate in a collection of a Store. A nil store
+// follows whichever store the package functions currently use.
+type StoreRepository[T any] struct {
+	store      Store
+	collection string
+	versioned  bool
+}
+
+// VersionField counts the writes to a document of a versioned repository.
+const VersionField = "version"
+
+func NewRepository[T any](store Store, collection string) *StoreRepository[T] {
+	return &StoreRepository[T]{store: store, collection: collection}
+}
+
+// NewVersionedRepository keeps a version on every document: inserts start it
+// at 1 and every update bumps it, whatever the update sets it to.
+func NewVersionedRepository[T any](store Store, collection string) *StoreRepository[T] {
+	return &StoreRepository[T]{store: store, collection: collection, versioned: true}
+}
+
+// VersionFilter matches a document at the given version. Version 0 is the
+// one of documents written before versions existed, which have none.
+func VersionFilter(id string, version int64) bson.M {
+	if version == 0 {
+		return bson.M{"id": id, "$or": bson.A{
+			bson.M{VersionField: 0},
+			bson.M{VersionField: bson.M{"$exists": false}},
+		}}
+	}
+
+	return bson.M{"id": id, VersionField: version}
+}
+
+// update turns object into a $set, bumping the version when the repository
+// keeps one.
+func (r *StoreRepository[T]) update(object interface{}) (bson.M, error) {
+	if !r.versioned {
+		return bson.M{"$set": object}, nil
+	}
+
+	changes, err := toDocument(object)
+	if err != nil {
+		return nil, err
+	}
+	delete(changes, VersionField)
+
+	update := bson.M{"$inc": bson.M{VersionField: 1}}
+	if len(changes) > 0 {
+		update["$set"] = changes
+	}
+
+	return update, nil
+}
+
+func (r *StoreRepository[T]) getStore() Store {
+	if r.store == nil {
+		return store
+	}
+
+	return r.store
+}
+
+func (r *StoreRepository[T]) Find(filters bson.M, opt *options.FindOptions) ([]T, error) {
+	results := make([]T, 0)
+
+	cursor, err := r.getStore().Find(r.collection, filters, opt)
+	if err != nil {
+		return results, err
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.Background()) {
+		// Documents that no longer fit the model are skipped, not fatal
+		var data T
+		if cursor.Decode(&data) == nil {
+			results = append(results, data)
+		}
+	}
+
+	return results, cursor.Err()
+}
+
+// FindDocuments is Find without decoding into the model, so fields it would
+// turn into their zero value stay missing.
+func (r *StoreRepository[T]) FindDocuments(filters bson.M, opt *options.FindOptions) ([]bson.M, error) {
+	results := make([]bson.M, 0)
+
+	cursor, err := r.getStore().Find(r.collection, filters, opt)
+	if err != nil {
+		return results, err
+	}
+	defer cursor.Close(context.Background())
+
+	err = cursor.All(context.Background(), &results)
+
+	return results, err
+}
+
+func (r *StoreRepository[T]) FindOne(filters bson.M, opt *options.FindOneOptions) (*T, error) {
+	var data T
+
+	err := r.getStore().FindOne(r.collection, filters, opt).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func (r *StoreRepository[T]) Count(filters bson.M) (int64, error) {
+	return r.getStore().Count(r.collection, filters)
+}
+
+func (r *StoreRepository[T]) InsertOne(document T) error {
+	if !r.versioned {
+		_, err := r.getStore().InsertOne(r.collection, document)
+		return err
+	}
+
+	versioned, err := toDocument(document)
+	if err != nil {
+		return err
+	}
+	versioned[VersionField] = int64(1)
+
+	_, err = r.getStore().InsertOne(r.collection, versioned)
+	return err
+}
+
+func (r *StoreRepository[T]) UpdateOne(filters bson.M, object interface{}) (*mongo.UpdateResult, error) {
+	update, err := r.update(object)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.getStore().UpdateOne(r.collection, filters, update, options.Update())
+}
+
+func (r *StoreRepository[T]) UpdateMany(filters bson.M, object interface{}) (*mongo.UpdateResult, error) {
+	update, err := r.update(object)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.getStore().UpdateMany(r.collection, filters, update, options.Update())
+}
+
+func (r *StoreRepository[T]) DeleteOne(filters bson.M) (*mongo.DeleteResult, error) {
+	return r.getStore().DeleteOne(r.collection, filters)
+}
+
+func (r *StoreRepository[T]) DeleteMany(filters bson.M) (*mongo.DeleteResult, error) {
+	return r.getStore().DeleteMany(r.collection, filters)
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SoftDeleteRepository hides the documents in the trash, those with a
+// deletedAt, from every read and write. Unscoped reaches the trash too.
+type SoftDeleteRepository[T any] interface {
+	Repository[T]
+	Unscoped() Repository[T]
+}
+
+type SoftDeleteStoreRepository[T any] struct {
+	*StoreRepository[T]
+}
+
+func NewSoftDeleteRepository[T any](store Store, collection string) *SoftDeleteStoreRepository[T] {
+	return &SoftDeleteStoreRepository[T]{StoreRepository: NewRepository[T](store, collection)}
+}
+
+// NewVersionedSoftDeleteRepository keeps a version on the documents, see
+// NewVersionedRepository, trash included.
+func NewVersionedSoftDeleteRepository[T any](store Store, collection string) *SoftDeleteStoreRepository[T] {
+	return &SoftDeleteStoreRepository[T]{StoreRepository: NewVersionedRepository[T](store, collection)}
+}
+
+// live restricts filters to the documents outside the trash. A null
+// deletedAt matches restored documents as well as those never deleted.
+func live(filters bson.M) bson.M {
+	if _, ok := filters["deletedAt"]; ok {
+		return bson.M{"$and": bson.A{filters, bson.M{"deletedAt": nil}}}
+	}
+
+	result := bson.M{"deletedAt": nil}
+	for key, value := range filters {
+		result[key] = value
+	}
+
+	return result
+}
+
+func (r *SoftDeleteStoreRepository[T]) Unscoped() Repository[T] {
+	return r.StoreRepository
+}
+
+func (r *SoftDeleteStoreRepository[T]) Find(filters bson.M, opt *options.FindOptions) ([]T, error) {
+	return r.StoreRepository.Find(live(filters), opt)
+}
+
+func (r *SoftDeleteStoreRepository[T]) FindDocuments(filters bson.M, opt *options.FindOptions) ([]bson.M, error) {
+	return r.StoreRepository.FindDocuments(live(filters), opt)
+}
+
+func (r *SoftDeleteStoreRepository[T]) FindOne(filters bson.M, opt *options.FindOneOptions) (*T, error) {
+	return r.StoreRepository.FindOne(live(filters), opt)
+}
+
+func (r *SoftDeleteStoreRepository[T]) Count(filters bson.M) (int64, error) {
+	return r.StoreRepository.Count(live(filters))
+}
+
+func (r *SoftDeleteStoreRepository[T]) UpdateOne(filters bson.M, object interface{}) (*mongo.UpdateResult, error) {
+	return r.StoreRepository.UpdateOne(live(filters), object)
+}
+
+func (r *SoftDeleteStoreRepository[T]) UpdateMany(filters bson.M, object interface{}) (*mongo.UpdateResult, error) {
+	return r.StoreRepository.UpdateMany(live(filters), object)
+}
+
+func (r *SoftDeleteStoreRepository[T]) DeleteOne(filters bson.M) (*mongo.DeleteResult, error) {
+	return r.StoreRepository.DeleteOne(live(filters))
+}
+
+func (r *SoftDeleteStoreRepository[T]) DeleteMany(filters bson.M) (*mongo.DeleteResult, error) {
+	return r.StoreRepository.DeleteMany(live(filters))
+}
@@ -0,0 +1,1708 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	_ "github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	_ "modernc.org/sqlite"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// SQLStore keeps the workspaces, projects, states, task labels, tasks and
+// users in relational tables with foreign keys between them, and every other
+// collection as documents in a generic table. Queries still use the MongoDB
+// language: simple conditions are pushed down to SQL, the rest is evaluated on
+// the loaded rows with the same matcher as the in-memory store. When every
+// condition is pushed down, so are the sort, skip, limit and count.
+type SQLStore struct {
+	db      *sql.DB
+	dialect *sqlDialect
+	tx      *sql.Tx // Set on the store handed to a Transaction
+}
+
+type sqlDialect struct {
+	name       string
+	replacer   *strings.Replacer // Fills the type placeholders of the migrations
+	order      string            // Insertion order of the rows
+	lock       string            // Locks the rows read by a write
+	binary     string            // Collation comparing text byte by byte, as Go does
+	hasExtra   string            // Tells whether extra has the field %s
+	positional bool              // $1, $2... instead of ?
+}
+
+var sqlDialects = map[string]*sqlDialect{
+	"postgres": {
+		name: "postgres",
+		replacer: strings.NewReplacer(
+			"{{seq}}", "seq BIGSERIAL,",
+			"{{bool}}", "BOOLEAN",
+			"{{false}}", "FALSE",
+			"{{time}}", "TIMESTAMPTZ",
+		),
+		order:      "seq",
+		lock:       " FOR UPDATE",
+		binary:     ` COLLATE "C"`,
+		hasExtra:   "jsonb_exists(extra::jsonb, '%s')",
+		positional: true,
+	},
+	"sqlite": {
+		name: "sqlite",
+		replacer: strings.NewReplacer(
+			"{{seq}}", "",
+			"{{bool}}", "BOOLEAN",
+			"{{false}}", "0",
+			"{{time}}", "TIMESTAMP",
+		),
+		order:    "rowid",
+		hasExtra: "json_type(extra, '$.%s') IS NOT NULL",
+	},
+}
+
+// rebind turns the ? placeholders into the ones of the dialect.
+func (d *sqlDialect) rebind(query string) string {
+	if !d.positional {
+		return query
+	}
+
+	var builder strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			builder.WriteString(fmt.Sprintf("$%d", n))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+
+	return builder.String()
+}
+
+type sqlColumnKind int
+
+const (
+	sqlText sqlColumnKind = iota
+	sqlBool
+	sqlTime
+	sqlRef // Foreign key, empty strings are stored as NULL
+)
+
+type sqlColumn struct {
+	Field  string // Document field
+	Column string
+	Kind   sqlColumnKind
+}
+
+// sqlJoin stores a list of ids in a join table.
+type sqlJoin struct {
+	Field  string // Document field
+	Table  string
+	Owner  string // Column referencing the owning row
+	Column string // Column holding the ids
+}
+
+type sqlTable struct {
+	Name    string
+	Columns []sqlColumn // The first column is the primary key
+	Joins   []sqlJoin
+}
+
+func (t *sqlTable) column(field string) *sqlColumn {
+	for i := range t.Columns {
+		if t.Columns[i].Field == field {
+			return &t.Columns[i]
+		}
+	}
+
+	return nil
+}
+
+func (t *sqlTable) join(field string) *sqlJoin {
+	for i := range t.Joins {
+		if t.Joins[i].Field == field {
+			return &t.Joins[i]
+		}
+	}
+
+	return nil
+}
+
+var basicDateColumns = []sqlColumn{
+	{Field: "createdAt", Column: "created_at", Kind: sqlTime},
+	{Field: "updatedAt", Column: "updated_at", Kind: sqlTime},
+}
+
+// deletedAtColumn keeps the trash of the soft deleted tables, see migration 3.
+var deletedAtColumn = sqlColumn{Field: "deletedAt", Column: "deleted_at", Kind: sqlTime}
+
+func columns(list ...sqlColumn) []sqlColumn {
+	return append(list, basicDateColumns...)
+}
+
+// sqlTables maps collections to their tables. Collections not listed here go
+// to the documents table.
+var sqlTables = map[string]*sqlTable{
+	"users": {
+		Name: "users",
+		Columns: columns(
+			sqlColumn{Field: "id", Column: "id"},
+			sqlColumn{Field: "email", Column: "email"},
+			sqlColumn{Field: "name", Column: "name"},
+			sqlColumn{Field: "password", Column: "password"},
+			sqlColumn{Field: "active", Column: "active", Kind: sqlBool},
+		),
+	},
+	"workspaces": {
+		Name: "workspaces",
+		Columns: columns(
+			sqlColumn{Field: "id", Column: "id"},
+			sqlColumn{Field: "name", Column: "name"},
+			sqlColumn{Field: "code", Column: "code"},
+			deletedAtColumn,
+		),
+		Joins: []sqlJoin{
+			{Field: "userids", Table: "workspace_users", Owner: "workspace_id", Column: "user_id"},
+		},
+	},
+	"projects": {
+		Name: "projects",
+		Columns: columns(
+			sqlColumn{Field: "id", Column: "id"},
+			sqlColumn{Field: "workspaceId", Column: "workspace_id", Kind: sqlRef},
+			sqlColumn{Field: "name", Column: "name"},
+			sqlColumn{Field: "code", Column: "code"},
+			sqlColumn{Field: "description", Column: "description"},
+			deletedAtColumn,
+		),
+		Joins: []sqlJoin{
+			{Field: "userids", Table: "project_users", Owner: "project_id", Column: "user_id"},
+		},
+	},
+	"states": {
+		Name: "states",
+		Columns: columns(
+			sqlColumn{Field: "id", Column: "id"},
+			sqlColumn{Field: "workspaceId", Column: "workspace_id", Kind: sqlRef},
+			sqlColumn{Field: "projectId", Column: "project_id", Kind: sqlRef},
+			sqlColumn{Field: "name", Column: "name"},
+			deletedAtColumn,
+		),
+	},
+	"tasklabels": {
+		Name: "labels",
+		Columns: columns(
+			sqlColumn{Field: "id", Column: "id"},
+			sqlColumn{Field: "workspaceId", Column: "workspace_id", Kind: sqlRef},
+			sqlColumn{Field: "projectId", Column: "project_id", Kind: sqlRef},
+			sqlColumn{Field: "label", Column: "label"},
+			sqlColumn{Field: "color", Column: "color"},
+			deletedAtColumn,
+		),
+	},
+	"tasks": {
+		Name: "tasks",
+		Columns: columns(
+			sqlColumn{Field: "id", Column: "id"},
+			sqlColumn{Field: "workspaceId", Column: "workspace_id", Kind: sqlRef},
+			sqlColumn{Field: "projectId", Column: "project_id", Kind: sqlRef},
+			sqlColumn{Field: "stateId", Column: "state_id", Kind: sqlRef},
+			sqlColumn{Field: "title", Column: "title"},
+			sqlColumn{Field: "code", Column: "code"},
+			sqlColumn{Field: "description", Column: "description"},
+			deletedAtColumn,
+		),
+		Joins: []sqlJoin{
+			{Field: "assigneeids", Table: "task_assignees", Owner: "task_id", Column: "user_id"},
+			{Field: "labelIds", Table: "task_labels", Owner: "task_id", Column: "label_id"},
+		},
+	},
+}
+
+type sqlMigration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// sqlMigrations are applied in order and recorded in schema_migrations. Never
+// edit an applied migration, add a new one instead.
+var sqlMigrations = []sqlMigration{
+	{
+		Version: 1,
+		Name:    "create tables",
+		Up: []string{
+			`CREATE TABLE users (
+				id TEXT PRIMARY KEY,
+				{{seq}}
+				email TEXT,
+				name TEXT,
+				password TEXT,
+				active {{bool}},
+				created_at {{time}},
+				updated_at {{time}},
+				extra TEXT NOT NULL DEFAULT '{}'
+			)`,
+			`CREATE UNIQUE INDEX users_email ON users (email) WHERE email <> ''`,
+			`CREATE TABLE workspaces (
+				id TEXT PRIMARY KEY,
+				{{seq}}
+				name TEXT,
+				code TEXT,
+				created_at {{time}},
+				updated_at {{time}},
+				extra TEXT NOT NULL DEFAULT '{}'
+			)`,
+			`CREATE TABLE workspace_users (
+				workspace_id TEXT NOT NULL REFERENCES workspaces (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				position INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (workspace_id, user_id)
+			)`,
+			`CREATE INDEX workspace_users_user ON workspace_users (user_id)`,
+			`CREATE TABLE projects (
+				id TEXT PRIMARY KEY,
+				{{seq}}
+				workspace_id TEXT REFERENCES workspaces (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				name TEXT,
+				code TEXT,
+				description TEXT,
+				created_at {{time}},
+				updated_at {{time}},
+				extra TEXT NOT NULL DEFAULT '{}'
+			)`,
+			`CREATE INDEX projects_workspace ON projects (workspace_id)`,
+			`CREATE TABLE project_users (
+				project_id TEXT NOT NULL REFERENCES projects (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				position INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (project_id, user_id)
+			)`,
+			`CREATE INDEX project_users_user ON project_users (user_id)`,
+			`CREATE TABLE states (
+				id TEXT PRIMARY KEY,
+				{{seq}}
+				workspace_id TEXT REFERENCES workspaces (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				project_id TEXT REFERENCES projects (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				name TEXT,
+				created_at {{time}},
+				updated_at {{time}},
+				extra TEXT NOT NULL DEFAULT '{}'
+			)`,
+			`CREATE INDEX states_workspace ON states (workspace_id)`,
+			`CREATE INDEX states_project ON states (project_id)`,
+			`CREATE TABLE labels (
+				id TEXT PRIMARY KEY,
+				{{seq}}
+				workspace_id TEXT REFERENCES workspaces (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				project_id TEXT REFERENCES projects (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				label TEXT,
+				color TEXT,
+				created_at {{time}},
+				updated_at {{time}},
+				extra TEXT NOT NULL DEFAULT '{}'
+			)`,
+			`CREATE INDEX labels_workspace ON labels (workspace_id)`,
+			`CREATE TABLE tasks (
+				id TEXT PRIMARY KEY,
+				{{seq}}
+				workspace_id TEXT REFERENCES workspaces (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				project_id TEXT REFERENCES projects (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				state_id TEXT REFERENCES states (id) ON DELETE SET NULL ON UPDATE CASCADE,
+				title TEXT,
+				code TEXT,
+				created_at {{time}},
+				updated_at {{time}},
+				extra TEXT NOT NULL DEFAULT '{}'
+			)`,
+			`CREATE INDEX tasks_workspace ON tasks (workspace_id)`,
+			`CREATE INDEX tasks_project ON tasks (project_id)`,
+			`CREATE INDEX tasks_state ON tasks (state_id)`,
+			`CREATE TABLE task_assignees (
+				task_id TEXT NOT NULL REFERENCES tasks (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				position INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (task_id, user_id)
+			)`,
+			`CREATE INDEX task_assignees_user ON task_assignees (user_id)`,
+			`CREATE TABLE task_labels (
+				task_id TEXT NOT NULL REFERENCES tasks (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				label_id TEXT NOT NULL REFERENCES labels (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				position INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (task_id, label_id)
+			)`,
+			`CREATE INDEX task_labels_label ON task_labels (label_id)`,
+			`CREATE TABLE documents (
+				collection TEXT NOT NULL,
+				id TEXT NOT NULL,
+				{{seq}}
+				ref TEXT,
+				data TEXT NOT NULL,
+				PRIMARY KEY (collection, id)
+			)`,
+			`CREATE INDEX documents_ref ON documents (collection, ref)`,
+		},
+		Down: []string{
+			`DROP TABLE documents`,
+			`DROP TABLE task_labels`,
+			`DROP TABLE task_assignees`,
+			`DROP TABLE tasks`,
+			`DROP TABLE labels`,
+			`DROP TABLE states`,
+			`DROP TABLE project_users`,
+			`DROP TABLE projects`,
+			`DROP TABLE workspace_users`,
+			`DROP TABLE workspaces`,
+			`DROP TABLE users`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "unique project codes",
+		Up:      []string{`CREATE UNIQUE INDEX projects_workspace_code ON projects (workspace_id, code) WHERE code <> ''`},
+		Down:    []string{`DROP INDEX projects_workspace_code`},
+	},
+	{
+		Version: 3,
+		Name:    "soft deletes",
+		Up: []string{
+			`ALTER TABLE workspaces ADD COLUMN deleted_at {{time}}`,
+			`ALTER TABLE projects ADD COLUMN deleted_at {{time}}`,
+			`ALTER TABLE states ADD COLUMN deleted_at {{time}}`,
+			`ALTER TABLE labels ADD COLUMN deleted_at {{time}}`,
+			`ALTER TABLE tasks ADD COLUMN deleted_at {{time}}`,
+		},
+		Down: []string{
+			`ALTER TABLE workspaces DROP COLUMN deleted_at`,
+			`ALTER TABLE projects DROP COLUMN deleted_at`,
+			`ALTER TABLE states DROP COLUMN deleted_at`,
+			`ALTER TABLE labels DROP COLUMN deleted_at`,
+			`ALTER TABLE tasks DROP COLUMN deleted_at`,
+		},
+	},
+	{
+		// The code column held the task description, the description moves
+		// to its own column and code holds the key
+		Version: 4,
+		Name:    "task keys",
+		Up: []string{
+			`ALTER TABLE tasks ADD COLUMN description TEXT`,
+			`UPDATE tasks SET description = code, code = ''`,
+			`CREATE UNIQUE INDEX tasks_workspace_code ON tasks (workspace_id, code) WHERE code <> ''`,
+		},
+		Down: []string{
+			`DROP INDEX tasks_workspace_code`,
+			`UPDATE tasks SET code = description`,
+			`ALTER TABLE tasks DROP COLUMN description`,
+		},
+	},
+}
+
+// sqlQuerier is either the database or a transaction.
+type sqlQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// ConnectSQL connects to a PostgreSQL or SQLite database without touching
+// its schema.
+func ConnectSQL(driver string, dsn string) (*SQLStore, error) {
+	dialect, ok := sqlDialects[driver]
+	if !ok {
+		return nil, errors.New("Unsupported SQL driver " + driver)
+	}
+
+	if driver == "sqlite" && !strings.Contains(dsn, "_pragma") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		dsn += separator + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if driver == "sqlite" {
+		// SQLite has a single writer: one connection serializes the
+		// transactions instead of failing them with "database is locked"
+		db.SetMaxOpenConns(1)
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLStore{db: db, dialect: dialect}, nil
+}
+
+// OpenSQL connects to a PostgreSQL or SQLite database and applies the
+// pending migrations.
+func OpenSQL(driver string, dsn string) (*SQLStore, error) {
+	s, err := ConnectSQL(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Up()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// InitSQL connects to DATABASE_URL with the given driver and makes it the
+// store behind the package functions. SQLite defaults to kickof.db in the
+// working directory.
+func InitSQL(driver string) bool {
+	s, err := OpenSQL(driver, sqlDSN(driver))
+	if err != nil {
+		log.Println("Unable to connect to " + driver + ": " + err.Error())
+		return false
+	}
+
+	log.Println("Connected to " + driver)
+
+	Use(s)
+
+	return true
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLStore) applied() (map[int]time.Time, error) {
+	_, err := s.db.Exec(s.dialect.replacer.Replace(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at {{time}} NOT NULL
+	)`))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt interface{}
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		value, _, err := fromColumn(sqlTime, appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = value.(primitive.DateTime).Time()
+	}
+
+	return applied, rows.Err()
+}
+
+// Up applies the migrations not recorded in schema_migrations yet, each in
+// its own transaction.
+func (s *SQLStore) Up() error {
+	applied, err := s.applied()
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range sqlMigrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err = s.transaction(func(tx *sql.Tx) error {
+			for _, statement := range migration.Up {
+				_, err := tx.Exec(s.dialect.replacer.Replace(statement))
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err := tx.Exec(s.dialect.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+				migration.Version, migration.Name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+
+		log.Printf("Applied migration %d: %s", migration.Version, migration.Name)
+	}
+
+	return nil
+}
+
+// Down rolls back the given number of applied migrations, latest first.
+func (s *SQLStore) Down(steps int) error {
+	applied, err := s.applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(sqlMigrations) - 1; i >= 0 && steps > 0; i-- {
+		migration := sqlMigrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		err = s.transaction(func(tx *sql.Tx) error {
+			for _, statement := range migration.Down {
+				_, err := tx.Exec(s.dialect.replacer.Replace(statement))
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err := tx.Exec(s.dialect.rebind("DELETE FROM schema_migrations WHERE version = ?"), migration.Version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("rollback %d (%s): %w", migration.Version, migration.Name, err)
+		}
+
+		log.Printf("Rolled back migration %d: %s", migration.Version, migration.Name)
+		steps--
+	}
+
+	return nil
+}
+
+func (s *SQLStore) Status() ([]MigrationStatus, error) {
+	applied, err := s.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]MigrationStatus, 0, len(sqlMigrations))
+	for _, migration := range sqlMigrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		results = append(results, status)
+	}
+
+	return results, nil
+}
+
+// isSQLUniqueViolation recognizes the unique constraint errors of both
+// drivers without importing their error types.
+func isSQLUniqueViolation(err error) bool {
+	message := err.Error()
+
+	return strings.Contains(message, "UNIQUE constraint failed") ||
+		strings.Contains(message, "duplicate key value violates unique constraint")
+}
+
+func (s *SQLStore) transaction(fn func(tx *sql.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Transaction runs fn in a database transaction, rolled back when fn fails.
+func (s *SQLStore) Transaction(fn func(tx Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	return s.transaction(func(tx *sql.Tx) error {
+		return fn(&SQLStore{db: s.db, dialect: s.dialect, tx: tx})
+	})
+}
+
+// querier runs the statements of the transaction the store is bound to.
+func (s *SQLStore) querier() sqlQuerier {
+	if s.tx != nil {
+		return s.tx
+	}
+
+	return s.db
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// sqlSelect reads the candidate rows of a collection.
+type sqlSelect struct {
+	table      *sqlTable
+	conditions []string
+	args       []interface{}
+	exact      bool     // The conditions select exactly the documents of the filter
+	order      []string // Pushed down sort, ahead of the insertion order
+	skip       int64
+	limit      int64
+}
+
+// from is the FROM and WHERE clauses of the select.
+func (q *sqlSelect) from() string {
+	name := "documents"
+	if q.table != nil {
+		name = q.table.Name
+	}
+
+	query := " FROM " + name
+	if len(q.conditions) > 0 {
+		query += " WHERE " + strings.Join(q.conditions, " AND ")
+	}
+
+	return query
+}
+
+// selectRows pushes the top level conditions SQL can answer down to it. The
+// full filter is still evaluated on the loaded rows, so unless all of them
+// are pushed down this only has to narrow the candidates, never to be exact.
+// A field with a column is taken to hold the type of the column or null, the
+// values of another type kept in extra are not matched in SQL.
+func (s *SQLStore) selectRows(collection string, filters bson.M) (*sqlSelect, error) {
+	filter, err := toDocument(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	query := &sqlSelect{table: sqlTables[collection], exact: true}
+	if query.table == nil {
+		query.conditions = append(query.conditions, "collection = ?")
+		query.args = append(query.args, collection)
+	}
+
+	for key, condition := range filter {
+		if query.table == nil {
+			// Documents keep their id in ref, everything else in data
+			values, ok := pushDownValues(condition)
+			if ok && key == "id" {
+				query.conditions = append(query.conditions, "ref IN ("+placeholders(len(values))+")")
+				query.args = append(query.args, values...)
+			}
+			query.exact = false
+			continue
+		}
+
+		if column := query.table.column(key); column != nil {
+			clause, args, ok := s.pushDownColumn(*column, condition)
+			if ok {
+				query.conditions = append(query.conditions, clause)
+				query.args = append(query.args, args...)
+				continue
+			}
+		}
+
+		if join := query.table.join(key); join != nil {
+			if values, ok := pushDownValues(condition); ok {
+				query.conditions = append(query.conditions, fmt.Sprintf("%s IN (SELECT %s FROM %s WHERE %s IN (%s))",
+					query.table.Columns[0].Column, join.Owner, join.Table, join.Column, placeholders(len(values))))
+				query.args = append(query.args, values...)
+				continue
+			}
+		}
+
+		query.exact = false
+	}
+
+	return query, nil
+}
+
+// pushDownValues accepts a non-empty string or an $in of non-empty strings.
+func pushDownValues(condition interface{}) ([]interface{}, bool) {
+	if value, ok := condition.(string); ok {
+		return []interface{}{value}, value != ""
+	}
+
+	operators, ok := isOperatorDocument(condition)
+	if !ok || len(operators) != 1 {
+		return nil, false
+	}
+
+	list, ok := operators["$in"].(primitive.A)
+	if !ok || len(list) == 0 {
+		return nil, false
+	}
+
+	values := make([]interface{}, 0, len(list))
+	for _, item := range list {
+		value, ok := item.(string)
+		if !ok || value == "" {
+			return nil, false
+		}
+		values = append(values, value)
+	}
+
+	return values, true
+}
+
+// sqlValue converts a filter value to a parameter compared with a column, or
+// fails when the column does not keep values of its type.
+func sqlValue(kind sqlColumnKind, value interface{}) (interface{}, bool) {
+	switch kind {
+	case sqlBool:
+		v, ok := value.(bool)
+		return v, ok
+	case sqlTime:
+		v, ok := value.(primitive.DateTime)
+		if !ok {
+			return nil, false
+		}
+		return v.Time().UTC(), true
+	default:
+		v, ok := value.(string)
+		return v, ok
+	}
+}
+
+// pushDownColumn translates the condition on the field of a column, with the
+// semantics of the matcher: null matches missing fields, $ne and $nin match
+// them too, and ranges only compare values of the same type.
+func (s *SQLStore) pushDownColumn(column sqlColumn, condition interface{}) (string, []interface{}, bool) {
+	// References store empty strings as NULL and read them back as empty, so
+	// they are never missing
+	expr, nullable := column.Column, true
+	if column.Kind == sqlRef {
+		expr, nullable = "COALESCE("+column.Column+", '')", false
+	}
+
+	operators, ok := isOperatorDocument(condition)
+	if !ok {
+		operators = bson.M{"$eq": condition}
+	}
+
+	conditions := make([]string, 0, len(operators))
+	args := make([]interface{}, 0)
+
+	for operator, argument := range operators {
+		switch operator {
+		case "$eq", "$ne":
+			var clause string
+			if argument == nil {
+				clause = "1 = 0"
+				if nullable {
+					clause = expr + " IS NULL"
+				}
+			} else {
+				value, ok := sqlValue(column.Kind, argument)
+				if !ok {
+					return "", nil, false
+				}
+				clause = expr + " = ?"
+				if column.Kind == sqlRef && value != "" {
+					// Keeps the index of the column
+					clause = column.Column + " = ?"
+				}
+				args = append(args, value)
+			}
+
+			if operator == "$ne" {
+				clause = negate(clause, expr, nullable && argument != nil)
+			}
+			conditions = append(conditions, clause)
+		case "$in", "$nin":
+			list, ok := argument.(primitive.A)
+			if !ok {
+				return "", nil, false
+			}
+
+			values := make([]interface{}, 0, len(list))
+			null := false
+			for _, item := range list {
+				if item == nil {
+					null = true
+					continue
+				}
+				value, ok := sqlValue(column.Kind, item)
+				if !ok {
+					return "", nil, false
+				}
+				values = append(values, value)
+			}
+
+			parts := make([]string, 0, 2)
+			if len(values) > 0 {
+				parts = append(parts, expr+" IN ("+placeholders(len(values))+")")
+				args = append(args, values...)
+			}
+			if null && nullable {
+				parts = append(parts, expr+" IS NULL")
+			}
+
+			clause := "1 = 0"
+			if len(parts) > 0 {
+				clause = "(" + strings.Join(parts, " OR ") + ")"
+			}
+			if operator == "$nin" {
+				clause = negate(clause, expr, nullable && !null)
+			}
+			conditions = append(conditions, clause)
+		case "$gt", "$gte", "$lt", "$lte":
+			value, ok := sqlValue(column.Kind, argument)
+			if !ok || column.Kind == sqlBool {
+				return "", nil, false
+			}
+
+			compared := expr
+			if column.Kind != sqlTime {
+				compared += s.dialect.binary
+			}
+			symbol := map[string]string{"$gt": ">", "$gte": ">=", "$lt": "<", "$lte": "<="}[operator]
+			conditions = append(conditions, compared+" "+symbol+" ?")
+			args = append(args, value)
+		case "$exists":
+			// A null value is kept in extra, where the field exists
+			clause := "1 = 1"
+			if nullable {
+				clause = "(" + column.Column + " IS NOT NULL OR " + fmt.Sprintf(s.dialect.hasExtra, column.Field) + ")"
+			}
+			if !truthy(argument) {
+				clause = "NOT " + clause
+			}
+			conditions = append(conditions, clause)
+		default:
+			return "", nil, false
+		}
+	}
+
+	return strings.Join(conditions, " AND "), args, true
+}
+
+// negate negates a condition on expr. With null, rows where expr is NULL
+// match the negation, which NOT alone would leave unknown.
+func negate(clause string, expr string, null bool) string {
+	if null {
+		return "(" + expr + " IS NULL OR NOT " + clause + ")"
+	}
+
+	return "NOT " + clause
+}
+
+// page pushes the sort, skip and limit of a find down to SQL. It only can when
+// the conditions are exact and every sort field has a column.
+func (s *SQLStore) page(query *sqlSelect, sortBy interface{}, skip int64, limit int64) bool {
+	if !query.exact || query.table == nil {
+		return false
+	}
+
+	order := make([]string, 0)
+	if sortBy != nil {
+		keys, err := sortKeys(sortBy)
+		if err != nil {
+			return false
+		}
+
+		for _, key := range keys {
+			if len(key.path) != 1 {
+				return false
+			}
+			column := query.table.column(key.path[0])
+			if column == nil {
+				return false
+			}
+
+			// Null and missing values sort first, like in the matcher
+			term := column.Column
+			if column.Kind == sqlText || column.Kind == sqlRef {
+				term += s.dialect.binary
+			}
+			if key.direction < 0 {
+				term += " DESC NULLS LAST"
+			} else {
+				term += " ASC NULLS FIRST"
+			}
+			order = append(order, term)
+		}
+	}
+
+	if limit < 0 {
+		limit = -limit
+	}
+	// OFFSET alone is not valid in every dialect
+	if skip > 0 && limit == 0 {
+		return false
+	}
+
+	query.order = order
+	query.skip = skip
+	query.limit = limit
+
+	return true
+}
+
+// load reads the candidate documents of a collection in insertion order,
+// unless the select has a sort. Rows are locked until the end of the
+// transaction when lock is set.
+func (s *SQLStore) load(q sqlQuerier, selection *sqlSelect, lock bool) ([]bson.M, error) {
+	table := selection.table
+
+	query := "SELECT data"
+	if table != nil {
+		names := make([]string, 0, len(table.Columns)+1)
+		for _, column := range table.Columns {
+			names = append(names, column.Column)
+		}
+		names = append(names, "extra")
+		query = "SELECT " + strings.Join(names, ", ")
+	}
+
+	query += selection.from()
+	query += " ORDER BY " + strings.Join(append(selection.order, s.dialect.order), ", ")
+	if selection.limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", selection.limit, selection.skip)
+	}
+	if lock {
+		query += s.dialect.lock
+	}
+
+	rows, err := q.Query(s.dialect.rebind(query), selection.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	documents := make([]bson.M, 0)
+	for rows.Next() {
+		var document bson.M
+		if table == nil {
+			document, err = scanDocument(rows)
+		} else {
+			document, err = scanRow(rows, table)
+		}
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if table != nil && len(documents) > 0 {
+		err = s.loadJoins(q, table, documents)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return documents, nil
+}
+
+func scanDocument(rows *sql.Rows) (bson.M, error) {
+	var data string
+	err := rows.Scan(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromExtJSON(data)
+}
+
+func scanRow(rows *sql.Rows, table *sqlTable) (bson.M, error) {
+	values := make([]interface{}, len(table.Columns))
+	var extra string
+
+	targets := make([]interface{}, 0, len(values)+1)
+	for i := range values {
+		targets = append(targets, &values[i])
+	}
+	targets = append(targets, &extra)
+
+	err := rows.Scan(targets...)
+	if err != nil {
+		return nil, err
+	}
+
+	document, err := fromExtJSON(extra)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, column := range table.Columns {
+		value, ok, err := fromColumn(column.Kind, values[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", table.Name, column.Column, err)
+		}
+		if ok {
+			document[column.Field] = value
+		}
+	}
+
+	return document, nil
+}
+
+// fromColumn converts a scanned column back to the value the Mongo driver
+// would have decoded. NULL means the field lives in extra, or is absent.
+func fromColumn(kind sqlColumnKind, value interface{}) (interface{}, bool, error) {
+	if value == nil {
+		if kind == sqlRef {
+			return "", true, nil
+		}
+		return nil, false, nil
+	}
+
+	switch kind {
+	case sqlBool:
+		switch v := value.(type) {
+		case bool:
+			return v, true, nil
+		case int64:
+			return v != 0, true, nil
+		}
+	case sqlTime:
+		switch v := value.(type) {
+		case time.Time:
+			return primitive.NewDateTimeFromTime(v), true, nil
+		case string:
+			return parseTime(v)
+		case []byte:
+			return parseTime(string(v))
+		}
+	default:
+		switch v := value.(type) {
+		case string:
+			return v, true, nil
+		case []byte:
+			return string(v), true, nil
+		}
+	}
+
+	return nil, false, fmt.Errorf("unexpected %T", value)
+}
+
+var sqlTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+func parseTime(value string) (interface{}, bool, error) {
+	// Go appends the monotonic clock reading when formatting a time.Time
+	if i := strings.Index(value, " m="); i >= 0 {
+		value = value[:i]
+	}
+
+	for _, layout := range sqlTimeLayouts {
+		parsed, err := time.Parse(layout, value)
+		if err == nil {
+			return primitive.NewDateTimeFromTime(parsed), true, nil
+		}
+	}
+
+	return nil, false, errors.New("invalid time " + value)
+}
+
+func (s *SQLStore) loadJoins(q sqlQuerier, table *sqlTable, documents []bson.M) error {
+	byId := map[string]bson.M{}
+	ids := make([]interface{}, 0, len(documents))
+	for _, document := range documents {
+		if id, ok := document[table.Columns[0].Field].(string); ok {
+			byId[id] = document
+			ids = append(ids, id)
+		}
+	}
+
+	for _, join := range table.Joins {
+		values := map[string]primitive.A{}
+
+		for start := 0; start < len(ids); start += 500 {
+			end := min(start+500, len(ids))
+			chunk := ids[start:end]
+
+			rows, err := q.Query(s.dialect.rebind(fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s IN (%s) ORDER BY %s, position",
+				join.Owner, join.Column, join.Table, join.Owner, placeholders(len(chunk)), join.Owner)), chunk...)
+			if err != nil {
+				return err
+			}
+
+			for rows.Next() {
+				var owner, value string
+				err = rows.Scan(&owner, &value)
+				if err != nil {
+					rows.Close()
+					return err
+				}
+				values[owner] = append(values[owner], value)
+			}
+
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				return err
+			}
+		}
+
+		for id, document := range byId {
+			if list, ok := values[id]; ok {
+				document[join.Field] = list
+			} else if _, ok := document[join.Field]; !ok {
+				// Like a nil slice encoded by the driver
+				document[join.Field] = nil
+			}
+		}
+	}
+
+	return nil
+}
+
+func fromExtJSON(data string) (bson.M, error) {
+	var document bson.M
+	err := bson.UnmarshalExtJSON([]byte(data), true, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nested documents decode as bson.D from extended JSON
+	return toDocument(document)
+}
+
+func toExtJSON(document bson.M) (string, error) {
+	data, err := bson.MarshalExtJSON(document, true, false)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// documentKey identifies a document of the documents table by its _id.
+func documentKey(id interface{}) (string, error) {
+	if objectId, ok := id.(primitive.ObjectID); ok {
+		return objectId.Hex(), nil
+	}
+
+	return toExtJSON(bson.M{"_id": id})
+}
+
+// toColumn returns the value to store in a column, or false when the field
+// does not fit the column and has to stay in extra.
+func toColumn(kind sqlColumnKind, value interface{}, present bool) (interface{}, bool) {
+	if !present {
+		return nil, true
+	}
+
+	switch kind {
+	case sqlBool:
+		v, ok := value.(bool)
+		return v, ok
+	case sqlTime:
+		v, ok := value.(primitive.DateTime)
+		if !ok {
+			return nil, false
+		}
+		return v.Time().UTC(), true
+	case sqlRef:
+		v, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+		if v == "" {
+			return nil, true
+		}
+		return v, true
+	default:
+		v, ok := value.(string)
+		return v, ok
+	}
+}
+
+// joinValues returns the ids to store in a join table, or false when the
+// field is not a list of strings and has to stay in extra.
+func joinValues(value interface{}, present bool) ([]string, bool) {
+	if !present || value == nil {
+		return nil, true
+	}
+
+	list, ok := value.(primitive.A)
+	if !ok {
+		return nil, false
+	}
+
+	values := make([]string, 0, len(list))
+	seen := map[string]bool{}
+	for _, item := range list {
+		v, ok := item.(string)
+		if !ok {
+			return nil, false
+		}
+		if !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+
+	return values, true
+}
+
+// write inserts a document, or replaces the row identified by previous.
+func (s *SQLStore) write(q sqlQuerier, collection string, document bson.M, previous interface{}, inserting bool) error {
+	table := sqlTables[collection]
+	if table == nil {
+		return s.writeDocument(q, collection, document, previous, inserting)
+	}
+
+	key := table.Columns[0]
+	if id, ok := document[key.Field].(string); !ok || id == "" {
+		return errors.New(collection + " requires an " + key.Field)
+	}
+
+	extra := bson.M{}
+	for field, value := range document {
+		if table.column(field) == nil && table.join(field) == nil {
+			extra[field] = value
+		}
+	}
+
+	names := make([]string, 0, len(table.Columns)+1)
+	args := make([]interface{}, 0, len(table.Columns)+2)
+	for _, column := range table.Columns {
+		value, present := document[column.Field]
+		converted, ok := toColumn(column.Kind, value, present)
+		if !ok {
+			extra[column.Field] = value
+		}
+		names = append(names, column.Column)
+		args = append(args, converted)
+	}
+
+	joins := map[string][]string{}
+	for _, join := range table.Joins {
+		value, present := document[join.Field]
+		values, ok := joinValues(value, present)
+		if !ok {
+			extra[join.Field] = value
+		}
+		joins[join.Table] = values
+	}
+
+	data, err := toExtJSON(extra)
+	if err != nil {
+		return err
+	}
+	names = append(names, "extra")
+	args = append(args, data)
+
+	if inserting {
+		_, err = q.Exec(s.dialect.rebind("INSERT INTO "+table.Name+" ("+strings.Join(names, ", ")+") VALUES ("+placeholders(len(names))+")"), args...)
+	} else {
+		assignments := make([]string, 0, len(names))
+		for _, name := range names {
+			assignments = append(assignments, name+" = ?")
+		}
+		_, err = q.Exec(s.dialect.rebind("UPDATE "+table.Name+" SET "+strings.Join(assignments, ", ")+" WHERE "+key.Column+" = ?"), append(args, previous)...)
+	}
+	if err != nil {
+		return err
+	}
+
+	id := document[key.Field]
+	for _, join := range table.Joins {
+		if !inserting {
+			_, err = q.Exec(s.dialect.rebind("DELETE FROM "+join.Table+" WHERE "+join.Owner+" = ?"), id)
+			if err != nil {
+				return err
+			}
+		}
+
+		for position, value := range joins[join.Table] {
+			_, err = q.Exec(s.dialect.rebind("INSERT INTO "+join.Table+" ("+join.Owner+", "+join.Column+", position) VALUES (?, ?, ?)"), id, value, position)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLStore) writeDocument(q sqlQuerier, collection string, document bson.M, previous interface{}, inserting bool) error {
+	key, err := documentKey(document["_id"])
+	if err != nil {
+		return err
+	}
+
+	data, err := toExtJSON(document)
+	if err != nil {
+		return err
+	}
+
+	var ref interface{}
+	if id, ok := document["id"].(string); ok {
+		ref = id
+	}
+
+	if inserting {
+		_, err = q.Exec(s.dialect.rebind("INSERT INTO documents (collection, id, ref, data) VALUES (?, ?, ?, ?)"), collection, key, ref, data)
+		return err
+	}
+
+	previousKey, err := documentKey(previous)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(s.dialect.rebind("UPDATE documents SET id = ?, ref = ?, data = ? WHERE collection = ? AND id = ?"), key, ref, data, collection, previousKey)
+	return err
+}
+
+func (s *SQLStore) remove(q sqlQuerier, collection string, document bson.M) error {
+	table := sqlTables[collection]
+	if table == nil {
+		key, err := documentKey(document["_id"])
+		if err != nil {
+			return err
+		}
+
+		_, err = q.Exec(s.dialect.rebind("DELETE FROM documents WHERE collection = ? AND id = ?"), collection, key)
+		return err
+	}
+
+	key := table.Columns[0]
+	_, err := q.Exec(s.dialect.rebind("DELETE FROM "+table.Name+" WHERE "+key.Column+" = ?"), document[key.Field])
+	return err
+}
+
+// rowKey is what write needs to find the stored row of a document again.
+func rowKey(collection string, document bson.M) interface{} {
+	if table := sqlTables[collection]; table != nil {
+		return document[table.Columns[0].Field]
+	}
+
+	return document["_id"]
+}
+
+func (s *SQLStore) find(q sqlQuerier, collection string, filters bson.M, sortBy interface{}, skip int64, limit int64, lock bool) ([]bson.M, error) {
+	query, err := s.selectRows(collection, filters)
+	if err != nil {
+		return nil, err
+	}
+	if s.page(query, sortBy, skip, limit) {
+		skip, limit = 0, 0
+	}
+
+	documents, err := s.load(q, query, lock)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, err := selectDocuments(documents, filters, sortBy, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]bson.M, 0, len(indexes))
+	for _, i := range indexes {
+		results = append(results, documents[i])
+	}
+
+	return results, nil
+}
+
+func (s *SQLStore) Find(collection string, filters bson.M, opt *options.FindOptions) (*mongo.Cursor, error) {
+	if opt == nil {
+		opt = options.Find()
+	}
+
+	var skip, limit int64
+	if opt.Skip != nil {
+		skip = *opt.Skip
+	}
+	if opt.Limit != nil {
+		limit = *opt.Limit
+	}
+
+	documents, err := s.find(s.querier(), collection, filters, opt.Sort, skip, limit, false)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]interface{}, 0, len(documents))
+	for _, document := range documents {
+		document, err = project(document, opt.Projection)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, document)
+	}
+
+	return mongo.NewCursorFromDocuments(results, nil, nil)
+}
+
+func (s *SQLStore) FindOne(collection string, filters bson.M, opt *options.FindOneOptions) *mongo.SingleResult {
+	if opt == nil {
+		opt = options.FindOne()
+	}
+
+	var skip int64
+	if opt.Skip != nil {
+		skip = *opt.Skip
+	}
+
+	documents, err := s.find(s.querier(), collection, filters, opt.Sort, skip, 1, false)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+
+	if len(documents) == 0 {
+		return mongo.NewSingleResultFromDocument(bson.M{}, mongo.ErrNoDocuments, nil)
+	}
+
+	document, err := project(documents[0], opt.Projection)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+
+	return mongo.NewSingleResultFromDocument(document, nil, nil)
+}
+
+func (s *SQLStore) Count(collection string, filters bson.M) (int64, error) {
+	query, err := s.selectRows(collection, filters)
+	if err != nil {
+		return 0, err
+	}
+	if query.exact && query.table != nil {
+		var count int64
+		err = s.querier().QueryRow(s.dialect.rebind("SELECT COUNT(*)"+query.from()), query.args...).Scan(&count)
+		return count, err
+	}
+
+	documents, err := s.find(s.querier(), collection, filters, nil, 0, 0, false)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(documents)), nil
+}
+
+func (s *SQLStore) InsertOne(collection string, object interface{}) (*mongo.InsertOneResult, error) {
+	document, err := toDocument(object)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := document["_id"]; !ok {
+		document["_id"] = primitive.NewObjectID()
+	}
+
+	// A row and its join table rows go in together
+	err = s.transaction(func(tx *sql.Tx) error {
+		return s.write(tx, collection, document, nil, true)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &mongo.InsertOneResult{InsertedID: document["_id"]}, nil
+}
+
+// update applies the update in a transaction and returns the matched
+// documents before and after it.
+func (s *SQLStore) update(collection string, filters bson.M, update bson.M, sortBy interface{}, upsert bool, many bool) (*mongo.UpdateResult, []bson.M, []bson.M, error) {
+	changes, err := toDocument(update)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var limit int64 = 1
+	if many {
+		limit = 0
+	}
+
+	var result *mongo.UpdateResult
+	var before, after []bson.M
+
+	err = s.transaction(func(tx *sql.Tx) error {
+		result = &mongo.UpdateResult{}
+		before, after = nil, nil
+
+		documents, err := s.find(tx, collection, filters, sortBy, 0, limit, true)
+		if err != nil {
+			return err
+		}
+
+		result.MatchedCount = int64(len(documents))
+
+		for _, document := range documents {
+			updated := cloneDocument(document)
+
+			err = applyUpdate(updated, changes, false)
+			if err != nil {
+				return err
+			}
+
+			if !reflect.DeepEqual(document, updated) {
+				err = s.write(tx, collection, updated, rowKey(collection, document), false)
+				if err != nil {
+					return err
+				}
+				result.ModifiedCount++
+			}
+
+			before = append(before, document)
+			after = append(after, updated)
+		}
+
+		if len(documents) > 0 || !upsert {
+			return nil
+		}
+
+		filter, err := toDocument(filters)
+		if err != nil {
+			return err
+		}
+
+		document := upsertDocument(filter)
+		err = applyUpdate(document, changes, true)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := document["_id"]; !ok {
+			document["_id"] = primitive.NewObjectID()
+		}
+
+		err = s.write(tx, collection, document, nil, true)
+		if err != nil {
+			return err
+		}
+
+		result.UpsertedCount = 1
+		result.UpsertedID = document["_id"]
+		after = append(after, document)
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return result, before, after, nil
+}
+
+func (s *SQLStore) UpdateOne(collection string, filters bson.M, update bson.M, opt *options.UpdateOptions) (*mongo.UpdateResult, error) {
+	upsert := opt != nil && opt.Upsert != nil && *opt.Upsert
+
+	result, _, _, err := s.update(collection, filters, update, nil, upsert, false)
+
+	return result, err
+}
+
+func (s *SQLStore) UpdateMany(collection string, filters bson.M, update bson.M, opt *options.UpdateOptions) (*mongo.UpdateResult, error) {
+	upsert := opt != nil && opt.Upsert != nil && *opt.Upsert
+
+	result, _, _, err := s.update(collection, filters, update, nil, upsert, true)
+
+	return result, err
+}
+
+func (s *SQLStore) FindOneAndUpdate(collection string, filters bson.M, update bson.M, opt *options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	if opt == nil {
+		opt = options.FindOneAndUpdate()
+	}
+
+	upsert := opt.Upsert != nil && *opt.Upsert
+
+	_, before, after, err := s.update(collection, filters, update, opt.Sort, upsert, false)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+
+	var document bson.M
+	if opt.ReturnDocument == nil || *opt.ReturnDocument == options.Before {
+		// An upserted document did not exist before the update
+		if len(before) > 0 {
+			document = before[0]
+		}
+	} else if len(after) > 0 {
+		document = after[0]
+	}
+
+	if document == nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, mongo.ErrNoDocuments, nil)
+	}
+
+	document, err = project(document, opt.Projection)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+
+	return mongo.NewSingleResultFromDocument(document, nil, nil)
+}
+
+func (s *SQLStore) delete(collection string, filters bson.M, many bool) (*mongo.DeleteResult, error) {
+	var limit int64 = 1
+	if many {
+		limit = 0
+	}
+
+	var count int64
+	err := s.transaction(func(tx *sql.Tx) error {
+		count = 0
+
+		documents, err := s.find(tx, collection, filters, nil, 0, limit, true)
+		if err != nil {
+			return err
+		}
+
+		for _, document := range documents {
+			err = s.remove(tx, collection, document)
+			if err != nil {
+				return err
+			}
+			count++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &mongo.DeleteResult{DeletedCount: count}, nil
+}
+
+func (s *SQLStore) DeleteOne(collection string, filters bson.M) (*mongo.DeleteResult, error) {
+	return s.delete(collection, filters, false)
+}
+
+func (s *SQLStore) DeleteMany(collection string, filters bson.M) (*mongo.DeleteResult, error) {
+	return s.delete(collection, filters, true)
+}
@@ -0,0 +1,244 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func openSQLite(t *testing.T, path string) *SQLStore {
+	t.Helper()
+
+	s, err := OpenSQL("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func TestSQLStoreRelations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kickof.db")
+	s := openSQLite(t, path)
+
+	insert := func(collection string, document bson.M) {
+		t.Helper()
+		if _, err := s.InsertOne(collection, document); err != nil {
+			t.Fatalf("insert %s: %v", collection, err)
+		}
+	}
+
+	insert("users", bson.M{"id": "u1", "email": "a@kickof.test"})
+	insert("users", bson.M{"id": "u2", "email": "b@kickof.test"})
+	insert("workspaces", bson.M{"id": "w1", "userids": bson.A{"u2", "u1"}})
+	insert("projects", bson.M{"id": "p1", "workspaceId": "w1"})
+	insert("states", bson.M{"id": "s1", "workspaceId": "w1", "projectId": ""})
+	insert("tasks", bson.M{"id": "t1", "workspaceId": "w1", "projectId": "p1", "stateId": "s1", "assigneeids": bson.A{"u1"}, "priority": 3})
+
+	if _, err := s.InsertOne("users", bson.M{"id": "u3", "email": "a@kickof.test"}); !IsDuplicateKey(err) {
+		t.Fatalf("duplicate email was not rejected as a duplicate key: %v", err)
+	}
+	if _, err := s.InsertOne("tasks", bson.M{"id": "t2", "workspaceId": "missing"}); err == nil {
+		t.Fatal("task in a missing workspace was accepted")
+	}
+
+	var workspace bson.M
+	if err := s.FindOne("workspaces", bson.M{"userids": "u1"}, nil).Decode(&workspace); err != nil {
+		t.Fatal(err)
+	}
+	if ids := workspace["userids"].(bson.A); len(ids) != 2 || ids[0] != "u2" {
+		t.Fatalf("user ids lost their order: %v", ids)
+	}
+
+	var task bson.M
+	if err := s.FindOne("tasks", bson.M{"id": "t1"}, nil).Decode(&task); err != nil {
+		t.Fatal(err)
+	}
+	if task["priority"] != int32(3) {
+		t.Fatalf("extra field not kept: %v", task["priority"])
+	}
+
+	// Deleting a state unsets it on its tasks, deleting a workspace removes
+	// everything in it
+	if _, err := s.DeleteOne("states", bson.M{"id": "s1"}); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := s.Count("tasks", bson.M{"stateId": ""}); count != 1 {
+		t.Fatalf("task still has its state: %d", count)
+	}
+
+	if _, err := s.DeleteOne("workspaces", bson.M{"id": "w1"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, collection := range []string{"projects", "tasks"} {
+		if count, _ := s.Count(collection, bson.M{}); count != 0 {
+			t.Fatalf("%d %s left after deleting their workspace", count, collection)
+		}
+	}
+
+	// Reopening applies no migration twice and keeps the data
+	s.Close()
+	s = openSQLite(t, path)
+	if err := s.FindOne("users", bson.M{"id": "u1"}, nil).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FindOne("users", bson.M{"id": "missing"}, nil).Err(); err != mongo.ErrNoDocuments {
+		t.Fatalf("expected no documents, got %v", err)
+	}
+}
+
+func TestSQLTaskDescriptionMigration(t *testing.T) {
+	s := openSQLite(t, filepath.Join(t.TempDir(), "kickof.db"))
+
+	if err := s.Down(1); err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []string{
+		`INSERT INTO workspaces (id) VALUES ('w1')`,
+		`INSERT INTO tasks (id, workspace_id, code) VALUES ('t1', 'w1', 'Fix the *header*')`,
+	} {
+		if _, err := s.db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	var task bson.M
+	if err := s.FindOne("tasks", bson.M{"id": "t1"}, nil).Decode(&task); err != nil {
+		t.Fatal(err)
+	}
+	if task["description"] != "Fix the *header*" || task["code"] != "" {
+		t.Fatalf("description not moved out of the code: %v", task)
+	}
+	if _, err := s.UpdateOne("tasks", bson.M{"id": "t1"}, bson.M{"$set": bson.M{"code": "WEB-1"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rolling back drops the key and puts the description back in the code
+	if err := s.Down(1); err != nil {
+		t.Fatal(err)
+	}
+	var code string
+	if err := s.db.QueryRow(`SELECT code FROM tasks WHERE id = 't1'`).Scan(&code); err != nil {
+		t.Fatal(err)
+	}
+	if code != "Fix the *header*" {
+		t.Fatalf("description not moved back to the code: %q", code)
+	}
+}
+
+// TestSQLPushDown runs the same finds on the SQL and the in-memory store,
+// whether SQL answers them alone or the matcher narrows the rows after it.
+func TestSQLPushDown(t *testing.T) {
+	s := openSQLite(t, filepath.Join(t.TempDir(), "kickof.db"))
+	memory := NewMemoryStore()
+
+	day := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) primitive.DateTime {
+		return primitive.NewDateTimeFromTime(day.Add(d))
+	}
+
+	documents := []bson.M{
+		{"id": "w1"},
+		{"id": "p1", "workspaceId": "w1"},
+		{"id": "t1", "workspaceId": "w1", "projectId": "", "title": "b", "code": "WEB-1", "createdAt": at(0), "deletedAt": nil},
+		{"id": "t2", "workspaceId": "w1", "projectId": "p1", "title": "a", "code": "", "createdAt": at(500 * time.Millisecond)},
+		{"id": "t3", "workspaceId": "w1", "projectId": "p1", "title": "B", "code": "WEB-2", "createdAt": at(0), "deletedAt": at(time.Hour)},
+		{"id": "t4", "workspaceId": "w1", "projectId": "", "title": "é", "createdAt": at(250 * time.Millisecond)},
+		{"id": "t5", "workspaceId": "w1", "projectId": "p1", "title": "a", "code": "WEB-10", "description": nil, "createdAt": at(time.Second)},
+	}
+	for i, document := range documents {
+		collection := map[int]string{0: "workspaces", 1: "projects"}[i]
+		if collection == "" {
+			collection = "tasks"
+		}
+		for _, store := range []Store{s, memory} {
+			if _, err := store.InsertOne(collection, document); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		filters bson.M
+		sort    bson.D
+		skip    int64
+		limit   int64
+		exact   bool
+	}{
+		{filters: bson.M{}, sort: bson.D{{Key: "title", Value: 1}}, skip: 1, limit: 2, exact: true},
+		{filters: bson.M{"deletedAt": nil}, sort: bson.D{{Key: "createdAt", Value: -1}}, exact: true},
+		{filters: bson.M{"deletedAt": bson.M{"$ne": nil}}, exact: true},
+		{filters: bson.M{"code": bson.M{"$exists": false}}, exact: true},
+		{filters: bson.M{"description": bson.M{"$exists": true}}, exact: true},
+		{filters: bson.M{"deletedAt": bson.M{"$exists": true}}, sort: bson.D{{Key: "deletedAt", Value: 1}, {Key: "title", Value: -1}}, limit: 1, exact: true},
+		{filters: bson.M{"code": bson.M{"$nin": bson.A{"WEB-1", nil}}}, sort: bson.D{{Key: "code", Value: -1}}, limit: 2, exact: true},
+		{filters: bson.M{}, sort: bson.D{{Key: "code", Value: -1}}, limit: 4, exact: true},
+		{filters: bson.M{"code": bson.M{"$in": bson.A{nil, "WEB-2"}}}, exact: true},
+		{filters: bson.M{"code": bson.M{"$nin": bson.A{"WEB-1"}}}, exact: true},
+		{filters: bson.M{"projectId": ""}, sort: bson.D{{Key: "createdAt", Value: 1}}, limit: 1, exact: true},
+		{filters: bson.M{"projectId": bson.M{"$ne": ""}}, exact: true},
+		{filters: bson.M{"projectId": bson.M{"$lt": "p2"}}, sort: bson.D{{Key: "projectId", Value: -1}}, exact: true},
+		{filters: bson.M{"projectId": nil}, exact: true},
+		{filters: bson.M{"title": bson.M{"$gt": "B", "$lte": "b"}}, sort: bson.D{{Key: "title", Value: -1}}, limit: 1, exact: true},
+		{filters: bson.M{"createdAt": bson.M{"$gte": at(250 * time.Millisecond)}}, sort: bson.D{{Key: "createdAt", Value: 1}}, skip: 1, limit: 2, exact: true},
+		{filters: bson.M{"title": bson.M{"$ne": "a"}, "workspaceId": "w1"}, sort: bson.D{{Key: "code", Value: 1}}, limit: 3, exact: true},
+		{filters: bson.M{"title": bson.M{"$regex": "^a"}}, sort: bson.D{{Key: "title", Value: 1}}, limit: 1},
+		{filters: bson.M{"code": 1}},
+	}
+
+	ids := func(store Store, filters bson.M, opt *options.FindOptions) []string {
+		t.Helper()
+		cursor, err := store.Find("tasks", filters, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results []bson.M
+		if err := cursor.All(context.Background(), &results); err != nil {
+			t.Fatal(err)
+		}
+		list := make([]string, 0, len(results))
+		for _, result := range results {
+			list = append(list, result["id"].(string))
+		}
+		return list
+	}
+
+	for _, test := range tests {
+		opt := options.Find().SetSkip(test.skip).SetLimit(test.limit)
+		if test.sort != nil {
+			opt.SetSort(test.sort)
+		}
+
+		query, err := s.selectRows("tasks", test.filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query.exact != test.exact {
+			t.Errorf("%v: pushed down exactly %v, expected %v", test.filters, query.exact, test.exact)
+		}
+
+		got, expected := ids(s, test.filters, opt), ids(memory, test.filters, opt)
+		if !slices.Equal(got, expected) {
+			t.Errorf("%v sorted by %v: got %v, expected %v", test.filters, test.sort, got, expected)
+		}
+
+		count, err := s.Count("tasks", test.filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expectedCount, _ := memory.Count("tasks", test.filters); count != expectedCount {
+			t.Errorf("%v: counted %d, expected %d", test.filters, count, expectedCount)
+		}
+	}
+}
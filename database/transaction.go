@@ -0,0 +1,184 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+)
+
+// Transaction runs fn with a store whose writes are committed together, or
+// not at all when fn fails.
+func Transaction(fn func(tx Store) error) error {
+	return store.Transaction(fn)
+}
+
+// journalStore emulates a transaction for stores without one: it records how
+// to undo every write and replays the undo log backwards when the unit of
+// work fails. Other clients may see the intermediate state, but none of it is
+// left behind.
+type journalStore struct {
+	Store
+	undo []func() error
+}
+
+// runJournaled runs fn on a journal over store, undoing its writes on error.
+func runJournaled(store Store, fn func(tx Store) error) error {
+	journal := &journalStore{Store: store}
+
+	err := fn(journal)
+	if err == nil {
+		return nil
+	}
+
+	for i := len(journal.undo) - 1; i >= 0; i-- {
+		undoErr := journal.undo[i]()
+		if undoErr != nil {
+			log.Println("Error undo write", undoErr.Error())
+			err = errors.Join(err, undoErr)
+		}
+	}
+
+	return err
+}
+
+// Transaction joins the unit of work already running.
+func (s *journalStore) Transaction(fn func(tx Store) error) error {
+	return fn(s)
+}
+
+// snapshot reads the documents a write is about to change.
+func (s *journalStore) snapshot(collection string, filters bson.M, many bool) ([]bson.M, error) {
+	opt := options.Find()
+	if !many {
+		opt.SetLimit(1)
+	}
+
+	cursor, err := s.Store.Find(collection, filters, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	var documents []bson.M
+	err = cursor.All(context.Background(), &documents)
+
+	return documents, err
+}
+
+// restore puts back documents as they were in the snapshot.
+func (s *journalStore) restore(collection string, documents []bson.M) {
+	for _, document := range documents {
+		s.undo = append(s.undo, func() error {
+			_, err := s.Store.DeleteOne(collection, bson.M{"_id": document["_id"]})
+			if err != nil {
+				return err
+			}
+
+			_, err = s.Store.InsertOne(collection, document)
+			return err
+		})
+	}
+}
+
+func (s *journalStore) remove(collection string, id interface{}) {
+	s.undo = append(s.undo, func() error {
+		_, err := s.Store.DeleteOne(collection, bson.M{"_id": id})
+		return err
+	})
+}
+
+func (s *journalStore) InsertOne(collection string, object interface{}) (*mongo.InsertOneResult, error) {
+	res, err := s.Store.InsertOne(collection, object)
+	if err == nil {
+		s.remove(collection, res.InsertedID)
+	}
+
+	return res, err
+}
+
+func (s *journalStore) update(collection string, filters bson.M, many bool, write func() (*mongo.UpdateResult, error)) (*mongo.UpdateResult, error) {
+	documents, err := s.snapshot(collection, filters, many)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := write()
+	if err != nil {
+		return res, err
+	}
+
+	s.restore(collection, documents)
+	if res.UpsertedID != nil {
+		s.remove(collection, res.UpsertedID)
+	}
+
+	return res, nil
+}
+
+func (s *journalStore) UpdateOne(collection string, filters bson.M, update bson.M, opt *options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return s.update(collection, filters, false, func() (*mongo.UpdateResult, error) {
+		return s.Store.UpdateOne(collection, filters, update, opt)
+	})
+}
+
+func (s *journalStore) UpdateMany(collection string, filters bson.M, update bson.M, opt *options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return s.update(collection, filters, true, func() (*mongo.UpdateResult, error) {
+		return s.Store.UpdateMany(collection, filters, update, opt)
+	})
+}
+
+func (s *journalStore) FindOneAndUpdate(collection string, filters bson.M, update bson.M, opt *options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	documents, err := s.snapshot(collection, filters, false)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+
+	result := s.Store.FindOneAndUpdate(collection, filters, update, opt)
+	if result.Err() == nil || errors.Is(result.Err(), mongo.ErrNoDocuments) {
+		s.restore(collection, documents)
+		if len(documents) == 0 && opt != nil && opt.Upsert != nil && *opt.Upsert {
+			// The upserted document has whatever _id the store gave it
+			var upserted bson.M
+			if s.Store.FindOne(collection, filters, nil).Decode(&upserted) == nil {
+				s.remove(collection, upserted["_id"])
+			}
+		}
+	}
+
+	return result
+}
+
+func (s *journalStore) delete(collection string, filters bson.M, many bool, write func() (*mongo.DeleteResult, error)) (*mongo.DeleteResult, error) {
+	documents, err := s.snapshot(collection, filters, many)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := write()
+	if err != nil {
+		return res, err
+	}
+
+	for _, document := range documents {
+		s.undo = append(s.undo, func() error {
+			_, err := s.Store.InsertOne(collection, document)
+			return err
+		})
+	}
+
+	return res, nil
+}
+
+func (s *journalStore) DeleteOne(collection string, filters bson.M) (*mongo.DeleteResult, error) {
+	return s.delete(collection, filters, false, func() (*mongo.DeleteResult, error) {
+		return s.Store.DeleteOne(collection, filters)
+	})
+}
+
+func (s *journalStore) DeleteMany(collection string, filters bson.M) (*mongo.DeleteResult, error) {
+	return s.delete(collection, filters, true, func() (*mongo.DeleteResult, error) {
+		return s.Store.DeleteMany(collection, filters)
+	})
+}
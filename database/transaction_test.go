@@ -0,0 +1,64 @@
+package database
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"path/filepath"
+	"testing"
+)
+
+func TestTransactionRollback(t *testing.T) {
+	stores := map[string]Store{
+		"memory": NewMemoryStore(),
+		"sqlite": openSQLite(t, filepath.Join(t.TempDir(), "kickof.db")),
+	}
+
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			if _, err := s.InsertOne("workspaces", bson.M{"id": "w1", "name": "Acme"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.InsertOne("projects", bson.M{"id": "p1", "workspaceId": "w1"}); err != nil {
+				t.Fatal(err)
+			}
+
+			failed := errors.New("failed")
+			err := s.Transaction(func(tx Store) error {
+				if _, err := tx.InsertOne("projects", bson.M{"id": "p2", "workspaceId": "w1"}); err != nil {
+					return err
+				}
+				if _, err := tx.UpdateOne("workspaces", bson.M{"id": "w1"}, bson.M{"$set": bson.M{"name": "Renamed"}}, nil); err != nil {
+					return err
+				}
+				if _, err := tx.DeleteOne("projects", bson.M{"id": "p1"}); err != nil {
+					return err
+				}
+
+				return failed
+			})
+			if !errors.Is(err, failed) {
+				t.Fatalf("expected the error of the unit of work, got %v", err)
+			}
+
+			count, err := s.Count("projects", bson.M{})
+			if err != nil || count != 1 {
+				t.Fatalf("expected the projects to be rolled back, got %d: %v", count, err)
+			}
+			var workspace bson.M
+			if err := s.FindOne("workspaces", bson.M{"id": "w1"}, nil).Decode(&workspace); err != nil || workspace["name"] != "Acme" {
+				t.Fatalf("expected the rename to be rolled back, got %v: %v", workspace, err)
+			}
+
+			err = s.Transaction(func(tx Store) error {
+				_, err := tx.InsertOne("projects", bson.M{"id": "p2", "workspaceId": "w1"})
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count, _ := s.Count("projects", bson.M{}); count != 2 {
+				t.Fatalf("committed insert is missing, got %d projects", count)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"path/filepath"
+	"testing"
+)
+
+func TestUniqueTaskKeys(t *testing.T) {
+	stores := map[string]Store{
+		"memory": NewMemoryStore(),
+		"sqlite": openSQLite(t, filepath.Join(t.TempDir(), "kickof.db")),
+	}
+
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			for _, document := range []bson.M{
+				{"id": "w1"},
+				{"id": "w2"},
+			} {
+				if _, err := s.InsertOne("workspaces", document); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, task := range []bson.M{
+				{"id": "t1", "workspaceId": "w1", "code": "WEB-1"},
+				{"id": "t2", "workspaceId": "w2", "code": "WEB-1"},
+				{"id": "t3", "workspaceId": "w1", "code": ""},
+				{"id": "t4", "workspaceId": "w1", "code": ""},
+			} {
+				if _, err := s.InsertOne("tasks", task); err != nil {
+					t.Fatalf("insert %s: %v", task["id"], err)
+				}
+			}
+
+			if _, err := s.InsertOne("tasks", bson.M{"id": "t5", "workspaceId": "w1", "code": "WEB-1"}); !IsDuplicateKey(err) {
+				t.Fatalf("duplicate key was not rejected: %v", err)
+			}
+			if _, err := s.UpdateOne("tasks", bson.M{"id": "t3"}, bson.M{"$set": bson.M{"code": "WEB-1"}}, nil); !IsDuplicateKey(err) {
+				t.Fatalf("update to a duplicate key was not rejected: %v", err)
+			}
+		})
+	}
+}
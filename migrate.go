@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"kickof/database"
+	"log"
+	"os"
+	"strconv"
+)
+
+const migrateUsage = "Usage: kickof-go migrate up|down [steps]|status"
+
+// migrate runs the migrate subcommand against the database selected by
+// DATABASE_DRIVER and returns the exit code.
+func migrate(args []string) int {
+	if len(args) == 0 {
+		log.Println(migrateUsage)
+		return 2
+	}
+
+	migrator, err := database.NewMigrator(os.Getenv("DATABASE_DRIVER"))
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		err = migrator.Up()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Println(migrateUsage)
+				return 2
+			}
+		}
+		err = migrator.Down(steps)
+	case "status":
+		var statuses []database.MigrationStatus
+		statuses, err = migrator.Status()
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-30s %s\n", status.Version, status.Name, applied)
+		}
+	default:
+		log.Println(migrateUsage)
+		return 2
+	}
+
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	return 0
+}
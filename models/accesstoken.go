@@ -0,0 +1,73 @@
+package models
+
+import "time"
+
+const (
+	AccessTokenPrefix = "kop_"
+
+	TokenTypePersonal = "personal"
+	TokenTypeService  = "service"
+)
+
+const (
+	ScopeReadTasks      = "read:tasks"
+	ScopeWriteTasks     = "write:tasks"
+	ScopeAdminWorkspace = "admin:workspace"
+)
+
+// ScopeIncludes lists the scopes every scope implies.
+var ScopeIncludes = map[string][]string{
+	ScopeReadTasks:      {ScopeReadTasks},
+	ScopeWriteTasks:     {ScopeReadTasks, ScopeWriteTasks},
+	ScopeAdminWorkspace: {ScopeReadTasks, ScopeWriteTasks, ScopeAdminWorkspace},
+}
+
+// PermissionScopes maps workspace permissions to the scope a token needs.
+var PermissionScopes = map[Permission]string{
+	PermissionRead:   ScopeReadTasks,
+	PermissionWrite:  ScopeWriteTasks,
+	PermissionManage: ScopeAdminWorkspace,
+	PermissionDelete: ScopeAdminWorkspace,
+}
+
+// AccessToken is either a personal access token acting as its user or a
+// workspace API key acting as a service account member of the workspace.
+type AccessToken struct {
+	Id          string     `json:"id"`
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	UserId      string     `json:"userId" bson:"userId"`
+	WorkspaceId string     `json:"workspaceId" bson:"workspaceId"`
+	CreatedBy   string     `json:"createdBy" bson:"createdBy"`
+	Scopes      []string   `json:"scopes"`
+	Prefix      string     `json:"prefix"`
+	Hash        string     `json:"-"`
+	Token       string     `json:"token,omitempty" bson:"-"` // Only returned once, on creation
+	ExpiresAt   *time.Time `json:"expiresAt" bson:"expiresAt"`
+	LastUsedAt  *time.Time `json:"lastUsedAt" bson:"lastUsedAt"`
+	RevokedAt   *time.Time `json:"revokedAt" bson:"revokedAt"`
+	BasicDate   `bson:",inline"`
+}
+
+type AccessTokenRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+func IsValidScope(scope string) bool {
+	_, ok := ScopeIncludes[scope]
+	return ok
+}
+
+func (t AccessToken) HasScope(scope string) bool {
+	for _, granted := range t.Scopes {
+		for _, included := range ScopeIncludes[granted] {
+			if included == scope {
+				return true
+			}
+		}
+	}
+
+	return false
+}
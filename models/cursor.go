@@ -0,0 +1,160 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+const (
+	DefaultCursorLimit = 50
+	MaxCursorLimit     = 500
+)
+
+// Cursor points at a document of a list sorted on a field, then on id to
+// break ties. It is handed to clients base64 encoded, opaque to them.
+type Cursor struct {
+	Field string      `bson:"f"`
+	Order int64       `bson:"o"`
+	Value interface{} `bson:"v"`
+	Id    string      `bson:"id"`
+}
+
+func (c Cursor) Encode() string {
+	data, err := bson.Marshal(c)
+	if err != nil {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(value string) (Cursor, error) {
+	var cursor Cursor
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+
+	err = bson.Unmarshal(data, &cursor)
+	if err != nil || cursor.Id == "" {
+		return cursor, ErrInvalidCursor
+	}
+
+	// The value goes into a find as is, so a document would be read as
+	// operators
+	switch cursor.Value.(type) {
+	case nil, string, int32, int64, float64, bool, primitive.DateTime:
+	default:
+		return cursor, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+// NewCursor points at a document, given as stored, of a list sorted like
+// the query.
+func (q Query) NewCursor(document bson.M) string {
+	field, order := q.cursorSort()
+	id, _ := document["id"].(string)
+
+	return Cursor{Field: field, Order: order, Value: document[field], Id: id}.Encode()
+}
+
+// IsCursor tells whether the list is paged with after or before cursors
+// rather than by offset. An empty after starts from the first document, an
+// empty before from the last.
+func (q Query) IsCursor() bool {
+	return q.After != nil || q.Before != nil
+}
+
+// IsBackward tells whether the page is read backwards, from a before cursor.
+func (q Query) IsBackward() bool {
+	return q.After == nil && q.Before != nil
+}
+
+// cursorSort is the field and order cursor pages are sorted on, createdAt
+// by default.
+func (q Query) cursorSort() (string, int64) {
+	key, _, _ := strings.Cut(q.Sort, ";")
+	field, order, err := parseSortKey(key)
+	if err != nil {
+		return "createdAt", 1
+	}
+
+	return field, order
+}
+
+// GetCursorLimit is the size of a cursor page.
+func (q Query) GetCursorLimit() int64 {
+	limit, _ := strconv.ParseInt(q.Limit, 10, 64)
+	if limit <= 0 {
+		return DefaultCursorLimit
+	}
+
+	return min(limit, MaxCursorLimit)
+}
+
+// GetCursorFind restricts filters to the documents past the cursor and
+// returns the options to read one more than a page of them, to tell whether
+// there are more. Backward pages come in reverse order.
+func (q Query) GetCursorFind(filters bson.M) (bson.M, *options.FindOptions, error) {
+	if strings.Contains(strings.Trim(q.Sort, "; "), ";") {
+		return nil, nil, errors.New("Cursor pages sort on a single field")
+	}
+
+	field, sortOrder := q.cursorSort()
+
+	order, value := sortOrder, q.After
+	if q.IsBackward() {
+		order, value = -sortOrder, q.Before
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: field, Value: order}, {Key: "id", Value: order}}).
+		SetLimit(q.GetCursorLimit() + 1)
+
+	if *value == "" {
+		return filters, opts, nil
+	}
+
+	cursor, err := DecodeCursor(*value)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cursor.Field != field || cursor.Order != sortOrder {
+		return nil, nil, errors.New("Cursor does not match the sort")
+	}
+
+	operator := "$gt"
+	if order < 0 {
+		operator = "$lt"
+	}
+
+	// Comparisons never match missing values, which sort before any other:
+	// ascending they are all behind a cursor on a value, descending ahead
+	var past bson.A
+	if cursor.Value == nil {
+		past = bson.A{bson.M{field: nil, "id": bson.M{operator: cursor.Id}}}
+		if order > 0 {
+			past = append(past, bson.M{field: bson.M{"$ne": nil}})
+		}
+	} else {
+		past = bson.A{
+			bson.M{field: bson.M{operator: cursor.Value}},
+			bson.M{field: cursor.Value, "id": bson.M{operator: cursor.Id}},
+		}
+		if order < 0 {
+			past = append(past, bson.M{field: nil})
+		}
+	}
+
+	return bson.M{"$and": bson.A{filters, bson.M{"$or": past}}}, opts, nil
+}
@@ -0,0 +1,298 @@
+package models
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldType tells how the values of a filter on a field are parsed and which
+// operators apply to it.
+type FieldType int
+
+const (
+	StringField FieldType = iota
+	StringsField
+	TimeField
+	NumberField
+	BoolField
+)
+
+// Field is a field a list may be filtered and sorted on. Key is where it is
+// stored, when that differs from its json name.
+type Field struct {
+	Type FieldType
+	Key  string
+}
+
+// Fields whitelists the fields of a resource, by json name, that ?filter=
+// and ?sort= accept.
+type Fields map[string]Field
+
+func (f Fields) get(name string) (Field, string, bool) {
+	field, ok := f[name]
+	if !ok {
+		return field, "", false
+	}
+	if field.Key == "" {
+		return field, name, true
+	}
+
+	return field, field.Key, true
+}
+
+var (
+	TaskFields = Fields{
+		"id":          {Type: StringField},
+		"workspaceId": {Type: StringField},
+		"projectId":   {Type: StringField},
+		"stateId":     {Type: StringField},
+		"title":       {Type: StringField},
+		"code":        {Type: StringField},
+		"description": {Type: StringField},
+		"startDate":   {Type: TimeField},
+		"endDate":     {Type: TimeField},
+		"labelIds":    {Type: StringsField},
+		"assigneeIds": {Type: StringsField, Key: "assigneeids"},
+		"version":     {Type: NumberField},
+		"createdAt":   {Type: TimeField},
+		"updatedAt":   {Type: TimeField},
+	}
+	StateFields = Fields{
+		"id":          {Type: StringField},
+		"workspaceId": {Type: StringField},
+		"projectId":   {Type: StringField},
+		"name":        {Type: StringField},
+		"version":     {Type: NumberField},
+		"createdAt":   {Type: TimeField},
+		"updatedAt":   {Type: TimeField},
+	}
+	ProjectFields = Fields{
+		"id":          {Type: StringField},
+		"workspaceId": {Type: StringField},
+		"name":        {Type: StringField},
+		"code":        {Type: StringField},
+		"description": {Type: StringField},
+		"userIds":     {Type: StringsField, Key: "userids"},
+		"version":     {Type: NumberField},
+		"createdAt":   {Type: TimeField},
+		"updatedAt":   {Type: TimeField},
+	}
+	WorkspaceFields = Fields{
+		"id":         {Type: StringField},
+		"name":       {Type: StringField},
+		"code":       {Type: StringField},
+		"size":       {Type: StringField},
+		"userIds":    {Type: StringsField, Key: "userids"},
+		"requireMfa": {Type: BoolField},
+		"version":    {Type: NumberField},
+		"createdAt":  {Type: TimeField},
+		"updatedAt":  {Type: TimeField},
+	}
+	TaskLabelFields = Fields{
+		"id":          {Type: StringField},
+		"workspaceId": {Type: StringField},
+		"projectId":   {Type: StringField},
+		"label":       {Type: StringField},
+		"color":       {Type: StringField},
+		"version":     {Type: NumberField},
+		"createdAt":   {Type: TimeField},
+		"updatedAt":   {Type: TimeField},
+	}
+)
+
+const filterOperators = "eq, ne, in, nin, gt, lt, between, exists, contains, all"
+
+// parseValue parses a filter value the way the field is stored.
+func parseValue(name string, field Field, value string) (interface{}, error) {
+	switch field.Type {
+	case TimeField:
+		for _, layout := range []string{time.RFC3339, time.DateOnly} {
+			if date, err := time.Parse(layout, value); err == nil {
+				return date, nil
+			}
+		}
+		return nil, fmt.Errorf("%s takes a date, as 2006-01-02 or 2006-01-02T15:04:05Z, not %q", name, value)
+	case NumberField:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s takes a number, not %q", name, value)
+		}
+		return number, nil
+	case BoolField:
+		flag, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s takes true or false, not %q", name, value)
+		}
+		return flag, nil
+	}
+
+	return value, nil
+}
+
+func parseValues(name string, field Field, value string) (bson.A, error) {
+	values := bson.A{}
+	for _, item := range strings.Split(value, ",") {
+		parsed, err := parseValue(name, field, strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, parsed)
+	}
+
+	return values, nil
+}
+
+// parseCondition turns one field:operator:value filter into a find
+// condition.
+func parseCondition(fields Fields, condition string) (bson.M, error) {
+	parts := strings.SplitN(condition, ":", 3)
+	if len(parts) != 3 || parts[0] == "" {
+		return nil, fmt.Errorf("Filter %q should be field:operator:value", condition)
+	}
+
+	name, operator, value := parts[0], parts[1], parts[2]
+	field, key, ok := fields.get(name)
+	if !ok {
+		return nil, fmt.Errorf("Cannot filter on %s", name)
+	}
+
+	ordered := field.Type == StringField || field.Type == TimeField || field.Type == NumberField
+
+	switch operator {
+	case "eq", "ne":
+		parsed, err := parseValue(name, field, value)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{key: bson.M{"$" + operator: parsed}}, nil
+	case "in", "nin":
+		values, err := parseValues(name, field, value)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{key: bson.M{"$" + operator: values}}, nil
+	case "gt", "lt":
+		if !ordered {
+			break
+		}
+		parsed, err := parseValue(name, field, value)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{key: bson.M{"$" + operator: parsed}}, nil
+	case "between":
+		if !ordered {
+			break
+		}
+		values, err := parseValues(name, field, value)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != 2 {
+			return nil, fmt.Errorf("between takes two values, as %s:between:from,to", name)
+		}
+		return bson.M{key: bson.M{"$gte": values[0], "$lte": values[1]}}, nil
+	case "exists":
+		exists, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("exists takes true or false, not %q", value)
+		}
+		// Empty lists are stored as null, so a list exists with a first item
+		if field.Type == StringsField {
+			return bson.M{key + ".0": bson.M{"$exists": exists}}, nil
+		}
+		if exists {
+			return bson.M{key: bson.M{"$exists": true, "$ne": nil}}, nil
+		}
+		return bson.M{key: nil}, nil
+	case "contains":
+		if field.Type == StringsField {
+			return bson.M{key: value}, nil
+		}
+		if field.Type != StringField {
+			break
+		}
+		return bson.M{key: primitive.Regex{Pattern: regexp.QuoteMeta(value), Options: "i"}}, nil
+	case "all":
+		if field.Type != StringsField {
+			break
+		}
+		values, err := parseValues(name, field, value)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{key: bson.M{"$all": values}}, nil
+	default:
+		return nil, fmt.Errorf("Unknown filter operator %s, use one of %s", operator, filterOperators)
+	}
+
+	return nil, fmt.Errorf("Operator %s does not apply to %s", operator, name)
+}
+
+// GetFilter parses filter, conditions joined by ";" that documents must all
+// meet, e.g. assigneeIds:in:u1,u2;endDate:lt:2026-11-01.
+func (q Query) GetFilter(fields Fields) ([]bson.M, error) {
+	conditions := make([]bson.M, 0)
+	for _, condition := range strings.Split(q.Filter, ";") {
+		condition = strings.TrimSpace(condition)
+		if condition == "" {
+			continue
+		}
+
+		parsed, err := parseCondition(fields, condition)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, parsed)
+	}
+
+	return conditions, nil
+}
+
+// parseSortKey parses one field,order sort key. The order is 1 or asc, -1
+// or desc, ascending when left out.
+func parseSortKey(key string) (string, int64, error) {
+	name, value, _ := strings.Cut(strings.TrimSpace(key), ",")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", 0, fmt.Errorf("Sort %q should be field,order", key)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "1", "asc":
+		return name, 1, nil
+	case "-1", "desc":
+		return name, -1, nil
+	}
+
+	return "", 0, fmt.Errorf("Sort order of %s should be 1, -1, asc or desc, not %q", name, value)
+}
+
+// GetSort parses sort, keys joined by ";" applied in turn, e.g.
+// endDate,-1;title,1.
+func (q Query) GetSort(fields Fields) (bson.D, error) {
+	sort := bson.D{}
+	for _, key := range strings.Split(q.Sort, ";") {
+		if strings.TrimSpace(key) == "" {
+			continue
+		}
+
+		name, order, err := parseSortKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		field, stored, ok := fields.get(name)
+		if !ok || field.Type == StringsField {
+			return nil, fmt.Errorf("Cannot sort on %s", name)
+		}
+		sort = append(sort, bson.E{Key: stored, Value: order})
+	}
+
+	return sort, nil
+}
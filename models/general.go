@@ -31,9 +31,22 @@ type BasicDate struct {
 	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
 }
 
+// SoftDelete marks a document in the trash. DeletedWith is the id of the
+// document whose deletion cascaded to this one, empty when it was deleted on
+// its own.
+type SoftDelete struct {
+	DeletedAt   *time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	DeletedBy   string     `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"`
+	DeletedWith string     `json:"deletedWith,omitempty" bson:"deletedWith,omitempty"`
+}
+
 type AuthResult struct {
-	Token string `json:"token"`
-	Id    string `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	Id           string    `json:"id"`
+	Name         string    `json:"name"`
+	Email        string    `json:"email"`
+	MfaRequired  bool      `json:"mfaRequired,omitempty"`
+	MfaToken     string    `json:"mfaToken,omitempty"`
 }
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+	InvitationExpired  = "expired"
+	InvitationRevoked  = "revoked"
+)
+
+type Invitation struct {
+	Id          string     `json:"id"`
+	WorkspaceId string     `json:"workspaceId" bson:"workspaceId"`
+	Email       string     `json:"email"`
+	Role        string     `json:"role"`
+	Status      string     `json:"status"`
+	InvitedBy   string     `json:"invitedBy" bson:"invitedBy"`
+	ExpiresAt   time.Time  `json:"expiresAt" bson:"expiresAt"`
+	SentAt      time.Time  `json:"sentAt" bson:"sentAt"`
+	RespondedAt *time.Time `json:"respondedAt" bson:"respondedAt"`
+	Workspace   *Workspace `json:"workspace,omitempty" bson:"-"`
+	BasicDate   `bson:",inline"`
+}
+
+// GetStatus reports pending invitations past their expiry as expired.
+func (i Invitation) GetStatus() string {
+	if i.Status == InvitationPending && i.ExpiresAt.Before(time.Now()) {
+		return InvitationExpired
+	}
+
+	return i.Status
+}
+
+type InvitationRequest struct {
+	Email string `json:"email" binding:"required"`
+	Role  string `json:"role"`
+}
+
+type InvitationMail struct {
+	Name      string `json:"name"`
+	Workspace string `json:"workspace"`
+	Inviter   string `json:"inviter"`
+	Role      string `json:"role"`
+	Link      string `json:"link"`
+}
@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+const (
+	JoinRequestPending  = "pending"
+	JoinRequestApproved = "approved"
+	JoinRequestRejected = "rejected"
+)
+
+// JoinRequest is created when a user with an allowed email domain asks to
+// join a workspace that requires approval.
+type JoinRequest struct {
+	Id          string     `json:"id"`
+	WorkspaceId string     `json:"workspaceId" bson:"workspaceId"`
+	UserId      string     `json:"userId" bson:"userId"`
+	Email       string     `json:"email"`
+	Status      string     `json:"status"`
+	DecidedBy   string     `json:"decidedBy" bson:"decidedBy"`
+	DecidedAt   *time.Time `json:"decidedAt" bson:"decidedAt"`
+	User        *User      `json:"user,omitempty" bson:"-"`
+	BasicDate   `bson:",inline"`
+}
+
+// InviteLink is a shareable link anybody signed in can use to join a
+// workspace, until it expires or runs out of uses.
+type InviteLink struct {
+	Id          string     `json:"id"`
+	WorkspaceId string     `json:"workspaceId" bson:"workspaceId"`
+	Role        string     `json:"role"`
+	Hash        string     `json:"-"`
+	Token       string     `json:"token,omitempty" bson:"-"` // Only returned once, on creation
+	Link        string     `json:"link,omitempty" bson:"-"`
+	MaxUses     int        `json:"maxUses" bson:"maxUses"` // 0 means unlimited
+	Uses        int        `json:"uses"`
+	ExpiresAt   *time.Time `json:"expiresAt" bson:"expiresAt"`
+	RevokedAt   *time.Time `json:"revokedAt" bson:"revokedAt"`
+	CreatedBy   string     `json:"createdBy" bson:"createdBy"`
+	Workspace   *Workspace `json:"workspace,omitempty" bson:"-"`
+	BasicDate   `bson:",inline"`
+}
+
+func (l InviteLink) IsUsable(now time.Time) bool {
+	if l.RevokedAt != nil {
+		return false
+	}
+
+	if l.ExpiresAt != nil && l.ExpiresAt.Before(now) {
+		return false
+	}
+
+	return l.MaxUses == 0 || l.Uses < l.MaxUses
+}
+
+type InviteLinkRequest struct {
+	Role      string     `json:"role"`
+	MaxUses   int        `json:"maxUses"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type JoinableWorkspace struct {
+	Id               string `json:"id"`
+	Name             string `json:"name"`
+	Code             string `json:"code"`
+	RequiresApproval bool   `json:"requiresApproval"`
+	Pending          bool   `json:"pending"`
+}
@@ -5,8 +5,9 @@ import (
 )
 
 type Claims struct {
-	UserId 			string 		`json:"userId"`
-	Email  			string   	`json:"email"`
+	UserId    string `json:"userId"`
+	Email     string `json:"email"`
+	SessionId string `json:"sid,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -14,4 +15,3 @@ type TokenEndExpire struct {
 	Token  string
 	Expire string
 }
-
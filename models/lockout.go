@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+type Lockout struct {
+	Key           string    `json:"key"` // "account:<email>" or "ip:<address>"
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"lastFailureAt" bson:"lastFailureAt"`
+	NextAttemptAt time.Time `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	LockedUntil   time.Time `json:"lockedUntil" bson:"lockedUntil"`
+}
+
+func (l Lockout) IsLocked(now time.Time) bool {
+	return now.Before(l.LockedUntil)
+}
+
+func (l Lockout) IsThrottled(now time.Time) bool {
+	return now.Before(l.NextAttemptAt) || l.IsLocked(now)
+}
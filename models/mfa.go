@@ -0,0 +1,20 @@
+package models
+
+type MfaEnrollment struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+	QrCode string `json:"qrCode"` // PNG as a data url
+}
+
+type MfaCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type MfaLoginRequest struct {
+	MfaToken string `json:"mfaToken" binding:"required"`
+	Code     string `json:"code" binding:"required"` // TOTP or recovery code
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"codes"`
+}
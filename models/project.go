@@ -10,5 +10,7 @@ type Project struct {
 	UserIds     []string  `json:"userIds"`
 	Members     []User    `json:"members" bson:"-"`
 	Workspace   Workspace `json:"workspace" bson:"-"`
+	Version     int64     `json:"version" bson:"version"`
+	SoftDelete  `bson:",inline"`
 	BasicDate   `bson:",inline"`
 }
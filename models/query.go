@@ -4,21 +4,26 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"math"
 	"strconv"
 	"strings"
 )
 
 type Query struct {
-	Keyword     string `form:"keyword"`
-	Sort        string `form:"sort"`
-	Limit       string `form:"limit"`
-	Page        string `form:"page"`
-	UserId      string `form:"user"`
-	ProjectId   string `form:"project"`
-	WorkspaceId string `form:"workspace"`
-	Assigned    string `form:"assigned"`
-	Completed   string `form:"completed"`
+	Keyword     string  `form:"keyword"`
+	Sort        string  `form:"sort"`
+	Limit       string  `form:"limit"`
+	Page        string  `form:"page"`
+	UserId      string  `form:"user"`
+	ProjectId   string  `form:"project"`
+	WorkspaceId string  `form:"workspace"`
+	Assigned    string  `form:"assigned"`
+	Completed   string  `form:"completed"`
+	Filter      string  `form:"filter"`
+	View        string  `form:"view"`                                  // Id of a saved view of tasks
+	Render      string  `form:"render" binding:"omitempty,oneof=html"` // html renders task descriptions
+	Include     *string `form:"include"`
+	After       *string `form:"after"`
+	Before      *string `form:"before"`
 }
 
 type Pagination struct {
@@ -33,6 +38,9 @@ type Result struct {
 	Data       interface{} `json:"data"`
 	Pagination Pagination  `json:"pagination"`
 	Query      Query       `json:"query"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+	PrevCursor string      `json:"prevCursor,omitempty"`
+	View       *View       `json:"view,omitempty"` // Saved view the list was read with
 }
 
 func (q Query) GetPagination(count int64) Pagination {
@@ -42,7 +50,9 @@ func (q Query) GetPagination(count int64) Pagination {
 
 	if q.Limit != "" {
 		limit, _ = strconv.ParseInt(q.Limit, 10, 64)
-		pages = int64(math.Round(float64(count) / float64(limit)))
+	}
+	if limit > 0 {
+		pages = (count + limit - 1) / limit
 	}
 
 	if q.Page != "" {
@@ -60,19 +70,37 @@ func (q Query) GetPagination(count int64) Pagination {
 	}
 }
 
-func (q Query) GetOptions() *options.FindOptions {
+// GetInclude lists the relations asked for with include, a comma separated
+// list, or the defaults when the parameter is missing. An empty include asks
+// for none.
+func (q Query) GetInclude(defaults []string) []string {
+	if q.Include == nil {
+		return defaults
+	}
+
+	include := make([]string, 0)
+	for _, relation := range strings.Split(*q.Include, ",") {
+		relation = strings.TrimSpace(relation)
+		if relation != "" {
+			include = append(include, relation)
+		}
+	}
+
+	return include
+}
+
+func (q Query) GetOptions(fields Fields) (*options.FindOptions, error) {
 	opts := options.Find()
 
 	limit, _ := strconv.ParseInt(q.Limit, 10, 64)
 	opts.SetLimit(limit)
 
-	if q.Sort != "" {
-		splitted := strings.Split(q.Sort, ",")
-
-		key := splitted[0]
-		value, _ := strconv.ParseInt(splitted[1], 10, 64)
-
-		opts.SetSort(bson.D{{key, value}})
+	sort, err := q.GetSort(fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(sort) > 0 {
+		opts.SetSort(sort)
 	}
 
 	page := int64(1)
@@ -83,10 +111,10 @@ func (q Query) GetOptions() *options.FindOptions {
 	skip := page*limit - limit
 	opts.SetSkip(skip)
 
-	return opts
+	return opts, nil
 }
 
-func (q Query) GetQueryFind() bson.M {
+func (q Query) GetQueryFind(fields Fields) (bson.M, error) {
 	query := bson.M{}
 
 	if q.Keyword != "" {
@@ -94,8 +122,8 @@ func (q Query) GetQueryFind() bson.M {
 			Pattern: "^" + q.Keyword,
 			Options: "i",
 		}
-		query["name"] = regex
-		query["title"] = regex
+		// Projects and workspaces have a name, tasks a title
+		query["$or"] = []bson.M{{"name": regex}, {"title": regex}}
 	}
 
 	if q.ProjectId != "" {
@@ -106,5 +134,13 @@ func (q Query) GetQueryFind() bson.M {
 		query["workspaceId"] = q.WorkspaceId
 	}
 
-	return query
+	conditions, err := q.GetFilter(fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(conditions) > 0 {
+		query["$and"] = conditions
+	}
+
+	return query, nil
 }
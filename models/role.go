@@ -0,0 +1,39 @@
+package models
+
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleGuest  = "guest"
+)
+
+type Permission string
+
+const (
+	PermissionRead   Permission = "read"
+	PermissionWrite  Permission = "write"
+	PermissionManage Permission = "manage"
+	PermissionDelete Permission = "delete"
+)
+
+var RolePermissions = map[string][]Permission{
+	RoleOwner:  {PermissionRead, PermissionWrite, PermissionManage, PermissionDelete},
+	RoleAdmin:  {PermissionRead, PermissionWrite, PermissionManage},
+	RoleMember: {PermissionRead, PermissionWrite},
+	RoleGuest:  {PermissionRead},
+}
+
+func IsValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+func RoleHasPermission(role string, permission Permission) bool {
+	for _, p := range RolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
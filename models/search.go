@@ -0,0 +1,57 @@
+package models
+
+const (
+	SearchTask      = "task"
+	SearchProject   = "project"
+	SearchWorkspace = "workspace"
+
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// SearchField is a text of a document the search looks into. Matches in
+// fields of a higher weight rank first.
+type SearchField struct {
+	Name   string
+	Text   string
+	Weight float64
+}
+
+// SearchDocument is what a search index keeps of a task, project or
+// workspace. Fields start with the one shown as the title of hits.
+type SearchDocument struct {
+	Kind        string
+	Id          string
+	WorkspaceId string
+	ProjectId   string
+	Fields      []SearchField
+}
+
+type SearchQuery struct {
+	Q            string   `form:"q" binding:"required"`
+	WorkspaceId  string   `form:"workspace"`
+	Kind         string   `form:"kind"` // task, project or workspace, all when empty
+	Limit        int      `form:"limit"`
+	WorkspaceIds []string `form:"-"` // Scope of the search, set by the controller
+}
+
+// GetLimit is the number of hits asked for, DefaultSearchLimit by default.
+func (q SearchQuery) GetLimit() int {
+	if q.Limit <= 0 {
+		return DefaultSearchLimit
+	}
+
+	return min(q.Limit, MaxSearchLimit)
+}
+
+// SearchHit is a document found by a search. Highlights holds the matching
+// fields, HTML escaped, with the matches wrapped in <mark>.
+type SearchHit struct {
+	Kind        string            `json:"kind"`
+	Id          string            `json:"id"`
+	WorkspaceId string            `json:"workspaceId"`
+	ProjectId   string            `json:"projectId,omitempty"`
+	Title       string            `json:"title"`
+	Score       float64           `json:"score"`
+	Highlights  map[string]string `json:"highlights"`
+}
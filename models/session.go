@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+type Session struct {
+	Id             string     `json:"id"`
+	UserId         string     `json:"userId" bson:"userId"`
+	TokenHash      string     `json:"-" bson:"tokenHash"`
+	PreviousHashes []string   `json:"-" bson:"previousHashes"`
+	UserAgent      string     `json:"userAgent" bson:"userAgent"`
+	Ip             string     `json:"ip"`
+	ExpiresAt      time.Time  `json:"expiresAt" bson:"expiresAt"`
+	LastUsedAt     time.Time  `json:"lastUsedAt" bson:"lastUsedAt"`
+	RevokedAt      *time.Time `json:"revokedAt" bson:"revokedAt"`
+	Current        bool       `json:"current" bson:"-"`
+	BasicDate      `bson:",inline"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
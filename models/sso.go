@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+const DefaultSsoProvider = "default"
+
+// SsoProvider is an OpenID Connect identity provider. Workspaces can bring
+// their own; the "default" one is configured through OIDC_* variables.
+type SsoProvider struct {
+	Id           string   `json:"id"`
+	WorkspaceId  string   `json:"workspaceId" bson:"workspaceId"`
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientId     string   `json:"clientId" bson:"clientId"`
+	ClientSecret string   `json:"clientSecret,omitempty" bson:"clientSecret"`
+	Scopes       []string `json:"scopes"`
+	Enforced     bool     `json:"enforced"`                       // Members may only sign in through this provider
+	DefaultRole  string   `json:"defaultRole" bson:"defaultRole"` // When set, users signing in join the workspace
+	BasicDate    `bson:",inline"`
+}
+
+// SsoState is the pending part of an authorization code flow, kept until
+// the identity provider redirects back.
+type SsoState struct {
+	Id           string    `json:"id"` // Hash of the state parameter
+	ProviderId   string    `json:"providerId" bson:"providerId"`
+	CodeVerifier string    `json:"-" bson:"codeVerifier"`
+	Nonce        string    `json:"-"`
+	RedirectUri  string    `json:"redirectUri" bson:"redirectUri"`
+	UserId       string    `json:"userId" bson:"userId"` // Signed in user linking the identity, empty for a login
+	ExpiresAt    time.Time `json:"expiresAt" bson:"expiresAt"`
+	CreatedAt    time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+type Identity struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+}
+
+type OidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+type OidcIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
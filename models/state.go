@@ -8,5 +8,7 @@ type State struct {
 	Workspace   Workspace `json:"workspace" bson:"-"`
 	Project     Project   `json:"project" bson:"-"`
 	Tasks       []Task    `json:"tasks" bson:"-"`
+	Version     int64     `json:"version" bson:"version"`
+	SoftDelete  `bson:",inline"`
 	BasicDate   `bson:",inline"`
 }
@@ -8,21 +8,44 @@ type TaskLabel struct {
 	ProjectId   string `json:"projectId" bson:"projectId"`
 	Label       string `json:"label"`
 	Color       string `json:"color"`
+	Version     int64  `json:"version" bson:"version"`
+	SoftDelete  `bson:",inline"`
 	BasicDate   `bson:",inline"`
 }
 
 type Task struct {
-	Id          string      `json:"id"`
-	WorkspaceId string      `json:"workspaceId" bson:"workspaceId"`
-	ProjectId   string      `json:"projectId" bson:"projectId"`
-	StateId     string      `json:"stateId" bson:"stateId"`
-	Title       string      `json:"title"`
-	Code        string      `json:"description"`
-	StartDate   time.Time   `json:"startDate" bson:"startDate"`
-	EndDate     time.Time   `json:"endDate" bson:"endDate"`
-	LabelIds    []string    `json:"labelIds" bson:"labelIds"` // Task Label ids
-	Labels      []TaskLabel `json:"labels" bson:"-"`
-	AssigneeIds []string    `json:"assigneeIds"`
-	Assignees   []User      `json:"assignees" bson:"-"` // User id
-	BasicDate   `bson:",inline"`
+	Id              string      `json:"id"`
+	WorkspaceId     string      `json:"workspaceId" bson:"workspaceId"`
+	ProjectId       string      `json:"projectId" bson:"projectId"`
+	StateId         string      `json:"stateId" bson:"stateId"`
+	Title           string      `json:"title"`
+	Code            string      `json:"code"`                               // Key, <project code>-<number>, set by the server
+	PreviousKeys    []string    `json:"previousKeys" bson:"previousKeys"`   // Keys the task had in the projects it moved from
+	Description     string      `json:"description" binding:"max=65536"`    // Markdown
+	DescriptionHtml string      `json:"descriptionHtml,omitempty" bson:"-"` // Description rendered with ?render=html
+	StartDate       time.Time   `json:"startDate" bson:"startDate"`
+	EndDate         time.Time   `json:"endDate" bson:"endDate"`
+	LabelIds        []string    `json:"labelIds" bson:"labelIds"` // Task Label ids
+	Labels          []TaskLabel `json:"labels" bson:"-"`
+	AssigneeIds     []string    `json:"assigneeIds"`
+	Assignees       []User      `json:"assignees" bson:"-"` // User id
+	Version         int64       `json:"version" bson:"version"`
+	SoftDelete      `bson:",inline"`
+	BasicDate       `bson:",inline"`
+}
+
+type TaskMoveRequest struct {
+	TaskIds   []string `json:"taskIds" binding:"required,min=1"`
+	ProjectId string   `json:"projectId" binding:"required"`
+	StateId   string   `json:"stateId"`
+}
+
+// TaskBulkRequest changes the fields that are set on every task.
+type TaskBulkRequest struct {
+	TaskIds     []string   `json:"taskIds" binding:"required,min=1"`
+	StateId     *string    `json:"stateId"`
+	AssigneeIds *[]string  `json:"assigneeIds"`
+	LabelIds    *[]string  `json:"labelIds"`
+	StartDate   *time.Time `json:"startDate"`
+	EndDate     *time.Time `json:"endDate"`
 }
@@ -0,0 +1,11 @@
+package models
+
+// Trash lists what was deleted on its own in a workspace, and the workspace
+// itself when it is in the trash.
+type Trash struct {
+	Workspace  *Workspace  `json:"workspace"`
+	Projects   []Project   `json:"projects"`
+	States     []State     `json:"states"`
+	Tasks      []Task      `json:"tasks"`
+	TaskLabels []TaskLabel `json:"taskLabels"`
+}
@@ -16,6 +16,16 @@ type User struct {
 	Country    string    `json:"country"`
 	City       string    `json:"city"`
 	CreatedBy  string    `json:"createdBy"`
+	Admin      bool      `json:"admin"`
+	Service    bool      `json:"service"` // Service account behind a workspace API key
+
+	MfaEnabled        bool     `json:"mfaEnabled" bson:"mfaEnabled"`
+	TotpSecret        string   `json:"-" bson:"totpSecret"`
+	TotpPendingSecret string   `json:"-" bson:"totpPendingSecret"`
+	TotpLastStep      int64    `json:"-" bson:"totpLastStep"`
+	RecoveryCodes     []string `json:"-" bson:"recoveryCodes"` // Hashed
+
+	Identities []Identity `json:"identities"` // Linked single sign-on accounts
 	BasicDate  `bson:",inline"`
 }
 
@@ -25,7 +35,8 @@ type Login struct {
 }
 
 type Register struct {
-	Email    string `json:"email" biding:"required"`
-	Name     string `json:"name" biding:"required"`
-	Password string `json:"password" biding:"required"`
+	Email       string `json:"email" biding:"required"`
+	Name        string `json:"name" biding:"required"`
+	Password    string `json:"password" biding:"required"`
+	InviteToken string `json:"inviteToken"`
 }
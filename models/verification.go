@@ -0,0 +1,42 @@
+package models
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"time"
+)
+
+const (
+	PurposeActivate      = "activate"
+	PurposeResetPassword = "reset-password"
+	PurposeEmailChange   = "email-change"
+	PurposeInvite        = "invite"
+	PurposeUnlock        = "unlock"
+	PurposeMfa           = "mfa"
+	PurposeSso           = "sso"
+)
+
+type VerificationClaims struct {
+	Email     string `json:"email"`
+	Purpose   string `json:"purpose"`
+	Reference string `json:"ref,omitempty"`
+	jwt.StandardClaims
+}
+
+// VerificationToken tracks a link sent by email so it can only be used once.
+type VerificationToken struct {
+	Id        string     `json:"id"`
+	Email     string     `json:"email"`
+	Purpose   string     `json:"purpose"`
+	Reference string     `json:"reference"` // New email address, invitation id, ...
+	ExpiresAt time.Time  `json:"expiresAt" bson:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt" bson:"usedAt"`
+	CreatedAt time.Time  `json:"createdAt" bson:"createdAt"`
+}
+
+type TokenRequest struct {
+	Token string `json:"token"`
+}
+
+type EmailChangeRequest struct {
+	Email string `json:"email" binding:"required"`
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// View is a saved task list: a filter and sort in the syntax of Query, how
+// the list is grouped and the columns it shows. A private view is only seen
+// by its owner, a shared one by the whole workspace.
+type View struct {
+	Id          string   `json:"id"`
+	WorkspaceId string   `json:"workspaceId" bson:"workspaceId"`
+	ProjectId   string   `json:"projectId" bson:"projectId"`
+	UserId      string   `json:"userId" bson:"userId"` // Owner
+	Name        string   `json:"name" binding:"required"`
+	Filter      string   `json:"filter"`
+	Sort        string   `json:"sort"`
+	GroupBy     string   `json:"groupBy" bson:"groupBy"`
+	Columns     []string `json:"columns"`
+	Shared      bool     `json:"shared"`
+	Version     int64    `json:"version" bson:"version"`
+	BasicDate   `bson:",inline"`
+}
+
+var ViewFields = Fields{
+	"id":          {Type: StringField},
+	"workspaceId": {Type: StringField},
+	"projectId":   {Type: StringField},
+	"userId":      {Type: StringField},
+	"name":        {Type: StringField},
+	"shared":      {Type: BoolField},
+	"createdAt":   {Type: TimeField},
+	"updatedAt":   {Type: TimeField},
+}
+
+// ViewGroups are the task fields a view may group the list by, none when
+// empty.
+var ViewGroups = []string{"", "stateId", "projectId", "assigneeIds", "labelIds"}
+
+// viewColumns are the columns a view may show besides the task fields.
+var viewColumns = []string{"labels", "assignees"}
+
+// Validate checks the filter and sort parse, and the grouping and columns
+// are ones of tasks.
+func (v View) Validate() error {
+	query := Query{Filter: v.Filter, Sort: v.Sort}
+
+	_, err := query.GetFilter(TaskFields)
+	if err != nil {
+		return err
+	}
+
+	_, err = query.GetSort(TaskFields)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(ViewGroups, v.GroupBy) {
+		return fmt.Errorf("Cannot group by %s", v.GroupBy)
+	}
+
+	for _, column := range v.Columns {
+		if _, ok := TaskFields[column]; !ok && !slices.Contains(viewColumns, column) {
+			return fmt.Errorf("Unknown column %s", column)
+		}
+	}
+
+	if v.WorkspaceId == "" {
+		return errors.New("View needs a workspace or a project")
+	}
+
+	return nil
+}
+
+func (v View) IsVisibleTo(userId string) bool {
+	return v.Shared || v.UserId == userId
+}
+
+// Apply narrows a task query to the view. The view filter adds to the one
+// of the query and its sort applies unless the query has one. The list stays
+// in the workspace of the view, and in its project when it has one.
+func (v View) Apply(q Query) Query {
+	q.Filter = strings.Trim(v.Filter+";"+q.Filter, ";")
+
+	if q.Sort == "" {
+		q.Sort = v.Sort
+	}
+	q.WorkspaceId = v.WorkspaceId
+	if v.ProjectId != "" {
+		q.ProjectId = v.ProjectId
+	}
+
+	return q
+}
@@ -1,12 +1,101 @@
 package models
 
+import (
+	"slices"
+	"strings"
+)
+
 type Workspace struct {
-	Id        string   `json:"id"`
-	Name      string   `json:"name"`
-	Code      string   `json:"code"`
-	Endpoint  string   `json:"endpoint"`
-	Size      string   `json:"size"`
-	UserIds   []string `json:"userIds"`
-	Members   []User   `json:"members" bson:"-"`
-	BasicDate `bson:",inline"`
+	Id         string            `json:"id"`
+	Name       string            `json:"name"`
+	Code       string            `json:"code"`
+	Endpoint   string            `json:"endpoint"`
+	Size       string            `json:"size"`
+	UserIds    []string          `json:"userIds"`
+	Roles      map[string]string `json:"roles"` // User id => role
+	RequireMfa bool              `json:"requireMfa" bson:"requireMfa"`
+
+	AllowedDomains     []string `json:"allowedDomains" bson:"allowedDomains"`         // Users with these email domains may join
+	DomainJoinApproval bool     `json:"domainJoinApproval" bson:"domainJoinApproval"` // Domain joins wait for an admin
+	Members            []User   `json:"members" bson:"-"`
+	Version            int64    `json:"version" bson:"version"`
+	SoftDelete         `bson:",inline"`
+	BasicDate          `bson:",inline"`
+}
+
+// GetRole returns the role of a user in the workspace. Workspaces created
+// before roles existed only have UserIds: the first user is treated as the
+// owner and everybody else as a plain member.
+func (w Workspace) GetRole(userId string) string {
+	if role, ok := w.Roles[userId]; ok {
+		return role
+	}
+
+	if !slices.Contains(w.UserIds, userId) {
+		return ""
+	}
+
+	if len(w.Roles) == 0 && w.UserIds[0] == userId {
+		return RoleOwner
+	}
+
+	return RoleMember
+}
+
+func (w Workspace) HasPermission(userId string, permission Permission) bool {
+	return RoleHasPermission(w.GetRole(userId), permission)
+}
+
+// SyncRoles keeps UserIds and Roles consistent: every user id gets a role and
+// roles of users no longer in the workspace are dropped.
+func (w *Workspace) SyncRoles() {
+	if w.Roles == nil {
+		w.Roles = map[string]string{}
+	}
+
+	for _, id := range w.UserIds {
+		if !IsValidRole(w.Roles[id]) {
+			delete(w.Roles, id)
+			w.Roles[id] = w.GetRole(id)
+		}
+	}
+
+	for id := range w.Roles {
+		if !slices.Contains(w.UserIds, id) {
+			delete(w.Roles, id)
+		}
+	}
+}
+
+func (w Workspace) CountOwners() int {
+	count := 0
+	for _, role := range w.Roles {
+		if role == RoleOwner {
+			count++
+		}
+	}
+
+	return count
+}
+
+func EmailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+
+	return strings.ToLower(strings.TrimSpace(email[at+1:]))
+}
+
+// NormalizeDomains lower cases domains and strips a leading "@".
+func (w *Workspace) NormalizeDomains() {
+	domains := make([]string, 0, len(w.AllowedDomains))
+	for _, domain := range w.AllowedDomains {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if domain != "" && !slices.Contains(domains, domain) {
+			domains = append(domains, domain)
+		}
+	}
+
+	w.AllowedDomains = domains
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/contrib/static"
+	"github.com/gin-gonic/gin"
+	"kickof/config"
+	"kickof/controllers"
+	"kickof/models"
+	"net/http"
+	"os"
+	"time"
+)
+
+// NewRouter builds the HTTP API. The datastore must be set up first.
+func NewRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(gin.Logger())
+
+	router.Use(static.Serve("/", static.LocalFile("./dist", true)))
+
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowAllOrigins = true
+	corsConfig.AllowMethods = []string{"POST", "GET", "PATCH", "OPTIONS", "DELETE"}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", "Accept", "User-Agent", "Cache-Control", "Pragma", "If-Match", "If-None-Match"}
+	corsConfig.ExposeHeaders = []string{"Content-Length", "ETag"}
+	corsConfig.AllowCredentials = true
+	corsConfig.MaxAge = 12 * time.Hour
+	router.Use(cors.New(corsConfig))
+
+	api := router.Group("/api")
+	{
+		api.GET("/version", func(c *gin.Context) {
+			c.JSON(http.StatusOK, models.Response{
+				Data: "KickOf Api v" + os.Getenv("VERSION"),
+			})
+			return
+		})
+
+		api.POST("/register", controllers.SignUp)
+		api.POST("/login", controllers.SignIn)
+		api.POST("/login/mfa", controllers.SignInMfa)
+		api.POST("/refresh-token", controllers.RefreshToken)
+		api.POST("/activate", controllers.Activate)
+		api.POST("/activate/:token", controllers.Activate)
+		api.POST("/forgot-password", controllers.ForgotPassword)
+		api.POST("/reset-password", controllers.UpdatePassword)
+		api.POST("/confirm-email", controllers.ConfirmEmailChange)
+		api.POST("/unlock", controllers.UnlockAccount)
+
+		api.GET("/sso/:provider/login", controllers.SsoLogin)
+		api.GET("/sso/callback", controllers.SsoCallback)
+		api.POST("/sso/exchange", controllers.SsoExchange)
+
+		api.GET("/invitations/token/:token", controllers.GetInvitationByToken)
+		api.POST("/invitations/decline", controllers.DeclineInvitationToken)
+		api.GET("/invite-links/:token", controllers.GetInviteLinkByToken)
+
+		protected := api.Group("/", config.AuthMiddleware(), config.ConditionalGet())
+		{
+			protected.GET("/profile", controllers.GetProfile)
+			protected.POST("/profile/email", controllers.RequestEmailChange)
+			protected.POST("/profile/mfa", controllers.EnrollMfa)
+			protected.POST("/profile/mfa/confirm", controllers.ConfirmMfa)
+			protected.POST("/profile/mfa/disable", controllers.DisableMfa)
+			protected.POST("/profile/mfa/recovery-codes", controllers.RegenerateRecoveryCodes)
+			protected.POST("/logout", controllers.Logout)
+			protected.POST("/logout-all", controllers.LogoutAll)
+
+			protected.POST("/sso/:provider/link", controllers.LinkSso)
+
+			protected.GET("/sessions", controllers.GetSessions)
+			protected.DELETE("/sessions/:id", controllers.DeleteSession)
+
+			protected.GET("/invitations", controllers.GetMyInvitations)
+			protected.POST("/invitations/accept", controllers.AcceptInvitationToken)
+			protected.POST("/invitations/:id/accept", controllers.AcceptInvitation)
+			protected.POST("/invitations/:id/decline", controllers.DeclineInvitation)
+			protected.POST("/invite-links/:token/join", controllers.JoinWithInviteLink)
+
+			protected.GET("/tokens", controllers.GetAccessTokens)
+			protected.POST("/tokens", controllers.CreateAccessToken)
+			protected.DELETE("/tokens/:id", controllers.DeleteAccessToken)
+
+			protected.GET("/project", controllers.GetProjects)
+			protected.POST("/project", controllers.CreateProject)
+			protected.GET("/project/:id", controllers.GetProjectByIdOrCode)
+			protected.PATCH("/project/:id", controllers.UpdateProject)
+			protected.DELETE("/project/:id", controllers.DeleteProject)
+			protected.POST("/project/:id/restore", controllers.RestoreProject)
+
+			protected.GET("/state", controllers.GetStates)
+			protected.POST("/state", controllers.CreateState)
+			protected.GET("/state/:id", controllers.GetStateById)
+			protected.PATCH("/state/:id", controllers.UpdateState)
+			protected.DELETE("/state/:id", controllers.DeleteState)
+			protected.POST("/state/:id/restore", controllers.RestoreState)
+
+			protected.GET("/task", controllers.GetTasks)
+			protected.POST("/task", controllers.CreateTask)
+			protected.GET("/task/:id", controllers.GetTaskById)
+			protected.PATCH("/task/:id", controllers.UpdateTask)
+			protected.DELETE("/task/:id", controllers.DeleteTask)
+			protected.POST("/task/:id/restore", controllers.RestoreTask)
+			protected.POST("/task/move", controllers.MoveTasks)
+			protected.POST("/task/bulk", controllers.BulkUpdateTasks)
+
+			protected.GET("/task-label", controllers.GetTaskLabels)
+			protected.POST("/task-label", controllers.CreateTaskLabel)
+			protected.GET("/task-label/:id", controllers.GetTaskLabelById)
+			protected.PATCH("/task-label/:id", controllers.UpdateTaskLabel)
+			protected.DELETE("/task-label/:id", controllers.DeleteTaskLabel)
+			protected.POST("/task-label/:id/restore", controllers.RestoreTaskLabel)
+
+			protected.GET("/view", controllers.GetViews)
+			protected.POST("/view", controllers.CreateView)
+			protected.GET("/view/:id", controllers.GetViewById)
+			protected.PATCH("/view/:id", controllers.UpdateView)
+			protected.DELETE("/view/:id", controllers.DeleteView)
+
+			protected.GET("/search", controllers.Search)
+
+			protected.GET("/workspace", controllers.GetWorkspaces)
+			protected.POST("/workspace", controllers.CreateWorkspace)
+			protected.GET("/workspace/members/:workspaceId", controllers.GetWorkspaceMembers)
+			protected.GET("/workspace/joinable", controllers.GetJoinableWorkspaces)
+			protected.GET("/workspace/:id", controllers.GetWorkspaceById)
+			protected.PATCH("/workspace/:id", controllers.UpdateWorkspace)
+			protected.DELETE("/workspace/:id", controllers.DeleteWorkspace)
+			protected.GET("/workspace/:id/trash", controllers.GetTrash)
+			protected.POST("/workspace/:id/restore", controllers.RestoreWorkspace)
+			protected.GET("/workspace/:id/sso", controllers.GetWorkspaceSso)
+			protected.PATCH("/workspace/:id/sso", controllers.UpdateWorkspaceSso)
+			protected.DELETE("/workspace/:id/sso", controllers.DeleteWorkspaceSso)
+			protected.GET("/workspace/:id/invitations", controllers.GetWorkspaceInvitations)
+			protected.POST("/workspace/:id/invitations", controllers.CreateInvitation)
+			protected.POST("/workspace/:id/invitations/:invitationId/resend", controllers.ResendInvitation)
+			protected.DELETE("/workspace/:id/invitations/:invitationId", controllers.RevokeInvitation)
+			protected.DELETE("/workspace/:id/members/:userId", controllers.RemoveWorkspaceMember)
+			protected.POST("/workspace/:id/leave", controllers.LeaveWorkspace)
+			protected.POST("/workspace/:id/join", controllers.JoinWorkspace)
+			protected.GET("/workspace/:id/join-requests", controllers.GetJoinRequests)
+			protected.POST("/workspace/:id/join-requests/:requestId/approve", controllers.ApproveJoinRequest)
+			protected.POST("/workspace/:id/join-requests/:requestId/reject", controllers.RejectJoinRequest)
+			protected.GET("/workspace/:id/links", controllers.GetInviteLinks)
+			protected.POST("/workspace/:id/links", controllers.CreateInviteLink)
+			protected.DELETE("/workspace/:id/links/:linkId", controllers.RevokeInviteLink)
+			protected.GET("/workspace/:id/api-keys", controllers.GetWorkspaceApiKeys)
+			protected.POST("/workspace/:id/api-keys", controllers.CreateWorkspaceApiKey)
+			protected.DELETE("/workspace/:id/api-keys/:keyId", controllers.DeleteWorkspaceApiKey)
+
+			admin := protected.Group("/admin", config.AdminMiddleware())
+			{
+				admin.GET("/lockouts", controllers.GetLockouts)
+				admin.DELETE("/lockouts/:key", controllers.DeleteLockout)
+			}
+		}
+	}
+
+	return router
+}
@@ -0,0 +1,2003 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"kickof/database"
+	"kickof/models"
+	"kickof/services"
+	"kickof/utils"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// These tests drive the real router against the in-memory store, then
+// against SQLite, so they need neither a database server nor a mail server.
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+
+	os.Setenv("JWT_SECRET_KEY", "test-secret")
+	os.Setenv("ADMIN_EMAILS", "admin@kickof.test")
+
+	code := 0
+	for _, driver := range []string{"memory", "sqlite"} {
+		testDriver = driver
+		if m.Run() != 0 {
+			log.Println("Tests failed on the " + driver + " store")
+			code = 1
+		}
+	}
+
+	os.Exit(code)
+}
+
+// testDriver is the store the current run of the suite uses
+var testDriver string
+
+type apiClient struct {
+	t      *testing.T
+	router http.Handler
+	token  string
+	userId string
+	email  string
+	header http.Header
+}
+
+type apiResponse struct {
+	Code   int
+	Header http.Header
+	Body   []byte
+	Data   json.RawMessage
+}
+
+func (r apiResponse) decode(t *testing.T, value interface{}) {
+	t.Helper()
+
+	err := json.Unmarshal(r.Data, value)
+	if err != nil {
+		t.Fatalf("cannot decode %s: %v", r.Data, err)
+	}
+}
+
+// newServer resets the datastore and returns an anonymous client.
+func newServer(t *testing.T) *apiClient {
+	t.Helper()
+
+	if testDriver == "sqlite" {
+		store, err := database.OpenSQL("sqlite", filepath.Join(t.TempDir(), "kickof.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		database.Use(store)
+	} else {
+		database.UseMemory()
+	}
+
+	return &apiClient{t: t, router: NewRouter()}
+}
+
+func (c *apiClient) as(other *apiClient) *apiClient {
+	return &apiClient{t: c.t, router: c.router, token: other.token, userId: other.userId, email: other.email}
+}
+
+func (c *apiClient) anonymous() *apiClient {
+	return &apiClient{t: c.t, router: c.router}
+}
+
+// with returns a client sending an extra header on every request.
+func (c *apiClient) with(key string, value string) *apiClient {
+	header := http.Header{}
+	for k, v := range c.header {
+		header[k] = v
+	}
+	header.Set(key, value)
+
+	return &apiClient{t: c.t, router: c.router, token: c.token, userId: c.userId, email: c.email, header: header}
+}
+
+func (c *apiClient) do(method string, path string, body interface{}) apiResponse {
+	c.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	request := httptest.NewRequest(method, path, reader)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "kickof-test")
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	for key, values := range c.header {
+		request.Header[key] = values
+	}
+
+	recorder := httptest.NewRecorder()
+	c.router.ServeHTTP(recorder, request)
+
+	response := apiResponse{Code: recorder.Code, Header: recorder.Header(), Body: recorder.Body.Bytes()}
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if json.Unmarshal(response.Body, &envelope) == nil {
+		response.Data = envelope.Data
+	}
+
+	return response
+}
+
+func (c *apiClient) expect(code int, method string, path string, body interface{}) apiResponse {
+	c.t.Helper()
+
+	response := c.do(method, path, body)
+	if response.Code != code {
+		c.t.Fatalf("%s %s: expected %d, got %d: %s", method, path, code, response.Code, response.Body)
+	}
+
+	return response
+}
+
+// signUp registers, activates and signs in a new user.
+func (c *apiClient) signUp(name string) *apiClient {
+	c.t.Helper()
+
+	email := strings.ToLower(name) + "@kickof.test"
+	password := "password-" + name
+
+	anonymous := c.anonymous()
+	anonymous.expect(http.StatusOK, "POST", "/api/register", models.Register{Name: name, Email: email, Password: password})
+
+	token, err := services.GenerateVerificationToken(email, models.PurposeActivate, "")
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	anonymous.expect(http.StatusOK, "POST", "/api/activate/"+*token, nil)
+
+	var auth models.AuthResult
+	anonymous.expect(http.StatusOK, "POST", "/api/login", models.Login{Email: email, Password: password}).decode(c.t, &auth)
+
+	return &apiClient{t: c.t, router: c.router, token: auth.Token, userId: auth.Id, email: email}
+}
+
+func (c *apiClient) createWorkspace(name string) models.Workspace {
+	c.t.Helper()
+
+	var workspace models.Workspace
+	c.expect(http.StatusOK, "POST", "/api/workspace", models.Workspace{Name: name, Code: strings.ToUpper(name)}).decode(c.t, &workspace)
+
+	return workspace
+}
+
+// invite adds a member to a workspace of c, through an invitation.
+func (c *apiClient) invite(workspaceId string, member *apiClient, role string) {
+	c.t.Helper()
+
+	var invitation models.Invitation
+	c.expect(http.StatusOK, "POST", "/api/workspace/"+workspaceId+"/invitations", models.InvitationRequest{Email: member.email, Role: role}).decode(c.t, &invitation)
+	member.expect(http.StatusOK, "POST", "/api/invitations/"+invitation.Id+"/accept", nil)
+}
+
+func (c *apiClient) createProject(workspaceId string, name string, code string) models.Project {
+	c.t.Helper()
+
+	var project models.Project
+	c.expect(http.StatusOK, "POST", "/api/project", models.Project{WorkspaceId: workspaceId, Name: name, Code: code}).decode(c.t, &project)
+
+	return project
+}
+
+func (c *apiClient) createTask(task models.Task) models.Task {
+	c.t.Helper()
+
+	var result models.Task
+	c.expect(http.StatusOK, "POST", "/api/task", task).decode(c.t, &result)
+
+	return result
+}
+
+func TestVersion(t *testing.T) {
+	os.Setenv("VERSION", "1.2.3")
+
+	var version string
+	newServer(t).expect(http.StatusOK, "GET", "/api/version", nil).decode(t, &version)
+
+	if version != "KickOf Api v1.2.3" {
+		t.Fatalf("unexpected version %q", version)
+	}
+}
+
+func TestRegisterActivateLogin(t *testing.T) {
+	server := newServer(t)
+
+	server.expect(http.StatusOK, "POST", "/api/register", models.Register{Name: "Ann", Email: "ann@kickof.test", Password: "secret-1"})
+	server.expect(http.StatusBadRequest, "POST", "/api/register", models.Register{Name: "Ann", Email: "ann@kickof.test", Password: "secret-1"})
+
+	user := services.GetUser(map[string]interface{}{"email": "ann@kickof.test"}, nil)
+	if user == nil || user.Active {
+		t.Fatalf("registered user should exist and be inactive: %+v", user)
+	}
+
+	server.expect(http.StatusBadRequest, "POST", "/api/activate/not-a-token", nil)
+
+	token, err := services.GenerateVerificationToken("ann@kickof.test", models.PurposeActivate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.expect(http.StatusOK, "POST", "/api/activate", models.TokenRequest{Token: *token})
+	// Activation links are single use
+	server.expect(http.StatusBadRequest, "POST", "/api/activate/"+*token, nil)
+
+	if !services.GetUser(map[string]interface{}{"email": "ann@kickof.test"}, nil).Active {
+		t.Fatal("user should be active")
+	}
+
+	server.expect(http.StatusBadRequest, "POST", "/api/login", models.Login{Email: "ann@kickof.test", Password: "wrong"})
+	server.expect(http.StatusBadRequest, "POST", "/api/login", models.Login{Email: "nobody@kickof.test", Password: "secret-1"})
+
+	var auth models.AuthResult
+	server.expect(http.StatusOK, "POST", "/api/login", models.Login{Email: "ann@kickof.test", Password: "secret-1"}).decode(t, &auth)
+	if auth.Token == "" || auth.RefreshToken == "" {
+		t.Fatalf("missing tokens: %+v", auth)
+	}
+
+	client := &apiClient{t: t, router: server.router, token: auth.Token}
+
+	var profile models.User
+	client.expect(http.StatusOK, "GET", "/api/profile", nil).decode(t, &profile)
+	if profile.Email != "ann@kickof.test" || profile.Password != "" {
+		t.Fatalf("unexpected profile %+v", profile)
+	}
+
+	var refreshed models.AuthResult
+	server.expect(http.StatusOK, "POST", "/api/refresh-token", models.RefreshTokenRequest{RefreshToken: auth.RefreshToken}).decode(t, &refreshed)
+	if refreshed.RefreshToken == auth.RefreshToken {
+		t.Fatal("refresh token should rotate")
+	}
+
+	// Replaying a rotated refresh token revokes the whole session
+	server.expect(http.StatusUnauthorized, "POST", "/api/refresh-token", models.RefreshTokenRequest{RefreshToken: auth.RefreshToken})
+	server.expect(http.StatusUnauthorized, "POST", "/api/refresh-token", models.RefreshTokenRequest{RefreshToken: refreshed.RefreshToken})
+}
+
+func TestSessionsAndLogout(t *testing.T) {
+	ann := newServer(t).signUp("Ann")
+
+	// Registering and signing in each opened a session
+	var sessions []models.Session
+	ann.expect(http.StatusOK, "GET", "/api/sessions", nil).decode(t, &sessions)
+	if len(sessions) != 2 {
+		t.Fatalf("expected two sessions, got %d", len(sessions))
+	}
+
+	for _, session := range sessions {
+		if !session.Current {
+			ann.expect(http.StatusOK, "DELETE", "/api/sessions/"+session.Id, nil)
+		}
+	}
+	ann.expect(http.StatusOK, "GET", "/api/sessions", nil).decode(t, &sessions)
+	if len(sessions) != 1 {
+		t.Fatalf("expected one session left, got %d", len(sessions))
+	}
+
+	ann.expect(http.StatusOK, "POST", "/api/logout", nil)
+	ann.expect(http.StatusUnauthorized, "GET", "/api/profile", nil)
+
+	ann = ann.signUpAgain("Ann")
+	ann.expect(http.StatusOK, "POST", "/api/logout-all", nil)
+	ann.expect(http.StatusUnauthorized, "GET", "/api/profile", nil)
+}
+
+// signUpAgain signs an existing user in again.
+func (c *apiClient) signUpAgain(name string) *apiClient {
+	c.t.Helper()
+
+	var auth models.AuthResult
+	c.anonymous().expect(http.StatusOK, "POST", "/api/login", models.Login{Email: c.email, Password: "password-" + name}).decode(c.t, &auth)
+
+	return &apiClient{t: c.t, router: c.router, token: auth.Token, userId: auth.Id, email: c.email}
+}
+
+func TestAuthFailures(t *testing.T) {
+	server := newServer(t)
+	ann := server.signUp("Ann")
+
+	server.expect(http.StatusUnauthorized, "GET", "/api/profile", nil)
+
+	request := httptest.NewRequest("GET", "/api/profile", nil)
+	request.Header.Set("Authorization", "Token abc")
+	recorder := httptest.NewRecorder()
+	server.router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusUnauthorized || recorder.Body.String() != "bearer token format needed" {
+		t.Fatalf("unexpected response %d %s", recorder.Code, recorder.Body)
+	}
+
+	forged := &apiClient{t: t, router: server.router, token: "not.a.jwt"}
+	forged.expect(http.StatusUnauthorized, "GET", "/api/profile", nil)
+
+	unknown := &apiClient{t: t, router: server.router, token: models.AccessTokenPrefix + "unknown"}
+	unknown.expect(http.StatusUnauthorized, "GET", "/api/profile", nil)
+
+	// Every protected route refuses anonymous callers
+	for _, route := range server.router.(*gin.Engine).Routes() {
+		if !isProtected(route.Path) {
+			continue
+		}
+
+		path := strings.NewReplacer(":id", "x", ":workspaceId", "x", ":userId", "x", ":invitationId", "x",
+			":keyId", "x", ":linkId", "x", ":requestId", "x", ":token", "x", ":key", "x").Replace(route.Path)
+
+		response := server.do(route.Method, path, nil)
+		if response.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: expected 401, got %d", route.Method, route.Path, response.Code)
+		}
+	}
+
+	// Non admins cannot reach the admin endpoints
+	ann.expect(http.StatusForbidden, "GET", "/api/admin/lockouts", nil)
+}
+
+func isProtected(path string) bool {
+	public := []string{
+		"/api/version", "/api/register", "/api/login", "/api/login/mfa", "/api/refresh-token",
+		"/api/activate", "/api/activate/:token", "/api/forgot-password", "/api/reset-password",
+		"/api/confirm-email", "/api/unlock", "/api/sso/:provider/login", "/api/sso/callback",
+		"/api/sso/exchange", "/api/invitations/token/:token", "/api/invitations/decline",
+		"/api/invite-links/:token",
+	}
+
+	if !strings.HasPrefix(path, "/api/") {
+		return false
+	}
+
+	for _, p := range public {
+		if p == path {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestWorkspaceCrud(t *testing.T) {
+	server := newServer(t)
+	ann := server.signUp("Ann")
+	bob := server.signUp("Bob")
+
+	workspace := ann.createWorkspace("Acme")
+	if workspace.GetRole(ann.userId) != models.RoleOwner {
+		t.Fatalf("creator should own the workspace: %+v", workspace.Roles)
+	}
+
+	var fetched models.Workspace
+	ann.expect(http.StatusOK, "GET", "/api/workspace/"+workspace.Id, nil).decode(t, &fetched)
+	if fetched.Name != "Acme" {
+		t.Fatalf("unexpected workspace %+v", fetched)
+	}
+
+	bob.expect(http.StatusForbidden, "GET", "/api/workspace/"+workspace.Id, nil)
+	ann.expect(http.StatusNotFound, "GET", "/api/workspace/missing", nil)
+
+	// Members join through an invitation, not by an edit of the workspace
+	edited := workspace
+	edited.UserIds = append(slices.Clone(workspace.UserIds), bob.userId)
+	ann.expect(http.StatusForbidden, "PATCH", "/api/workspace/"+workspace.Id, edited)
+	edited.Roles = map[string]string{ann.userId: models.RoleOwner, bob.userId: models.RoleMember}
+	ann.expect(http.StatusForbidden, "PATCH", "/api/workspace/"+workspace.Id, edited)
+	bob.expect(http.StatusForbidden, "GET", "/api/workspace/"+workspace.Id, nil)
+
+	ann.invite(workspace.Id, bob, models.RoleMember)
+	ann.expect(http.StatusOK, "GET", "/api/workspace/"+workspace.Id, nil).decode(t, &workspace)
+	workspace.Name = "Acme Inc"
+	ann.expect(http.StatusOK, "PATCH", "/api/workspace/"+workspace.Id, workspace)
+
+	var list models.Result
+	var workspaces []models.Workspace
+	bob.expect(http.StatusOK, "GET", "/api/workspace", nil).decode(t, &list)
+	remarshal(t, list.Data, &workspaces)
+	if len(workspaces) != 1 || workspaces[0].Name != "Acme Inc" {
+		t.Fatalf("bob should see the workspace: %+v", workspaces)
+	}
+
+	var members []models.User
+	bob.expect(http.StatusOK, "GET", "/api/workspace/members/"+workspace.Id, nil).decode(t, &members)
+	if len(members) != 2 {
+		t.Fatalf("expected two members, got %d", len(members))
+	}
+
+	// Members cannot manage or delete the workspace
+	bob.expect(http.StatusForbidden, "PATCH", "/api/workspace/"+workspace.Id, workspace)
+	bob.expect(http.StatusForbidden, "DELETE", "/api/workspace/"+workspace.Id, nil)
+
+	bob.expect(http.StatusOK, "POST", "/api/workspace/"+workspace.Id+"/leave", nil)
+	bob.expect(http.StatusForbidden, "GET", "/api/workspace/"+workspace.Id, nil)
+
+	// The last owner cannot leave
+	ann.expect(http.StatusBadRequest, "POST", "/api/workspace/"+workspace.Id+"/leave", nil)
+
+	ann.expect(http.StatusOK, "DELETE", "/api/workspace/"+workspace.Id, nil)
+	ann.expect(http.StatusNotFound, "GET", "/api/workspace/"+workspace.Id, nil)
+}
+
+func remarshal(t *testing.T, from interface{}, to interface{}) {
+	t.Helper()
+
+	data, err := json.Marshal(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(data, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProjectCrud(t *testing.T) {
+	server := newServer(t)
+	ann := server.signUp("Ann")
+	bob := server.signUp("Bob")
+
+	workspace := ann.createWorkspace("Acme")
+	project := ann.createProject(workspace.Id, "Website", "WEB")
+
+	// Bob is not a member of the workspace
+	bob.expect(http.StatusForbidden, "POST", "/api/project", models.Project{WorkspaceId: workspace.Id, Name: "Nope"})
+	bob.expect(http.StatusForbidden, "GET", "/api/project/"+project.Id, nil)
+
+	var byId, byCode models.Project
+	ann.expect(http.StatusOK, "GET", "/api/project/"+project.Id, nil).decode(t, &byId)
+	ann.expect(http.StatusOK, "GET", "/api/project/WEB", nil).decode(t, &byCode)
+	if byId.Id != project.Id || byCode.Id != project.Id {
+		t.Fatalf("lookup by id or code failed: %+v %+v", byId, byCode)
+	}
+	ann.expect(http.StatusNotFound, "GET", "/api/project/NOPE", nil)
+
+	// The workspace of a project is immutable
+	project.Name = "Web site"
+	project.WorkspaceId = "another"
+	ann.expect(http.StatusBadRequest, "PATCH", "/api/project/"+project.Id, project)
+
+	var updated models.Project
+	ann.expect(http.StatusOK, "PATCH", "/api/project/"+project.Id, map[string]string{"name": "Web site"}).decode(t, &updated)
+	if updated.Name != "Web site" || updated.WorkspaceId != workspace.Id || !updated.CreatedAt.Equal(byId.CreatedAt) {
+		t.Fatalf("update should keep the workspace: %+v", updated)
+	}
+
+	ann.expect(http.StatusOK, "DELETE", "/api/project/"+project.Id, nil)
+	ann.expect(http.StatusNotFound, "GET", "/api/project/"+project.Id, nil)
+}
+
+func TestStateCrud(t *testing.T) {
+	ann := newServer(t).signUp("Ann")
+
+	workspace := ann.createWorkspace("Acme")
+	project := ann.createProject(workspace.Id, "Website", "WEB")
+
+	var state models.State
+	ann.expect(http.StatusOK, "POST", "/api/state", models.State{ProjectId: project.Id, Name: "Todo"}).decode(t, &state)
+	if state.WorkspaceId != workspace.Id {
+		t.Fatalf("state should inherit the workspace of its project: %+v", state)
+	}
+
+	task := ann.createTask(models.Task{ProjectId: project.Id, StateId: state.Id, Title: "Write copy"})
+
+	var fetched models.State
+	ann.expect(http.StatusOK, "GET", "/api/state/"+state.Id, nil).decode(t, &fetched)
+	if fetched.Name != "Todo" {
+		t.Fatalf("unexpected state %+v", fetched)
+	}
+
+	var list models.Result
+	var states []models.State
+	ann.expect(http.StatusOK, "GET", "/api/state?project="+project.Id, nil).decode(t, &list)
+	remarshal(t, list.Data, &states)
+	if len(states) != 1 || len(states[0].Tasks) != 1 || states[0].Tasks[0].Id != task.Id || states[0].Project.Id != project.Id {
+		t.Fatalf("states should embed their project and tasks: %+v", states)
+	}
+
+	state.Name = "Doing"
+	ann.expect(http.StatusOK, "PATCH", "/api/state/"+state.Id, state)
+	ann.expect(http.StatusOK, "GET", "/api/state/"+state.Id, nil).decode(t, &fetched)
+	if fetched.Name != "Doing" {
+		t.Fatalf("state was not renamed: %+v", fetched)
+	}
+
+	// The tasks of a deleted state need a state to move to
+	var done models.State
+	ann.expect(http.StatusOK, "POST", "/api/state", models.State{ProjectId: project.Id, Name: "Done"}).decode(t, &done)
+	ann.expect(http.StatusBadRequest, "DELETE", "/api/state/"+state.Id, nil)
+	ann.expect(http.StatusBadRequest, "DELETE", "/api/state/"+state.Id+"?targetStateId="+state.Id, nil)
+	ann.expect(http.StatusOK, "DELETE", "/api/state/"+state.Id+"?targetStateId="+done.Id, nil)
+	ann.expect(http.StatusNotFound, "GET", "/api/state/"+state.Id, nil)
+
+	var moved models.Task
+	ann.expect(http.StatusOK, "GET", "/api/task/"+task.Id, nil).decode(t, &moved)
+	if moved.StateId != done.Id {
+		t.Fatalf("task was not moved to the target state: %+v", moved)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	ann := newServer(t).signUp("Ann")
+	bob := ann.signUp("Bob")
+
+	workspace := ann.createWorkspace("Acme")
+	project := ann.createProject(workspace.Id, "Website", "WEB")
+	var state models.State
+	ann.expect(http.StatusOK, "POST", "/api/state", models.State{ProjectId: project.Id, Name: "Todo"}).decode(t, &state)
+	task := ann.createTask(models.Task{ProjectId: project.Id, StateId: state.Id, Title: "Write copy"})
+	other := ann.createTask(models.Task{ProjectId: project.Id, StateId: state.Id, Title: "Pick fonts"})
+
+	// A task deleted on its own stays in the trash when its project comes back
+	ann.expect(http.StatusOK, "DELETE", "/api/task/"+other.Id, nil)
+	ann.expect(http.StatusOK, "DELETE", "/api/project/"+project.Id, nil)
+
+	for _, path := range []string{"/api/project/" + project.Id, "/api/state/" + state.Id, "/api/task/" + task.Id} {
+		ann.expect(http.StatusNotFound, "GET", path, nil)
+	}
+
+	var list models.Result
+	ann.expect(http.StatusOK, "GET", "/api/task?workspaceId="+workspace.Id, nil).decode(t, &list)
+	if list.Pagination.Count != 0 {
+		t.Fatalf("deleted tasks are still listed: %+v", list.Data)
+	}
+
+	var trash models.Trash
+	ann.expect(http.StatusOK, "GET", "/api/workspace/"+workspace.Id+"/trash", nil).decode(t, &trash)
+	if trash.Workspace != nil || len(trash.Projects) != 1 || len(trash.Tasks) != 1 || trash.Tasks[0].Id != other.Id || len(trash.States) != 0 {
+		t.Fatalf("trash should list what was deleted on its own: %+v", trash)
+	}
+	if trash.Projects[0].DeletedBy != ann.userId || trash.Projects[0].DeletedAt == nil {
+		t.Fatalf("trash should tell who deleted what and when: %+v", trash.Projects[0])
+	}
+
+	bob.expect(http.StatusForbidden, "GET", "/api/workspace/"+workspace.Id+"/trash", nil)
+	ann.expect(http.StatusBadRequest, "POST", "/api/task/"+task.Id+"/restore", nil)
+	ann.expect(http.StatusOK, "POST", "/api/project/"+project.Id+"/restore", nil)
+	ann.expect(http.StatusBadRequest, "POST", "/api/project/"+project.Id+"/restore", nil)
+	ann.expect(http.StatusOK, "GET", "/api/task/"+task.Id, nil)
+	ann.expect(http.StatusNotFound, "GET", "/api/task/"+other.Id, nil)
+	ann.expect(http.StatusOK, "POST", "/api/task/"+other.Id+"/restore", nil)
+	ann.expect(http.StatusOK, "GET", "/api/task/"+other.Id, nil)
+
+	// Deleting the workspace hides it and everything in it until restored
+	ann.expect(http.StatusOK, "DELETE", "/api/workspace/"+workspace.Id, nil)
+	ann.expect(http.StatusNotFound, "GET", "/api/workspace/"+workspace.Id, nil)
+	ann.expect(http.StatusForbidden, "POST", "/api/project", models.Project{WorkspaceId: workspace.Id, Name: "Blog", Code: "BLOG"})
+	ann.expect(http.StatusOK, "GET", "/api/workspace/"+workspace.Id+"/trash", nil).decode(t, &trash)
+	if trash.Workspace == nil || len(trash.Projects) != 0 {
+		t.Fatalf("trash should list the deleted workspace alone: %+v", trash)
+	}
+	ann.expect(http.StatusOK, "POST", "/api/workspace/"+workspace.Id+"/restore", nil)
+	ann.expect(http.StatusOK, "GET", "/api/task/"+task.Id, nil)
+
+	// Purging removes what outlived the retention for good
+	ann.expect(http.StatusOK, "DELETE", "/api/task/"+task.Id, nil)
+	if count := services.PurgeTrash(time.Now().Add(-time.Hour)); count != 0 {
+		t.Fatalf("purged %d documents still within the retention", count)
+	}
+	if count := services.PurgeTrash(time.Now().Add(time.Second)); count != 1 {
+		t.Fatalf("expected the deleted task to be purged, got %d", count)
+	}
+	ann.expect(http.StatusNotFound, "POST", "/api/task/"+task.Id+"/restore", nil)
+
+	// Purging a workspace takes what belongs to it outside the trash along
+	kept := ann.createWorkspace("Kept")
+	ann.createTask(models.Task{ProjectId: ann.createProject(kept.Id, "Blog", "WEB").Id, Title: "Draft"})
+	leftovers := []string{services.ViewCollection, services.InvitationCollection, services.InviteLinkCollection,
+		services.JoinRequestCollection, services.AccessTokenCollection, services.SsoProviderCollection}
+	for _, id := range []string{workspace.Id, kept.Id} {
+		for _, collection := range leftovers {
+			_, err := database.InsertOne(collection, bson.M{"id": uuid.New().String(), "workspaceId": id})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	ann.expect(http.StatusOK, "DELETE", "/api/workspace/"+workspace.Id, nil)
+	services.PurgeTrash(time.Now().Add(time.Second))
+	for _, collection := range leftovers {
+		if count := database.Count(collection, bson.M{"workspaceId": workspace.Id}); count != 0 {
+			t.Fatalf("%d %s of the purged workspace are left", count, collection)
+		}
+		if count := database.Count(collection, bson.M{"workspaceId": kept.Id}); count != 1 {
+			t.Fatalf("%s of another workspace should be kept, got %d", collection, count)
+		}
+	}
+	if count := database.Count(services.CounterCollection, bson.M{}); count != 1 {
+		t.Fatalf("only the counter of the kept workspace should be left, got %d", count)
+	}
+}
+
+func TestTaskLabelCrud(t *testing.T) {
+	ann := newServer(t).signUp("Ann")
+
+	workspace := ann.createWorkspace("Acme")
+	project := ann.createProject(workspace.Id, "Website", "WEB")
+
+	var label models.TaskLabel
+	ann.expect(http.StatusOK, "POST", "/api/task-label", models.TaskLabel{ProjectId: project.Id, Label: "Bug", Color: "red"}).decode(t, &label)
+	if label.WorkspaceId != workspace.Id {
+		t.Fatalf("label should inherit the workspace of its project: %+v", label)
+	}
+
+	label.Color = "orange"
+	ann.expect(http.StatusOK, "PATCH", "/api/task-label/"+label.Id, label)
+
+	var fetched models.TaskLabel
+	ann.expect(http.StatusOK, "GET", "/api/task-label/"+label.Id, nil).decode(t, &fetched)
+	if fetched.Color != "orange" {
+		t.Fatalf("label was not updated: %+v", fetched)
+	}
+
+	var list models.Result
+	var labels []models.TaskLabel
+	ann.expect(http.StatusOK, "GET", "/api/task-label?workspace="+workspace.Id, nil).decode(t, &list)
+	remarshal(t, list.Data, &labels)
+	if len(labels) != 1 {
+		t.Fatalf("expected one label, got %d", len(labels))
+	}
+
+	ann.expect(http.StatusOK, "DELETE", "/api/task-label/"+label.Id, nil)
+	ann.expect(http.StatusNotFound, "GET", "/api/task-label/"+label.Id, nil)
+}
+
+func TestTaskCrud(t *testing.T) {
+	server := newServer(t)
+	ann := server.signUp("Ann")
+	bob := server.signUp("Bob")
+
+	workspace := ann.createWorkspace("Acme")
+	project := ann.createProject(workspace.Id, "Website", "WEB")
+
+	var bug models.TaskLabel
+	ann.expect(http.StatusOK, "POST", "/api/task-label", models.TaskLabel{ProjectId: project.Id, Label: "Bug"}).decode(t, &bug)
+
+	// New labels are created on the fly, existing ones are referenced
+	task := ann.createTask(models.Task{
+		ProjectId: project.Id,
+		Title:     "Fix header",
+		Labels:    []models.TaskLabel{bug, {Label: "Urgent", Color: "red"}},
+		Assignees: []models.User{{Id: ann.userId}},
+	})
+	if task.WorkspaceId != workspace.Id || len(task.LabelIds) != 2 || task.LabelIds[0] != bug.Id {
+		t.Fatalf("unexpected task %+v", task)
+	}
+
+	var fetched models.Task
+	ann.expect(http.StatusOK, "GET", "/api/task/"+task.Id, nil).decode(t, &fetched)
+	if len(fetched.AssigneeIds) != 1 || fetched.AssigneeIds[0] != ann.userId {
+		t.Fatalf("assignees were not saved: %+v", fetched)
+	}
+
+	bob.expect(http.StatusForbidden, "GET", "/api/task/"+task.Id, nil)
+	bob.expect(http.StatusForbidden, "PATCH", "/api/task/"+task.Id, task)
+	bob.expect(http.StatusForbidden, "POST", "/api/task", models.Task{ProjectId: project.Id, Title: "Nope"})
+
+	// Sending both label objects and ids must not duplicate labels
+	task.Labels = []models.TaskLabel{bug, {Label: "Design"}}
+	var updated models.Task
+	ann.expect(http.StatusOK, "PATCH", "/api/task/"+task.Id, task).decode(t, &updated)
+	if len(updated.LabelIds) != 3 {
+		t.Fatalf("expected three distinct labels, got %v", updated.LabelIds)
+	}
+
+	ann.expect(http.StatusOK, "GET", "/api/task/"+task.Id, nil).decode(t, &fetched)
+	if len(fetched.LabelIds) != 3 {
+		t.Fatalf("labels were not saved: %v", fetched.LabelIds)
+	}
+
+	other := ann.createWorkspace("Other")
+	foreign := ann.createProject(other.Id, "Foreign", "FOR")
+	task.ProjectId = foreign.Id
+	ann.expect(http.StatusBadRequest, "PATCH", "/api/task/"+task.Id, task)
+
+	ann.expect(http.StatusOK, "DELETE", "/api/task/"+task.Id, nil)
+	ann.expect(http.StatusNotFound, "GET", "/api/task/"+task.Id, nil)
+}
+
+func TestTaskMoveAndBulk(t *testing.T) {
+	server := newServer(t)
+	ann := server.signUp("Ann")
+	bob := server.signUp("Bob")
+
+	workspace := ann.createWorkspace("Acme")
+	website := ann.createProject(workspace.Id, "Website", "WEB")
+	mobile := ann.createProject(workspace.Id, "Mobile", "MOB")
+
+	var bug models.TaskLabel
+	var state, otherState models.State
+	ann.expect(http.StatusOK, "POST", "/api/state", models.State{ProjectId: mobile.Id, Name: "Todo"}).decode(t, &state)
+	ann.expect(http.StatusOK, "POST", "/api/state", models.State{ProjectId: website.Id, Name: "Todo"}).decode(t, &otherState)
+	ann.expect(http.StatusOK, "POST", "/api/task-label", models.TaskLabel{ProjectId: mobile.Id, Label: "Bug"}).decode(t, &bug)
+
+	first := ann.createTask(models.Task{ProjectId: website.Id, Title: "Fix header"})
+	second := ann.createTask(models.Task{ProjectId: website.Id, Title: "Fix footer"})
+	ids := []string{first.Id, second.Id}
+
+	// The state must belong to the target project
+	ann.expect(http.StatusBadRequest, "POST", "/api/task/move", models.TaskMoveRequest{TaskIds: ids, ProjectId: mobile.Id, StateId: otherState.Id})
+	ann.expect(http.StatusNotFound, "POST", "/api/task/move", models.TaskMoveRequest{TaskIds: []string{first.Id, "missing"}, ProjectId: mobile.Id})
+	bob.expect(http.StatusForbidden, "POST", "/api/task/move", models.TaskMoveRequest{TaskIds: ids, ProjectId: mobile.Id})
+
+	other := ann.createWorkspace("Other")
+	foreign := ann.createProject(other.Id, "Foreign", "FOR")
+	ann.expect(http.StatusBadRequest, "POST", "/api/task/move", models.TaskMoveRequest{TaskIds: ids, ProjectId: foreign.Id})
+
+	ann.expect(http.StatusOK, "POST", "/api/task/move", models.TaskMoveRequest{TaskIds: ids, ProjectId: mobile.Id, StateId: state.Id})
+
+	var fetched models.Task
+	ann.expect(http.StatusOK, "GET", "/api/task/"+second.Id, nil).decode(t, &fetched)
+	if fetched.ProjectId != mobile.Id || fetched.StateId != state.Id {
+		t.Fatalf("task was not moved: %+v", fetched)
+	}
+
+	// A bulk edit with one bad change leaves every task as it was
+	labelIds := []string{bug.Id}
+	foreignLabel := []string{bug.Id, "missing"}
+	ann.expect(http.StatusBadRequest, "POST", "/api/task/bulk", models.TaskBulkRequest{TaskIds: ids, LabelIds: &foreignLabel})
+	ann.expect(http.StatusBadRequest, "POST", "/api/task/bulk", models.TaskBulkRequest{TaskIds: ids, StateId: &otherState.Id})
+
+	assignees := []string{ann.userId}
+	ann.expect(http.StatusOK, "POST", "/api/task/bulk", models.TaskBulkRequest{TaskIds: ids, LabelIds: &labelIds, AssigneeIds: &assignees})
+	ann.expect(http.StatusOK, "GET", "/api/task/"+first.Id, nil).decode(t, &fetched)
+	if len(fetched.LabelIds) != 1 || fetched.LabelIds[0] != bug.Id || len(fetched.AssigneeIds) != 1 || fetched.StateId != state.Id {
+		t.Fatalf("bulk edit was not applied: %+v", fetched)
+	}
+
+	// Creates and edits hold to the same rules
+	var outsider models.TaskLabel
+	ann.expect(http.StatusOK, "POST", "/api/task-label", models.TaskLabel{ProjectId: foreign.Id, Label: "Outsider"}).decode(t, &outsider)
+	ann.expect(http.StatusBadRequest, "POST", "/api/task", models.Task{ProjectId: website.Id, Title: "Nope", StateId: state.Id})
+	ann.expect(http.StatusBadRequest, "POST", "/api/task", models.Task{ProjectId: website.Id, Title: "Nope", LabelIds: []string{outsider.Id}})
+	ann.expect(http.StatusBadRequest, "PATCH", "/api/task/"+first.Id, map[string]interface{}{"stateId": otherState.Id})
+	ann.expect(http.StatusBadRequest, "PATCH", "/api/task/"+first.Id, map[string]interface{}{"labelIds": []string{bug.Id, outsider.Id}})
+	ann.expect(http.StatusOK, "PATCH", "/api/task/"+first.Id, map[string]interface{}{"labelIds": []string{}})
+	ann.createTask(models.Task{ProjectId: mobile.Id, Title: "Labelled", StateId: state.Id, LabelIds: []string{bug.Id}})
+
+	if testDriver == "sqlite" {
+		// The missing assignee fails the task insert, the new label must not
+		// be left behind
+		ann.expect(http.StatusBadRequest, "POST", "/api/task", models.Task{
+			ProjectId: mobile.Id,
+			Title:     "Orphan",
+			Labels:    []models.TaskLabel{{Label: "Orphan"}},
+			Assignees: []models.User{{Id: "missing"}},
+		})
+
+		var list models.Result
+		var labels []models.TaskLabel
+		ann.expect(http.StatusOK, "GET", "/api/task-label?project="+mobile.Id, nil).decode(t, &list)
+		remarshal(t, list.Data, &labels)
+		if len(labels) != 1 {
+			t.Fatalf("label of the failed task was kept: %+v", labels)
+		}
+	}
+}
+
+func TestTaskPatch(t *testing.T) {
+	ann := newServer(t).signUp("Ann")
+
+	workspace := ann.createWorkspace("Acme")
+	project := ann.createProject(workspace.Id, "Website", "WEB")
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	task := ann.createTask(models.Task{ProjectId: project.Id, Title: "Fix header", StartDate: start, EndDate: start.AddDate(0, 0, 7)})
+	path := "/api/task/" + task.Id
+
+	// A merge patch only touches the fields it names
+	var patched models.Task
+	ann.expect(http.StatusOK, "PATCH", path, map[string]interface{}{"title": "Fix the header"}).decode(t, &patched)
+	if patched.Title != "Fix the header" || !patched.StartDate.Equal(start) || !patched.EndDate.Equal(start.AddDate(0, 0, 7)) {
+		t.Fatalf("merge patch lost fields: %+v", patched)
+	}
+
+	// A JSON patch applies in full or not at all
+	jsonPatch := ann.with("Content-Type", "application/json-patch+json")
+	jsonPatch.expect(http.StatusBadRequest, "PATCH", path, []map[string]interface{}{
+		{"op": "replace", "path": "/title", "value": "Nope"},
+		{"op": "test", "path": "/title", "value": "Fix header"},
+	})
+	jsonPatch.expect(http.StatusOK, "PATCH", path, []map[string]interface{}{
+		{"op": "test", "path": "/title", "value": "Fix the header"},
+		{"op": "add", "path": "/assigneeIds", "value": []string{ann.userId}},
+	}).decode(t, &patched)
+	if patched.Title != "Fix the header" || len(patched.AssigneeIds) != 1 {
+		t.Fatalf("JSON patch was not applied: %+v", patched)
+	}
+
+	for _, field := range []string{"id", "createdAt", "workspaceId"} {
+		ann.expect(http.StatusBadRequest, "PATCH", path, map[string]interface{}{field: "2020-01-01T00:00:00Z"})
+	}
+	ann.expect(http.StatusBadRequest, "PATCH", path, map[string]interface{}{"title": 42})
+
+	// An empty patch writes nothing
+	response := ann.expect(http.StatusOK, "PATCH", path, map[string]interface{}{})
+	if response.Header.Get("ETag") != `"3"` {
+		t.Fatalf("empty patch should keep the version, got %q", response.Header.Get("ETag"))
+	}
+}
+
+func TestETags(t *testing.T) {
+	ann := newServer(t).signUp("Ann")
+
+	workspace := ann.createWorkspace("Acme")
+	project := ann.createProject(workspace.Id, "Website", "WEB")
+	task := ann.createTask(models.Task{ProjectId: project.Id, Title: "Fix header"})
+	if task.Version != 1 {
+		t.Fatalf("new task should be at version 1: %+v", task)
+	}
+
+	path := "/api/task/" + task.Id
+	tag := ann.expect(http.StatusOK, "GET", path, nil).Header.Get("ETag")
+	if tag != `"1"` {
+		t.Fatalf("unexpected ETag %q", tag)
+	}
+	ann.with("If-None-Match", tag).expect(http.StatusNotModified, "GET", path, nil)
+
+	// Both editors read version 1, the second one to save is turned away
+	task.Title = "Fix the header"
+	response := ann.with("If-Match", tag).expect(http.StatusOK, "PATCH", path, task)
+	if response.Header.Get("ETag") != `"2"` {
+		t.Fatalf("update should bump the version, got %q", response.Header.Get("ETag"))
+	}
+	task.Title = "Fix the footer"
+	ann.with("If-Match", tag).expect(http.StatusPreconditionFailed, "PATCH", path, task)
+	ann.with("If-Match", tag).expect(http.StatusPreconditionFailed, "DELETE", path, nil)
+
+	var fetched models.Task
+	ann.with("If-None-Match", tag).expect(http.StatusOK, "GET", path, nil).decode(t, &fetched)
+	if fetched.Title != "Fix the header" || fetched.Version != 2 {
+		t.Fatalf("unexpected task %+v", fetched)
+	}
+
+	// Lists get a weak tag from their content
+	list := ann.expect(http.StatusOK, "GET", "/api/task?project="+project.Id, nil).Header.Get("ETag")
+	if !strings.HasPrefix(list, `W/"`) {
+		t.Fatalf("unexpected list ETag %q", list)
+	}
+	ann.with("If-None-Match", list).expect(http.StatusNotModified, "GET", "/api/task?project="+project.Id, nil)
+
+	ann.with("If-Match", `"2"`).expect(http.StatusOK, "DELETE", path, nil)
+	ann.with("If-None-Match", list).expect(http.StatusOK, "GET", "/api/task?project="+project.Id, nil)
+}
+
+// countingStore counts the queries the services run.
+type countingStore struct {
+	database.Store
+	finds int
+}
+
+func (s *countingStore) Find(collection string, filters bson.M, opt *options.FindOptions) (*mongo.Cursor, error) {
+	s.finds++
+	return s.Store.Find(collection, filters, opt)
+}
+
+func TestIncludes(t *testing.T) {
+	ann := newServer(t).signUp("Ann")
+
+	workspace := ann.createWorkspace("Acme")
+	project := ann.createProject(workspace.Id, "Website", "WEB")
+
+	var bug models.TaskLabel
+	ann.expect(http.StatusOK, "POST", "/api/task-label", models.TaskLabel{ProjectId: project.Id, Label: "Bug"}).decode(t, &bug)
+
+	store := &countingStore{Store: database.Current()}
+	database.Use(store)
+
+	// The number of queries must not grow with the number of states and tasks
+	board := func() int {
+		var state models.State
+		ann.expect(http.StatusOK, "POST", "/api/state", models.State{ProjectId: project.Id, Name: "Todo"}).decode(t, &state)
+		for i := 0; i < 3; i++ {
+			ann.createTask(models.Task{ProjectId: project.Id, StateId: state.Id, Title: "Task", LabelIds: []string{bug.Id}, AssigneeIds: []string{ann.userId}})
+		}
+
+		store.finds = 0
+		ann.expect(http.StatusOK, "GET", "/api/state?project="+project.Id, nil)
+
+		return store.finds
+	}
+	if first, second := board(), board(); first != second {
+		t.Fatalf("board queries grew from %d to %d", first, second)
+	}
+
+	var list models.Result
+	var states []models.State
+	ann.expect(http.StatusOK, "GET", "/api/state?project="+project.Id, nil).decode(t, &list)
+	remarshal(t, list.Data, &states)
+	if len(states[0].Tasks) != 3 || len(states[0].Tasks[0].Labels) != 1 || states[0].Tasks[0].Assignees[0].Id != ann.userId || states[0].Project.Id != project.Id {
+		t.Fatalf("states should embed every relation by default: %+v", states[0])
+	}
+
+	ann.expect(http.StatusOK, "GET", "/api/state?project="+project.Id+"&include=tasks,labels", nil).decode(t, &list)
+	remarshal(t, list.Data, &states)
+	if len(states[0].Tasks) != 3 || len(states[0].Tasks[0].Labels) != 1 || len(states[0].Tasks[0].Assignees) != 0 || states[0].Project.Id != "" {
+		t.Fatalf("states should only embed the included relations: %+v", states[0])
+	}
+
+	var tasks []models.Task
+	ann.expect(http.StatusOK, "GET", "/api/task?project="+project.Id+"&include=", nil).decode(t, &list)
+	remarshal(t, list.Data, &tasks)
+	if len(tasks) != 6 || len(tasks[0].Labels) != 0 || len(tasks[0].Assignees) != 0 {
+		t.Fatalf("an empty include should embed nothing: %+v", tasks)
+	}
+}
+
+func TestTaskFilters(t *testing.T) {
+	ann := newServer(t).signUp("Ann")
+
+	workspace := ann.createWorkspace("Acme")
+	project := ann.createProject(workspace.Id, "Website", "WEB")
+
+	var done models.State
+	ann.expect(http.StatusOK, "POST", "/api/state", models.State{ProjectId: project.Id, Name: "Done"}).decode(t, &done)
+
+	ann.createTask(models.Task{ProjectId: project.Id, Title: "Assigned", Assignees: []models.User{{Id: ann.userId}}})
+	ann.createTask(models.Task{ProjectId: project.Id, Title: "Finished", StateId: done.Id})
+	ann.createTask(models.Task{ProjectId: project.Id, Title: "Open"})
+
+	titles := func(path string) []string {
+		var list models.Result
+		var tasks []models.Task
+		ann.expect(http.StatusOK, "GET", path, nil).decode(t, &list)
+		remarshal(t, list.Data, &tasks)
+
+		results := make([]string, 0)
+		for _, task := range tasks {
+			results = append(results, task.Title)
+		}
+		return results
+	}
+
+	if got := titles("/api/task?workspace=" + workspace.Id + "&assigned=true"); fmt.Sprint(got) != "[Assigned]" {
+		t.Fatalf("assigned filter returned %v", got)
+	}
+
+	if got := titles("/api/task?workspace=" + workspace.Id + "&user=" + ann.userId); fmt.Sprint(got) != "[Assigned]" {
+		t.Fatalf("user filter returned %v", got)
+	}
+
+	if got := titles("/api/task?workspace=" + workspace.Id + "&completed=true"); fmt.Sprint(got) != "[Finished]" {
+		t.Fatalf("completed filter returned %v", got)
+	}
+}
+
+func TestPaginationAndKeywordSearch(t *testing.T) {
+	ann := newServer(t).signUp("Ann")
+	bob := ann.signUp("Bob")
+
+	workspace := ann.createWorkspace("Acme")
+	project := ann.createProject(workspace.Id, "Website", "WEB")
+	ann.createProject(workspace.Id, "Mobile", "MOB")
+
+	for i, title := range []string{"Alpha", "Bravo", "Charlie"} {
+		ann.createTask(models.Task{ProjectId: project.Id, Title: title, StartDate: time.Now().Add(time.Duration(i) * time.Hour)})
+	}
+
+	// Tasks of other workspaces never leak into the list
+	foreign := bob.createWorkspace("Foreign")
+	bob.createTask(models.Task{ProjectId: bob.createProject(foreign.Id, "Secret", "SEC").Id, Title: "Alien"})
+
+	page := func(path string) ([]models.Task, models.Pagination) {
+		var list models.Result
+		var tasks []models.Task
+		ann.expect(http.StatusOK, "GET", path, nil).decode(t, &list)
+		remarshal(t, list.Data, &tasks)
+		return tasks, list.Pagination
+	}
+
+	tasks, pagination := page("/api/task?sort=title,1&limit=2&page=1")
+	if len(tasks) != 2 || tasks[0].Title != "Alpha" || tasks[1].Title != "Bravo" {
+		t.Fatalf("unexpected first page %+v", tasks)
+	}
+	if pagination.Count != 3 || pagination.Pages != 2 || pagination.Limit != 2 {
+		t.Fatalf("unexpected pagination %+v", pagination)
+	}
+
+	tasks, pagination = page("/api/task?sort=title,1&limit=2&page=2")
+	if len(tasks) != 1 || tasks[0].Title != "Charlie" || pagination.Skip != 2 {
+		t.Fatalf("unexpected second page %+v %+v", tasks, pagination)
+	}
+
+	tasks, _ = page("/api/task?sort=title,-1")
+	if len(tasks) != 3 || tasks[0].Title != "Charlie" {
+		t.Fatalf("unexpected descending sort %+v", tasks)
+	}
+
+	tasks, _ = page("/api/task?keyword=br")
+	if len(tasks) != 1 || tasks[0].Title != "Bravo" {
+		t.Fatalf("keyword search on tasks returned %+v", tasks)
+	}
+
+	var list models.Result
+	var projects []models.Project
+	ann.expect(http.StatusOK, "GET", "/api/project?keyword=mob", nil).decode(t, &list)
+	remarshal(t, list.Data, &projects)
+	if len(projects) != 1 || projects[0].Code != "MOB" {
+		t.Fatalf("keyword search on projects returned %+v", projects)
+	}
+
+	// Asking for a workspace the user is not part of is refused
+	ann.expect(http.StatusForbidden, "GET", "/api/task?workspace="+foreign.Id, nil)
+}
+
+func TestCursorPagination(t *testing.T) {
+	ann := newServer(t).signUp("Ann")
+
+	workspace := ann.createWorkspace("Acme")
+	project := ann.createProject(workspace.Id, "Website", "WEB")
+	for _, title := range []string{"A", "B", "C", "D", "E"} {
+		ann.createTask(models.Task{ProjectId: project.Id, Title: title})
+	}
+
+	page := func(query string) ([]string, models.Result) {
+		var list models.Result
+		var tasks []models.Task
+		ann.expect(http.StatusOK, "GET", "/api/task?sort=title,1&"+query, nil).decode(t, &list)
+		remarshal(t, list.Data, &tasks)
+
+		titles := make([]string, 0, len(tasks))
+		for _, task := range tasks {
+			titles = append(titles, task.Title)
+		}
+		return titles, list
+	}
+
+	// Partial pages count as a page
+	_, list := page("limit=4&page=1")
+	if list.Pagination.Pages != 2 {
+		t.Fatalf("expected 2 pages, got %+v", list.Pagination)
+	}
+
+	titles, first := page("limit=2&after=")
+	if strings.Join(titles, "") != "AB" || first.NextCursor == "" || first.PrevCursor != "" {
+		t.Fatalf("unexpected first page %v %+v", titles, first)
+	}
+
+	// Inserting before the cursor does not shift the next page
+	ann.createTask(models.Task{ProjectId: project.Id, Title: "AA"})
+
+	titles, second := page("limit=2&after=" + first.NextCursor)
+	if strings.Join(titles, "") != "CD" || second.NextCursor == "" || second.PrevCursor == "" {
+		t.Fatalf("unexpected second page %v %+v", titles, second)
+	}
+
+	titles, last := page("limit=2&after=" + second.NextCursor)
+	if strings.Join(titles, "") != "E" || last.NextCursor != "" {
+		t.Fatalf("unexpected last page %v %+v", titles, last)
+	}
+
+	titles, previous := page("limit=2&before=" + second.PrevCursor)
+	if strings.Join(titles, "") != "AAB" || previous.PrevCursor == "" || previous.NextCursor == "" {
+		t.Fatalf("unexpected previous page %v %+v", titles, previous)
+	}
+
+	titles, _ = page("limit=2&before=")
+	if strings.Join(titles, "") != "DE" {
+		t.Fatalf("unexpected page from the end %v", titles)
+	}
+
+	ann.expect(http.StatusBadRequest, "GET", "/api/task?after=nope", nil)
+	forged := models.Cursor{Field: "title", Order: 1, Value: bson.M{"$ne": nil}, Id: "x"}.Encode()
+	ann.expect(http.StatusBadRequest, "GET", "/api/task?sort=title,1&after="+forged, nil)
+	ann.expect(http.StatusBadRequest, "GET", "/api/task?sort=createdAt,1&after="+first.NextCursor, nil)
+
+	// Tasks missing the sort field come first ascending and last descending,
+	// and pages go through them
+	for i, title := range []string{"B", "D", "A", "C", "E", "AA"} {
+		update := bson.M{"$unset": bson.M{"endDate": ""}}
+		if i > 1 {
+			update = bson.M{"$set": bson.M{"endDate": time.Date(2026, 10, i, 0, 0, 0, 0, time.UTC)}}
+		}
+		_, err := database.Current().UpdateMany("tasks", bson.M{"title": title}, update, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for sort, expected := range map[string]string{"endDate,1": "BD,A,C,E,AA", "endDate,-1": "AA,E,C,A,BD"} {
+		var titles []string
+		cursor := ""
+		for len(titles) <= 6 {
+			var list models.Result
+			var tasks []models.Task
+			ann.expect(http.StatusOK, "GET", "/api/task?limit=1&sort="+url.QueryEscape(sort)+"&after="+cursor, nil).decode(t, &list)
+			remarshal(t, list.Data, &tasks)
+			for _, task := range tasks {
+				titles = append(titles, task.Title)
+			}
+			if list.NextCursor == "" {
+				break
+			}
+			cursor = list.NextCursor
+		}
+
+		// Tasks without a date come in the order of their ids
+		joined := strings.Join(titles, ",")
+		joined = strings.NewReplacer("B,D", "BD", "D,B", "BD").Replace(joined)
+		if joined != expected {
+			t.Fatalf("unexpected pages sorted on %s %v", sort, titles)
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	ann := newServer(t).signUp("Ann")
+	bob := ann.signUp("Bob")
+
+	workspace := ann.createWorkspace("Acme")
+	project := ann.createProject(workspace.Id, "Website", "WEB")
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	ann.createTask(models.Task{ProjectId: project.Id, Title: "Fix header", EndDate: start.AddDate(0, 0, 10), AssigneeIds: []string{ann.userId, bob.userId}})
+	ann.createTask(models.Task{ProjectId: project.Id, Title: "Fix footer", EndDate: start.AddDate(0, 0, 20), AssigneeIds: []string{bob.userId}})
+	ann.createTask(models.Task{ProjectId: project.Id, Title: "Write copy", EndDate: start.AddDate(0, 2, 0)})
+
+	list := func(filter string, sort string) string {
+		var result models.Result
+		var tasks []models.Task
+		ann.expect(http.StatusOK, "GET", "/api/task?filter="+url.QueryEscape(filter)+"&sort="+url.QueryEscape(sort), nil).decode(t, &result)
+		remarshal(t, result.Data, &tasks)
+
+		titles := make([]string, 0, len(tasks))
+		for _, task := range tasks {
+			titles = append(titles, task.Title)
+		}
+		return strings.Join(titles, ",")
+	}
+
+	cases := []struct {
+		filter string
+		sort   string
+		want   string
+	}{
+		{"assigneeIds:in:" + ann.userId + ",nobody", "title", "Fix header"},
+		{"assigneeIds:all:" + ann.userId + "," + bob.userId, "title", "Fix header"},
+		{"assigneeIds:exists:false", "title", "Write copy"},
+		{"endDate:lt:2026-11-01;title:contains:FIX", "endDate,desc", "Fix footer,Fix header"},
+		{"endDate:between:2026-10-05,2026-10-15", "", "Fix header"},
+		{"title:nin:Fix header,Fix footer", "", "Write copy"},
+		{"title:ne:Write copy", "endDate,-1;title,1", "Fix footer,Fix header"},
+	}
+	for _, c := range cases {
+		if got := list(c.filter, c.sort); got != c.want {
+			t.Errorf("filter %q sort %q returned %q, want %q", c.filter, c.sort, got, c.want)
+		}
+	}
+
+	for _, query := range []string{
+		"filter=" + url.QueryEscape("secret:eq:x"),
+		"filter=" + url.QueryEscape("title:like:x"),
+		"filter=" + url.QueryEscape("title"),
+		"filter=" + url.QueryEscape("endDate:gt:tomorrow"),
+		"filter=" + url.QueryEscape("title:all:x"),
+		"filter=" + url.QueryEscape("endDate:between:2026-10-01"),
+		"sort=title,up",
+		"sort=password,1",
+		"sort=" + url.QueryEscape("title,1;endDate,1") + "&after=",
+	} {
+		ann.expect(http.StatusBadRequest, "GET", "/api/task?"+query, nil)
+	}
+
+	// The legacy sort without an order no longer panics
+	if got := list("", "title"); got != "Fix footer,Fix header,Write copy" {
+		t.Fatalf("sort without an order returned %q", got)
+	}
+}
+
+func TestViews(t *testing.T) {
+	ann := newServer(t).signUp("Ann")
+	bob := ann.signUp("Bob")
+
+	workspace := ann.createWorkspace("Acme")
+	ann.invite(workspace.Id, bob, models.RoleGuest)
+
+	project := ann.createProject(workspace.Id, "Website", "WEB")
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	ann.createTask(models.Task{ProjectId: project.Id, Title: "Fix header", EndDate: start, AssigneeIds: []string{ann.userId}})
+	ann.createTask(models.Task{ProjectId: project.Id, Title: "Fix footer", EndDate: start})
+	ann.createTask(models.Task{ProjectId: project.Id, Title: "Write copy", EndDate: start.AddDate(0, 3, 0)})
+
+	var private, shared models.View
+	ann.expect(http.StatusOK, "POST", "/api/view", models.View{ProjectId: project.Id, Name: "Mine", Filter: "assigneeIds:in:" + ann.userId}).decode(t, &private)
+	ann.expect(http.StatusOK, "POST", "/api/view", models.View{ProjectId: project.Id, Name: "Overdue", Filter: "endDate:lt:2026-11-01", Sort: "title,desc", GroupBy: "stateId", Columns: []string{"title", "endDate", "assignees"}, Shared: true}).decode(t, &shared)
+	if shared.WorkspaceId != workspace.Id || shared.UserId != ann.userId {
+		t.Fatalf("unexpected view %+v", shared)
+	}
+
+	ann.expect(http.StatusBadRequest, "POST", "/api/view", models.View{ProjectId: project.Id, Name: "Broken", Filter: "title:like:x"})
+	ann.expect(http.StatusBadRequest, "POST", "/api/view", models.View{ProjectId: project.Id, Name: "Broken", GroupBy: "title"})
+	ann.expect(http.StatusBadRequest, "POST", "/api/view", models.View{ProjectId: project.Id, Name: "Broken", Columns: []string{"password"}})
+
+	// Guests keep private views but cannot share them
+	bob.expect(http.StatusOK, "POST", "/api/view", models.View{WorkspaceId: workspace.Id, Name: "Bob's"})
+	bob.expect(http.StatusForbidden, "POST", "/api/view", models.View{WorkspaceId: workspace.Id, Name: "Bob's", Shared: true})
+
+	var list models.Result
+	var views []models.View
+	bob.expect(http.StatusOK, "GET", "/api/view?sort=name", nil).decode(t, &list)
+	remarshal(t, list.Data, &views)
+	if len(views) != 2 || views[0].Name != "Bob's" || views[1].Id != shared.Id {
+		t.Fatalf("unexpected views %+v", views)
+	}
+
+	tasks := func(client *apiClient, query string) (string, *models.View) {
+		var list models.Result
+		var tasks []models.Task
+		client.expect(http.StatusOK, "GET", "/api/task?"+query, nil).decode(t, &list)
+		remarshal(t, list.Data, &tasks)
+
+		titles := make([]string, 0, len(tasks))
+		for _, task := range tasks {
+			titles = append(titles, task.Title)
+		}
+		return strings.Join(titles, ","), list.View
+	}
+
+	titles, view := tasks(bob, "view="+shared.Id)
+	if titles != "Fix header,Fix footer" || view == nil || view.GroupBy != "stateId" {
+		t.Fatalf("unexpected tasks of the view %q %+v", titles, view)
+	}
+
+	// The query adds to the view and overrides its sort
+	titles, _ = tasks(bob, "view="+shared.Id+"&sort=title&filter="+url.QueryEscape("title:contains:head"))
+	if titles != "Fix header" {
+		t.Fatalf("unexpected narrowed tasks %q", titles)
+	}
+
+	if titles, _ = tasks(ann, "view="+private.Id); titles != "Fix header" {
+		t.Fatalf("unexpected tasks of the private view %q", titles)
+	}
+
+	bob.expect(http.StatusNotFound, "GET", "/api/view/"+private.Id, nil)
+	bob.expect(http.StatusNotFound, "GET", "/api/task?view="+private.Id, nil)
+
+	// The view keeps its scope, and stays in its workspace
+	other := ann.createProject(workspace.Id, "Mobile", "MOB")
+	if titles, _ = tasks(bob, "view="+shared.Id+"&project="+other.Id); titles != "Fix header,Fix footer" {
+		t.Fatalf("view moved to another project %q", titles)
+	}
+	cid := ann.signUp("Cid")
+	own := cid.createWorkspace("Own")
+	cid.expect(http.StatusForbidden, "GET", "/api/task?view="+shared.Id+"&workspace="+own.Id, nil)
+	bob.expect(http.StatusForbidden, "PATCH", "/api/view/"+shared.Id, map[string]string{"name": "Mine now"})
+
+	var renamed models.View
+	ann.expect(http.StatusOK, "PATCH", "/api/view/"+shared.Id, map[string]string{"name": "Late"}).decode(t, &renamed)
+	if renamed.Name != "Late" || renamed.Filter != shared.Filter || renamed.Version != 2 {
+		t.Fatalf("unexpected renamed view %+v", renamed)
+	}
+	ann.expect(http.StatusBadRequest, "PATCH", "/api/view/"+shared.Id, map[string]string{"userId": bob.userId})
+
+	ann.expect(http.StatusOK, "DELETE", "/api/view/"+shared.Id, nil)
+	bob.expect(http.StatusNotFound, "GET", "/api/task?view="+shared.Id, nil)
+}
+
+func TestSearch(t *testing.T) {
+	ann := newServer(t).signUp("Ann")
+	bob := ann.signUp("Bob")
+
+	workspace := ann.createWorkspace("Acme")
+	project := ann.createProject(workspace.Id, "Website", "WEB")
+	header := ann.createTask(models.Task{ProjectId: project.Id, Title: "Fix header layout", Description: "The <nav> overlaps the logo"})
+	ann.createTask(models.Task{ProjectId: project.Id, Title: "Write copy", Description: "Header and footer texts"})
+
+	foreign := bob.createWorkspace("Foreign")
+	bob.createTask(models.Task{ProjectId: bob.createProject(foreign.Id, "Secret", "SEC").Id, Title: "Fix header"})
+
+	search := func(query string) []models.SearchHit {
+		var hits []models.SearchHit
+		ann.expect(http.StatusOK, "GET", "/api/search?"+query, nil).decode(t, &hits)
+		return hits
+	}
+
+	// A typo still finds the task, and matches in titles rank first
+	hits := search("q=heder")
+	if len(hits) != 2 || hits[0].Id != header.Id || hits[1].Title != "Write copy" {
+		t.Fatalf("unexpected hits %+v", hits)
+	}
+	if hits[0].Highlights["title"] != "Fix <mark>header</mark> layout" {
+		t.Fatalf("unexpected highlights %+v", hits[0].Highlights)
+	}
+
+	hits = search("q=nav+logo")
+	if len(hits) != 1 || hits[0].Highlights["description"] != "The &lt;<mark>nav</mark>&gt; overlaps the <mark>logo</mark>" {
+		t.Fatalf("unexpected hits %+v", hits)
+	}
+
+	// Tasks match by their key too, below the project of the code
+	hits = search("q=web")
+	if len(hits) != 3 || hits[0].Kind != models.SearchProject || hits[0].Id != project.Id {
+		t.Fatalf("unexpected project hits %+v", hits)
+	}
+
+	hits = search("q=acme&kind=task")
+	if len(hits) != 0 {
+		t.Fatalf("kind did not narrow the search %+v", hits)
+	}
+
+	// Trashed tasks drop out until restored, edits are searchable at once
+	ann.expect(http.StatusOK, "DELETE", "/api/task/"+header.Id, nil)
+	if hits = search("q=header&workspace=" + workspace.Id); len(hits) != 1 {
+		t.Fatalf("trashed task still found %+v", hits)
+	}
+	ann.expect(http.StatusOK, "POST", "/api/task/"+header.Id+"/restore", nil)
+	ann.expect(http.StatusOK, "PATCH", "/api/task/"+header.Id, map[string]string{"title": "Fix navbar layout"})
+	if hits = search("q=navbar"); len(hits) != 1 || hits[0].Id != header.Id {
+		t.Fatalf("edited task not found %+v", hits)
+	}
+
+	// The index itself lets go of what is in the trash, and takes it back
+	indexed := func(q string) int {
+		return len(services.GetSearchIndex().Search(models.SearchQuery{Q: q, WorkspaceIds: []string{workspace.Id}}))
+	}
+	ann.expect(http.StatusOK, "DELETE", "/api/project/"+project.Id, nil)
+	if count := indexed("web"); count != 0 {
+		t.Fatalf("trashed project and tasks still indexed: %d", count)
+	}
+	ann.expect(http.StatusOK, "POST", "/api/project/"+project.Id+"/restore", nil)
+	if count := indexed("web"); count != 3 {
+		t.Fatalf("restored project and tasks should be indexed again: %d", count)
+	}
+	ann.expect(http.StatusOK, "DELETE", "/api/workspace/"+workspace.Id, nil)
+	if count := indexed("acme") + indexed("web"); count != 0 {
+		t.Fatalf("trashed workspace still indexed: %d", count)
+	}
+	ann.expect(http.StatusOK, "POST", "/api/workspace/"+workspace.Id+"/restore", nil)
+	if count := indexed("acme") + indexed("web"); count != 4 {
+		t.Fatalf("restored workspace should be indexed again: %d", count)
+	}
+
+	ann.expect(http.StatusForbidden, "GET", "/api/search?q=header&workspace="+foreign.Id, nil)
+	ann.expect(http.StatusBadRequest, "GET", "/api/search", nil)
+}
+
+func TestTaskKeys(t *testing.T) {
+	ann := newServer(t).signUp("Ann")
+	bob := ann.signUp("Bob")
+
+	workspace := ann.createWorkspace("Acme")
+	web := ann.createProject(workspace.Id, "Website", "WEB")
+	app := ann.createProject(workspace.Id, "Mobile", "APP")
+
+	first := ann.createTask(models.Task{ProjectId: web.Id, Title: "Fix header", Code: "WEB-99"})
+	second := ann.createTask(models.Task{ProjectId: web.Id, Title: "Fix footer"})
+	if first.Code != "WEB-1" || second.Code != "WEB-2" {
+		t.Fatalf("unexpected keys %q %q", first.Code, second.Code)
+	}
+
+	var found models.Task
+	ann.expect(http.StatusOK, "GET", "/api/task/WEB-2", nil).decode(t, &found)
+	if found.Id != second.Id {
+		t.Fatalf("unexpected task of WEB-2 %+v", found)
+	}
+
+	// Keys are set by the server, and renewed when the task moves
+	ann.expect(http.StatusOK, "PATCH", "/api/task/"+first.Id, map[string]string{"title": "Fix navbar", "code": "WEB-7"}).decode(t, &found)
+	if found.Title != "Fix navbar" || found.Code != "WEB-1" {
+		t.Fatalf("key changed on edit %+v", found)
+	}
+
+	ann.expect(http.StatusOK, "POST", "/api/task/move", models.TaskMoveRequest{TaskIds: []string{first.Id}, ProjectId: app.Id})
+	ann.expect(http.StatusOK, "GET", "/api/task/APP-1", nil).decode(t, &found)
+	if found.Id != first.Id || !slices.Equal(found.PreviousKeys, []string{"WEB-1"}) {
+		t.Fatalf("unexpected moved task %+v", found)
+	}
+
+	response := ann.expect(http.StatusMovedPermanently, "GET", "/api/task/WEB-1?workspace="+workspace.Id, nil)
+	if location := response.Header.Get("Location"); location != "/api/task/APP-1?workspace="+workspace.Id {
+		t.Fatalf("unexpected redirect to %q", location)
+	}
+
+	// Numbers are never reissued
+	if third := ann.createTask(models.Task{ProjectId: web.Id, Title: "Write copy"}); third.Code != "WEB-3" {
+		t.Fatalf("unexpected key %q", third.Code)
+	}
+
+	bob.expect(http.StatusNotFound, "GET", "/api/task/WEB-2", nil)
+	ann.expect(http.StatusNotFound, "GET", "/api/task/WEB-42", nil)
+}
+
+func TestTaskDescriptions(t *testing.T) {
+	ann := newServer(t).signUp("Ann")
+	bob := ann.signUp("Bob")
+
+	workspace := ann.createWorkspace("Acme")
+	project := ann.createProject(workspace.Id, "Website", "WEB")
+	header := ann.createTask(models.Task{ProjectId: project.Id, Title: "Fix header"})
+
+	description := "**Blocked** by " + header.Code + ", ask @ann or @bob.\n\n" +
+		"Run <script>alert(1)</script>[this](javascript:alert(1)), not `WEB-1`, mail ann@kickof.test"
+	footer := ann.createTask(models.Task{ProjectId: project.Id, Title: "Fix footer", Description: description})
+	if footer.Description != description || footer.DescriptionHtml != "" {
+		t.Fatalf("unexpected task %+v", footer)
+	}
+
+	var rendered models.Task
+	response := ann.expect(http.StatusOK, "GET", "/api/task/"+footer.Id+"?render=html", nil)
+	response.decode(t, &rendered)
+	plain := ann.expect(http.StatusOK, "GET", "/api/task/"+footer.Id, nil).Header.Get("ETag")
+	if tag := response.Header.Get("ETag"); tag == "" || tag == plain {
+		t.Fatalf("rendered task tagged %q like the plain one %q", tag, plain)
+	}
+
+	expected := `<p><strong>Blocked</strong> by <a class="task-key" data-task-id="` + header.Id + `" href="/api/task/WEB-1" rel="nofollow">WEB-1</a>, ` +
+		`ask <span class="mention" data-user-id="` + ann.userId + `">@ann</span> or @bob.</p>` + "\n" +
+		`<p>Run alert(1)this, not <code>WEB-1</code>, mail <a href="mailto:ann@kickof.test" rel="nofollow">ann@kickof.test</a></p>` + "\n"
+	if rendered.DescriptionHtml != expected {
+		t.Fatalf("unexpected rendered description\n%s\nexpected\n%s", rendered.DescriptionHtml, expected)
+	}
+
+	var list models.Result
+	var tasks []models.Task
+	ann.expect(http.StatusOK, "GET", "/api/task?render=html&filter="+url.QueryEscape("id:eq:"+footer.Id), nil).decode(t, &list)
+	remarshal(t, list.Data, &tasks)
+	if len(tasks) != 1 || tasks[0].DescriptionHtml != expected {
+		t.Fatalf("unexpected rendered tasks %+v", tasks)
+	}
+
+	ann.expect(http.StatusBadRequest, "GET", "/api/task/"+footer.Id+"?render=pdf", nil)
+	ann.expect(http.StatusBadRequest, "PATCH", "/api/task/"+footer.Id, map[string]string{"description": strings.Repeat("a", 65537)})
+	ann.expect(http.StatusBadRequest, "POST", "/api/task", models.Task{ProjectId: project.Id, Title: "Long", Description: strings.Repeat("a", 65537)})
+	bob.expect(http.StatusForbidden, "GET", "/api/task/"+footer.Id+"?render=html", nil)
+}
+
+func TestInvitations(t *testing.T) {
+	server := newServer(t)
+	ann := server.signUp("Ann")
+	bob := server.signUp("Bob")
+	workspace := ann.createWorkspace("Acme")
+
+	var invitation models.Invitation
+	ann.expect(http.StatusOK, "POST", "/api/workspace/"+workspace.Id+"/invitations", models.InvitationRequest{Email: bob.email, Role: models.RoleMember}).decode(t, &invitation)
+	ann.expect(http.StatusBadRequest, "POST", "/api/workspace/"+workspace.Id+"/invitations", models.InvitationRequest{Email: bob.email})
+	bob.expect(http.StatusForbidden, "GET", "/api/workspace/"+workspace.Id+"/invitations", nil)
+
+	var invitations []models.Invitation
+	bob.expect(http.StatusOK, "GET", "/api/invitations", nil).decode(t, &invitations)
+	if len(invitations) != 1 || invitations[0].Workspace == nil || invitations[0].Workspace.Name != "Acme" {
+		t.Fatalf("bob should see his invitation: %+v", invitations)
+	}
+
+	ann.expect(http.StatusOK, "POST", "/api/workspace/"+workspace.Id+"/invitations/"+invitation.Id+"/resend", nil)
+	bob.expect(http.StatusOK, "POST", "/api/invitations/"+invitation.Id+"/accept", nil)
+	bob.expect(http.StatusOK, "GET", "/api/workspace/"+workspace.Id, nil)
+
+	ann.expect(http.StatusOK, "DELETE", "/api/workspace/"+workspace.Id+"/members/"+bob.userId, nil)
+	bob.expect(http.StatusForbidden, "GET", "/api/workspace/"+workspace.Id, nil)
+
+	// Invitation links work for people without an account yet
+	ann.expect(http.StatusOK, "POST", "/api/workspace/"+workspace.Id+"/invitations", models.InvitationRequest{Email: "cid@kickof.test"}).decode(t, &invitation)
+	token, err := services.GenerateVerificationToken("cid@kickof.test", models.PurposeInvite, invitation.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.expect(http.StatusOK, "GET", "/api/invitations/token/"+*token, nil)
+	server.expect(http.StatusOK, "POST", "/api/register", models.Register{Name: "Cid", Email: "cid@kickof.test", Password: "password-Cid", InviteToken: *token})
+
+	cid := (&apiClient{t: t, router: server.router, email: "cid@kickof.test"}).signUpAgain("Cid")
+	cid.expect(http.StatusOK, "GET", "/api/workspace/"+workspace.Id, nil)
+
+	// Unverified accounts cannot see nor answer the invitations of their email
+	ann.expect(http.StatusOK, "POST", "/api/workspace/"+workspace.Id+"/invitations", models.InvitationRequest{Email: "eve@kickof.test"}).decode(t, &invitation)
+	var auth models.AuthResult
+	server.expect(http.StatusOK, "POST", "/api/register", models.Register{Name: "Eve", Email: "eve@kickof.test", Password: "password-Eve"}).decode(t, &auth)
+	eve := &apiClient{t: t, router: server.router, token: auth.Token, userId: auth.Id, email: "eve@kickof.test"}
+	eve.expect(http.StatusOK, "GET", "/api/invitations", nil).decode(t, &invitations)
+	if len(invitations) != 0 {
+		t.Fatalf("unverified account sees invitations %+v", invitations)
+	}
+	eve.expect(http.StatusBadRequest, "POST", "/api/invitations/"+invitation.Id+"/accept", nil)
+	eve.expect(http.StatusForbidden, "GET", "/api/workspace/"+workspace.Id, nil)
+
+	ann.expect(http.StatusOK, "POST", "/api/workspace/"+workspace.Id+"/invitations", models.InvitationRequest{Email: "dan@kickof.test"}).decode(t, &invitation)
+	ann.expect(http.StatusOK, "DELETE", "/api/workspace/"+workspace.Id+"/invitations/"+invitation.Id, nil)
+	ann.expect(http.StatusOK, "GET", "/api/workspace/"+workspace.Id+"/invitations?status="+models.InvitationRevoked, nil).decode(t, &invitations)
+	if len(invitations) != 1 {
+		t.Fatalf("expected one revoked invitation, got %d", len(invitations))
+	}
+}
+
+func TestInviteLinksAndDomainJoin(t *testing.T) {
+	server := newServer(t)
+	ann := server.signUp("Ann")
+	bob := server.signUp("Bob")
+	workspace := ann.createWorkspace("Acme")
+
+	var link models.InviteLink
+	ann.expect(http.StatusOK, "POST", "/api/workspace/"+workspace.Id+"/links", models.InviteLinkRequest{Role: models.RoleGuest, MaxUses: 1}).decode(t, &link)
+	ann.expect(http.StatusBadRequest, "POST", "/api/workspace/"+workspace.Id+"/links", models.InviteLinkRequest{Role: models.RoleOwner})
+
+	server.expect(http.StatusOK, "GET", "/api/invite-links/"+link.Token, nil)
+	bob.expect(http.StatusOK, "POST", "/api/invite-links/"+link.Token+"/join", nil)
+	bob.expect(http.StatusOK, "GET", "/api/workspace/"+workspace.Id, nil)
+
+	// The single use is spent
+	server.expect(http.StatusNotFound, "GET", "/api/invite-links/"+link.Token, nil)
+
+	var links []models.InviteLink
+	ann.expect(http.StatusOK, "GET", "/api/workspace/"+workspace.Id+"/links", nil).decode(t, &links)
+	if len(links) != 1 || links[0].Uses != 1 || links[0].Token != "" {
+		t.Fatalf("unexpected links %+v", links)
+	}
+	ann.expect(http.StatusOK, "DELETE", "/api/workspace/"+workspace.Id+"/links/"+link.Id, nil)
+
+	workspace.AllowedDomains = []string{"@KickOf.test"}
+	workspace.DomainJoinApproval = true
+	ann.expect(http.StatusOK, "PATCH", "/api/workspace/"+workspace.Id, workspace)
+
+	cid := server.signUp("Cid")
+	var joinable []models.JoinableWorkspace
+	cid.expect(http.StatusOK, "GET", "/api/workspace/joinable", nil).decode(t, &joinable)
+	if len(joinable) != 1 || !joinable[0].Pending {
+		t.Fatalf("activation should have filed a join request: %+v", joinable)
+	}
+
+	var requests []models.JoinRequest
+	ann.expect(http.StatusOK, "GET", "/api/workspace/"+workspace.Id+"/join-requests", nil).decode(t, &requests)
+	if len(requests) != 1 || requests[0].UserId != cid.userId {
+		t.Fatalf("unexpected join requests %+v", requests)
+	}
+
+	ann.expect(http.StatusOK, "POST", "/api/workspace/"+workspace.Id+"/join-requests/"+requests[0].Id+"/approve", nil)
+	ann.expect(http.StatusBadRequest, "POST", "/api/workspace/"+workspace.Id+"/join-requests/"+requests[0].Id+"/reject", nil)
+	cid.expect(http.StatusOK, "GET", "/api/workspace/"+workspace.Id, nil)
+
+	workspace.DomainJoinApproval = false
+	workspace.UserIds = nil
+	workspace.Roles = nil
+	ann.expect(http.StatusOK, "PATCH", "/api/workspace/"+workspace.Id, workspace)
+	ann.expect(http.StatusOK, "DELETE", "/api/workspace/"+workspace.Id+"/members/"+cid.userId, nil)
+	cid.expect(http.StatusOK, "POST", "/api/workspace/"+workspace.Id+"/join", nil)
+	cid.expect(http.StatusOK, "GET", "/api/workspace/"+workspace.Id, nil)
+}
+
+func TestAccessTokens(t *testing.T) {
+	ann := newServer(t).signUp("Ann")
+	workspace := ann.createWorkspace("Acme")
+	project := ann.createProject(workspace.Id, "Website", "WEB")
+
+	var token models.AccessToken
+	ann.expect(http.StatusOK, "POST", "/api/tokens", models.AccessTokenRequest{Name: "ci", Scopes: []string{models.ScopeReadTasks}}).decode(t, &token)
+
+	reader := ann.as(ann)
+	reader.token = token.Token
+	reader.expect(http.StatusOK, "GET", "/api/task", nil)
+	reader.expect(http.StatusForbidden, "POST", "/api/task", models.Task{ProjectId: project.Id, Title: "Nope"})
+	reader.expect(http.StatusForbidden, "GET", "/api/tokens", nil)
+
+	var tokens []models.AccessToken
+	ann.expect(http.StatusOK, "GET", "/api/tokens", nil).decode(t, &tokens)
+	if len(tokens) != 1 || tokens[0].Token != "" {
+		t.Fatalf("unexpected tokens %+v", tokens)
+	}
+
+	var key models.AccessToken
+	ann.expect(http.StatusOK, "POST", "/api/workspace/"+workspace.Id+"/api-keys", models.AccessTokenRequest{Name: "bot", Scopes: []string{models.ScopeWriteTasks}}).decode(t, &key)
+	bot := ann.as(ann)
+	bot.token = key.Token
+	bot.createTask(models.Task{ProjectId: project.Id, Title: "From the bot"})
+	bot.expect(http.StatusForbidden, "GET", "/api/workspace/"+workspace.Id+"/api-keys", nil)
+
+	// A key for a workspace that does not exist leaves no service account
+	ann.expect(http.StatusNotFound, "POST", "/api/workspace/missing/api-keys", models.AccessTokenRequest{Name: "ghost", Scopes: []string{models.ScopeReadTasks}})
+	_, err := services.CreateWorkspaceApiKey("missing", models.User{Id: ann.userId}, models.AccessTokenRequest{Name: "ghost", Scopes: []string{models.ScopeReadTasks}})
+	if !errors.Is(err, services.ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if count, _ := database.Current().Count(services.UserCollection, bson.M{"service": true}); count != 1 {
+		t.Fatalf("expected only the bot service account, got %d", count)
+	}
+
+	var keys []models.AccessToken
+	ann.expect(http.StatusOK, "GET", "/api/workspace/"+workspace.Id+"/api-keys", nil).decode(t, &keys)
+	ann.expect(http.StatusOK, "DELETE", "/api/workspace/"+workspace.Id+"/api-keys/"+key.Id, nil)
+	bot.expect(http.StatusUnauthorized, "GET", "/api/task", nil)
+
+	ann.expect(http.StatusOK, "DELETE", "/api/tokens/"+token.Id, nil)
+	reader.expect(http.StatusUnauthorized, "GET", "/api/task", nil)
+}
+
+func TestMfa(t *testing.T) {
+	server := newServer(t)
+	ann := server.signUp("Ann")
+
+	var enrollment models.MfaEnrollment
+	ann.expect(http.StatusOK, "POST", "/api/profile/mfa", nil).decode(t, &enrollment)
+
+	code := func() string {
+		value, err := utils.TotpCode(enrollment.Secret, utils.TotpStep(time.Now()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	ann.expect(http.StatusBadRequest, "POST", "/api/profile/mfa/confirm", models.MfaCodeRequest{Code: "000000"})
+
+	var recovery models.RecoveryCodes
+	ann.expect(http.StatusOK, "POST", "/api/profile/mfa/confirm", models.MfaCodeRequest{Code: code()}).decode(t, &recovery)
+	if len(recovery.Codes) == 0 {
+		t.Fatal("confirming should return recovery codes")
+	}
+
+	var challenge models.AuthResult
+	server.expect(http.StatusOK, "POST", "/api/login", models.Login{Email: ann.email, Password: "password-Ann"}).decode(t, &challenge)
+	if !challenge.MfaRequired || challenge.Token != "" {
+		t.Fatalf("login should ask for a second factor: %+v", challenge)
+	}
+
+	var auth models.AuthResult
+	server.expect(http.StatusOK, "POST", "/api/login/mfa", models.MfaLoginRequest{MfaToken: challenge.MfaToken, Code: recovery.Codes[0]}).decode(t, &auth)
+	if auth.Token == "" {
+		t.Fatal("second step should sign in")
+	}
+}
+
+func TestLockoutAdmin(t *testing.T) {
+	server := newServer(t)
+	admin := server.signUp("Admin")
+	eve := server.signUp("Eve")
+
+	for i := 0; i < services.AccountLockoutPolicy.BackoffAfter; i++ {
+		server.expect(http.StatusBadRequest, "POST", "/api/login", models.Login{Email: eve.email, Password: "wrong"})
+	}
+
+	var lockouts []models.Lockout
+	admin.expect(http.StatusOK, "GET", "/api/admin/lockouts", nil).decode(t, &lockouts)
+
+	key := ""
+	for _, lockout := range lockouts {
+		if strings.Contains(lockout.Key, eve.email) {
+			key = lockout.Key
+		}
+	}
+	if key == "" {
+		t.Fatalf("eve's failures should be listed: %+v", lockouts)
+	}
+
+	admin.expect(http.StatusOK, "DELETE", "/api/admin/lockouts/"+key, nil)
+	eve.signUpAgain("Eve")
+}
+
+// TestForgotPassword answers the same whether or not the email has an
+// account, and only creates a reset token for an account.
+func TestForgotPassword(t *testing.T) {
+	server := newServer(t)
+	ann := server.signUp("Ann")
+
+	known := server.expect(http.StatusOK, "POST", "/api/forgot-password", models.ForgotPasswordRequest{Email: ann.email})
+	unknown := server.expect(http.StatusOK, "POST", "/api/forgot-password", models.ForgotPasswordRequest{Email: "nobody@kickof.test"})
+	if !bytes.Equal(known.Body, unknown.Body) {
+		t.Fatalf("responses should not tell accounts apart: %s, %s", known.Body, unknown.Body)
+	}
+
+	purpose := bson.M{"purpose": models.PurposeResetPassword}
+	if count := database.Count(services.VerificationTokenCollection, bson.M{"email": ann.email, "purpose": models.PurposeResetPassword}); count != 1 {
+		t.Fatalf("a reset token should be created for ann, got %d", count)
+	}
+	if count := database.Count(services.VerificationTokenCollection, purpose); count != 1 {
+		t.Fatalf("no reset token should be created without an account, got %d", count)
+	}
+}
+
+// TestLockoutStores counts concurrent failures, which must neither be lost
+// when the key gets throttled nor when its window restarts.
+func TestLockoutStores(t *testing.T) {
+	newServer(t)
+
+	stores := map[string]services.LockoutStore{
+		"memory":   services.NewMemoryLockoutStore(),
+		"database": &services.MongoLockoutStore{},
+	}
+	for name, store := range stores {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				lockout, err := store.Increment("ip:test", time.Hour)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				_ = store.Throttle("ip:test", time.Now().Add(time.Duration(lockout.Failures)*time.Second), time.Time{})
+			}()
+		}
+		wg.Wait()
+
+		lockout := store.Get("ip:test")
+		if lockout == nil || lockout.Failures != 20 || !lockout.IsThrottled(time.Now()) {
+			t.Fatalf("%s: every failure should be counted: %+v", name, lockout)
+		}
+
+		_ = store.Throttle("ip:test", time.Time{}, time.Now().Add(time.Minute))
+		lockout, _ = store.Increment("ip:test", 0)
+		if lockout.Failures != 21 {
+			t.Fatalf("%s: a locked key should keep its failures: %+v", name, lockout)
+		}
+
+		_ = store.Delete("ip:test")
+		_, _ = store.Increment("ip:test", time.Hour)
+		time.Sleep(2 * time.Millisecond) // dates are stored in milliseconds
+		lockout, _ = store.Increment("ip:test", 0)
+		if lockout.Failures != 1 || lockout.IsThrottled(time.Now()) {
+			t.Fatalf("%s: failures out of the window should be forgotten: %+v", name, lockout)
+		}
+	}
+}
+
+// oidcIssuer is an OpenID Connect provider for the tests. The codes a user
+// would get from its login page come from authorize instead.
+type oidcIssuer struct {
+	*httptest.Server
+	t     *testing.T
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]oidcGrant
+}
+
+type oidcGrant struct {
+	claims    jwt.MapClaims
+	challenge string
+}
+
+func newOidcIssuer(t *testing.T) *oidcIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &oidcIssuer{t: t, key: key, codes: map[string]oidcGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.OidcDiscovery{
+			Issuer:                issuer.URL,
+			AuthorizationEndpoint: issuer.URL + "/authorize",
+			TokenEndpoint:         issuer.URL + "/token",
+			JwksUri:               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		encode := func(value *big.Int) string {
+			return base64.RawURLEncoding.EncodeToString(value.Bytes())
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "test", "kty": "RSA", "use": "sig", "n": encode(key.N), "e": encode(big.NewInt(int64(key.E))),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mu.Lock()
+		grant, ok := issuer.codes[r.PostFormValue("code")]
+		delete(issuer.codes, r.PostFormValue("code"))
+		issuer.mu.Unlock()
+
+		if !ok || grant.claims["aud"] != r.PostFormValue("client_id") || services.PkceChallenge(r.PostFormValue("code_verifier")) != grant.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	})
+
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+
+	return issuer
+}
+
+// authorize signs the identity in at the provider for the authorization
+// request of location, and returns the callback the provider redirects to.
+func (i *oidcIssuer) authorize(location string, identity jwt.MapClaims) string {
+	i.t.Helper()
+
+	request, err := url.Parse(location)
+	if err != nil || !strings.HasPrefix(location, i.URL+"/authorize?") {
+		i.t.Fatalf("unexpected authorization request %s", location)
+	}
+	query := request.Query()
+
+	claims := jwt.MapClaims{
+		"iss":   i.URL,
+		"aud":   query.Get("client_id"),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for name, value := range identity {
+		claims[name] = value
+	}
+
+	code := uuid.New().String()
+	i.mu.Lock()
+	i.codes[code] = oidcGrant{claims: claims, challenge: query.Get("code_challenge")}
+	i.mu.Unlock()
+
+	return "/api/sso/callback?" + url.Values{"state": {query.Get("state")}, "code": {code}}.Encode()
+}
+
+// ssoCallback follows the provider back to the callback and returns what it
+// hands the frontend, a token or an error.
+func (c *apiClient) ssoCallback(issuer *oidcIssuer, location string, identity jwt.MapClaims) url.Values {
+	c.t.Helper()
+
+	response := c.anonymous().expect(http.StatusFound, "GET", issuer.authorize(location, identity), nil)
+	frontend, err := url.Parse(response.Header.Get("Location"))
+	if err != nil || frontend.Path != "/sso" {
+		c.t.Fatalf("unexpected callback redirect %s", response.Header.Get("Location"))
+	}
+
+	return frontend.Query()
+}
+
+// ssoLogin signs an identity in through a provider and returns what the
+// callback hands the frontend.
+func (c *apiClient) ssoLogin(issuer *oidcIssuer, provider string, identity jwt.MapClaims) url.Values {
+	c.t.Helper()
+
+	response := c.anonymous().expect(http.StatusFound, "GET", "/api/sso/"+provider+"/login", nil)
+
+	return c.ssoCallback(issuer, response.Header.Get("Location"), identity)
+}
+
+// ssoExchange trades the token of a callback for a session.
+func (c *apiClient) ssoExchange(callback url.Values) models.AuthResult {
+	c.t.Helper()
+
+	if callback.Get("token") == "" {
+		c.t.Fatalf("sign in failed: %s", callback.Get("error"))
+	}
+
+	var auth models.AuthResult
+	c.anonymous().expect(http.StatusOK, "POST", "/api/sso/exchange", models.TokenRequest{Token: callback.Get("token")}).decode(c.t, &auth)
+
+	return auth
+}
+
+func TestSso(t *testing.T) {
+	server := newServer(t)
+	issuer := newOidcIssuer(t)
+	t.Setenv("OIDC_ISSUER", issuer.URL)
+	t.Setenv("OIDC_CLIENT_ID", "kickof")
+	t.Setenv("FRONTEND_URL", "http://app.test")
+
+	ann := server.signUp("Ann")
+	mallory := server.signUp("Mallory")
+
+	// A new identity gets an account, and the exchange token is single use
+	callback := server.ssoLogin(issuer, models.DefaultSsoProvider, jwt.MapClaims{"sub": "sam", "email": "Sam@Corp.test", "email_verified": true, "name": "Sam"})
+	sam := server.ssoExchange(callback)
+	if sam.Token == "" || sam.Email != "sam@corp.test" || sam.Name != "Sam" {
+		t.Fatalf("unexpected session %+v", sam)
+	}
+	server.anonymous().expect(http.StatusBadRequest, "POST", "/api/sso/exchange", models.TokenRequest{Token: callback.Get("token")})
+	server.anonymous().expect(http.StatusFound, "GET", "/api/sso/callback?state=unknown&code=unknown", nil)
+
+	// The instance provider signs in to the account with its email, once
+	// verified
+	callback = server.ssoLogin(issuer, models.DefaultSsoProvider, jwt.MapClaims{"sub": "ann", "email": ann.email})
+	if callback.Get("token") != "" || callback.Get("error") == "" {
+		t.Fatalf("unverified email signed in: %v", callback)
+	}
+	if auth := server.ssoExchange(server.ssoLogin(issuer, models.DefaultSsoProvider, jwt.MapClaims{"sub": "ann", "email": ann.email, "email_verified": true})); auth.Id != ann.userId {
+		t.Fatalf("signed in as %s instead of ann", auth.Id)
+	}
+
+	// Nobody can bring a provider to the workspace of someone else, or to one
+	// that does not exist
+	acme := ann.createWorkspace("Acme")
+	provider := models.SsoProvider{Issuer: issuer.URL, ClientId: "acme"}
+	mallory.expect(http.StatusForbidden, "PATCH", "/api/workspace/"+acme.Id+"/sso", provider)
+	mallory.expect(http.StatusNotFound, "PATCH", "/api/workspace/missing/sso", provider)
+
+	// Workspace providers are held to https and public addresses, unless the
+	// instance allows private ones
+	for _, uri := range []string{issuer.URL, "https://127.0.0.1:1", "https://169.254.169.254", "https://[::1]:1"} {
+		ann.expect(http.StatusBadRequest, "PATCH", "/api/workspace/"+acme.Id+"/sso", models.SsoProvider{Issuer: uri, ClientId: "acme"})
+	}
+	t.Setenv("OIDC_ALLOW_PRIVATE_ISSUERS", "true")
+	ann.expect(http.StatusOK, "PATCH", "/api/workspace/"+acme.Id+"/sso", provider)
+	server.anonymous().expect(http.StatusBadRequest, "GET", "/api/sso/missing/login", nil)
+	server.anonymous().expect(http.StatusBadRequest, "GET", "/api/sso/"+acme.Code+"/login", nil)
+
+	// The provider of a workspace cannot sign in to the account of an email
+	// its workspace does not accept
+	evil := mallory.createWorkspace("Evil")
+	mallory.expect(http.StatusOK, "PATCH", "/api/workspace/"+evil.Id+"/sso", models.SsoProvider{Issuer: issuer.URL, ClientId: "evil"})
+	callback = server.ssoLogin(issuer, evil.Id, jwt.MapClaims{"sub": "fake-ann", "email": ann.email, "email_verified": true})
+	if callback.Get("token") != "" || callback.Get("error") != services.ErrSsoLinkRequired.Error() {
+		t.Fatalf("workspace provider took over ann: %v", callback)
+	}
+	if user := services.GetUser(bson.M{"id": ann.userId}, nil); len(user.Identities) != 1 {
+		t.Fatalf("identity linked to ann: %+v", user.Identities)
+	}
+
+	// Nor when its workspace claims the domain, which nobody verified
+	evil.AllowedDomains = []string{"kickof.test"}
+	mallory.expect(http.StatusOK, "PATCH", "/api/workspace/"+evil.Id, evil)
+	callback = server.ssoLogin(issuer, evil.Id, jwt.MapClaims{"sub": "fake-ann", "email": ann.email, "email_verified": true})
+	if callback.Get("error") != services.ErrSsoLinkRequired.Error() {
+		t.Fatalf("workspace provider took over ann through its domain: %v", callback)
+	}
+
+	// Only the instance vouches for the email that joins workspaces by domain
+	acme.AllowedDomains = []string{"corp.test"}
+	ann.expect(http.StatusOK, "PATCH", "/api/workspace/"+acme.Id, acme)
+	members := func() []string {
+		return services.GetWorkspace(bson.M{"id": acme.Id}, nil).UserIds
+	}
+	joined := server.ssoExchange(server.ssoLogin(issuer, evil.Id, jwt.MapClaims{"sub": "dan", "email": "dan@corp.test", "email_verified": true}))
+	if slices.Contains(members(), joined.Id) {
+		t.Fatal("an email asserted by a workspace provider joined by domain")
+	}
+	joined = server.ssoExchange(server.ssoLogin(issuer, models.DefaultSsoProvider, jwt.MapClaims{"sub": "eve", "email": "eve@corp.test", "email_verified": true}))
+	if !slices.Contains(members(), joined.Id) {
+		t.Fatal("an email of the instance provider did not join by domain")
+	}
+
+	// A signed in user links an identity themselves, which then signs them in
+	var location string
+	ann.expect(http.StatusOK, "POST", "/api/sso/"+evil.Id+"/link", nil).decode(t, &location)
+	server.ssoExchange(server.ssoCallback(issuer, location, jwt.MapClaims{"sub": "ann-evil", "email": "ann@evil.test"}))
+	if auth := server.ssoExchange(server.ssoLogin(issuer, evil.Id, jwt.MapClaims{"sub": "ann-evil"})); auth.Id != ann.userId {
+		t.Fatalf("linked identity signed in as %s instead of ann", auth.Id)
+	}
+
+	mallory.expect(http.StatusOK, "POST", "/api/sso/"+evil.Id+"/link", nil).decode(t, &location)
+	callback = server.ssoCallback(issuer, location, jwt.MapClaims{"sub": "ann-evil"})
+	if callback.Get("token") != "" || callback.Get("error") == "" {
+		t.Fatalf("identity of ann linked to mallory: %v", callback)
+	}
+	server.anonymous().expect(http.StatusUnauthorized, "POST", "/api/sso/"+evil.Id+"/link", nil)
+
+	// The provider stands for the password, the second factor is still asked
+	_, err := database.Current().UpdateOne(services.UserCollection, bson.M{"id": ann.userId}, bson.M{"$set": bson.M{"mfaEnabled": true}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := server.ssoExchange(server.ssoLogin(issuer, models.DefaultSsoProvider, jwt.MapClaims{"sub": "ann"}))
+	if !challenge.MfaRequired || challenge.MfaToken == "" || challenge.Token != "" {
+		t.Fatalf("expected an MFA challenge, got %+v", challenge)
+	}
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"kickof/database"
+	"kickof/models"
+	"kickof/utils"
+	"slices"
+	"strings"
+	"time"
+)
+
+const AccessTokenCollection = "accesstokens"
+
+func GetAccessTokens(filters bson.M, opt *options.FindOptions) []models.AccessToken {
+	results := make([]models.AccessToken, 0)
+
+	cursor := database.Find(AccessTokenCollection, filters, opt)
+	if cursor == nil {
+		return results
+	}
+	for cursor.Next(context.Background()) {
+		var data models.AccessToken
+		if cursor.Decode(&data) == nil {
+			results = append(results, data)
+		}
+	}
+
+	return results
+}
+
+func GetAccessToken(filter bson.M) *models.AccessToken {
+	var data models.AccessToken
+	err := database.FindOne(AccessTokenCollection, filter, nil).Decode(&data)
+	if err != nil {
+		return nil
+	}
+
+	return &data
+}
+
+func validateAccessTokenRequest(request models.AccessTokenRequest) error {
+	if strings.TrimSpace(request.Name) == "" {
+		return errors.New("Name is required")
+	}
+
+	if len(request.Scopes) == 0 {
+		return errors.New("At least one scope is required")
+	}
+
+	for _, scope := range request.Scopes {
+		if !models.IsValidScope(scope) {
+			return errors.New("Invalid scope " + scope)
+		}
+	}
+
+	if request.ExpiresAt != nil && request.ExpiresAt.Before(time.Now()) {
+		return errors.New("Expiration must be in the future")
+	}
+
+	return nil
+}
+
+// createAccessToken stores a new token in the given store, so it can be
+// part of a transaction.
+func createAccessToken(store database.Store, data models.AccessToken) (*models.AccessToken, error) {
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	token := models.AccessTokenPrefix + secret
+
+	data.Id = uuid.New().String()
+	data.Token = token
+	data.Prefix = token[:len(models.AccessTokenPrefix)+6]
+	data.Hash = utils.HashToken(token)
+	data.CreatedAt = time.Now()
+	data.UpdatedAt = time.Now()
+
+	_, err = store.InsertOne(AccessTokenCollection, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func CreatePersonalAccessToken(user models.User, request models.AccessTokenRequest) (*models.AccessToken, error) {
+	err := validateAccessTokenRequest(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return createAccessToken(storage.current(), models.AccessToken{
+		Name:      request.Name,
+		Type:      models.TokenTypePersonal,
+		UserId:    user.Id,
+		CreatedBy: user.Id,
+		Scopes:    request.Scopes,
+		ExpiresAt: request.ExpiresAt,
+	})
+}
+
+func serviceAccountRole(scopes []string) string {
+	if slices.Contains(scopes, models.ScopeAdminWorkspace) {
+		return models.RoleAdmin
+	}
+
+	if slices.Contains(scopes, models.ScopeWriteTasks) {
+		return models.RoleMember
+	}
+
+	return models.RoleGuest
+}
+
+// CreateWorkspaceApiKey creates a service account user that joins the
+// workspace with a role matching the requested scopes. The account, its
+// membership and its token are written together.
+func CreateWorkspaceApiKey(workspaceId string, creator models.User, request models.AccessTokenRequest) (*models.AccessToken, error) {
+	err := validateAccessTokenRequest(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if GetWorkspace(bson.M{"id": workspaceId}, nil) == nil {
+		return nil, ErrNotFound
+	}
+
+	password, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	account := models.User{
+		Id:       uuid.New().String(),
+		Name:     request.Name,
+		Password: utils.HashAndSalt(password),
+		Active:   true,
+		Status:   true,
+		Service:  true,
+	}
+	account.Email = "service+" + account.Id + "@kickof.local"
+	account.CreatedBy = creator.Id
+	account.CreatedAt = time.Now()
+	account.UpdatedAt = time.Now()
+
+	var result *models.AccessToken
+	err = storage.Transaction(func(tx Storage) error {
+		err := tx.Users.InsertOne(account)
+		if err != nil {
+			return err
+		}
+
+		err = addWorkspaceMember(tx, workspaceId, account.Id, serviceAccountRole(request.Scopes))
+		if err != nil {
+			return err
+		}
+
+		result, err = createAccessToken(tx.current(), models.AccessToken{
+			Name:        request.Name,
+			Type:        models.TokenTypeService,
+			UserId:      account.Id,
+			WorkspaceId: workspaceId,
+			CreatedBy:   creator.Id,
+			Scopes:      request.Scopes,
+			ExpiresAt:   request.ExpiresAt,
+		})
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func RevokeAccessToken(token models.AccessToken) error {
+	_, err := database.UpdateOne(AccessTokenCollection, bson.M{"id": token.Id}, bson.M{"revokedAt": time.Now()})
+	if err != nil {
+		return err
+	}
+
+	if token.Type == models.TokenTypeService {
+		return RemoveWorkspaceMember(token.WorkspaceId, token.UserId)
+	}
+
+	return nil
+}
+
+// AuthenticateAccessToken resolves a token presented as bearer into the
+// token and the user it acts as.
+func AuthenticateAccessToken(token string) (*models.AccessToken, *models.User, error) {
+	data := GetAccessToken(bson.M{"hash": utils.HashToken(token)})
+	if data == nil || data.RevokedAt != nil {
+		return nil, nil, errors.New("token invalid")
+	}
+
+	if data.ExpiresAt != nil && data.ExpiresAt.Before(time.Now()) {
+		return nil, nil, errors.New("token expired")
+	}
+
+	user := GetUser(bson.M{"id": data.UserId}, options.FindOne().SetProjection(bson.M{"password": 0}))
+	if user == nil {
+		return nil, nil, errors.New("user not found")
+	}
+
+	// Only record usage once a minute to avoid a write on every request
+	if data.LastUsedAt == nil || time.Since(*data.LastUsedAt) > time.Minute {
+		_, _ = database.UpdateOne(AccessTokenCollection, bson.M{"id": data.Id}, bson.M{"lastUsedAt": time.Now()})
+	}
+
+	return data, user, nil
+}
@@ -2,104 +2,63 @@ package services
 
 import (
 	"errors"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"kickof/database"
 	"kickof/models"
 	"kickof/utils"
 	"log"
-	"net/http"
-	"os"
-	"strings"
 	"time"
 )
 
-func GenerateToken(email string) (*string, error) {
-	expire := time.Now().Add(24 * time.Hour)
+// dummyPassword is compared against when the email is unknown so both
+// failure cases take about the same time.
+var dummyPassword = utils.HashAndSalt("kickof-dummy-password")
 
-	claims := models.Claims{
-		Email: email,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expire.Unix(),
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET_KEY")))
-
-	if err == nil {
-		return &tokenString, nil
-	}
-
-	return nil, errors.New(err.Error())
-}
-
-func CheckHeader(r *http.Request) (string, error) {
-	header := r.Header["Authorization"]
-
-	if header == nil {
-		return "", errors.New("unauthorized")
-	}
-
-	split := strings.Split(header[0], " ")
-	if len(split) != 2 || strings.ToLower(split[0]) != "bearer" {
-		return "", errors.New("unauthorized")
-	}
-
-	return split[1], nil
-}
-
-func VerifyToken(tokenString string) (string, error) {
-	claims := &models.Claims{}
-
-	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET_KEY")), nil
-	})
+var ErrEmailExists = errors.New("Email already exists")
 
+func SignIn(params models.Login, ip string) (*models.User, error) {
+	err := CheckLoginAllowed(params.Email, ip)
 	if err != nil {
-		log.Println(err)
-		return "", errors.New("Token invalid")
+		return nil, err
 	}
 
-	return claims.Email, nil
-}
-
-func SignIn(params models.Login) (*string, error) {
 	user := GetUser(bson.M{"email": params.Email}, nil)
 
-	if user == nil {
-		return nil, errors.New("User not found")
+	hashed := dummyPassword
+	if user != nil && !user.Service {
+		hashed = user.Password
 	}
 
-	pass := utils.ComparePassword(user.Password, []byte(params.Password))
+	pass := utils.ComparePassword(hashed, []byte(params.Password))
 
-	if !pass {
-		return nil, errors.New("Password does not match")
+	if user == nil || user.Service || !pass {
+		RegisterLoginFailure(params.Email, ip)
+		return nil, ErrInvalidCredentials
 	}
 
-	token, err := GenerateToken(params.Email)
+	ClearLoginFailures(params.Email)
 
-	if err == nil {
-		data := user
-		data.LastActive = time.Now()
+	if IsSsoEnforced(*user) {
+		return nil, ErrSsoRequired
+	}
 
-		_, err = database.UpdateOne(UserCollection, bson.M{"email": params.Email}, data)
-		if err != nil {
-			return nil, err
-		}
+	data := user
+	data.LastActive = time.Now()
 
-		return token, nil
+	_, err = storage.Users.UpdateOne(bson.M{"email": params.Email}, data)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, errors.New(err.Error())
+	return user, nil
 }
 
-func Register(params models.Register, url string) (*string, error) {
+func Register(params models.Register, url string) (*models.User, error) {
 	email := GetUser(bson.M{"email": params.Email}, nil)
 
 	if email != nil {
-		return nil, errors.New("Email already exists")
+		return nil, ErrEmailExists
 	}
 
 	request := models.User{}
@@ -110,13 +69,38 @@ func Register(params models.Register, url string) (*string, error) {
 	request.CreatedAt = time.Now()
 	request.LastActive = time.Now()
 
-	_, e := database.InsertOne(UserCollection, request)
+	e := storage.Users.InsertOne(request)
 
+	// The unique index catches a concurrent registration the check missed
+	if database.IsDuplicateKey(e) {
+		return nil, ErrEmailExists
+	}
 	if e != nil {
 		return nil, e
 	}
 
-	token, err := GenerateToken(params.Email)
+	// An invitation link proves the email just like the activation link does
+	if params.InviteToken != "" {
+		_, err := AcceptInvitationToken(params.InviteToken, request)
+		if err == nil {
+			request.Active = true
+			request.Status = true
+
+			_, err = storage.Users.UpdateOne(bson.M{"id": request.Id}, bson.M{"active": true, "status": true})
+			if err != nil {
+				return nil, err
+			}
+
+			AcceptPendingInvitations(request)
+			JoinWorkspacesByDomain(request)
+
+			return &request, nil
+		}
+
+		log.Println("Failed to accept invitation on registration", err)
+	}
+
+	token, err := GenerateVerificationToken(request.Email, models.PurposeActivate, "")
 
 	if err != nil {
 		return nil, err
@@ -135,22 +119,19 @@ func Register(params models.Register, url string) (*string, error) {
 		log.Println("Failed to send verification email")
 	}
 
-	return token, nil
+	return &request, nil
 }
 
 func Activate(token string) (bool, error) {
-	email, err := VerifyToken(token)
+	verification, err := ConsumeVerificationToken(token, models.PurposeActivate)
 
 	if err != nil {
 		return false, err
 	}
 
-	filter := bson.M{"email": email}
-
-	var user models.User
-	cursor := database.FindOne(UserCollection, filter, nil)
+	filter := bson.M{"email": verification.Email}
 
-	err = cursor.Decode(&user)
+	user, err := storage.Users.FindOne(filter, nil)
 	if err != nil {
 		return false, err
 	}
@@ -158,65 +139,121 @@ func Activate(token string) (bool, error) {
 	user.Active = true
 	user.Status = true
 
-	_, err = database.UpdateOne(UserCollection, filter, user)
+	_, err = storage.Users.UpdateOne(filter, user)
 	if err != nil {
 		return false, err
 	}
 
+	AcceptPendingInvitations(*user)
+	JoinWorkspacesByDomain(*user)
+
 	return true, nil
 }
 
+// ForgotPassword emails a reset link to the account of the email, if there
+// is one. It succeeds either way, so that it cannot tell which emails have an
+// account.
 func ForgotPassword(email string, url string) (bool, error) {
 	user := GetUser(bson.M{"email": email}, nil)
 	if user == nil {
-		return false, errors.New("user not found")
+		return true, nil
+	}
+
+	token, err := GenerateVerificationToken(user.Email, models.PurposeResetPassword, "")
+	if err != nil {
+		log.Println("Failed to create reset token", err)
+		return true, nil
 	}
 
-	token, err := GenerateToken(email)
+	data := models.VerificationMail{
+		Name: user.Name,
+		Link: url + *token,
+	}
+
+	_, err = utils.SendEmailVerification("forgot-password", user.Email, data)
+	if err != nil {
+		log.Println("Failed to send reset email", err)
+	}
+
+	return true, nil
+}
+
+func UpdatePassword(token string, password string) (bool, error) {
+	if password == "" {
+		return false, errors.New("Password is required")
+	}
+
+	verification, err := ConsumeVerificationToken(token, models.PurposeResetPassword)
 
 	if err != nil {
 		return false, err
 	}
 
-	tokenValue := *token
+	filter := bson.M{"email": verification.Email}
 
-	data := models.VerificationMail{
-		Name: user.Name,
-		Link: url + tokenValue,
+	user, err := storage.Users.FindOne(filter, nil)
+	if err != nil {
+		return false, err
 	}
 
-	res, err := utils.SendEmailVerification("forgot-password", user.Email, data)
+	user.Password = utils.HashAndSalt(password)
+
+	_, err = storage.Users.UpdateOne(filter, user)
+	if err != nil {
+		return false, err
+	}
 
+	// A password reset usually means the old one leaked: end every session
+	err = RevokeUserSessions(user.Id)
 	if err != nil {
 		return false, err
 	}
 
-	return res, nil
+	return true, nil
 }
 
-func UpdatePassword(token string, password string) (bool, error) {
-	email, err := VerifyToken(token)
+func RequestEmailChange(user models.User, email string, url string) (bool, error) {
+	if GetUser(bson.M{"email": email}, nil) != nil {
+		return false, ErrEmailExists
+	}
 
+	token, err := GenerateVerificationToken(user.Email, models.PurposeEmailChange, email)
 	if err != nil {
 		return false, err
 	}
 
-	filter := bson.M{"email": email}
+	data := models.VerificationMail{
+		Name: user.Name,
+		Link: url + *token,
+	}
 
-	var user models.User
-	cursor := database.FindOne(UserCollection, filter, nil)
+	return utils.SendEmailVerification("email-change", email, data)
+}
 
-	err = cursor.Decode(&user)
+func ConfirmEmailChange(token string) (bool, error) {
+	verification, err := ConsumeVerificationToken(token, models.PurposeEmailChange)
 	if err != nil {
 		return false, err
 	}
 
-	user.Password = utils.HashAndSalt(password)
+	if GetUser(bson.M{"email": verification.Reference}, nil) != nil {
+		return false, ErrEmailExists
+	}
 
-	_, err = database.UpdateOne(UserCollection, filter, user)
+	res, err := storage.Users.UpdateOne(bson.M{"email": verification.Email}, bson.M{
+		"email":     verification.Reference,
+		"updatedAt": time.Now(),
+	})
+	if database.IsDuplicateKey(err) {
+		return false, ErrEmailExists
+	}
 	if err != nil {
 		return false, err
 	}
 
+	if res.MatchedCount == 0 {
+		return false, errors.New("User not found")
+	}
+
 	return true, nil
 }
@@ -0,0 +1,349 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"kickof/database"
+	"kickof/models"
+	"kickof/utils"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const InvitationCollection = "invitations"
+
+func GetInvitations(filters bson.M, opt *options.FindOptions) []models.Invitation {
+	results := make([]models.Invitation, 0)
+
+	cursor := database.Find(InvitationCollection, filters, opt)
+	if cursor == nil {
+		return results
+	}
+	for cursor.Next(context.Background()) {
+		var data models.Invitation
+		if cursor.Decode(&data) == nil {
+			data.Status = data.GetStatus()
+			results = append(results, data)
+		}
+	}
+
+	return results
+}
+
+func GetInvitation(filter bson.M) *models.Invitation {
+	var data models.Invitation
+	err := database.FindOne(InvitationCollection, filter, nil).Decode(&data)
+	if err != nil {
+		return nil
+	}
+
+	data.Status = data.GetStatus()
+	return &data
+}
+
+func sendInvitation(invitation models.Invitation, workspace models.Workspace, inviter models.User) error {
+	token, err := GenerateVerificationToken(invitation.Email, models.PurposeInvite, invitation.Id)
+	if err != nil {
+		return err
+	}
+
+	data := models.InvitationMail{
+		Name:      invitation.Email,
+		Workspace: workspace.Name,
+		Inviter:   inviter.Name,
+		Role:      invitation.Role,
+		Link:      os.Getenv("FRONTEND_URL") + "/invitation/" + *token,
+	}
+
+	return utils.SendEmail(invitation.Email, "You have been invited to "+workspace.Name, data, "templates/invitation.html")
+}
+
+func CreateInvitation(workspaceId string, inviter models.User, request models.InvitationRequest) (*models.Invitation, error) {
+	workspace := GetWorkspace(bson.M{"id": workspaceId}, nil)
+	if workspace == nil {
+		return nil, errors.New("Workspace not found")
+	}
+
+	email := strings.ToLower(strings.TrimSpace(request.Email))
+	if !strings.Contains(email, "@") {
+		return nil, errors.New("Invalid email")
+	}
+
+	role := request.Role
+	if role == "" {
+		role = models.RoleMember
+	}
+
+	if !models.IsValidRole(role) {
+		return nil, errors.New("Invalid role " + role)
+	}
+
+	if role == models.RoleOwner && workspace.GetRole(inviter.Id) != models.RoleOwner {
+		return nil, errors.New("Only owners can invite owners")
+	}
+
+	user := GetUser(bson.M{"email": email}, nil)
+	if user != nil && workspace.GetRole(user.Id) != "" {
+		return nil, errors.New("User is already a member of the workspace")
+	}
+
+	pending := GetInvitation(bson.M{"workspaceId": workspaceId, "email": email, "status": models.InvitationPending, "expiresAt": bson.M{"$gt": time.Now()}})
+	if pending != nil {
+		return nil, errors.New("An invitation is already pending for this email")
+	}
+
+	invitation := models.Invitation{
+		Id:          uuid.New().String(),
+		WorkspaceId: workspaceId,
+		Email:       email,
+		Role:        role,
+		Status:      models.InvitationPending,
+		InvitedBy:   inviter.Id,
+		ExpiresAt:   time.Now().Add(VerificationTokenTTL[models.PurposeInvite]),
+		SentAt:      time.Now(),
+	}
+	invitation.CreatedAt = time.Now()
+	invitation.UpdatedAt = time.Now()
+
+	_, err := database.InsertOne(InvitationCollection, invitation)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sendInvitation(invitation, *workspace, inviter)
+	if err != nil {
+		log.Println("Failed to send invitation email", err)
+	}
+
+	return &invitation, nil
+}
+
+// ResendInvitation sends a fresh link and extends the expiry; links sent
+// before stop working.
+func ResendInvitation(invitation models.Invitation, inviter models.User) (*models.Invitation, error) {
+	if invitation.Status != models.InvitationPending && invitation.Status != models.InvitationExpired {
+		return nil, errors.New("Only pending invitations can be resent")
+	}
+
+	workspace := GetWorkspace(bson.M{"id": invitation.WorkspaceId}, nil)
+	if workspace == nil {
+		return nil, errors.New("Workspace not found")
+	}
+
+	err := InvalidateVerificationTokens(models.PurposeInvite, invitation.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation.Status = models.InvitationPending
+	invitation.ExpiresAt = time.Now().Add(VerificationTokenTTL[models.PurposeInvite])
+	invitation.SentAt = time.Now()
+	invitation.UpdatedAt = time.Now()
+
+	_, err = database.UpdateOne(InvitationCollection, bson.M{"id": invitation.Id}, bson.M{
+		"status":    invitation.Status,
+		"expiresAt": invitation.ExpiresAt,
+		"sentAt":    invitation.SentAt,
+		"updatedAt": invitation.UpdatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = sendInvitation(invitation, *workspace, inviter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+func setInvitationStatus(invitation models.Invitation, status string) error {
+	res, err := database.UpdateOne(InvitationCollection, bson.M{"id": invitation.Id, "status": models.InvitationPending}, bson.M{
+		"status":      status,
+		"respondedAt": time.Now(),
+		"updatedAt":   time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return errors.New("Invitation is no longer pending")
+	}
+
+	return InvalidateVerificationTokens(models.PurposeInvite, invitation.Id)
+}
+
+func RevokeInvitation(invitation models.Invitation) error {
+	if invitation.Status != models.InvitationPending && invitation.Status != models.InvitationExpired {
+		return errors.New("Only pending invitations can be revoked")
+	}
+
+	return setInvitationStatus(invitation, models.InvitationRevoked)
+}
+
+// acceptInvitation joins the user to the workspace. The caller must have
+// checked the user owns the invited email.
+func acceptInvitation(invitation models.Invitation, user models.User) error {
+	if invitation.GetStatus() != models.InvitationPending {
+		return errors.New("Invitation is " + invitation.GetStatus())
+	}
+
+	err := setInvitationStatus(invitation, models.InvitationAccepted)
+	if err != nil {
+		return err
+	}
+
+	return AddWorkspaceMember(invitation.WorkspaceId, user.Id, invitation.Role)
+}
+
+func getInvitationFromToken(token string) (*models.Invitation, error) {
+	verification, err := CheckVerificationToken(token, models.PurposeInvite)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := GetInvitation(bson.M{"id": verification.Reference})
+	if invitation == nil {
+		return nil, errors.New("Invitation not found")
+	}
+
+	return invitation, nil
+}
+
+// GetInvitationByToken lets the invite page show what is being accepted
+// without consuming the link.
+func GetInvitationByToken(token string) (*models.Invitation, error) {
+	invitation, err := getInvitationFromToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace := GetWorkspace(bson.M{"id": invitation.WorkspaceId}, options.FindOne().SetProjection(bson.M{"id": 1, "name": 1, "code": 1}))
+	if workspace != nil {
+		invitation.Workspace = workspace
+	}
+
+	return invitation, nil
+}
+
+func AcceptInvitationToken(token string, user models.User) (*models.Invitation, error) {
+	invitation, err := getInvitationFromToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(invitation.Email, user.Email) {
+		return nil, errors.New("This invitation was sent to another email address")
+	}
+
+	_, err = ConsumeVerificationToken(token, models.PurposeInvite)
+	if err != nil {
+		return nil, err
+	}
+
+	err = acceptInvitation(*invitation, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+func DeclineInvitationToken(token string) error {
+	invitation, err := getInvitationFromToken(token)
+	if err != nil {
+		return err
+	}
+
+	if invitation.GetStatus() != models.InvitationPending {
+		return errors.New("Invitation is " + invitation.GetStatus())
+	}
+
+	return setInvitationStatus(*invitation, models.InvitationDeclined)
+}
+
+func RespondToInvitation(id string, user models.User, accept bool) error {
+	// Until the email is verified the account does not prove it owns it
+	if !user.Active {
+		return errors.New("Verify your email before answering invitations")
+	}
+
+	invitation := GetInvitation(bson.M{"id": id, "email": strings.ToLower(user.Email)})
+	if invitation == nil {
+		return errors.New("Invitation not found")
+	}
+
+	if !accept {
+		if invitation.GetStatus() != models.InvitationPending {
+			return errors.New("Invitation is " + invitation.GetStatus())
+		}
+		return setInvitationStatus(*invitation, models.InvitationDeclined)
+	}
+
+	return acceptInvitation(*invitation, user)
+}
+
+// AcceptPendingInvitations joins a user whose email was just verified to every
+// workspace that invited them before they had an account.
+func AcceptPendingInvitations(user models.User) {
+	filters := bson.M{
+		"email":     strings.ToLower(user.Email),
+		"status":    models.InvitationPending,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+
+	for _, invitation := range GetInvitations(filters, nil) {
+		err := acceptInvitation(invitation, user)
+		if err != nil {
+			log.Println("Failed to accept invitation", invitation.Id, err)
+		}
+	}
+}
+
+// LeaveWorkspace removes the user from a workspace, refusing to leave it
+// without an owner.
+func LeaveWorkspace(workspaceId string, userId string) error {
+	workspace := GetWorkspace(bson.M{"id": workspaceId}, nil)
+	if workspace == nil {
+		return errors.New("Workspace not found")
+	}
+
+	workspace.SyncRoles()
+	if workspace.GetRole(userId) == models.RoleOwner && workspace.CountOwners() == 1 {
+		return errors.New("Transfer ownership before leaving the workspace")
+	}
+
+	return RemoveWorkspaceMember(workspaceId, userId)
+}
+
+func RemoveMember(workspaceId string, actorId string, userId string) error {
+	workspace := GetWorkspace(bson.M{"id": workspaceId}, nil)
+	if workspace == nil {
+		return errors.New("Workspace not found")
+	}
+
+	workspace.SyncRoles()
+
+	role := workspace.GetRole(userId)
+	if role == "" {
+		return errors.New("User is not a member of the workspace")
+	}
+
+	if role == models.RoleOwner {
+		if workspace.GetRole(actorId) != models.RoleOwner {
+			return errors.New("Only owners can remove an owner")
+		}
+		if workspace.CountOwners() == 1 {
+			return errors.New("Workspace must have at least one owner")
+		}
+	}
+
+	return RemoveWorkspaceMember(workspaceId, userId)
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"kickof/database"
+	"kickof/models"
+	"kickof/utils"
+	"log"
+	"os"
+	"slices"
+	"time"
+)
+
+const (
+	JoinRequestCollection = "joinrequests"
+	InviteLinkCollection  = "invitelinks"
+)
+
+var workspaceSummary = options.FindOne().SetProjection(bson.M{"id": 1, "name": 1, "code": 1})
+
+func GetJoinRequests(filters bson.M, opt *options.FindOptions) []models.JoinRequest {
+	results := make([]models.JoinRequest, 0)
+
+	cursor := database.Find(JoinRequestCollection, filters, opt)
+	if cursor == nil {
+		return results
+	}
+	for cursor.Next(context.Background()) {
+		var data models.JoinRequest
+		if cursor.Decode(&data) == nil {
+			results = append(results, data)
+		}
+	}
+
+	return results
+}
+
+func GetJoinRequest(filter bson.M) *models.JoinRequest {
+	var data models.JoinRequest
+	err := database.FindOne(JoinRequestCollection, filter, nil).Decode(&data)
+	if err != nil {
+		return nil
+	}
+
+	return &data
+}
+
+// GetJoinableWorkspaces lists the workspaces that accept the user's email
+// domain and the user is not a member of yet.
+func GetJoinableWorkspaces(user models.User) []models.JoinableWorkspace {
+	results := make([]models.JoinableWorkspace, 0)
+
+	domain := models.EmailDomain(user.Email)
+	if domain == "" || !user.Active {
+		return results
+	}
+
+	filters := bson.M{"allowedDomains": domain, "userids": bson.M{"$ne": user.Id}}
+	for _, workspace := range GetWorkspaces(filters, nil, nil) {
+		pending := GetJoinRequest(bson.M{"workspaceId": workspace.Id, "userId": user.Id, "status": models.JoinRequestPending})
+
+		results = append(results, models.JoinableWorkspace{
+			Id:               workspace.Id,
+			Name:             workspace.Name,
+			Code:             workspace.Code,
+			RequiresApproval: workspace.DomainJoinApproval,
+			Pending:          pending != nil,
+		})
+	}
+
+	return results
+}
+
+// JoinWorkspaceByDomain adds the user as a member when the workspace accepts
+// their verified email domain, or files a join request when an admin has to
+// approve it first. It returns true when the user joined right away.
+func JoinWorkspaceByDomain(workspaceId string, user models.User) (bool, error) {
+	workspace := GetWorkspace(bson.M{"id": workspaceId}, nil)
+	if workspace == nil {
+		return false, errors.New("Workspace not found")
+	}
+
+	if !user.Active {
+		return false, errors.New("Verify your email before joining a workspace")
+	}
+
+	domain := models.EmailDomain(user.Email)
+	workspace.NormalizeDomains()
+	if domain == "" || !slices.Contains(workspace.AllowedDomains, domain) {
+		return false, errors.New("Your email domain is not allowed to join this workspace")
+	}
+
+	if workspace.GetRole(user.Id) != "" {
+		return false, errors.New("User is already a member of the workspace")
+	}
+
+	if !workspace.DomainJoinApproval {
+		return true, AddWorkspaceMember(workspaceId, user.Id, models.RoleMember)
+	}
+
+	pending := GetJoinRequest(bson.M{"workspaceId": workspaceId, "userId": user.Id, "status": models.JoinRequestPending})
+	if pending != nil {
+		return false, nil
+	}
+
+	request := models.JoinRequest{
+		Id:          uuid.New().String(),
+		WorkspaceId: workspaceId,
+		UserId:      user.Id,
+		Email:       user.Email,
+		Status:      models.JoinRequestPending,
+	}
+	request.CreatedAt = time.Now()
+	request.UpdatedAt = time.Now()
+
+	_, err := database.InsertOne(JoinRequestCollection, request)
+	if err != nil {
+		return false, err
+	}
+
+	return false, nil
+}
+
+// JoinWorkspacesByDomain runs the domain join for every workspace accepting
+// the user's email, once the email has been verified.
+func JoinWorkspacesByDomain(user models.User) {
+	for _, workspace := range GetJoinableWorkspaces(user) {
+		_, err := JoinWorkspaceByDomain(workspace.Id, user)
+		if err != nil {
+			log.Println("Failed to join workspace by domain", workspace.Id, err)
+		}
+	}
+}
+
+func DecideJoinRequest(request models.JoinRequest, actorId string, approve bool) error {
+	if request.Status != models.JoinRequestPending {
+		return errors.New("Join request is " + request.Status)
+	}
+
+	status := models.JoinRequestRejected
+	if approve {
+		status = models.JoinRequestApproved
+	}
+
+	res, err := database.UpdateOne(JoinRequestCollection, bson.M{"id": request.Id, "status": models.JoinRequestPending}, bson.M{
+		"status":    status,
+		"decidedBy": actorId,
+		"decidedAt": time.Now(),
+		"updatedAt": time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return errors.New("Join request is no longer pending")
+	}
+
+	if !approve {
+		return nil
+	}
+
+	return AddWorkspaceMember(request.WorkspaceId, request.UserId, models.RoleMember)
+}
+
+func GetInviteLinks(filters bson.M, opt *options.FindOptions) []models.InviteLink {
+	results := make([]models.InviteLink, 0)
+
+	cursor := database.Find(InviteLinkCollection, filters, opt)
+	if cursor == nil {
+		return results
+	}
+	for cursor.Next(context.Background()) {
+		var data models.InviteLink
+		if cursor.Decode(&data) == nil {
+			results = append(results, data)
+		}
+	}
+
+	return results
+}
+
+func GetInviteLink(filter bson.M) *models.InviteLink {
+	var data models.InviteLink
+	err := database.FindOne(InviteLinkCollection, filter, nil).Decode(&data)
+	if err != nil {
+		return nil
+	}
+
+	return &data
+}
+
+func CreateInviteLink(workspaceId string, creator models.User, request models.InviteLinkRequest) (*models.InviteLink, error) {
+	workspace := GetWorkspace(bson.M{"id": workspaceId}, nil)
+	if workspace == nil {
+		return nil, errors.New("Workspace not found")
+	}
+
+	role := request.Role
+	if role == "" {
+		role = models.RoleMember
+	}
+
+	if !models.IsValidRole(role) {
+		return nil, errors.New("Invalid role " + role)
+	}
+
+	// Anybody holding the link gets the role, so it cannot hand out ownership
+	if role == models.RoleOwner {
+		return nil, errors.New("Invite links cannot grant the owner role")
+	}
+
+	if request.MaxUses < 0 {
+		return nil, errors.New("Max uses cannot be negative")
+	}
+
+	if request.ExpiresAt != nil && request.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("Expiry must be in the future")
+	}
+
+	token, err := utils.RandomToken(24)
+	if err != nil {
+		return nil, err
+	}
+
+	link := models.InviteLink{
+		Id:          uuid.New().String(),
+		WorkspaceId: workspaceId,
+		Role:        role,
+		Hash:        utils.HashToken(token),
+		MaxUses:     request.MaxUses,
+		ExpiresAt:   request.ExpiresAt,
+		CreatedBy:   creator.Id,
+	}
+	link.CreatedAt = time.Now()
+	link.UpdatedAt = time.Now()
+
+	_, err = database.InsertOne(InviteLinkCollection, link)
+	if err != nil {
+		return nil, err
+	}
+
+	link.Token = token
+	link.Link = os.Getenv("FRONTEND_URL") + "/join/" + token
+
+	return &link, nil
+}
+
+func RevokeInviteLink(link models.InviteLink) error {
+	if link.RevokedAt != nil {
+		return errors.New("Invite link is already revoked")
+	}
+
+	_, err := database.UpdateOne(InviteLinkCollection, bson.M{"id": link.Id}, bson.M{
+		"revokedAt": time.Now(),
+		"updatedAt": time.Now(),
+	})
+
+	return err
+}
+
+func getUsableInviteLink(token string) (*models.InviteLink, error) {
+	link := GetInviteLink(bson.M{"hash": utils.HashToken(token)})
+	if link == nil || !link.IsUsable(time.Now()) {
+		return nil, errors.New("Invite link is invalid or has expired")
+	}
+
+	return link, nil
+}
+
+// GetInviteLinkByToken lets the join page show the workspace before the
+// user commits to joining.
+func GetInviteLinkByToken(token string) (*models.InviteLink, error) {
+	link, err := getUsableInviteLink(token)
+	if err != nil {
+		return nil, err
+	}
+
+	link.Workspace = GetWorkspace(bson.M{"id": link.WorkspaceId}, workspaceSummary)
+
+	return link, nil
+}
+
+// JoinWithInviteLink spends one use of the link and adds the user to its
+// workspace. Uses are claimed with a compare-and-set on the counter so
+// concurrent joins cannot go over MaxUses.
+func JoinWithInviteLink(token string, user models.User) (*models.InviteLink, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		link, err := getUsableInviteLink(token)
+		if err != nil {
+			return nil, err
+		}
+
+		workspace := GetWorkspace(bson.M{"id": link.WorkspaceId}, nil)
+		if workspace == nil {
+			return nil, errors.New("Workspace not found")
+		}
+
+		if workspace.GetRole(user.Id) != "" {
+			return nil, errors.New("User is already a member of the workspace")
+		}
+
+		res, err := database.UpdateOne(InviteLinkCollection, bson.M{"id": link.Id, "uses": link.Uses, "revokedAt": nil}, bson.M{
+			"uses":      link.Uses + 1,
+			"updatedAt": time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if res.MatchedCount == 0 {
+			continue
+		}
+
+		err = AddWorkspaceMember(link.WorkspaceId, user.Id, link.Role)
+		if err != nil {
+			return nil, err
+		}
+
+		link.Uses++
+		link.Workspace = GetWorkspace(bson.M{"id": link.WorkspaceId}, workspaceSummary)
+
+		return link, nil
+	}
+
+	return nil, errors.New("Invite link is busy, please try again")
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"kickof/models"
+	"slices"
+)
+
+// Relations lists embed on request, with ?include=
+const (
+	IncludeAssignees = "assignees"
+	IncludeLabels    = "labels"
+	IncludeTasks     = "tasks"
+	IncludeProject   = "project"
+	IncludeMembers   = "members"
+)
+
+// Default relations of each list, those embedded before ?include= existed
+var (
+	TaskIncludes      = []string{IncludeAssignees, IncludeLabels}
+	StateIncludes     = []string{IncludeProject, IncludeTasks, IncludeAssignees, IncludeLabels}
+	ProjectIncludes   = []string{IncludeMembers}
+	WorkspaceIncludes = []string{IncludeMembers}
+)
+
+// The loaders below fetch one relation for a whole result set with a single
+// $in on the distinct ids, instead of a query per document.
+
+func distinct(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+
+	return result
+}
+
+func loadUsers(ids []string) map[string]models.User {
+	result := map[string]models.User{}
+
+	ids = distinct(ids)
+	if len(ids) == 0 {
+		return result
+	}
+
+	users, err := storage.Users.Find(bson.M{"id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.D{{Key: "password", Value: 0}}))
+	logError(err)
+
+	for _, user := range users {
+		result[user.Id] = user
+	}
+
+	return result
+}
+
+func loadTaskLabels(ids []string) map[string]models.TaskLabel {
+	result := map[string]models.TaskLabel{}
+
+	ids = distinct(ids)
+	if len(ids) == 0 {
+		return result
+	}
+
+	labels, err := storage.TaskLabels.Find(bson.M{"id": bson.M{"$in": ids}}, nil)
+	logError(err)
+
+	for _, label := range labels {
+		result[label.Id] = label
+	}
+
+	return result
+}
+
+func loadProjects(ids []string) map[string]models.Project {
+	result := map[string]models.Project{}
+
+	ids = distinct(ids)
+	if len(ids) == 0 {
+		return result
+	}
+
+	projects, err := storage.Projects.Find(bson.M{"id": bson.M{"$in": ids}}, nil)
+	logError(err)
+
+	for _, project := range projects {
+		result[project.Id] = project
+	}
+
+	return result
+}
+
+// pick returns the loaded documents of the ids, in the order of the ids.
+func pick[T any](loaded map[string]T, ids []string) []T {
+	result := make([]T, 0, len(ids))
+	for _, id := range ids {
+		if document, ok := loaded[id]; ok {
+			result = append(result, document)
+		}
+	}
+
+	return result
+}
+
+// LoadTaskRelations embeds the included assignees and labels in tasks.
+func LoadTaskRelations(tasks []models.Task, include []string) {
+	if slices.Contains(include, IncludeAssignees) {
+		var ids []string
+		for _, task := range tasks {
+			ids = append(ids, task.AssigneeIds...)
+		}
+
+		users := loadUsers(ids)
+		for i := range tasks {
+			tasks[i].Assignees = pick(users, tasks[i].AssigneeIds)
+		}
+	}
+
+	if slices.Contains(include, IncludeLabels) {
+		var ids []string
+		for _, task := range tasks {
+			ids = append(ids, task.LabelIds...)
+		}
+
+		labels := loadTaskLabels(ids)
+		for i := range tasks {
+			tasks[i].Labels = pick(labels, tasks[i].LabelIds)
+		}
+	}
+}
+
+// LoadStateRelations embeds the included project and tasks in states, the
+// tasks with their own included relations.
+func LoadStateRelations(states []models.State, include []string) {
+	if slices.Contains(include, IncludeProject) {
+		var ids []string
+		for _, state := range states {
+			ids = append(ids, state.ProjectId)
+		}
+
+		projects := loadProjects(ids)
+		for i := range states {
+			if project, ok := projects[states[i].ProjectId]; ok {
+				states[i].Project = project
+			}
+		}
+	}
+
+	if slices.Contains(include, IncludeTasks) {
+		ids := make([]string, 0, len(states))
+		for _, state := range states {
+			ids = append(ids, state.Id)
+		}
+
+		byState := map[string][]models.Task{}
+		if len(ids) > 0 {
+			for _, task := range GetTasks(bson.M{"stateId": bson.M{"$in": ids}}, nil, include) {
+				byState[task.StateId] = append(byState[task.StateId], task)
+			}
+		}
+
+		for i := range states {
+			states[i].Tasks = byState[states[i].Id]
+			if states[i].Tasks == nil {
+				states[i].Tasks = []models.Task{}
+			}
+		}
+	}
+}
+
+// LoadProjectRelations embeds the included members in projects.
+func LoadProjectRelations(projects []models.Project, include []string) {
+	if slices.Contains(include, IncludeMembers) {
+		var ids []string
+		for _, project := range projects {
+			ids = append(ids, project.UserIds...)
+		}
+
+		users := loadUsers(ids)
+		for i := range projects {
+			projects[i].Members = pick(users, projects[i].UserIds)
+		}
+	}
+}
+
+// LoadWorkspaceRelations embeds the included members in workspaces.
+func LoadWorkspaceRelations(workspaces []models.Workspace, include []string) {
+	if slices.Contains(include, IncludeMembers) {
+		var ids []string
+		for _, workspace := range workspaces {
+			ids = append(ids, workspace.UserIds...)
+		}
+
+		users := loadUsers(ids)
+		for i := range workspaces {
+			workspaces[i].Members = pick(users, workspaces[i].UserIds)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"kickof/database"
+	"kickof/models"
+	"kickof/utils"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const LockoutCollection = "lockouts"
+
+var (
+	ErrInvalidCredentials = errors.New("Invalid email or password")
+	ErrLoginThrottled     = errors.New("Too many failed attempts, please try again later")
+)
+
+type LockoutPolicy struct {
+	BackoffAfter int           // Failures allowed before attempts are delayed
+	MaxBackoff   time.Duration // Upper bound of the exponential delay
+	LockAfter    int           // Failures before the key is locked
+	LockDuration time.Duration // Can be overridden with LOCKOUT_DURATION
+	Window       time.Duration // Failures older than this are forgotten
+}
+
+var AccountLockoutPolicy = LockoutPolicy{
+	BackoffAfter: 3,
+	MaxBackoff:   5 * time.Minute,
+	LockAfter:    10,
+	LockDuration: 30 * time.Minute,
+	Window:       time.Hour,
+}
+
+var IpLockoutPolicy = LockoutPolicy{
+	BackoffAfter: 10,
+	MaxBackoff:   5 * time.Minute,
+	LockAfter:    50,
+	LockDuration: 30 * time.Minute,
+	Window:       time.Hour,
+}
+
+// LockoutStore keeps failed login counters. The in-memory store is enough
+// for a single instance; set LOCKOUT_STORE=mongo to share counters between
+// instances.
+type LockoutStore interface {
+	Get(key string) *models.Lockout
+	// Increment counts a failure, after forgetting the failures older than
+	// window unless the key is locked.
+	Increment(key string, window time.Duration) (*models.Lockout, error)
+	// Throttle delays the next attempt and, unless lockedUntil is zero, locks
+	// the key. The failures are left as they are.
+	Throttle(key string, nextAttemptAt time.Time, lockedUntil time.Time) error
+	Delete(key string) error
+	List() []models.Lockout
+}
+
+var (
+	lockoutStore     LockoutStore
+	lockoutStoreOnce sync.Once
+)
+
+func GetLockoutStore() LockoutStore {
+	lockoutStoreOnce.Do(func() {
+		if os.Getenv("LOCKOUT_STORE") == "mongo" {
+			lockoutStore = &MongoLockoutStore{}
+		} else {
+			lockoutStore = NewMemoryLockoutStore()
+		}
+	})
+
+	return lockoutStore
+}
+
+type MemoryLockoutStore struct {
+	mu       sync.Mutex
+	lockouts map[string]models.Lockout
+}
+
+func NewMemoryLockoutStore() *MemoryLockoutStore {
+	return &MemoryLockoutStore{lockouts: map[string]models.Lockout{}}
+}
+
+func (s *MemoryLockoutStore) Get(key string) *models.Lockout {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lockout, ok := s.lockouts[key]
+	if !ok {
+		return nil
+	}
+
+	return &lockout
+}
+
+func (s *MemoryLockoutStore) Increment(key string, window time.Duration) (*models.Lockout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lockout := s.lockouts[key]
+	if time.Since(lockout.LastFailureAt) > window && !lockout.IsLocked(time.Now()) {
+		lockout = models.Lockout{}
+	}
+	lockout.Key = key
+	lockout.Failures++
+	lockout.LastFailureAt = time.Now()
+	s.lockouts[key] = lockout
+
+	return &lockout, nil
+}
+
+func (s *MemoryLockoutStore) Throttle(key string, nextAttemptAt time.Time, lockedUntil time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lockout, ok := s.lockouts[key]
+	if !ok {
+		return nil
+	}
+	if nextAttemptAt.After(lockout.NextAttemptAt) {
+		lockout.NextAttemptAt = nextAttemptAt
+	}
+	if lockedUntil.After(lockout.LockedUntil) {
+		lockout.LockedUntil = lockedUntil
+	}
+	s.lockouts[key] = lockout
+
+	return nil
+}
+
+func (s *MemoryLockoutStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.lockouts, key)
+	return nil
+}
+
+func (s *MemoryLockoutStore) List() []models.Lockout {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]models.Lockout, 0, len(s.lockouts))
+	for _, lockout := range s.lockouts {
+		results = append(results, lockout)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].LastFailureAt.After(results[j].LastFailureAt)
+	})
+
+	return results
+}
+
+type MongoLockoutStore struct{}
+
+func (s *MongoLockoutStore) Get(key string) *models.Lockout {
+	var data models.Lockout
+	err := database.FindOne(LockoutCollection, bson.M{"key": key}, nil).Decode(&data)
+	if err != nil {
+		return nil
+	}
+
+	return &data
+}
+
+func (s *MongoLockoutStore) Increment(key string, window time.Duration) (*models.Lockout, error) {
+	// Restarting the window also moves lastFailureAt, so that of concurrent
+	// failures only the first one resets the counter.
+	now := time.Now()
+	expired := bson.M{
+		"key":           key,
+		"lastFailureAt": bson.M{"$lt": now.Add(-window)},
+		"lockedUntil":   bson.M{"$not": bson.M{"$gt": now}},
+	}
+	_, err := database.UpdateOne(LockoutCollection, expired, bson.M{
+		"failures":      0,
+		"lastFailureAt": now,
+		"nextAttemptAt": time.Time{},
+		"lockedUntil":   time.Time{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	update := bson.M{
+		"$inc": bson.M{"failures": 1},
+		"$set": bson.M{"lastFailureAt": time.Now()},
+	}
+	opt := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var data models.Lockout
+	err = database.FindOneAndUpdate(LockoutCollection, bson.M{"key": key}, update, opt).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+// Throttle only ever moves the dates forward, whatever order concurrent
+// failures are registered in.
+func (s *MongoLockoutStore) Throttle(key string, nextAttemptAt time.Time, lockedUntil time.Time) error {
+	if !nextAttemptAt.IsZero() {
+		filters := bson.M{"key": key, "nextAttemptAt": bson.M{"$not": bson.M{"$gte": nextAttemptAt}}}
+		_, err := database.UpdateOne(LockoutCollection, filters, bson.M{"nextAttemptAt": nextAttemptAt})
+		if err != nil {
+			return err
+		}
+	}
+
+	if !lockedUntil.IsZero() {
+		filters := bson.M{"key": key, "lockedUntil": bson.M{"$not": bson.M{"$gte": lockedUntil}}}
+		_, err := database.UpdateOne(LockoutCollection, filters, bson.M{"lockedUntil": lockedUntil})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *MongoLockoutStore) Delete(key string) error {
+	_, err := database.DeleteOne(LockoutCollection, bson.M{"key": key})
+	return err
+}
+
+func (s *MongoLockoutStore) List() []models.Lockout {
+	results := make([]models.Lockout, 0)
+
+	cursor := database.Find(LockoutCollection, bson.M{}, options.Find().SetSort(bson.M{"lastFailureAt": -1}))
+	if cursor == nil {
+		return results
+	}
+	for cursor.Next(context.Background()) {
+		var data models.Lockout
+		if cursor.Decode(&data) == nil {
+			results = append(results, data)
+		}
+	}
+
+	return results
+}
+
+func AccountLockoutKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func IpLockoutKey(ip string) string {
+	return "ip:" + ip
+}
+
+func isThrottled(key string) bool {
+	lockout := GetLockoutStore().Get(key)
+	return lockout != nil && lockout.IsThrottled(time.Now())
+}
+
+// CheckLoginAllowed fails when either the account or the client address is
+// in a backoff period or locked.
+func CheckLoginAllowed(email string, ip string) error {
+	if isThrottled(AccountLockoutKey(email)) || isThrottled(IpLockoutKey(ip)) {
+		return ErrLoginThrottled
+	}
+
+	return nil
+}
+
+func registerFailure(key string, policy LockoutPolicy) (*models.Lockout, bool) {
+	store := GetLockoutStore()
+
+	lockout, err := store.Increment(key, policy.Window)
+	if err != nil {
+		log.Println("Failed to record login failure", err)
+		return nil, false
+	}
+
+	locked := false
+	now := time.Now()
+
+	if lockout.Failures > policy.BackoffAfter {
+		delay := time.Duration(math.Pow(2, float64(lockout.Failures-policy.BackoffAfter))) * time.Second
+		if delay > policy.MaxBackoff || delay <= 0 {
+			delay = policy.MaxBackoff
+		}
+		lockout.NextAttemptAt = now.Add(delay)
+	}
+
+	if lockout.Failures >= policy.LockAfter && !lockout.IsLocked(now) {
+		lockout.LockedUntil = now.Add(utils.EnvDuration("LOCKOUT_DURATION", policy.LockDuration))
+		locked = true
+	}
+
+	lockedUntil := time.Time{}
+	if locked {
+		lockedUntil = lockout.LockedUntil
+	}
+
+	err = store.Throttle(key, lockout.NextAttemptAt, lockedUntil)
+	if err != nil {
+		log.Println("Failed to save lockout", err)
+	}
+
+	return lockout, locked
+}
+
+// RegisterLoginFailure counts a failed attempt for the account and address
+// and emails the owner of the account when it gets locked.
+func RegisterLoginFailure(email string, ip string) {
+	registerFailure(IpLockoutKey(ip), IpLockoutPolicy)
+
+	_, locked := registerFailure(AccountLockoutKey(email), AccountLockoutPolicy)
+	if !locked {
+		return
+	}
+
+	user := GetUser(bson.M{"email": email}, nil)
+	if user == nil {
+		return
+	}
+
+	err := sendUnlockEmail(*user, os.Getenv("FRONTEND_URL")+"/unlock/")
+	if err != nil {
+		log.Println("Failed to send unlock email", err)
+	}
+}
+
+func ClearLoginFailures(email string) {
+	_ = GetLockoutStore().Delete(AccountLockoutKey(email))
+}
+
+func sendUnlockEmail(user models.User, url string) error {
+	token, err := GenerateVerificationToken(user.Email, models.PurposeUnlock, "")
+	if err != nil {
+		return err
+	}
+
+	data := models.VerificationMail{
+		Name: user.Name,
+		Link: url + *token,
+	}
+
+	return utils.SendEmail(user.Email, "Your account has been locked", data, "templates/unlock-account.html")
+}
+
+func UnlockAccount(token string) (bool, error) {
+	verification, err := ConsumeVerificationToken(token, models.PurposeUnlock)
+	if err != nil {
+		return false, err
+	}
+
+	ClearLoginFailures(verification.Email)
+
+	return true, nil
+}
+
+func GetLockouts() []models.Lockout {
+	return GetLockoutStore().List()
+}
+
+func ClearLockout(key string) error {
+	return GetLockoutStore().Delete(key)
+}
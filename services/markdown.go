@@ -0,0 +1,230 @@
+package services
+
+import (
+	"bytes"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/html"
+	"html/template"
+	"kickof/models"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxDescriptionLinks bounds the @mentions and task keys of a description
+// that are looked up to be linked.
+const maxDescriptionLinks = 100
+
+// Raw HTML in descriptions is left out by goldmark, and what it renders is
+// sanitized again before it is sent.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+var descriptionPolicy = func() *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^(mention|task-key)$`)).OnElements("a", "span")
+	policy.AllowAttrs("data-user-id", "data-task-id").OnElements("a", "span")
+	policy.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	policy.AllowAttrs("checked", "disabled").OnElements("input")
+
+	return policy
+}()
+
+// descriptionLink finds the @mentions and task keys in a text, e.g. @ann
+// and WEB-12.
+var descriptionLink = regexp.MustCompile(`@[\pL\pN_.-]*[\pL\pN_]|\pL[\pL\pN]*-[0-9]+`)
+
+// descriptionLinks lists the @mentions and task keys of a text that stand on
+// their own, so neither the domain of an email nor part of a longer word.
+func descriptionLinks(text string) []string {
+	links := make([]string, 0)
+	for _, match := range descriptionLink.FindAllStringIndex(text, -1) {
+		if isLinkBoundary(text, match[0], match[1]) {
+			links = append(links, text[match[0]:match[1]])
+		}
+	}
+
+	return links
+}
+
+func isLinkBoundary(text string, start int, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(text[:start])
+	after, _ := utf8.DecodeRuneInString(text[end:])
+
+	word := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '@'
+	}
+
+	return (start == 0 || !word(before)) && (end == len(text) || !word(after))
+}
+
+// RenderTaskDescriptions renders the Markdown descriptions of tasks to
+// sanitized HTML. @mentions of the members of the workspace, by the name of
+// their email, and keys of its tasks become links.
+func RenderTaskDescriptions(tasks []models.Task) {
+	members := map[string]map[string]models.User{}
+
+	for i := range tasks {
+		task := &tasks[i]
+		if task.Description == "" {
+			task.DescriptionHtml = ""
+			continue
+		}
+
+		if _, ok := members[task.WorkspaceId]; !ok {
+			members[task.WorkspaceId] = workspaceHandles(task.WorkspaceId)
+		}
+
+		var rendered bytes.Buffer
+		err := markdown.Convert([]byte(task.Description), &rendered)
+		if err != nil {
+			logError(err)
+			task.DescriptionHtml = template.HTMLEscapeString(task.Description)
+			continue
+		}
+
+		links := resolveDescriptionLinks(task.WorkspaceId, task.Description, members[task.WorkspaceId])
+		task.DescriptionHtml = descriptionPolicy.Sanitize(linkDescription(rendered.String(), links))
+	}
+}
+
+// workspaceHandles maps the handles members of a workspace are mentioned
+// by, the name of their email in lower case, to them.
+func workspaceHandles(workspaceId string) map[string]models.User {
+	handles := map[string]models.User{}
+
+	workspace := GetWorkspace(bson.M{"id": workspaceId}, options.FindOne().SetProjection(bson.M{"userids": 1}))
+	if workspace == nil || len(workspace.UserIds) == 0 {
+		return handles
+	}
+
+	users := GetUsers(bson.M{"id": bson.M{"$in": workspace.UserIds}}, options.Find().SetProjection(bson.M{"id": 1, "email": 1}))
+	for _, user := range users {
+		name, _, found := strings.Cut(user.Email, "@")
+		if found && name != "" {
+			handles[strings.ToLower(name)] = user
+		}
+	}
+
+	return handles
+}
+
+// resolveDescriptionLinks maps the @mentions and task keys of a description
+// to the HTML of their link. Unknown ones are left out and stay text.
+func resolveDescriptionLinks(workspaceId string, description string, handles map[string]models.User) map[string]string {
+	links := map[string]string{}
+
+	keys := make([]string, 0)
+	for _, link := range descriptionLinks(description) {
+		if len(links)+len(keys) >= maxDescriptionLinks {
+			break
+		}
+		if _, ok := links[link]; ok || slices.Contains(keys, link) {
+			continue
+		}
+
+		if handle, ok := strings.CutPrefix(link, "@"); ok {
+			if user, ok := handles[strings.ToLower(handle)]; ok {
+				links[link] = `<span class="mention" data-user-id="` + template.HTMLEscapeString(user.Id) + `">` +
+					template.HTMLEscapeString(link) + `</span>`
+			}
+			continue
+		}
+
+		keys = append(keys, link)
+	}
+	if len(keys) == 0 {
+		return links
+	}
+
+	filters := bson.M{
+		"workspaceId": workspaceId,
+		"$or":         bson.A{bson.M{"code": bson.M{"$in": keys}}, bson.M{"previousKeys": bson.M{"$in": keys}}},
+	}
+	tasks, err := storage.Tasks.Find(filters, options.Find().SetProjection(bson.M{"id": 1, "code": 1, "previousKeys": 1}))
+	logError(err)
+
+	for _, task := range tasks {
+		for _, key := range append([]string{task.Code}, task.PreviousKeys...) {
+			if !slices.Contains(keys, key) {
+				continue
+			}
+
+			// Keys a task had before it moved link to its current one
+			links[key] = `<a class="task-key" data-task-id="` + template.HTMLEscapeString(task.Id) + `" href="/api/task/` +
+				template.HTMLEscapeString(task.Code) + `">` + template.HTMLEscapeString(key) + `</a>`
+		}
+	}
+
+	return links
+}
+
+// linkDescription replaces the @mentions and task keys in the text of
+// rendered HTML by their links, leaving links and code as they are.
+func linkDescription(rendered string, links map[string]string) string {
+	if len(links) == 0 {
+		return rendered
+	}
+
+	var result strings.Builder
+	skip := 0
+
+	tokenizer := html.NewTokenizer(strings.NewReader(rendered))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		raw := string(tokenizer.Raw())
+		name, _ := tokenizer.TagName()
+
+		switch tokenType {
+		case html.StartTagToken:
+			if isUnlinkedTag(string(name)) {
+				skip++
+			}
+		case html.EndTagToken:
+			if isUnlinkedTag(string(name)) && skip > 0 {
+				skip--
+			}
+		case html.TextToken:
+			if skip == 0 {
+				raw = linkText(raw, links)
+			}
+		}
+
+		result.WriteString(raw)
+	}
+
+	return result.String()
+}
+
+func isUnlinkedTag(name string) bool {
+	return name == "a" || name == "code" || name == "pre"
+}
+
+// linkText links the mentions and keys of escaped text.
+func linkText(text string, links map[string]string) string {
+	var result strings.Builder
+
+	last := 0
+	for _, match := range descriptionLink.FindAllStringIndex(text, -1) {
+		link, ok := links[text[match[0]:match[1]]]
+		if !ok || !isLinkBoundary(text, match[0], match[1]) {
+			continue
+		}
+
+		result.WriteString(text[last:match[0]])
+		result.WriteString(link)
+		last = match[1]
+	}
+	result.WriteString(text[last:])
+
+	return result.String()
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/skip2/go-qrcode"
+	"go.mongodb.org/mongo-driver/bson"
+	"kickof/models"
+	"kickof/utils"
+	"strings"
+	"time"
+)
+
+const (
+	TotpIssuer         = "Kickof"
+	RecoveryCodeCount  = 10
+	RecoveryCodeLength = 10
+)
+
+var ErrMfaRequired = errors.New("Two-factor authentication is required for this workspace")
+
+func EnrollMfa(user models.User) (*models.MfaEnrollment, error) {
+	if user.MfaEnabled {
+		return nil, errors.New("Two-factor authentication is already enabled")
+	}
+
+	secret, err := utils.GenerateTotpSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	uri := utils.TotpUri(TotpIssuer, user.Email, secret)
+
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = storage.Users.UpdateOne(bson.M{"id": user.Id}, bson.M{"totpPendingSecret": secret})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.MfaEnrollment{
+		Secret: secret,
+		Uri:    uri,
+		QrCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}, nil
+}
+
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	hashes := make([]string, 0, RecoveryCodeCount)
+
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code, err := utils.RandomCode(RecoveryCodeLength)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code = strings.ToLower(code)
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, utils.HashAndSalt(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// ConfirmMfa enables two-factor authentication once the user proved their
+// app produces valid codes for the pending secret.
+func ConfirmMfa(user models.User, code string) (*models.RecoveryCodes, error) {
+	if user.TotpPendingSecret == "" {
+		return nil, errors.New("No two-factor enrollment in progress")
+	}
+
+	step, ok := utils.ValidateTotp(user.TotpPendingSecret, code, time.Now())
+	if !ok {
+		return nil, errors.New("Invalid code")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = storage.Users.UpdateOne(bson.M{"id": user.Id}, bson.M{
+		"mfaEnabled":        true,
+		"totpSecret":        user.TotpPendingSecret,
+		"totpPendingSecret": "",
+		"totpLastStep":      step,
+		"recoveryCodes":     hashes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.RecoveryCodes{Codes: codes}, nil
+}
+
+// VerifyMfaCode accepts a TOTP code that was not used before or one of the
+// recovery codes, which is then removed.
+func VerifyMfaCode(user models.User, code string) bool {
+	if !user.MfaEnabled {
+		return false
+	}
+
+	step, ok := utils.ValidateTotp(user.TotpSecret, code, time.Now())
+	if ok {
+		if step <= user.TotpLastStep {
+			return false
+		}
+
+		res, err := storage.Users.UpdateOne(bson.M{"id": user.Id, "totpLastStep": user.TotpLastStep}, bson.M{"totpLastStep": step})
+		return err == nil && res.MatchedCount > 0
+	}
+
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	for i, hash := range user.RecoveryCodes {
+		if utils.ComparePassword(hash, []byte(normalized)) {
+			remaining := append(append([]string{}, user.RecoveryCodes[:i]...), user.RecoveryCodes[i+1:]...)
+
+			res, err := storage.Users.UpdateOne(bson.M{"id": user.Id, "recoveryCodes": hash}, bson.M{"recoveryCodes": remaining})
+			return err == nil && res.MatchedCount > 0
+		}
+	}
+
+	return false
+}
+
+func DisableMfa(user models.User, code string) (bool, error) {
+	if !VerifyMfaCode(user, code) {
+		return false, errors.New("Invalid code")
+	}
+
+	_, err := storage.Users.UpdateOne(bson.M{"id": user.Id}, bson.M{
+		"mfaEnabled":    false,
+		"totpSecret":    "",
+		"totpLastStep":  0,
+		"recoveryCodes": []string{},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func RegenerateRecoveryCodes(user models.User, code string) (*models.RecoveryCodes, error) {
+	if !VerifyMfaCode(user, code) {
+		return nil, errors.New("Invalid code")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = storage.Users.UpdateOne(bson.M{"id": user.Id}, bson.M{"recoveryCodes": hashes})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.RecoveryCodes{Codes: codes}, nil
+}
+
+// CreateMfaChallenge is returned instead of a session when the password was
+// right but the account has two-factor authentication enabled.
+func CreateMfaChallenge(user models.User) (*models.AuthResult, error) {
+	token, err := GenerateVerificationToken(user.Email, models.PurposeMfa, user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuthResult{
+		Id:          user.Id,
+		Email:       user.Email,
+		MfaRequired: true,
+		MfaToken:    *token,
+	}, nil
+}
+
+func CompleteMfaChallenge(request models.MfaLoginRequest, ip string) (*models.User, error) {
+	verification, err := CheckVerificationToken(request.MfaToken, models.PurposeMfa)
+	if err != nil {
+		return nil, err
+	}
+
+	err = CheckLoginAllowed(verification.Email, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	user := GetUser(bson.M{"id": verification.Reference}, nil)
+	if user == nil {
+		return nil, errors.New("User not found")
+	}
+
+	if !VerifyMfaCode(*user, request.Code) {
+		RegisterLoginFailure(user.Email, ip)
+		return nil, errors.New("Invalid code")
+	}
+
+	_, err = ConsumeVerificationToken(request.MfaToken, models.PurposeMfa)
+	if err != nil {
+		return nil, err
+	}
+
+	ClearLoginFailures(user.Email)
+
+	return user, nil
+}
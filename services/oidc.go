@@ -0,0 +1,430 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"kickof/models"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+// publicOidcClient only connects to public addresses, redirects included, so
+// the providers workspace managers register cannot reach the private network.
+var publicOidcClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: dialPublic}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+func dialPublic(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return errors.New(host + " is not a public address")
+	}
+
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// isPublicProvider tells whether a provider was registered by a workspace,
+// and is held to https and public addresses. OIDC_ALLOW_PRIVATE_ISSUERS lifts
+// that for instances whose workspaces use an identity provider on their
+// network.
+func isPublicProvider(provider models.SsoProvider) bool {
+	return provider.Id != models.DefaultSsoProvider && os.Getenv("OIDC_ALLOW_PRIVATE_ISSUERS") != "true"
+}
+
+func oidcClientFor(provider models.SsoProvider) *http.Client {
+	if isPublicProvider(provider) {
+		return publicOidcClient
+	}
+
+	return oidcClient
+}
+
+func isHttps(uri string) bool {
+	parsed, err := url.Parse(uri)
+	return err == nil && parsed.Scheme == "https" && parsed.Host != ""
+}
+
+const (
+	oidcDiscoveryTTL = time.Hour
+	oidcJwksTTL      = time.Hour
+	oidcJwksMinAge   = time.Minute // Do not refetch keys more often on unknown kid
+)
+
+type cachedDiscovery struct {
+	discovery models.OidcDiscovery
+	fetchedAt time.Time
+}
+
+type cachedJwks struct {
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+var (
+	oidcCacheMu    sync.Mutex
+	discoveryCache = map[string]cachedDiscovery{}
+	jwksCache      = map[string]cachedJwks{}
+)
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func getJSON(client *http.Client, uri string, target interface{}) error {
+	res, err := client.Get(uri)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %d", uri, res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(target)
+}
+
+// GetOidcDiscovery loads the discovery document of a provider. The endpoints
+// of workspace providers must be https, like their issuer.
+func GetOidcDiscovery(provider models.SsoProvider) (*models.OidcDiscovery, error) {
+	issuer := strings.TrimSuffix(provider.Issuer, "/")
+
+	public := isPublicProvider(provider)
+	if public && !isHttps(issuer) {
+		return nil, errors.New("The issuer must be an https url")
+	}
+
+	oidcCacheMu.Lock()
+	cached, ok := discoveryCache[issuer]
+	oidcCacheMu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < oidcDiscoveryTTL {
+		return checkOidcDiscovery(cached.discovery, public)
+	}
+
+	var discovery models.OidcDiscovery
+	err := getJSON(oidcClientFor(provider), issuer+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, errors.New("Discovery document issuer mismatch")
+	}
+
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JwksUri == "" {
+		return nil, errors.New("Incomplete discovery document")
+	}
+
+	oidcCacheMu.Lock()
+	discoveryCache[issuer] = cachedDiscovery{discovery: discovery, fetchedAt: time.Now()}
+	oidcCacheMu.Unlock()
+
+	return checkOidcDiscovery(discovery, public)
+}
+
+func checkOidcDiscovery(discovery models.OidcDiscovery, public bool) (*models.OidcDiscovery, error) {
+	if public && (!isHttps(discovery.TokenEndpoint) || !isHttps(discovery.JwksUri)) {
+		return nil, errors.New("The provider endpoints must be https urls")
+	}
+
+	return &discovery, nil
+}
+
+func decodeBase64Int(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+func parseJsonWebKey(key jsonWebKey) (interface{}, error) {
+	switch key.Kty {
+	case "RSA":
+		n, err := decodeBase64Int(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBase64Int(key.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch key.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("Unsupported curve " + key.Crv)
+		}
+
+		x, err := decodeBase64Int(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBase64Int(key.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, errors.New("Unsupported key type " + key.Kty)
+}
+
+func fetchJwks(client *http.Client, uri string) (map[string]interface{}, error) {
+	var body struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	err := getJSON(client, uri, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]interface{}{}
+	for _, key := range body.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+
+		parsed, err := parseJsonWebKey(key)
+		if err == nil {
+			keys[key.Kid] = parsed
+		}
+	}
+
+	return keys, nil
+}
+
+// GetSigningKey looks a key up in the provider JWKS, refetching it when the
+// kid is unknown since providers rotate their keys.
+func GetSigningKey(client *http.Client, jwksUri string, kid string) (interface{}, error) {
+	oidcCacheMu.Lock()
+	cached, ok := jwksCache[jwksUri]
+	oidcCacheMu.Unlock()
+
+	if ok && time.Since(cached.fetchedAt) < oidcJwksTTL {
+		if key, found := cached.keys[kid]; found {
+			return key, nil
+		}
+		if time.Since(cached.fetchedAt) < oidcJwksMinAge {
+			return nil, errors.New("Unknown signing key")
+		}
+	}
+
+	keys, err := fetchJwks(client, jwksUri)
+	if err != nil {
+		return nil, err
+	}
+
+	oidcCacheMu.Lock()
+	jwksCache[jwksUri] = cachedJwks{keys: keys, fetchedAt: time.Now()}
+	oidcCacheMu.Unlock()
+
+	key, found := keys[kid]
+	if !found {
+		// Providers with a single key often omit the kid
+		if kid == "" && len(keys) == 1 {
+			for _, only := range keys {
+				return only, nil
+			}
+		}
+		return nil, errors.New("Unknown signing key")
+	}
+
+	return key, nil
+}
+
+func PkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func OidcAuthorizationUrl(discovery models.OidcDiscovery, provider models.SsoProvider, redirectUri string, state string, nonce string, verifier string) string {
+	scopes := provider.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", provider.ClientId)
+	values.Set("redirect_uri", redirectUri)
+	values.Set("scope", strings.Join(scopes, " "))
+	values.Set("state", state)
+	values.Set("nonce", nonce)
+	values.Set("code_challenge", PkceChallenge(verifier))
+	values.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return discovery.AuthorizationEndpoint + separator + values.Encode()
+}
+
+// ExchangeOidcCode redeems an authorization code and returns the raw id token.
+func ExchangeOidcCode(discovery models.OidcDiscovery, provider models.SsoProvider, code string, redirectUri string, verifier string) (string, error) {
+	values := url.Values{}
+	values.Set("grant_type", "authorization_code")
+	values.Set("code", code)
+	values.Set("redirect_uri", redirectUri)
+	values.Set("code_verifier", verifier)
+	values.Set("client_id", provider.ClientId)
+
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if provider.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(provider.ClientId), url.QueryEscape(provider.ClientSecret))
+	}
+
+	res, err := oidcClientFor(provider).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+
+	if res.StatusCode != http.StatusOK || body.Error != "" {
+		return "", errors.New("Token request failed: " + body.Error + " " + body.ErrorDescription)
+	}
+
+	if body.IdToken == "" {
+		return "", errors.New("Provider did not return an id token")
+	}
+
+	return body.IdToken, nil
+}
+
+func hasAudience(claims jwt.MapClaims, clientId string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == clientId
+	case []interface{}:
+		for _, value := range aud {
+			if value == clientId {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ValidateIdToken checks the signature against the provider keys and the
+// standard claims before trusting the identity it carries.
+func ValidateIdToken(discovery models.OidcDiscovery, provider models.SsoProvider, idToken string, nonce string) (*models.OidcIdentity, error) {
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodRSAPSS:
+		default:
+			return nil, errors.New("unexpected signing method")
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		return GetSigningKey(oidcClientFor(provider), discovery.JwksUri, kid)
+	})
+	if err != nil {
+		return nil, errors.New("Id token invalid: " + err.Error())
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("Id token invalid")
+	}
+
+	if !claims.VerifyIssuer(discovery.Issuer, true) {
+		return nil, errors.New("Id token issuer mismatch")
+	}
+
+	if !hasAudience(claims, provider.ClientId) {
+		return nil, errors.New("Id token audience mismatch")
+	}
+
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errors.New("Id token expired")
+	}
+
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, errors.New("Id token nonce mismatch")
+	}
+
+	identity := models.OidcIdentity{Issuer: discovery.Issuer}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+
+	if identity.Subject == "" {
+		return nil, errors.New("Id token has no subject")
+	}
+
+	return &identity, nil
+}
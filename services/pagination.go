@@ -0,0 +1,110 @@
+package services
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"kickof/database"
+	"kickof/models"
+	"slices"
+)
+
+// paginate finds a page of a list. Cursor pages read one document past the
+// page instead of counting the list, offset pages count it.
+func paginate[T any](repository database.Repository[T], filters bson.M, opt *options.FindOptions, query models.Query) ([]T, models.Result, error) {
+	result := models.Result{Query: query}
+
+	if !query.IsCursor() {
+		results, err := repository.Find(filters, opt)
+		logError(err)
+
+		count, err := repository.Count(filters)
+		logError(err)
+
+		result.Pagination = query.GetPagination(count)
+
+		return results, result, nil
+	}
+
+	cursorFilters, cursorOpt, err := query.GetCursorFind(filters)
+	if err != nil {
+		return nil, result, err
+	}
+	if opt != nil && opt.Projection != nil {
+		cursorOpt.SetProjection(opt.Projection)
+	}
+
+	results, err := repository.Find(cursorFilters, cursorOpt)
+	if err != nil {
+		return nil, result, err
+	}
+
+	limit := query.GetCursorLimit()
+	more := int64(len(results)) > limit
+	if more {
+		results = results[:limit]
+	}
+	if query.IsBackward() {
+		slices.Reverse(results)
+	}
+
+	result.Pagination = models.Pagination{Limit: limit}
+	if len(results) == 0 {
+		return results, result, nil
+	}
+
+	first, last, err := cursorDocuments(repository, results[0], results[len(results)-1])
+	if err != nil {
+		return nil, result, err
+	}
+
+	// The far side has more when the page was cut, the near one whenever the
+	// page starts from a cursor
+	if query.IsBackward() {
+		if more {
+			result.PrevCursor = query.NewCursor(first)
+		}
+		if *query.Before != "" {
+			result.NextCursor = query.NewCursor(last)
+		}
+	} else {
+		if more {
+			result.NextCursor = query.NewCursor(last)
+		}
+		if *query.After != "" {
+			result.PrevCursor = query.NewCursor(first)
+		}
+	}
+
+	return results, result, nil
+}
+
+// cursorDocuments reads the first and last documents of a page as stored,
+// where a missing sort value stays missing rather than becoming the zero
+// value of the model.
+func cursorDocuments[T any](repository database.Repository[T], first T, last T) (bson.M, bson.M, error) {
+	firstDocument, err := toDocument(first)
+	if err != nil {
+		return nil, nil, err
+	}
+	lastDocument, err := toDocument(last)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := bson.A{firstDocument["id"], lastDocument["id"]}
+	documents, err := repository.FindDocuments(bson.M{"id": bson.M{"$in": ids}}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, document := range documents {
+		if document["id"] == firstDocument["id"] {
+			firstDocument = document
+		}
+		if document["id"] == lastDocument["id"] {
+			lastDocument = document
+		}
+	}
+
+	return firstDocument, lastDocument, nil
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"kickof/database"
+	"reflect"
+	"time"
+)
+
+var ErrImmutableField = errors.New("Field cannot be changed")
+
+var (
+	// immutableFields are set once, a patch changing them is refused
+	immutableFields = []string{"id", "createdAt", "workspaceId"}
+	// managedFields are kept by the server, whatever a patch says
+	managedFields = []string{"version", "updatedAt", "deletedAt", "deletedBy", "deletedWith"}
+)
+
+func toDocument(object interface{}) (bson.M, error) {
+	data, err := bson.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+
+	var document bson.M
+	err = bson.Unmarshal(data, &document)
+
+	return document, err
+}
+
+// Changes lists the stored fields that differ between two versions of a
+// document, ready for a $set.
+func Changes(current interface{}, updated interface{}) (bson.M, error) {
+	before, err := toDocument(current)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := toDocument(updated)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := bson.M{}
+	for key, value := range after {
+		if !reflect.DeepEqual(before[key], value) {
+			changes[key] = value
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changes[key] = nil
+		}
+	}
+
+	for _, key := range managedFields {
+		delete(changes, key)
+	}
+	for _, key := range immutableFields {
+		if _, ok := changes[key]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrImmutableField, key)
+		}
+	}
+
+	return changes, nil
+}
+
+// patchVersion writes what changed from current to updated, at the version
+// current was read at. Nothing is written when nothing changed.
+func patchVersion[T any](repository database.Repository[T], id string, version int64, current interface{}, updated interface{}) error {
+	changes, err := Changes(current, updated)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	changes["updatedAt"] = time.Now()
+
+	return updateVersion[T](repository, id, version, changes)
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"kickof/models"
+	"slices"
+)
+
+// GetResourceWorkspaceId returns the id of the workspace a resource belongs
+// to, or an empty string when the resource does not exist.
+func GetResourceWorkspaceId(resource string, id string) string {
+	switch resource {
+	case WorkspaceCollection:
+		workspace := GetWorkspace(bson.M{"id": id}, nil)
+		if workspace != nil {
+			return workspace.Id
+		}
+	case ProjectCollection:
+		project := GetProject(bson.M{"id": id}, nil)
+		if project == nil {
+			project = GetProject(bson.M{"code": id}, nil)
+		}
+		if project != nil {
+			return project.WorkspaceId
+		}
+	case StateCollection:
+		state := GetState(bson.M{"id": id}, nil)
+		if state != nil {
+			return state.WorkspaceId
+		}
+	case TaskCollection:
+		task := GetTask(bson.M{"id": id}, nil)
+		if task != nil {
+			return task.WorkspaceId
+		}
+	case TaskLabelCollection:
+		label := GetTaskLabel(bson.M{"id": id}, nil)
+		if label != nil {
+			return label.WorkspaceId
+		}
+	case ViewCollection:
+		view := GetView(bson.M{"id": id}, nil)
+		if view != nil {
+			return view.WorkspaceId
+		}
+	}
+
+	return ""
+}
+
+// GetTrashResourceWorkspaceId is GetResourceWorkspaceId for the routes
+// working on the trash, where the resource may be deleted.
+func GetTrashResourceWorkspaceId(resource string, id string) string {
+	filter := bson.M{"id": id}
+
+	switch resource {
+	case WorkspaceCollection:
+		workspace, err := storage.Workspaces.Unscoped().FindOne(filter, nil)
+		if err == nil {
+			return workspace.Id
+		}
+	case ProjectCollection:
+		project, err := storage.Projects.Unscoped().FindOne(filter, nil)
+		if err == nil {
+			return project.WorkspaceId
+		}
+	case StateCollection:
+		state, err := storage.States.Unscoped().FindOne(filter, nil)
+		if err == nil {
+			return state.WorkspaceId
+		}
+	case TaskCollection:
+		task, err := storage.Tasks.Unscoped().FindOne(filter, nil)
+		if err == nil {
+			return task.WorkspaceId
+		}
+	case TaskLabelCollection:
+		label, err := storage.TaskLabels.Unscoped().FindOne(filter, nil)
+		if err == nil {
+			return label.WorkspaceId
+		}
+	}
+
+	return ""
+}
+
+var ErrForbidden = errors.New("Forbidden")
+
+// CheckPermission fails with ErrForbidden when the user lacks the permission
+// and with ErrMfaRequired when the workspace requires two-factor
+// authentication the user has not enabled.
+func CheckPermission(workspaceId string, user models.User, permission models.Permission) error {
+	return checkWorkspacePermission(GetWorkspace(bson.M{"id": workspaceId}, nil), user, permission)
+}
+
+// CheckTrashPermission is CheckPermission for the routes working on the
+// trash, where the workspace itself may be deleted.
+func CheckTrashPermission(workspaceId string, user models.User, permission models.Permission) error {
+	workspace, _ := storage.Workspaces.Unscoped().FindOne(bson.M{"id": workspaceId}, nil)
+
+	return checkWorkspacePermission(workspace, user, permission)
+}
+
+func checkWorkspacePermission(workspace *models.Workspace, user models.User, permission models.Permission) error {
+	if workspace == nil || !workspace.HasPermission(user.Id, permission) {
+		return ErrForbidden
+	}
+
+	if workspace.RequireMfa && !user.MfaEnabled {
+		return ErrMfaRequired
+	}
+
+	return nil
+}
+
+// ValidateRoleChange checks that a member with the given role is allowed to
+// turn the current roles of a workspace into the requested ones. Only members
+// can be given a role: nobody joins a workspace this way.
+func ValidateRoleChange(workspace models.Workspace, actorId string, roles map[string]string) error {
+	actorRole := workspace.GetRole(actorId)
+
+	for userId, role := range roles {
+		if !models.IsValidRole(role) {
+			return errors.New("Invalid role " + role)
+		}
+
+		if !slices.Contains(workspace.UserIds, userId) {
+			return errors.New("Members join through an invitation, an invite link or their email domain")
+		}
+
+		current := workspace.GetRole(userId)
+		if current == role {
+			continue
+		}
+
+		if (role == models.RoleOwner || current == models.RoleOwner) && actorRole != models.RoleOwner {
+			return errors.New("Only owners can grant or revoke the owner role")
+		}
+	}
+
+	for userId, role := range workspace.Roles {
+		if _, ok := roles[userId]; !ok && role == models.RoleOwner && actorRole != models.RoleOwner {
+			return errors.New("Only owners can remove an owner")
+		}
+	}
+
+	owners := 0
+	for _, role := range roles {
+		if role == models.RoleOwner {
+			owners++
+		}
+	}
+
+	if owners == 0 {
+		return errors.New("Workspace must have at least one owner")
+	}
+
+	return nil
+}
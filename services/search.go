@@ -0,0 +1,473 @@
+package services
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"html"
+	"kickof/database"
+	"kickof/models"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// SearchIndex finds tasks, projects and workspaces by their text. The
+// embedded MemorySearchIndex is built from the store on first use and kept
+// up to date by the writes of this instance; UseSearchIndex plugs in another
+// one, e.g. backed by a search server shared between instances.
+type SearchIndex interface {
+	Index(document models.SearchDocument)
+	Remove(kind string, id string)
+	Search(query models.SearchQuery) []models.SearchHit
+}
+
+var (
+	searchIndex      SearchIndex
+	searchIndexStore database.Store // Store the embedded index was built from
+	searchIndexMu    sync.Mutex
+)
+
+// UseSearchIndex replaces the embedded index. The index is expected to hold
+// the documents already, see RebuildSearchIndex.
+func UseSearchIndex(index SearchIndex) {
+	searchIndexMu.Lock()
+	defer searchIndexMu.Unlock()
+
+	searchIndex = index
+	searchIndexStore = nil
+}
+
+// GetSearchIndex returns the index searches run on, building the embedded
+// one when the store changed since it was built.
+func GetSearchIndex() SearchIndex {
+	searchIndexMu.Lock()
+	defer searchIndexMu.Unlock()
+
+	_, embedded := searchIndex.(*MemorySearchIndex)
+	if searchIndex == nil || embedded && searchIndexStore != storage.current() {
+		index := NewMemorySearchIndex()
+		rebuildSearchIndex(index)
+		searchIndex, searchIndexStore = index, storage.current()
+	}
+
+	return searchIndex
+}
+
+// RebuildSearchIndex indexes every task, project and workspace out of the
+// trash again.
+func RebuildSearchIndex() {
+	rebuildSearchIndex(GetSearchIndex())
+}
+
+func rebuildSearchIndex(index SearchIndex) {
+	workspaces, err := storage.Workspaces.Find(bson.M{}, nil)
+	logError(err)
+	for _, workspace := range workspaces {
+		index.Index(workspaceDocument(workspace))
+	}
+
+	projects, err := storage.Projects.Find(bson.M{}, nil)
+	logError(err)
+	for _, project := range projects {
+		index.Index(projectDocument(project))
+	}
+
+	tasks, err := storage.Tasks.Find(bson.M{}, nil)
+	logError(err)
+	for _, task := range tasks {
+		index.Index(taskDocument(task))
+	}
+}
+
+func taskDocument(task models.Task) models.SearchDocument {
+	return models.SearchDocument{
+		Kind:        models.SearchTask,
+		Id:          task.Id,
+		WorkspaceId: task.WorkspaceId,
+		ProjectId:   task.ProjectId,
+		Fields: []models.SearchField{
+			{Name: "title", Text: task.Title, Weight: 3},
+			{Name: "code", Text: task.Code, Weight: 2},
+			{Name: "description", Text: task.Description, Weight: 1},
+		},
+	}
+}
+
+func projectDocument(project models.Project) models.SearchDocument {
+	return models.SearchDocument{
+		Kind:        models.SearchProject,
+		Id:          project.Id,
+		WorkspaceId: project.WorkspaceId,
+		ProjectId:   project.Id,
+		Fields: []models.SearchField{
+			{Name: "name", Text: project.Name, Weight: 3},
+			{Name: "code", Text: project.Code, Weight: 2},
+			{Name: "description", Text: project.Description, Weight: 1},
+		},
+	}
+}
+
+func workspaceDocument(workspace models.Workspace) models.SearchDocument {
+	return models.SearchDocument{
+		Kind:        models.SearchWorkspace,
+		Id:          workspace.Id,
+		WorkspaceId: workspace.Id,
+		Fields: []models.SearchField{
+			{Name: "name", Text: workspace.Name, Weight: 3},
+			{Name: "code", Text: workspace.Code, Weight: 2},
+		},
+	}
+}
+
+func indexTasks(tasks ...models.Task) {
+	index := GetSearchIndex()
+	for _, task := range tasks {
+		index.Index(taskDocument(task))
+	}
+}
+
+func indexProject(project models.Project) {
+	GetSearchIndex().Index(projectDocument(project))
+}
+
+func indexWorkspace(workspace models.Workspace) {
+	GetSearchIndex().Index(workspaceDocument(workspace))
+}
+
+// unindex removes documents of a kind from the search index, once they went
+// to the trash or for good.
+func unindex(kind string, ids ...string) {
+	index := GetSearchIndex()
+	for _, id := range ids {
+		index.Remove(kind, id)
+	}
+}
+
+// Search finds the documents of the scope of the query, best matches first.
+// Hits trashed or purged since they were indexed are left out.
+func Search(query models.SearchQuery) []models.SearchHit {
+	hits := GetSearchIndex().Search(query)
+
+	ids := map[string][]string{}
+	for _, hit := range hits {
+		ids[hit.Kind] = append(ids[hit.Kind], hit.Id)
+	}
+
+	live := map[string]bool{}
+	opt := options.Find().SetProjection(bson.M{"id": 1})
+	for kind, kindIds := range ids {
+		filters := bson.M{"id": bson.M{"$in": kindIds}}
+
+		var found []string
+		switch kind {
+		case models.SearchTask:
+			tasks, err := storage.Tasks.Find(filters, opt)
+			logError(err)
+			for _, task := range tasks {
+				found = append(found, task.Id)
+			}
+		case models.SearchProject:
+			projects, err := storage.Projects.Find(filters, opt)
+			logError(err)
+			for _, project := range projects {
+				found = append(found, project.Id)
+			}
+		case models.SearchWorkspace:
+			workspaces, err := storage.Workspaces.Find(filters, opt)
+			logError(err)
+			for _, workspace := range workspaces {
+				found = append(found, workspace.Id)
+			}
+		}
+
+		for _, id := range found {
+			live[kind+":"+id] = true
+		}
+	}
+
+	results := make([]models.SearchHit, 0, len(hits))
+	for _, hit := range hits {
+		if live[hit.Kind+":"+hit.Id] {
+			results = append(results, hit)
+		}
+	}
+
+	return results
+}
+
+// MemorySearchIndex is an inverted index of the words of the documents, kept
+// in memory. Query words match words equal to them, starting with them, or
+// a typo or two away for longer words.
+type MemorySearchIndex struct {
+	mu        sync.RWMutex
+	documents map[string]models.SearchDocument // kind:id => document
+	postings  map[string]map[string]bool       // word => kind:id of the documents with it
+}
+
+func NewMemorySearchIndex() *MemorySearchIndex {
+	return &MemorySearchIndex{
+		documents: map[string]models.SearchDocument{},
+		postings:  map[string]map[string]bool{},
+	}
+}
+
+func searchKey(kind string, id string) string {
+	return kind + ":" + id
+}
+
+func (s *MemorySearchIndex) Index(document models.SearchDocument) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := searchKey(document.Kind, document.Id)
+	s.remove(key)
+
+	s.documents[key] = document
+	for _, field := range document.Fields {
+		for _, word := range searchWords(field.Text) {
+			if s.postings[word.text] == nil {
+				s.postings[word.text] = map[string]bool{}
+			}
+			s.postings[word.text][key] = true
+		}
+	}
+}
+
+func (s *MemorySearchIndex) Remove(kind string, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(searchKey(kind, id))
+}
+
+func (s *MemorySearchIndex) remove(key string) {
+	document, ok := s.documents[key]
+	if !ok {
+		return
+	}
+
+	for _, field := range document.Fields {
+		for _, word := range searchWords(field.Text) {
+			delete(s.postings[word.text], key)
+			if len(s.postings[word.text]) == 0 {
+				delete(s.postings, word.text)
+			}
+		}
+	}
+	delete(s.documents, key)
+}
+
+// Search returns the documents matching every word of the query.
+func (s *MemorySearchIndex) Search(query models.SearchQuery) []models.SearchHit {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	terms := make([]string, 0)
+	for _, word := range searchWords(query.Q) {
+		terms = append(terms, word.text)
+	}
+	if len(terms) == 0 {
+		return []models.SearchHit{}
+	}
+
+	var candidates map[string]bool
+	for _, term := range terms {
+		matching := map[string]bool{}
+		for word, keys := range s.postings {
+			if matchWord(term, word) > 0 {
+				for key := range keys {
+					matching[key] = true
+				}
+			}
+		}
+
+		if candidates != nil {
+			for key := range candidates {
+				if !matching[key] {
+					delete(candidates, key)
+				}
+			}
+		} else {
+			candidates = matching
+		}
+	}
+
+	hits := make([]models.SearchHit, 0, len(candidates))
+	for key := range candidates {
+		document := s.documents[key]
+		if query.Kind != "" && document.Kind != query.Kind {
+			continue
+		}
+		if query.WorkspaceIds != nil && !slices.Contains(query.WorkspaceIds, document.WorkspaceId) {
+			continue
+		}
+
+		hits = append(hits, scoreDocument(document, terms))
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		if hits[i].Title != hits[j].Title {
+			return hits[i].Title < hits[j].Title
+		}
+		return hits[i].Id < hits[j].Id
+	})
+
+	if limit := query.GetLimit(); len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	return hits
+}
+
+// scoreDocument adds up, for each term, its best match weighted by the field
+// it is found in, and highlights the fields matched.
+func scoreDocument(document models.SearchDocument, terms []string) models.SearchHit {
+	hit := models.SearchHit{
+		Kind:        document.Kind,
+		Id:          document.Id,
+		WorkspaceId: document.WorkspaceId,
+		ProjectId:   document.ProjectId,
+		Highlights:  map[string]string{},
+	}
+	if len(document.Fields) > 0 {
+		hit.Title = document.Fields[0].Text
+	}
+
+	for _, term := range terms {
+		best := 0.0
+		for _, field := range document.Fields {
+			for _, word := range searchWords(field.Text) {
+				best = max(best, field.Weight*matchWord(term, word.text))
+			}
+		}
+		hit.Score += best
+	}
+
+	for _, field := range document.Fields {
+		if highlighted, ok := highlight(field.Text, terms); ok {
+			hit.Highlights[field.Name] = highlighted
+		}
+	}
+
+	return hit
+}
+
+type searchWord struct {
+	text       string // Lower cased
+	start, end int    // Byte offsets in the text
+}
+
+// searchWords splits a text into its lower cased words, runs of letters and
+// digits.
+func searchWords(text string) []searchWord {
+	words := make([]searchWord, 0)
+
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord && start >= 0 {
+			words = append(words, searchWord{text: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, searchWord{text: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+
+	return words
+}
+
+// matchWord rates how well a word of a document matches a term of the
+// query, from 1 when equal to 0 when it does not.
+func matchWord(term string, word string) float64 {
+	if word == term {
+		return 1
+	}
+
+	length := len([]rune(term))
+	if length >= 2 && strings.HasPrefix(word, term) {
+		return 0.8
+	}
+
+	// Longer terms tolerate more typos
+	typos := 0
+	switch {
+	case length >= 8:
+		typos = 2
+	case length >= 4:
+		typos = 1
+	}
+
+	distance := editDistance(term, word, typos)
+	switch {
+	case distance == 0 || distance > typos:
+		return 0
+	case distance == 1:
+		return 0.6
+	}
+
+	return 0.4
+}
+
+// editDistance is the Levenshtein distance between a and b, or limit + 1
+// when it is above limit.
+func editDistance(a string, b string, limit int) int {
+	x, y := []rune(a), []rune(b)
+	if len(x)-len(y) > limit || len(y)-len(x) > limit {
+		return limit + 1
+	}
+
+	previous := make([]int, len(y)+1)
+	current := make([]int, len(y)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(x); i++ {
+		current[0] = i
+		smallest := current[0]
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			smallest = min(smallest, current[j])
+		}
+		if smallest > limit {
+			return limit + 1
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(y)]
+}
+
+// highlight escapes text for HTML and wraps the words matching a term in
+// <mark>. It reports whether any did.
+func highlight(text string, terms []string) (string, bool) {
+	var builder strings.Builder
+
+	matched, last := false, 0
+	for _, word := range searchWords(text) {
+		for _, term := range terms {
+			if matchWord(term, word.text) > 0 {
+				builder.WriteString(html.EscapeString(text[last:word.start]))
+				builder.WriteString("<mark>" + html.EscapeString(text[word.start:word.end]) + "</mark>")
+				last, matched = word.end, true
+				break
+			}
+		}
+	}
+	builder.WriteString(html.EscapeString(text[last:]))
+
+	return builder.String(), matched
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"kickof/database"
+	"kickof/models"
+	"kickof/utils"
+	"log"
+	"os"
+	"time"
+)
+
+const SessionCollection = "sessions"
+
+func GetAccessTokenTTL() time.Duration {
+	return utils.EnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
+
+func GetRefreshTokenTTL() time.Duration {
+	return utils.EnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+func GenerateAccessToken(user models.User, sessionId string, expire time.Time) (*string, error) {
+	claims := models.Claims{
+		UserId:    user.Id,
+		Email:     user.Email,
+		SessionId: sessionId,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: expire.Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET_KEY")))
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenString, nil
+}
+
+func GetSessions(filters bson.M, opt *options.FindOptions) []models.Session {
+	results := make([]models.Session, 0)
+
+	cursor := database.Find(SessionCollection, filters, opt)
+	if cursor == nil {
+		return results
+	}
+	for cursor.Next(context.Background()) {
+		var data models.Session
+		if cursor.Decode(&data) == nil {
+			results = append(results, data)
+		}
+	}
+
+	return results
+}
+
+func GetSession(filter bson.M) *models.Session {
+	var data models.Session
+	err := database.FindOne(SessionCollection, filter, nil).Decode(&data)
+	if err != nil {
+		return nil
+	}
+
+	return &data
+}
+
+func GetActiveSessions(userId string) []models.Session {
+	filters := bson.M{
+		"userId":    userId,
+		"revokedAt": nil,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+
+	return GetSessions(filters, options.Find().SetSort(bson.M{"lastUsedAt": -1}))
+}
+
+func IsSessionActive(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	filters := bson.M{
+		"id":        id,
+		"revokedAt": nil,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+
+	return database.Count(SessionCollection, filters) > 0
+}
+
+func issueTokens(user models.User, sessionId string, refreshToken string) (*models.AuthResult, error) {
+	expire := time.Now().Add(GetAccessTokenTTL())
+
+	token, err := GenerateAccessToken(user, sessionId, expire)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuthResult{
+		Token:        *token,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expire,
+		Id:           user.Id,
+		Name:         user.Name,
+		Email:        user.Email,
+	}, nil
+}
+
+// CreateSession starts a new session family for a device and returns the
+// first access and refresh token pair.
+func CreateSession(user models.User, userAgent string, ip string) (*models.AuthResult, error) {
+	refreshToken, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	session := models.Session{
+		Id:             uuid.New().String(),
+		UserId:         user.Id,
+		TokenHash:      utils.HashToken(refreshToken),
+		PreviousHashes: []string{},
+		UserAgent:      userAgent,
+		Ip:             ip,
+		ExpiresAt:      time.Now().Add(GetRefreshTokenTTL()),
+		LastUsedAt:     time.Now(),
+	}
+	session.CreatedAt = time.Now()
+	session.UpdatedAt = time.Now()
+
+	_, err = database.InsertOne(SessionCollection, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return issueTokens(user, session.Id, refreshToken)
+}
+
+// RefreshSession rotates a refresh token. Presenting a token that was already
+// rotated means it leaked, so the whole session family is revoked.
+func RefreshSession(refreshToken string, userAgent string, ip string) (*models.AuthResult, error) {
+	hash := utils.HashToken(refreshToken)
+
+	session := GetSession(bson.M{"tokenHash": hash})
+	if session == nil {
+		reused := GetSession(bson.M{"previousHashes": hash})
+		if reused != nil {
+			log.Println("Refresh token reuse detected, revoking session", reused.Id)
+			_ = RevokeSession(reused.Id)
+		}
+
+		return nil, errors.New("Refresh token invalid")
+	}
+
+	if session.RevokedAt != nil || session.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("Refresh token expired")
+	}
+
+	user := GetUser(bson.M{"id": session.UserId}, nil)
+	if user == nil {
+		return nil, errors.New("User not found")
+	}
+
+	newToken, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	// Filtering on the old hash makes the rotation atomic: of two concurrent
+	// refreshes with the same token only one can win.
+	filters := bson.M{"id": session.Id, "tokenHash": hash, "revokedAt": nil}
+	update := bson.M{
+		"tokenHash":      utils.HashToken(newToken),
+		"previousHashes": append(session.PreviousHashes, hash),
+		"userAgent":      userAgent,
+		"ip":             ip,
+		"expiresAt":      time.Now().Add(GetRefreshTokenTTL()),
+		"lastUsedAt":     time.Now(),
+		"updatedAt":      time.Now(),
+	}
+
+	res, err := database.UpdateOne(SessionCollection, filters, update)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.MatchedCount == 0 {
+		_ = RevokeSession(session.Id)
+		return nil, errors.New("Refresh token invalid")
+	}
+
+	return issueTokens(*user, session.Id, newToken)
+}
+
+func RevokeSession(id string) error {
+	_, err := database.UpdateOne(SessionCollection, bson.M{"id": id}, bson.M{"revokedAt": time.Now()})
+	return err
+}
+
+func RevokeUserSessions(userId string) error {
+	filters := bson.M{"userId": userId, "revokedAt": nil}
+
+	_, err := database.UpdateMany(SessionCollection, filters, bson.M{"revokedAt": time.Now()})
+	return err
+}
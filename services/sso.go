@@ -0,0 +1,371 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"kickof/database"
+	"kickof/models"
+	"kickof/utils"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	SsoProviderCollection = "ssoproviders"
+	SsoStateCollection    = "ssostates"
+)
+
+var (
+	ErrSsoRequired     = errors.New("This account must sign in with single sign-on")
+	ErrSsoLinkRequired = errors.New("An account with this email already exists, sign in and link this identity from your profile")
+)
+
+func getDefaultSsoProvider() *models.SsoProvider {
+	if os.Getenv("OIDC_ISSUER") == "" {
+		return nil
+	}
+
+	return &models.SsoProvider{
+		Id:           models.DefaultSsoProvider,
+		Name:         os.Getenv("OIDC_NAME"),
+		Issuer:       os.Getenv("OIDC_ISSUER"),
+		ClientId:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+	}
+}
+
+func GetSsoProviders(filters bson.M, opt *options.FindOptions) []models.SsoProvider {
+	results := make([]models.SsoProvider, 0)
+
+	cursor := database.Find(SsoProviderCollection, filters, opt)
+	if cursor == nil {
+		return results
+	}
+	for cursor.Next(context.Background()) {
+		var data models.SsoProvider
+		if cursor.Decode(&data) == nil {
+			results = append(results, data)
+		}
+	}
+
+	return results
+}
+
+// GetSsoProvider resolves "default" to the environment provider and
+// anything else to the provider of the workspace with that id.
+func GetSsoProvider(id string) *models.SsoProvider {
+	if id == models.DefaultSsoProvider {
+		return getDefaultSsoProvider()
+	}
+
+	var data models.SsoProvider
+	err := database.FindOne(SsoProviderCollection, bson.M{"workspaceId": id}, nil).Decode(&data)
+	if err != nil {
+		return nil
+	}
+
+	return &data
+}
+
+func SaveWorkspaceSsoProvider(workspaceId string, request models.SsoProvider) (*models.SsoProvider, error) {
+	if GetWorkspace(bson.M{"id": workspaceId}, nil) == nil {
+		return nil, ErrNotFound
+	}
+
+	if request.Issuer == "" || request.ClientId == "" {
+		return nil, errors.New("Issuer and client id are required")
+	}
+
+	if request.DefaultRole != "" && (!models.IsValidRole(request.DefaultRole) || request.DefaultRole == models.RoleOwner) {
+		return nil, errors.New("Invalid default role")
+	}
+
+	request.Id = workspaceId
+	request.WorkspaceId = workspaceId
+
+	_, err := GetOidcDiscovery(request)
+	if err != nil {
+		return nil, errors.New("Unable to load the provider discovery document: " + err.Error())
+	}
+
+	current := GetSsoProvider(workspaceId)
+
+	request.UpdatedAt = time.Now()
+	if current != nil {
+		request.CreatedAt = current.CreatedAt
+		if request.ClientSecret == "" {
+			request.ClientSecret = current.ClientSecret
+		}
+	} else {
+		request.CreatedAt = time.Now()
+	}
+
+	_, err = database.UpsertOne(SsoProviderCollection, bson.M{"workspaceId": workspaceId}, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+func DeleteWorkspaceSsoProvider(workspaceId string) error {
+	_, err := database.DeleteOne(SsoProviderCollection, bson.M{"workspaceId": workspaceId})
+	return err
+}
+
+// StartSsoLogin stores the PKCE verifier and nonce of a new authorization
+// code flow and returns the provider url to send the browser to. With a
+// userId the flow links the identity to that signed in user.
+func StartSsoLogin(providerId string, redirectUri string, userId string) (string, error) {
+	provider := GetSsoProvider(providerId)
+	if provider == nil {
+		return "", errors.New("Unknown identity provider")
+	}
+
+	discovery, err := GetOidcDiscovery(*provider)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := utils.RandomToken(48)
+	if err != nil {
+		return "", err
+	}
+
+	data := models.SsoState{
+		Id:           utils.HashToken(state),
+		ProviderId:   providerId,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		RedirectUri:  redirectUri,
+		UserId:       userId,
+		ExpiresAt:    time.Now().Add(10 * time.Minute),
+		CreatedAt:    time.Now(),
+	}
+
+	_, err = database.InsertOne(SsoStateCollection, data)
+	if err != nil {
+		return "", err
+	}
+
+	return OidcAuthorizationUrl(*discovery, *provider, redirectUri, state, nonce, verifier), nil
+}
+
+// CompleteSsoLogin handles the provider callback. It returns a short lived
+// single-use token the frontend exchanges for a session, so the real tokens
+// never travel in a url.
+func CompleteSsoLogin(state string, code string) (*string, error) {
+	var pending models.SsoState
+	err := database.FindOne(SsoStateCollection, bson.M{"id": utils.HashToken(state)}, nil).Decode(&pending)
+	if err != nil {
+		return nil, errors.New("Unknown login state")
+	}
+
+	res, err := database.DeleteOne(SsoStateCollection, bson.M{"id": pending.Id})
+	if err != nil || res.DeletedCount == 0 {
+		return nil, errors.New("Unknown login state")
+	}
+
+	if pending.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("Login expired, please try again")
+	}
+
+	provider := GetSsoProvider(pending.ProviderId)
+	if provider == nil {
+		return nil, errors.New("Unknown identity provider")
+	}
+
+	discovery, err := GetOidcDiscovery(*provider)
+	if err != nil {
+		return nil, err
+	}
+
+	idToken, err := ExchangeOidcCode(*discovery, *provider, code, pending.RedirectUri, pending.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := ValidateIdToken(*discovery, *provider, idToken, pending.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *models.User
+	if pending.UserId != "" {
+		user, err = linkSsoIdentity(pending.UserId, *identity)
+	} else {
+		user, err = linkSsoUser(*provider, *identity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if provider.WorkspaceId != "" && provider.DefaultRole != "" {
+		err = AddWorkspaceMember(provider.WorkspaceId, user.Id, provider.DefaultRole)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return GenerateVerificationToken(user.Email, models.PurposeSso, user.Id)
+}
+
+// trustsSsoEmail tells whether a provider may sign in to an existing account
+// by its email. Only the instance provider may: anyone can register a
+// provider on their own workspace, and have it assert any email.
+func trustsSsoEmail(provider models.SsoProvider) bool {
+	return provider.Id == models.DefaultSsoProvider
+}
+
+// linkSsoIdentity adds an identity to a signed in user, unless another
+// account has it already.
+func linkSsoIdentity(userId string, identity models.OidcIdentity) (*models.User, error) {
+	link := models.Identity{Issuer: identity.Issuer, Subject: identity.Subject}
+
+	owner := GetUser(bson.M{"identities": bson.M{"$elemMatch": bson.M{"issuer": link.Issuer, "subject": link.Subject}}}, nil)
+	if owner != nil && owner.Id != userId {
+		return nil, errors.New("This identity is linked to another account")
+	}
+
+	user := GetUser(bson.M{"id": userId}, nil)
+	if user == nil {
+		return nil, errors.New("User not found")
+	}
+	if owner != nil {
+		return user, nil
+	}
+
+	_, err := storage.Users.UpdateOne(bson.M{"id": user.Id}, bson.M{
+		"identities": append(user.Identities, link),
+		"updatedAt":  time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// linkSsoUser finds the user of an identity by its issuer and subject, then by
+// verified email when the provider is trusted with it, and creates an
+// account when there is none.
+func linkSsoUser(provider models.SsoProvider, identity models.OidcIdentity) (*models.User, error) {
+	link := models.Identity{Issuer: identity.Issuer, Subject: identity.Subject}
+
+	user := GetUser(bson.M{"identities": bson.M{"$elemMatch": bson.M{"issuer": link.Issuer, "subject": link.Subject}}}, nil)
+	if user != nil {
+		return user, nil
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errors.New("The identity provider did not return a verified email")
+	}
+
+	email := strings.ToLower(identity.Email)
+
+	user = GetUser(bson.M{"email": email}, nil)
+	if user != nil {
+		if !trustsSsoEmail(provider) {
+			return nil, ErrSsoLinkRequired
+		}
+
+		update := bson.M{
+			"identities": append(user.Identities, link),
+			"active":     true,
+			"status":     true,
+			"updatedAt":  time.Now(),
+		}
+
+		_, err := storage.Users.UpdateOne(bson.M{"id": user.Id}, update)
+		if err != nil {
+			return nil, err
+		}
+
+		user.Active = true
+		JoinWorkspacesByDomain(*user)
+
+		return user, nil
+	}
+
+	// Nobody knows this password: the account can only sign in through the
+	// provider until its owner resets it.
+	password, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	name := identity.Name
+	if name == "" {
+		name = email
+	}
+
+	request := models.User{
+		Id:         uuid.New().String(),
+		Email:      email,
+		Name:       name,
+		Password:   utils.HashAndSalt(password),
+		Active:     true,
+		Status:     true,
+		LastActive: time.Now(),
+		Identities: []models.Identity{link},
+	}
+	request.CreatedAt = time.Now()
+	request.UpdatedAt = time.Now()
+
+	_, err = CreateUser(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only an email the instance vouches for joins workspaces by its domain
+	if trustsSsoEmail(provider) {
+		JoinWorkspacesByDomain(request)
+	}
+
+	return &request, nil
+}
+
+func ExchangeSsoToken(token string) (*models.User, error) {
+	verification, err := ConsumeVerificationToken(token, models.PurposeSso)
+	if err != nil {
+		return nil, err
+	}
+
+	user := GetUser(bson.M{"id": verification.Reference}, nil)
+	if user == nil {
+		return nil, errors.New("User not found")
+	}
+
+	return user, nil
+}
+
+// IsSsoEnforced tells whether one of the user's workspaces only allows
+// single sign-on. Owners are exempt so a broken provider cannot lock a
+// workspace out.
+func IsSsoEnforced(user models.User) bool {
+	providers := GetSsoProviders(bson.M{"enforced": true}, nil)
+	if len(providers) == 0 {
+		return false
+	}
+
+	for _, provider := range providers {
+		workspace := GetWorkspace(bson.M{"id": provider.WorkspaceId, "userids": user.Id}, nil)
+		if workspace != nil && workspace.GetRole(user.Id) != models.RoleOwner {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,67 +1,53 @@
 package services
 
 import (
-	"context"
 	"errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"kickof/database"
 	"kickof/models"
 )
 
 const StateCollection = "states"
 
-func GetStateTasks(stateId string) []models.Task {
-	result := GetTasks(bson.M{"stateId": stateId}, nil)
-	return result
+// GetStates finds states with the included relations, see StateIncludes.
+func GetStates(filters bson.M, opt *options.FindOptions, include []string) []models.State {
+	results, err := storage.States.Find(filters, opt)
+	logError(err)
+
+	LoadStateRelations(results, include)
+
+	return results
 }
 
-func GetStates(filters bson.M, opt *options.FindOptions) []models.State {
-	results := make([]models.State, 0)
+func GetStateIds(filters bson.M) []string {
+	results := make([]string, 0)
 
-	cursor := database.Find(StateCollection, filters, opt)
-	if cursor == nil {
-		return results
-	}
-	for cursor.Next(context.Background()) {
-		var data models.State
-		err := cursor.Decode(&data)
-		if err == nil {
-			if data.ProjectId != "" {
-				project := GetProject(bson.M{"id": data.ProjectId}, nil)
-				if project != nil {
-					data.Project = *project
-				}
-			}
-
-			data.Tasks = GetStateTasks(data.Id)
-
-			results = append(results, data)
-		}
+	states, err := storage.States.Find(filters, options.Find().SetProjection(bson.M{"id": 1}))
+	logError(err)
+
+	for _, data := range states {
+		results = append(results, data.Id)
 	}
 
 	return results
 }
 
-func GetStatesWithPagination(filters bson.M, opt *options.FindOptions, query models.Query) models.Result {
-	results := GetStates(filters, opt)
-
-	count := database.Count(StateCollection, filters)
+func GetStatesWithPagination(filters bson.M, opt *options.FindOptions, query models.Query) (models.Result, error) {
+	results, result, err := paginate[models.State](storage.States, filters, opt, query)
+	if err != nil {
+		return result, err
+	}
 
-	pagination := query.GetPagination(count)
+	LoadStateRelations(results, query.GetInclude(StateIncludes))
 
-	result := models.Result{
-		Data:       results,
-		Pagination: pagination,
-		Query:      query,
-	}
+	result.Data = results
 
-	return result
+	return result, nil
 }
 
 func CreateState(State models.State) (bool, error) {
-	_, err := database.InsertOne(StateCollection, State)
+	err := storage.States.InsertOne(State)
 	if err != nil {
 		return false, err
 	}
@@ -70,37 +56,17 @@ func CreateState(State models.State) (bool, error) {
 }
 
 func GetState(filter bson.M, opts *options.FindOneOptions) *models.State {
-	var data models.State
-	err := database.FindOne(StateCollection, filter, opts).Decode(&data)
+	data, err := storage.States.FindOne(filter, opts)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return nil
 		}
 		return nil
 	}
-	return &data
+	return data
 }
 
-func UpdateState(id string, State interface{}) (*mongo.UpdateResult, error) {
-	filters := bson.M{"id": id}
-
-	res, err := database.UpdateOne(StateCollection, filters, State)
-
-	if res == nil {
-		return nil, err
-	}
-
-	return res, nil
-}
-
-func DeleteState(id string) (*mongo.DeleteResult, error) {
-	filter := bson.M{"id": id}
-
-	res, err := database.DeleteOne(StateCollection, filter)
-
-	if res == nil {
-		return nil, err
-	}
-
-	return res, nil
+// UpdateState writes what changed in the state since current was read.
+func UpdateState(current models.State, State models.State) error {
+	return patchVersion[models.State](storage.States, current.Id, current.Version, current, State)
 }
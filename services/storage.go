@@ -0,0 +1,127 @@
+package services
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"kickof/database"
+	"kickof/models"
+	"log"
+)
+
+type UserRepository interface {
+	database.Repository[models.User]
+}
+
+type WorkspaceRepository interface {
+	database.SoftDeleteRepository[models.Workspace]
+}
+
+type ProjectRepository interface {
+	database.SoftDeleteRepository[models.Project]
+}
+
+type StateRepository interface {
+	database.SoftDeleteRepository[models.State]
+}
+
+type TaskRepository interface {
+	database.SoftDeleteRepository[models.Task]
+}
+
+type TaskLabelRepository interface {
+	database.SoftDeleteRepository[models.TaskLabel]
+}
+
+type ViewRepository interface {
+	database.Repository[models.View]
+}
+
+// Storage holds the repository of every aggregate the services work with.
+// Workspaces and everything in them go to the trash when deleted.
+type Storage struct {
+	Users      UserRepository
+	Workspaces WorkspaceRepository
+	Projects   ProjectRepository
+	States     StateRepository
+	Tasks      TaskRepository
+	TaskLabels TaskLabelRepository
+	Views      ViewRepository
+
+	store database.Store
+}
+
+// NewStorage keeps every aggregate in its collection of the given store. A
+// nil store follows the store of the database package.
+func NewStorage(store database.Store) Storage {
+	return Storage{
+		Users:      database.NewRepository[models.User](store, UserCollection),
+		Workspaces: database.NewVersionedSoftDeleteRepository[models.Workspace](store, WorkspaceCollection),
+		Projects:   database.NewVersionedSoftDeleteRepository[models.Project](store, ProjectCollection),
+		States:     database.NewVersionedSoftDeleteRepository[models.State](store, StateCollection),
+		Tasks:      database.NewVersionedSoftDeleteRepository[models.Task](store, TaskCollection),
+		TaskLabels: database.NewVersionedSoftDeleteRepository[models.TaskLabel](store, TaskLabelCollection),
+		Views:      database.NewVersionedRepository[models.View](store, ViewCollection),
+		store:      store,
+	}
+}
+
+// Transaction runs a unit of work on repositories bound to one transaction,
+// so its writes are committed together or not at all. fn must only use tx:
+// the other repositories do not see its writes until it returns.
+func (s Storage) Transaction(fn func(tx Storage) error) error {
+	return s.current().Transaction(func(tx database.Store) error {
+		return fn(NewStorage(tx))
+	})
+}
+
+// current is the store the repositories work on.
+func (s Storage) current() database.Store {
+	if s.store == nil {
+		return database.Current()
+	}
+
+	return s.store
+}
+
+var storage = NewStorage(nil)
+
+// UseStorage injects the repositories the services use.
+func UseStorage(s Storage) {
+	storage = s
+}
+
+func GetStorage() Storage {
+	return storage
+}
+
+// logError reports repository failures the callers have no way to return.
+func logError(err error) {
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+var ErrVersionConflict = errors.New("Data was changed since it was read, reload it and try again")
+
+// updateVersion writes changes to a document at the version it was read at,
+// and fails with ErrVersionConflict when someone wrote it since.
+func updateVersion[T any](repository database.Repository[T], id string, version int64, changes interface{}) error {
+	res, err := repository.UpdateOne(database.VersionFilter(id, version), changes)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		count, err := repository.Count(bson.M{"id": id})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrNotFound
+		}
+
+		return ErrVersionConflict
+	}
+
+	return nil
+}
@@ -1,106 +1,370 @@
 package services
 
 import (
-	"context"
 	"errors"
+	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"kickof/database"
 	"kickof/models"
+	"slices"
+	"time"
 )
 
 const TaskCollection = "tasks"
 
-func GetTaskAssignees(userIds []string) []models.User {
-	result := GetUsers(bson.M{"id": bson.M{"$in": userIds}}, options.Find().SetProjection(bson.D{{"password", 0}}))
-	return result
+// GetTasks finds tasks with the included relations, see TaskIncludes.
+func GetTasks(filters bson.M, opt *options.FindOptions, include []string) []models.Task {
+	results, err := storage.Tasks.Find(filters, opt)
+	logError(err)
+
+	LoadTaskRelations(results, include)
+
+	return results
+}
+
+func GetTasksWithPagination(filters bson.M, opt *options.FindOptions, query models.Query) (models.Result, error) {
+	results, result, err := paginate[models.Task](storage.Tasks, filters, opt, query)
+	if err != nil {
+		return result, err
+	}
+
+	LoadTaskRelations(results, query.GetInclude(TaskIncludes))
+	if query.Render == "html" {
+		RenderTaskDescriptions(results)
+	}
+
+	result.Data = results
+
+	return result, nil
 }
 
-func GetLabelsOfATask(labelIds []string) []models.TaskLabel {
-	result := GetTaskLabels(bson.M{"id": bson.M{"$in": labelIds}}, nil)
-	return result
+func CreateTask(Task models.Task) (bool, error) {
+	err := storage.Tasks.InsertOne(Task)
+	if err != nil {
+		return false, err
+	}
+
+	indexTasks(Task)
+
+	return true, nil
 }
 
-func GetTasks(filters bson.M, opt *options.FindOptions) []models.Task {
-	results := make([]models.Task, 0)
+func GetTask(filter bson.M, opts *options.FindOneOptions) *models.Task {
+	data, err := storage.Tasks.FindOne(filter, opts)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return nil
+	}
+	return data
+}
 
-	cursor := database.Find(TaskCollection, filters, opt)
-	if cursor == nil {
-		return results
+// UpdateTask writes what changed in the task since current was read.
+func UpdateTask(current models.Task, Task models.Task) error {
+	err := patchVersion[models.Task](storage.Tasks, current.Id, current.Version, current, Task)
+	if err != nil {
+		return err
 	}
-	for cursor.Next(context.Background()) {
-		var data models.Task
-		err := cursor.Decode(&data)
-		if err == nil {
-			data.Assignees = GetTaskAssignees(data.AssigneeIds)
-			data.Labels = GetLabelsOfATask(data.LabelIds)
 
-			results = append(results, data)
+	indexTasks(Task)
+
+	return nil
+}
+
+var (
+	ErrTaskWorkspace = errors.New("Tasks can only move within their workspace")
+	ErrTaskState     = errors.New("State does not belong to the project of the task")
+	ErrTaskLabel     = errors.New("Label does not belong to the workspace of the task")
+)
+
+// createTaskLabels creates the labels of the task that have no id yet in the
+// project of the task, and adds the label and assignee ids from them.
+func createTaskLabels(tx Storage, task *models.Task) error {
+	for i, label := range task.Labels {
+		if label.Id == "" {
+			label.Id = uuid.New().String()
+			label.Version = 1
+			label.WorkspaceId = task.WorkspaceId
+			label.ProjectId = task.ProjectId
+			label.CreatedAt = time.Now()
+			label.UpdatedAt = time.Now()
+
+			err := tx.TaskLabels.InsertOne(label)
+			if err != nil {
+				return err
+			}
+			task.Labels[i] = label
 		}
 
+		task.LabelIds = append(task.LabelIds, label.Id)
 	}
 
-	return results
+	for _, assignee := range task.Assignees {
+		task.AssigneeIds = append(task.AssigneeIds, assignee.Id)
+	}
+
+	return nil
 }
 
-func GetTasksWithPagination(filters bson.M, opt *options.FindOptions, query models.Query) models.Result {
-	results := GetTasks(filters, opt)
+// CreateTaskWithLabels inserts a task along with its new labels, all or
+// nothing.
+func CreateTaskWithLabels(task *models.Task) error {
+	err := storage.Transaction(func(tx Storage) error {
+		err := createTaskLabels(tx, task)
+		if err != nil {
+			return err
+		}
 
-	count := database.Count(TaskCollection, filters)
+		err = checkTaskRelations(tx, *task)
+		if err != nil {
+			return err
+		}
 
-	pagination := query.GetPagination(count)
+		task.Code, task.PreviousKeys = "", nil
+		err = assignTaskKey(tx, task)
+		if err != nil {
+			return err
+		}
 
-	result := models.Result{
-		Data:       results,
-		Pagination: pagination,
-		Query:      query,
+		return tx.Tasks.InsertOne(*task)
+	})
+	if err != nil {
+		return err
 	}
 
-	return result
+	indexTasks(*task)
+
+	return nil
 }
 
-func CreateTask(Task models.Task) (bool, error) {
-	_, err := database.InsertOne(TaskCollection, Task)
+// UpdateTaskWithLabels writes what changed in a task since current was read
+// and creates its new labels, all or nothing.
+func UpdateTaskWithLabels(current models.Task, task *models.Task) error {
+	err := storage.Transaction(func(tx Storage) error {
+		err := createTaskLabels(tx, task)
+		if err != nil {
+			return err
+		}
+
+		slices.Sort(task.LabelIds)
+		task.LabelIds = slices.Compact(task.LabelIds)
+		slices.Sort(task.AssigneeIds)
+		task.AssigneeIds = slices.Compact(task.AssigneeIds)
+
+		err = checkTaskRelations(tx, *task)
+		if err != nil {
+			return err
+		}
+
+		// Keys are kept by the server, and renewed when the task moves
+		task.Code, task.PreviousKeys = current.Code, current.PreviousKeys
+		if task.ProjectId != current.ProjectId {
+			err = assignTaskKey(tx, task)
+			if err != nil {
+				return err
+			}
+		}
+
+		return patchVersion[models.Task](tx.Tasks, current.Id, current.Version, current, task)
+	})
 	if err != nil {
-		return false, err
+		return err
 	}
 
-	return true, nil
+	indexTasks(*task)
+
+	return nil
 }
 
-func GetTask(filter bson.M, opts *options.FindOneOptions) *models.Task {
-	var data models.Task
-	err := database.FindOne(TaskCollection, filter, opts).Decode(&data)
+// findTasks loads every task of the ids, or fails with ErrNotFound.
+func findTasks(tx Storage, taskIds []string) ([]models.Task, error) {
+	tasks, err := tx.Tasks.Find(bson.M{"id": bson.M{"$in": taskIds}}, nil)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil
+		return nil, err
+	}
+
+	slices.Sort(taskIds)
+	if len(tasks) != len(slices.Compact(taskIds)) {
+		return nil, ErrNotFound
+	}
+
+	return tasks, nil
+}
+
+// GetTasksWorkspaceId returns the workspace shared by the tasks, or an empty
+// string when they are missing or span several workspaces.
+func GetTasksWorkspaceId(taskIds []string) string {
+	tasks, err := findTasks(storage, slices.Clone(taskIds))
+	if err != nil || len(tasks) == 0 {
+		return ""
+	}
+
+	for _, task := range tasks {
+		if task.WorkspaceId != tasks[0].WorkspaceId {
+			return ""
 		}
+	}
+
+	return tasks[0].WorkspaceId
+}
+
+// checkTaskState fails unless the state can hold tasks of the project.
+func checkTaskState(tx Storage, stateId string, workspaceId string, projectId string) error {
+	if stateId == "" {
 		return nil
 	}
-	return &data
+
+	state, err := tx.States.FindOne(bson.M{"id": stateId}, nil)
+	if err != nil {
+		return ErrTaskState
+	}
+	if state.WorkspaceId != workspaceId || state.ProjectId != "" && state.ProjectId != projectId {
+		return ErrTaskState
+	}
+
+	return nil
 }
 
-func UpdateTask(id string, Task interface{}) (*mongo.UpdateResult, error) {
-	filters := bson.M{"id": id}
+// checkTaskLabels fails unless every label belongs to the workspace.
+func checkTaskLabels(tx Storage, labelIds []string, workspaceId string) error {
+	labelIds = slices.Clone(labelIds)
+	slices.Sort(labelIds)
+	labelIds = slices.Compact(labelIds)
+	if len(labelIds) == 0 {
+		return nil
+	}
+
+	count, err := tx.TaskLabels.Count(bson.M{"id": bson.M{"$in": labelIds}, "workspaceId": workspaceId})
+	if err != nil {
+		return err
+	}
+	if count != int64(len(labelIds)) {
+		return ErrTaskLabel
+	}
 
-	res, err := database.UpdateOne(TaskCollection, filters, Task)
+	return nil
+}
 
-	if res == nil {
-		return nil, err
+// checkTaskRelations fails unless the state and labels of a task belong to
+// its project and workspace.
+func checkTaskRelations(tx Storage, task models.Task) error {
+	err := checkTaskState(tx, task.StateId, task.WorkspaceId, task.ProjectId)
+	if err != nil {
+		return err
 	}
 
-	return res, nil
+	return checkTaskLabels(tx, task.LabelIds, task.WorkspaceId)
 }
 
-func DeleteTask(id string) (*mongo.DeleteResult, error) {
-	filter := bson.M{"id": id}
+// MoveTasks moves tasks to another project of their workspace, in the given
+// state of that project, all or nothing.
+func MoveTasks(request models.TaskMoveRequest) error {
+	err := storage.Transaction(func(tx Storage) error {
+		project, err := tx.Projects.FindOne(bson.M{"id": request.ProjectId}, nil)
+		if err != nil {
+			return ErrNotFound
+		}
 
-	res, err := database.DeleteOne(TaskCollection, filter)
+		tasks, err := findTasks(tx, slices.Clone(request.TaskIds))
+		if err != nil {
+			return err
+		}
 
-	if res == nil {
-		return nil, err
+		for _, task := range tasks {
+			if task.WorkspaceId != project.WorkspaceId {
+				return ErrTaskWorkspace
+			}
+		}
+
+		err = checkTaskState(tx, request.StateId, project.WorkspaceId, project.Id)
+		if err != nil {
+			return err
+		}
+
+		for _, task := range tasks {
+			changes := bson.M{
+				"projectId": project.Id,
+				"stateId":   request.StateId,
+				"updatedAt": time.Now(),
+			}
+
+			if task.ProjectId != project.Id {
+				task.ProjectId = project.Id
+				err = assignTaskKey(tx, &task)
+				if err != nil {
+					return err
+				}
+				changes["code"] = task.Code
+				changes["previousKeys"] = task.PreviousKeys
+			}
+
+			_, err = tx.Tasks.UpdateOne(bson.M{"id": task.Id}, changes)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	return res, nil
+	tasks, err := storage.Tasks.Find(bson.M{"id": bson.M{"$in": request.TaskIds}}, nil)
+	logError(err)
+	indexTasks(tasks...)
+
+	return nil
+}
+
+// BulkUpdateTasks applies the same changes to several tasks, all or nothing.
+func BulkUpdateTasks(request models.TaskBulkRequest) error {
+	return storage.Transaction(func(tx Storage) error {
+		tasks, err := findTasks(tx, slices.Clone(request.TaskIds))
+		if err != nil {
+			return err
+		}
+
+		changes := bson.M{"updatedAt": time.Now()}
+		if request.AssigneeIds != nil {
+			changes["assigneeids"] = *request.AssigneeIds
+		}
+		if request.LabelIds != nil {
+			changes["labelIds"] = *request.LabelIds
+		}
+		if request.StateId != nil {
+			changes["stateId"] = *request.StateId
+		}
+		if request.StartDate != nil {
+			changes["startDate"] = *request.StartDate
+		}
+		if request.EndDate != nil {
+			changes["endDate"] = *request.EndDate
+		}
+
+		for _, task := range tasks {
+			if request.StateId != nil {
+				err = checkTaskState(tx, *request.StateId, task.WorkspaceId, task.ProjectId)
+				if err != nil {
+					return err
+				}
+			}
+
+			if request.LabelIds != nil {
+				err = checkTaskLabels(tx, *request.LabelIds, task.WorkspaceId)
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err = tx.Tasks.UpdateOne(bson.M{"id": task.Id}, changes)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 }
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"kickof/models"
+	"log"
+)
+
+const CounterCollection = "counters"
+
+var ErrAmbiguousTaskKey = errors.New("Several of your workspaces have a task with this key, choose one with ?workspace=")
+
+// errTaskKeyAssigned rolls back a key taken for a task another instance keyed
+// first.
+var errTaskKeyAssigned = errors.New("task key already assigned")
+
+// nextTaskKey takes the next key of the tasks of a project, from an atomic
+// counter. Counters are kept per workspace and code rather than per project,
+// so a project recreated with the code of a deleted one never reissues its
+// keys. Tasks of projects without a code get no key.
+func nextTaskKey(tx Storage, project models.Project) (string, error) {
+	if project.Code == "" {
+		return "", nil
+	}
+
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+
+	err := tx.current().FindOneAndUpdate(CounterCollection,
+		bson.M{"key": "tasks:" + project.WorkspaceId + ":" + project.Code},
+		bson.M{"$inc": bson.M{"seq": int64(1)}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s-%d", project.Code, counter.Seq), nil
+}
+
+// assignTaskKey gives a task the next key of its project. A task that had
+// another key keeps it among its previous keys, so the old key still leads
+// to it.
+func assignTaskKey(tx Storage, task *models.Task) error {
+	project := models.Project{}
+	if task.ProjectId != "" {
+		found, err := tx.Projects.Unscoped().FindOne(bson.M{"id": task.ProjectId}, nil)
+		if err != nil {
+			return ErrNotFound
+		}
+		project = *found
+	}
+
+	key, err := nextTaskKey(tx, project)
+	if err != nil {
+		return err
+	}
+
+	if task.Code != "" {
+		task.PreviousKeys = append(task.PreviousKeys, task.Code)
+	}
+	task.Code = key
+
+	return nil
+}
+
+// GetTaskByKey finds the task of a key in the given workspaces. A key the
+// task had before it moved finds it too, and moved tells so.
+func GetTaskByKey(key string, workspaceIds []string) (*models.Task, bool, error) {
+	opt := options.Find().SetLimit(2)
+	workspaces := bson.M{"$in": workspaceIds}
+
+	moved := false
+	tasks, err := storage.Tasks.Find(bson.M{"code": key, "workspaceId": workspaces}, opt)
+	if err == nil && len(tasks) == 0 {
+		moved = true
+		tasks, err = storage.Tasks.Find(bson.M{"previousKeys": key, "workspaceId": workspaces}, opt)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch len(tasks) {
+	case 0:
+		return nil, false, ErrNotFound
+	case 1:
+		return &tasks[0], moved, nil
+	}
+
+	return nil, false, ErrAmbiguousTaskKey
+}
+
+// AssignTaskKeys gives a key to the tasks created before keys existed, the
+// oldest first, trash included.
+func AssignTaskKeys() {
+	filters := bson.M{"projectId": bson.M{"$gt": ""}, "$or": bson.A{bson.M{"code": nil}, bson.M{"code": ""}}}
+
+	tasks, err := storage.Tasks.Unscoped().Find(filters, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		log.Println("Error assigning task keys", err.Error())
+		return
+	}
+
+	count := 0
+	for _, task := range tasks {
+		err = storage.Transaction(func(tx Storage) error {
+			err := assignTaskKey(tx, &task)
+			if err != nil || task.Code == "" {
+				return err
+			}
+
+			// Another instance may have keyed the task since it was read, its
+			// key stays and the one taken here is given back
+			res, err := tx.Tasks.Unscoped().UpdateOne(bson.M{"id": task.Id, "code": bson.M{"$in": bson.A{nil, ""}}}, bson.M{"code": task.Code})
+			if err == nil && res.MatchedCount == 0 {
+				return errTaskKeyAssigned
+			}
+			return err
+		})
+		if errors.Is(err, errTaskKeyAssigned) {
+			continue
+		}
+		if err != nil {
+			log.Println("Error assigning a key to task", task.Id, err.Error())
+			continue
+		}
+		if task.Code != "" {
+			count++
+		}
+	}
+
+	if count > 0 {
+		log.Printf("Assigned keys to %d tasks", count)
+	}
+}
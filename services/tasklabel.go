@@ -1,54 +1,35 @@
 package services
 
 import (
-	"context"
 	"errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"kickof/database"
 	"kickof/models"
 )
 
 const TaskLabelCollection = "tasklabels"
 
 func GetTaskLabels(filters bson.M, opt *options.FindOptions) []models.TaskLabel {
-	results := make([]models.TaskLabel, 0)
-
-	cursor := database.Find(TaskLabelCollection, filters, opt)
-	if cursor == nil {
-		return results
-	}
-	for cursor.Next(context.Background()) {
-		var data models.TaskLabel
-		err := cursor.Decode(&data)
-		if err == nil {
-			results = append(results, data)
-		}
-
-	}
+	results, err := storage.TaskLabels.Find(filters, opt)
+	logError(err)
 
 	return results
 }
 
-func GetTaskLabelsWithPagination(filters bson.M, opt *options.FindOptions, query models.Query) models.Result {
-	results := GetTaskLabels(filters, opt)
-
-	count := database.Count(TaskLabelCollection, filters)
-
-	pagination := query.GetPagination(count)
-
-	result := models.Result{
-		Data:       results,
-		Pagination: pagination,
-		Query:      query,
+func GetTaskLabelsWithPagination(filters bson.M, opt *options.FindOptions, query models.Query) (models.Result, error) {
+	results, result, err := paginate[models.TaskLabel](storage.TaskLabels, filters, opt, query)
+	if err != nil {
+		return result, err
 	}
 
-	return result
+	result.Data = results
+
+	return result, nil
 }
 
 func CreateTaskLabel(TaskLabel models.TaskLabel) (bool, error) {
-	_, err := database.InsertOne(TaskLabelCollection, TaskLabel)
+	err := storage.TaskLabels.InsertOne(TaskLabel)
 	if err != nil {
 		return false, err
 	}
@@ -57,37 +38,17 @@ func CreateTaskLabel(TaskLabel models.TaskLabel) (bool, error) {
 }
 
 func GetTaskLabel(filter bson.M, opts *options.FindOneOptions) *models.TaskLabel {
-	var data models.TaskLabel
-	err := database.FindOne(TaskLabelCollection, filter, opts).Decode(&data)
+	data, err := storage.TaskLabels.FindOne(filter, opts)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return nil
 		}
 		return nil
 	}
-	return &data
+	return data
 }
 
-func UpdateTaskLabel(id string, TaskLabel interface{}) (*mongo.UpdateResult, error) {
-	filters := bson.M{"id": id}
-
-	res, err := database.UpdateOne(TaskLabelCollection, filters, TaskLabel)
-
-	if res == nil {
-		return nil, err
-	}
-
-	return res, nil
-}
-
-func DeleteTaskLabel(id string) (*mongo.DeleteResult, error) {
-	filter := bson.M{"id": id}
-
-	res, err := database.DeleteOne(TaskLabelCollection, filter)
-
-	if res == nil {
-		return nil, err
-	}
-
-	return res, nil
+// UpdateTaskLabel writes what changed in the label since current was read.
+func UpdateTaskLabel(current models.TaskLabel, TaskLabel models.TaskLabel) error {
+	return patchVersion[models.TaskLabel](storage.TaskLabels, current.Id, current.Version, current, TaskLabel)
 }
@@ -0,0 +1,502 @@
+package services
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"kickof/models"
+	"kickof/utils"
+	"log"
+	"regexp"
+	"time"
+)
+
+var (
+	ErrNotFound      = errors.New("Data Not Found")
+	ErrStateHasTasks = errors.New("State still has tasks, choose a target state to move them to")
+	ErrInvalidTarget = errors.New("Target state must be another state of the same project")
+	ErrDeletedWith   = errors.New("Deleted along with its parent, restore the parent instead")
+	ErrParentInTrash = errors.New("Restore the parent first")
+	ErrNotInTrash    = errors.New("Data is not in the trash")
+)
+
+// trashed matches the documents in the trash. Those deleted on their own,
+// rather than along with a parent, are the roots restore works on.
+var (
+	trashed     = bson.M{"$ne": nil}
+	trashedRoot = bson.M{"deletedAt": trashed, "deletedWith": bson.M{"$in": bson.A{nil, ""}}}
+)
+
+// trashMark moves documents to the trash. The root of a cascade has an empty
+// deletedWith, the documents that went with it have the root id.
+func trashMark(userId string, with string) bson.M {
+	return bson.M{
+		"deletedAt":   time.Now(),
+		"deletedBy":   userId,
+		"deletedWith": with,
+	}
+}
+
+var restoreMark = bson.M{
+	"deletedAt":   nil,
+	"deletedBy":   "",
+	"deletedWith": "",
+}
+
+type manyUpdater interface {
+	UpdateMany(filters bson.M, object interface{}) (*mongo.UpdateResult, error)
+}
+
+type manyDeleter interface {
+	DeleteMany(filters bson.M) (*mongo.DeleteResult, error)
+}
+
+// trashChildren moves the live documents matching filters to the trash
+// along with their parent.
+func trashChildren(tx Storage, filters bson.M, mark bson.M) error {
+	for _, repository := range []manyUpdater{tx.Tasks, tx.TaskLabels, tx.States, tx.Projects} {
+		_, err := repository.UpdateMany(filters, mark)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreChildren brings back what went to the trash along with a parent.
+func restoreChildren(tx Storage, parentId string) error {
+	for _, repository := range []manyUpdater{tx.Tasks.Unscoped(), tx.TaskLabels.Unscoped(), tx.States.Unscoped(), tx.Projects.Unscoped()} {
+		_, err := repository.UpdateMany(bson.M{"deletedWith": parentId}, restoreMark)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexChildren removes the projects and tasks matching filters from the
+// search index, trashed or not.
+func unindexChildren(filters bson.M) {
+	projects, err := storage.Projects.Unscoped().Find(filters, nil)
+	logError(err)
+	for _, project := range projects {
+		unindex(models.SearchProject, project.Id)
+	}
+
+	tasks, err := storage.Tasks.Unscoped().Find(filters, nil)
+	logError(err)
+	for _, task := range tasks {
+		unindex(models.SearchTask, task.Id)
+	}
+}
+
+// reindexChildren indexes the live projects and tasks matching filters
+// again, once they came back from the trash.
+func reindexChildren(filters bson.M) {
+	projects, err := storage.Projects.Find(filters, nil)
+	logError(err)
+	for _, project := range projects {
+		indexProject(project)
+	}
+
+	tasks, err := storage.Tasks.Find(filters, nil)
+	logError(err)
+	indexTasks(tasks...)
+}
+
+// DeleteWorkspace moves a workspace to the trash along with its projects,
+// states, tasks and labels.
+func DeleteWorkspace(id string, version int64, userId string) error {
+	err := storage.Transaction(func(tx Storage) error {
+		err := trashChildren(tx, bson.M{"workspaceId": id}, trashMark(userId, id))
+		if err != nil {
+			return err
+		}
+
+		return updateVersion[models.Workspace](tx.Workspaces, id, version, trashMark(userId, ""))
+	})
+	if err != nil {
+		return err
+	}
+
+	unindex(models.SearchWorkspace, id)
+	unindexChildren(bson.M{"workspaceId": id})
+
+	return nil
+}
+
+// DeleteProject moves a project to the trash along with its states, tasks
+// and labels.
+func DeleteProject(id string, version int64, userId string) error {
+	err := storage.Transaction(func(tx Storage) error {
+		err := trashChildren(tx, bson.M{"projectId": id}, trashMark(userId, id))
+		if err != nil {
+			return err
+		}
+
+		return updateVersion[models.Project](tx.Projects, id, version, trashMark(userId, ""))
+	})
+	if err != nil {
+		return err
+	}
+
+	unindex(models.SearchProject, id)
+	unindexChildren(bson.M{"projectId": id})
+
+	return nil
+}
+
+// DeleteState moves a state to the trash. Its tasks move to targetStateId
+// first, which is required as long as the state has tasks.
+func DeleteState(id string, version int64, targetStateId string, userId string) error {
+	return storage.Transaction(func(tx Storage) error {
+		state, err := tx.States.FindOne(bson.M{"id": id}, nil)
+		if err != nil {
+			return ErrNotFound
+		}
+		if state.Version != version {
+			return ErrVersionConflict
+		}
+
+		count, err := tx.Tasks.Count(bson.M{"stateId": id})
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			if targetStateId == "" {
+				return ErrStateHasTasks
+			}
+
+			target, err := tx.States.FindOne(bson.M{"id": targetStateId}, nil)
+			if err != nil || target.Id == state.Id || target.WorkspaceId != state.WorkspaceId || target.ProjectId != state.ProjectId {
+				return ErrInvalidTarget
+			}
+
+			_, err = tx.Tasks.UpdateMany(bson.M{"stateId": id}, bson.M{
+				"stateId":   targetStateId,
+				"updatedAt": time.Now(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return updateVersion[models.State](tx.States, id, version, trashMark(userId, ""))
+	})
+}
+
+func DeleteTask(id string, version int64, userId string) error {
+	err := updateVersion[models.Task](storage.Tasks, id, version, trashMark(userId, ""))
+	if err != nil {
+		return err
+	}
+
+	unindex(models.SearchTask, id)
+
+	return nil
+}
+
+func DeleteTaskLabel(id string, version int64, userId string) error {
+	return updateVersion[models.TaskLabel](storage.TaskLabels, id, version, trashMark(userId, ""))
+}
+
+// GetTrash lists what was deleted on its own in a workspace. Documents that
+// went to the trash with a parent come back with it.
+func GetTrash(workspaceId string) models.Trash {
+	result := models.Trash{}
+
+	workspace, err := storage.Workspaces.Unscoped().FindOne(bson.M{"id": workspaceId, "deletedAt": trashed}, nil)
+	if err == nil {
+		result.Workspace = workspace
+	}
+
+	filters := bson.M{"workspaceId": workspaceId}
+	for key, value := range trashedRoot {
+		filters[key] = value
+	}
+
+	result.Projects, err = storage.Projects.Unscoped().Find(filters, nil)
+	logError(err)
+	result.States, err = storage.States.Unscoped().Find(filters, nil)
+	logError(err)
+	result.Tasks, err = storage.Tasks.Unscoped().Find(filters, nil)
+	logError(err)
+	result.TaskLabels, err = storage.TaskLabels.Unscoped().Find(filters, nil)
+	logError(err)
+
+	return result
+}
+
+// checkRestorable fails unless the document is a root of the trash.
+func checkRestorable(deleted models.SoftDelete) error {
+	if deleted.DeletedAt == nil {
+		return ErrNotInTrash
+	}
+	if deleted.DeletedWith != "" {
+		return ErrDeletedWith
+	}
+
+	return nil
+}
+
+// checkParents fails when one of the parents a document points to is not
+// live, since restoring it would leave it hidden.
+func checkParents(workspaceId string, projectId string, stateId string) error {
+	if GetWorkspace(bson.M{"id": workspaceId}, nil) == nil {
+		return ErrParentInTrash
+	}
+	if projectId != "" && GetProject(bson.M{"id": projectId}, nil) == nil {
+		return ErrParentInTrash
+	}
+	if stateId != "" && GetState(bson.M{"id": stateId}, nil) == nil {
+		return ErrParentInTrash
+	}
+
+	return nil
+}
+
+func RestoreWorkspace(id string) error {
+	workspace, err := storage.Workspaces.Unscoped().FindOne(bson.M{"id": id}, nil)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	err = checkRestorable(workspace.SoftDelete)
+	if err != nil {
+		return err
+	}
+
+	err = storage.Transaction(func(tx Storage) error {
+		err := restoreChildren(tx, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Workspaces.Unscoped().UpdateOne(bson.M{"id": id}, restoreMark)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	indexWorkspace(*workspace)
+	reindexChildren(bson.M{"workspaceId": id})
+
+	return nil
+}
+
+func RestoreProject(id string) error {
+	project, err := storage.Projects.Unscoped().FindOne(bson.M{"id": id}, nil)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	err = checkRestorable(project.SoftDelete)
+	if err != nil {
+		return err
+	}
+
+	err = checkParents(project.WorkspaceId, "", "")
+	if err != nil {
+		return err
+	}
+
+	err = storage.Transaction(func(tx Storage) error {
+		err := restoreChildren(tx, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Projects.Unscoped().UpdateOne(bson.M{"id": id}, restoreMark)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	indexProject(*project)
+	reindexChildren(bson.M{"projectId": id})
+
+	return nil
+}
+
+func RestoreState(id string) error {
+	state, err := storage.States.Unscoped().FindOne(bson.M{"id": id}, nil)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	err = checkRestorable(state.SoftDelete)
+	if err != nil {
+		return err
+	}
+
+	err = checkParents(state.WorkspaceId, state.ProjectId, "")
+	if err != nil {
+		return err
+	}
+
+	_, err = storage.States.Unscoped().UpdateOne(bson.M{"id": id}, restoreMark)
+
+	return err
+}
+
+func RestoreTask(id string) error {
+	task, err := storage.Tasks.Unscoped().FindOne(bson.M{"id": id}, nil)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	err = checkRestorable(task.SoftDelete)
+	if err != nil {
+		return err
+	}
+
+	err = checkParents(task.WorkspaceId, task.ProjectId, task.StateId)
+	if err != nil {
+		return err
+	}
+
+	_, err = storage.Tasks.Unscoped().UpdateOne(bson.M{"id": id}, restoreMark)
+	if err != nil {
+		return err
+	}
+
+	indexTasks(*task)
+
+	return nil
+}
+
+func RestoreTaskLabel(id string) error {
+	label, err := storage.TaskLabels.Unscoped().FindOne(bson.M{"id": id}, nil)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	err = checkRestorable(label.SoftDelete)
+	if err != nil {
+		return err
+	}
+
+	err = checkParents(label.WorkspaceId, label.ProjectId, "")
+	if err != nil {
+		return err
+	}
+
+	_, err = storage.TaskLabels.Unscoped().UpdateOne(bson.M{"id": id}, restoreMark)
+
+	return err
+}
+
+// workspaceCollections hold what belongs to a workspace but stays out of the
+// trash with it, deleted when the workspace is purged.
+var workspaceCollections = []string{
+	ViewCollection,
+	InvitationCollection,
+	InviteLinkCollection,
+	JoinRequestCollection,
+	AccessTokenCollection,
+	SsoProviderCollection,
+}
+
+// purgeWorkspace deletes a workspace for good along with its views,
+// invitations, links, API keys, SSO provider and task key counters.
+func purgeWorkspace(tx Storage, id string) (int64, error) {
+	var count int64
+
+	for _, collection := range workspaceCollections {
+		res, err := tx.current().DeleteMany(collection, bson.M{"workspaceId": id})
+		if err != nil {
+			return 0, err
+		}
+		count += res.DeletedCount
+	}
+
+	counters := bson.M{"key": bson.M{"$regex": "^" + regexp.QuoteMeta("tasks:"+id+":")}}
+	res, err := tx.current().DeleteMany(CounterCollection, counters)
+	if err != nil {
+		return 0, err
+	}
+	count += res.DeletedCount
+
+	res, err = tx.Workspaces.Unscoped().DeleteMany(bson.M{"id": id})
+	if err != nil {
+		return 0, err
+	}
+
+	return count + res.DeletedCount, nil
+}
+
+// PurgeTrash deletes for good what went to the trash before the given time,
+// children first.
+func PurgeTrash(before time.Time) int64 {
+	var count int64
+
+	expired := bson.M{"deletedAt": bson.M{"$lt": before}}
+	unindexChildren(expired)
+
+	repositories := []manyDeleter{
+		storage.Tasks.Unscoped(),
+		storage.TaskLabels.Unscoped(),
+		storage.States.Unscoped(),
+		storage.Projects.Unscoped(),
+	}
+
+	for _, repository := range repositories {
+		res, err := repository.DeleteMany(expired)
+		if err != nil {
+			log.Println("Error purge trash", err.Error())
+			continue
+		}
+		count += res.DeletedCount
+	}
+
+	workspaces, err := storage.Workspaces.Unscoped().Find(expired, nil)
+	if err != nil {
+		log.Println("Error purge trash", err.Error())
+		return count
+	}
+
+	for _, workspace := range workspaces {
+		var deleted int64
+		err := storage.Transaction(func(tx Storage) error {
+			var err error
+			deleted, err = purgeWorkspace(tx, workspace.Id)
+			return err
+		})
+		if err != nil {
+			log.Println("Error purge workspace", err.Error())
+			continue
+		}
+		unindex(models.SearchWorkspace, workspace.Id)
+		count += deleted
+	}
+
+	return count
+}
+
+// TrashRetention is how long deleted data can be restored, TRASH_RETENTION
+// in the environment, 30 days by default.
+func TrashRetention() time.Duration {
+	return utils.EnvDuration("TRASH_RETENTION", 30*24*time.Hour)
+}
+
+// StartTrashPurge purges the expired trash now and then every interval,
+// until the process exits.
+func StartTrashPurge(interval time.Duration) {
+	go func() {
+		for {
+			count := PurgeTrash(time.Now().Add(-TrashRetention()))
+			if count > 0 {
+				log.Printf("Purged %d documents from the trash", count)
+			}
+
+			time.Sleep(interval)
+		}
+	}()
+}
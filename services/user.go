@@ -1,39 +1,28 @@
 package services
 
 import (
-	"context"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"kickof/database"
 	"kickof/models"
-	"net/http"
+	"os"
+	"strings"
 )
 
 const UserCollection = "users"
 
 func GetUsers(filters bson.M, opt *options.FindOptions) []models.User {
-	results := make([]models.User, 0)
+	results, err := storage.Users.Find(filters, opt)
+	logError(err)
 
-	cursor := database.Find(UserCollection, filters, opt)
-
-	if cursor == nil {
-		return results
-	}
-	for cursor.Next(context.Background()) {
-		var user models.User
-
-		if cursor.Decode(&user) == nil {
-			results = append(results, user)
-		}
-	}
 	return results
 }
 
 func GetUsersWithPagination(filters bson.M, opt *options.FindOptions, query models.Query) models.Result {
 	results := GetUsers(filters, opt)
 
-	count := database.Count(UserCollection, filters)
+	count, err := storage.Users.Count(filters)
+	logError(err)
 
 	pagination := query.GetPagination(count)
 
@@ -47,7 +36,7 @@ func GetUsersWithPagination(filters bson.M, opt *options.FindOptions, query mode
 }
 
 func CreateUser(user models.User) (bool, error) {
-	_, err := database.InsertOne(UserCollection, user)
+	err := storage.Users.InsertOne(user)
 	if err != nil {
 		return false, err
 	}
@@ -56,44 +45,29 @@ func CreateUser(user models.User) (bool, error) {
 }
 
 func GetUser(filter bson.M, opts *options.FindOneOptions) *models.User {
-
-	cursor := database.FindOne(UserCollection, filter, opts)
-
-	if cursor == nil {
-		return nil
-	}
-
-	var user models.User
-
-	err := cursor.Decode(&user)
+	user, err := storage.Users.FindOne(filter, opts)
 	if err != nil {
 		return nil
 	}
 
-	return &user
+	return user
 }
 
 func GetUserByEmail(email string) *models.User {
-	cursor := database.FindOne(UserCollection, bson.M{"email": email}, nil)
-	if cursor == nil {
-		return nil
-	}
-
-	var user models.User
-	err := cursor.Decode(&user)
+	user, err := storage.Users.FindOne(bson.M{"email": email}, nil)
 	if err != nil {
 		return nil
 	}
 
 	user.Password = ""
 
-	return &user
+	return user
 }
 
 func UpdateUser(id string, user models.User) (*mongo.UpdateResult, error) {
 	filters := bson.M{"id": id}
 
-	res, err := database.UpdateOne(UserCollection, filters, user)
+	res, err := storage.Users.UpdateOne(filters, user)
 
 	if res == nil {
 		return nil, err
@@ -105,7 +79,7 @@ func UpdateUser(id string, user models.User) (*mongo.UpdateResult, error) {
 func DeleteUser(id string) (*mongo.DeleteResult, error) {
 	filter := bson.M{"id": id}
 
-	res, err := database.DeleteOne(UserCollection, filter)
+	res, err := storage.Users.DeleteOne(filter)
 
 	if res == nil {
 		return nil, err
@@ -114,20 +88,18 @@ func DeleteUser(id string) (*mongo.DeleteResult, error) {
 	return res, nil
 }
 
-func GetCurrentUser(r *http.Request) *models.User {
-	token, e := CheckHeader(r)
-
-	if e != nil {
-		return nil
+// IsAdmin tells whether a user may use the instance administration endpoints,
+// either through the admin flag or by being listed in ADMIN_EMAILS.
+func IsAdmin(user models.User) bool {
+	if user.Admin {
+		return true
 	}
 
-	email, err := VerifyToken(token)
-
-	if err != nil {
-		return nil
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email != "" && strings.EqualFold(strings.TrimSpace(email), user.Email) {
+			return true
+		}
 	}
 
-	user := GetUser(bson.M{"email": email}, options.FindOne().SetProjection(bson.D{{"password", 0}}))
-
-	return user
+	return false
 }
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"kickof/database"
+	"kickof/models"
+	"os"
+	"time"
+)
+
+const VerificationTokenCollection = "verificationtokens"
+
+var VerificationTokenTTL = map[string]time.Duration{
+	models.PurposeActivate:      48 * time.Hour,
+	models.PurposeResetPassword: time.Hour,
+	models.PurposeEmailChange:   24 * time.Hour,
+	models.PurposeInvite:        7 * 24 * time.Hour,
+	models.PurposeUnlock:        24 * time.Hour,
+	models.PurposeMfa:           5 * time.Minute,
+	models.PurposeSso:           2 * time.Minute,
+}
+
+// GenerateVerificationToken signs a short lived token that is only valid for
+// the given purpose and records it so it can be consumed once.
+func GenerateVerificationToken(email string, purpose string, reference string) (*string, error) {
+	ttl, ok := VerificationTokenTTL[purpose]
+	if !ok {
+		return nil, errors.New("Unknown token purpose")
+	}
+
+	data := models.VerificationToken{
+		Id:        uuid.New().String(),
+		Email:     email,
+		Purpose:   purpose,
+		Reference: reference,
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedAt: time.Now(),
+	}
+
+	claims := models.VerificationClaims{
+		Email:     email,
+		Purpose:   purpose,
+		Reference: reference,
+		StandardClaims: jwt.StandardClaims{
+			Id:        data.Id,
+			Audience:  purpose,
+			ExpiresAt: data.ExpiresAt.Unix(),
+			IssuedAt:  data.CreatedAt.Unix(),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET_KEY")))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = database.InsertOne(VerificationTokenCollection, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenString, nil
+}
+
+func parseVerificationToken(tokenString string, purpose string) (*models.VerificationClaims, error) {
+	claims := &models.VerificationClaims{}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return []byte(os.Getenv("JWT_SECRET_KEY")), nil
+	})
+	if err != nil {
+		return nil, errors.New("Token invalid")
+	}
+
+	if claims.Purpose != purpose || !claims.VerifyAudience(purpose, true) || claims.Id == "" {
+		return nil, errors.New("Token invalid")
+	}
+
+	return claims, nil
+}
+
+// CheckVerificationToken validates a token without consuming it.
+func CheckVerificationToken(tokenString string, purpose string) (*models.VerificationToken, error) {
+	claims, err := parseVerificationToken(tokenString, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	var data models.VerificationToken
+	err = database.FindOne(VerificationTokenCollection, bson.M{"id": claims.Id, "purpose": purpose}, nil).Decode(&data)
+	if err != nil {
+		return nil, errors.New("Token invalid")
+	}
+
+	if data.UsedAt != nil {
+		return nil, errors.New("Token already used")
+	}
+
+	if data.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("Token expired")
+	}
+
+	return &data, nil
+}
+
+// ConsumeVerificationToken validates a token and marks it as used. The update
+// is conditional on usedAt being empty so a link cannot be replayed, even by
+// two concurrent requests.
+func ConsumeVerificationToken(tokenString string, purpose string) (*models.VerificationToken, error) {
+	data, err := CheckVerificationToken(tokenString, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	res, err := database.UpdateOne(VerificationTokenCollection, bson.M{"id": data.Id, "usedAt": nil}, bson.M{"usedAt": now})
+	if err != nil {
+		return nil, err
+	}
+
+	if res.MatchedCount == 0 {
+		return nil, errors.New("Token already used")
+	}
+
+	data.UsedAt = &now
+	return data, nil
+}
+
+// InvalidateVerificationTokens marks every unused token of a purpose and
+// reference as used, e.g. when an invitation is resent or revoked.
+func InvalidateVerificationTokens(purpose string, reference string) error {
+	filters := bson.M{"purpose": purpose, "reference": reference, "usedAt": nil}
+
+	_, err := database.UpdateMany(VerificationTokenCollection, filters, bson.M{"usedAt": time.Now()})
+	return err
+}
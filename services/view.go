@@ -0,0 +1,74 @@
+package services
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"kickof/database"
+	"kickof/models"
+)
+
+const ViewCollection = "views"
+
+// VisibleViews restricts filters to the views a user sees: the shared ones
+// and their own.
+func VisibleViews(filters bson.M, userId string) bson.M {
+	visible := bson.M{"$or": bson.A{bson.M{"shared": true}, bson.M{"userId": userId}}}
+
+	return bson.M{"$and": bson.A{filters, visible}}
+}
+
+func GetViewsWithPagination(filters bson.M, opt *options.FindOptions, query models.Query) (models.Result, error) {
+	results, result, err := paginate[models.View](storage.Views, filters, opt, query)
+	if err != nil {
+		return result, err
+	}
+
+	result.Data = results
+
+	return result, nil
+}
+
+func CreateView(View models.View) (bool, error) {
+	err := storage.Views.InsertOne(View)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func GetView(filter bson.M, opts *options.FindOneOptions) *models.View {
+	data, err := storage.Views.FindOne(filter, opts)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// UpdateView writes what changed in the view since current was read.
+func UpdateView(current models.View, View models.View) error {
+	return patchVersion[models.View](storage.Views, current.Id, current.Version, current, View)
+}
+
+// DeleteView deletes a view at the version it was read at. Views do not go
+// to the trash.
+func DeleteView(id string, version int64) error {
+	res, err := storage.Views.DeleteOne(database.VersionFilter(id, version))
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		count, err := storage.Views.Count(bson.M{"id": id})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrNotFound
+		}
+
+		return ErrVersionConflict
+	}
+
+	return nil
+}
@@ -1,99 +1,141 @@
 package services
 
 import (
-	"context"
 	"errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"kickof/database"
 	"kickof/models"
+	"slices"
+	"time"
 )
 
 const WorkspaceCollection = "workspaces"
 
-func GetWorkspaceMembers(userIds []string) []models.User {
-	result := GetUsers(bson.M{"id": bson.M{"$in": userIds}}, options.Find().SetProjection(bson.D{{"password", 0}}))
-	return result
-}
-
-func GetWorkspaces(filters bson.M, opt *options.FindOptions) []models.Workspace {
-	results := make([]models.Workspace, 0)
-
-	cursor := database.Find(WorkspaceCollection, filters, opt)
-	if cursor == nil {
-		return results
-	}
-	for cursor.Next(context.Background()) {
-		var data models.Workspace
-		err := cursor.Decode(&data)
-		if err == nil {
-			data.Members = GetWorkspaceMembers(data.UserIds)
-			results = append(results, data)
-		}
+// GetWorkspaces finds workspaces with the included relations, see
+// WorkspaceIncludes.
+func GetWorkspaces(filters bson.M, opt *options.FindOptions, include []string) []models.Workspace {
+	results, err := storage.Workspaces.Find(filters, opt)
+	logError(err)
 
-	}
+	LoadWorkspaceRelations(results, include)
 
 	return results
 }
 
-func GetWorkspacesWithPagination(filters bson.M, opt *options.FindOptions, query models.Query) models.Result {
-	results := GetWorkspaces(filters, opt)
-
-	count := database.Count(WorkspaceCollection, filters)
+func GetWorkspacesWithPagination(filters bson.M, opt *options.FindOptions, query models.Query) (models.Result, error) {
+	results, result, err := paginate[models.Workspace](storage.Workspaces, filters, opt, query)
+	if err != nil {
+		return result, err
+	}
 
-	pagination := query.GetPagination(count)
+	LoadWorkspaceRelations(results, query.GetInclude(WorkspaceIncludes))
 
-	result := models.Result{
-		Data:       results,
-		Pagination: pagination,
-		Query:      query,
-	}
+	result.Data = results
 
-	return result
+	return result, nil
 }
 
 func CreateWorkspace(Workspace models.Workspace) (bool, error) {
-	_, err := database.InsertOne(WorkspaceCollection, Workspace)
+	err := storage.Workspaces.InsertOne(Workspace)
 	if err != nil {
 		return false, err
 	}
 
+	indexWorkspace(Workspace)
+
 	return true, nil
 }
 
 func GetWorkspace(filter bson.M, opts *options.FindOneOptions) *models.Workspace {
-	var data models.Workspace
-	err := database.FindOne(WorkspaceCollection, filter, opts).Decode(&data)
+	data, err := storage.Workspaces.FindOne(filter, opts)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return nil
 		}
 		return nil
 	}
-	return &data
+	return data
+}
+
+// UpdateWorkspace writes what changed in the workspace since current was read.
+func UpdateWorkspace(current models.Workspace, Workspace models.Workspace) error {
+	err := patchVersion[models.Workspace](storage.Workspaces, current.Id, current.Version, current, Workspace)
+	if err != nil {
+		return err
+	}
+
+	indexWorkspace(Workspace)
+
+	return nil
 }
 
-func UpdateWorkspace(id string, Workspace interface{}) (*mongo.UpdateResult, error) {
-	filters := bson.M{"id": id}
+func GetUserWorkspaceIds(user models.User) []string {
+	results := make([]string, 0)
+
+	filters := bson.M{"userids": user.Id}
+	if !user.MfaEnabled {
+		filters["requireMfa"] = bson.M{"$ne": true}
+	}
 
-	res, err := database.UpdateOne(WorkspaceCollection, filters, Workspace)
+	workspaces, err := storage.Workspaces.Find(filters, options.Find().SetProjection(bson.M{"id": 1}))
+	logError(err)
 
-	if res == nil {
-		return nil, err
+	for _, data := range workspaces {
+		results = append(results, data.Id)
 	}
 
-	return res, nil
+	return results
+}
+
+// AddWorkspaceMember adds a user with the given role. Existing members keep
+// their current role.
+func AddWorkspaceMember(workspaceId string, userId string, role string) error {
+	return addWorkspaceMember(storage, workspaceId, userId, role)
 }
 
-func DeleteWorkspace(id string) (*mongo.DeleteResult, error) {
-	filter := bson.M{"id": id}
+// addWorkspaceMember adds a member through the given repositories, so it can
+// be part of a transaction.
+func addWorkspaceMember(s Storage, workspaceId string, userId string, role string) error {
+	workspace, err := s.Workspaces.FindOne(bson.M{"id": workspaceId}, nil)
+	if err != nil {
+		return errors.New("Workspace not found")
+	}
+
+	if workspace.GetRole(userId) != "" {
+		return nil
+	}
+
+	workspace.SyncRoles()
+	workspace.UserIds = append(workspace.UserIds, userId)
+	workspace.Roles[userId] = role
 
-	res, err := database.DeleteOne(WorkspaceCollection, filter)
+	_, err = s.Workspaces.UpdateOne(bson.M{"id": workspaceId}, bson.M{
+		"userids":   workspace.UserIds,
+		"roles":     workspace.Roles,
+		"updatedAt": time.Now(),
+	})
 
-	if res == nil {
-		return nil, err
+	return err
+}
+
+func RemoveWorkspaceMember(workspaceId string, userId string) error {
+	workspace := GetWorkspace(bson.M{"id": workspaceId}, nil)
+	if workspace == nil {
+		return errors.New("Workspace not found")
 	}
 
-	return res, nil
+	workspace.SyncRoles()
+	workspace.UserIds = slices.DeleteFunc(workspace.UserIds, func(id string) bool {
+		return id == userId
+	})
+	workspace.SyncRoles()
+
+	_, err := storage.Workspaces.UpdateOne(bson.M{"id": workspaceId}, bson.M{
+		"userids":   workspace.UserIds,
+		"roles":     workspace.Roles,
+		"updatedAt": time.Now(),
+	})
+
+	return err
 }
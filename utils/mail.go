@@ -12,6 +12,11 @@ import (
 func SendEmail(to string, subject string, data interface{}, templateFile string) error {
 	from := "no-reply@kickof.com"
 
+	if os.Getenv("MAIL_HOST") == "" {
+		log.Println("MAIL_HOST is not set, not sending", subject, "to", to)
+		return nil
+	}
+
 	result, _ := ParseTemplate(templateFile, data)
 
 	m := gomail.NewMessage()
@@ -24,7 +29,7 @@ func SendEmail(to string, subject string, data interface{}, templateFile string)
 	d := gomail.NewDialer(os.Getenv("MAIL_HOST"), int(port), os.Getenv("MAIL_EMAIL"), os.Getenv("MAIL_APP_PASSWORD"))
 	err := d.DialAndSend(m)
 	if err != nil {
-		panic(err)
+		log.Println(err)
 	}
 	return err
 }
@@ -0,0 +1,355 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidPatch = errors.New("Invalid patch")
+
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err.Error())
+	}
+
+	return value, nil
+}
+
+// MergePatch applies an RFC 7396 merge patch to a JSON document: members of
+// the patch replace those of the document, recursively for objects, and
+// null removes them.
+func MergePatch(document []byte, patch []byte) ([]byte, error) {
+	target, err := decodeJSON(document)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := decodeJSON(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergePatch(target, changes))
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	changes, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	object, ok := target.(map[string]interface{})
+	if !ok {
+		object = map[string]interface{}{}
+	}
+
+	for key, value := range changes {
+		if value == nil {
+			delete(object, key)
+		} else {
+			object[key] = mergePatch(object[key], value)
+		}
+	}
+
+	return object
+}
+
+type jsonPatchOperation struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  string           `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// JSONPatch applies an RFC 6902 JSON patch to a JSON document. The patch is
+// all or nothing: the first operation that fails, a failed test included,
+// fails it.
+func JSONPatch(document []byte, patch []byte) ([]byte, error) {
+	target, err := decodeJSON(document)
+	if err != nil {
+		return nil, err
+	}
+
+	var operations []jsonPatchOperation
+	err = json.Unmarshal(patch, &operations)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err.Error())
+	}
+
+	for i, operation := range operations {
+		target, err = applyOperation(target, operation)
+		if err != nil {
+			return nil, fmt.Errorf("%w: operation %d: %s", ErrInvalidPatch, i, err.Error())
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func applyOperation(target interface{}, operation jsonPatchOperation) (interface{}, error) {
+	path, err := parsePointer(operation.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if operation.Value != nil {
+		value, err = decodeJSON(*operation.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch operation.Op {
+	case "add":
+		if operation.Value == nil {
+			return nil, errors.New("add needs a value")
+		}
+		return pointerAdd(target, path, value)
+	case "remove":
+		target, _, err = pointerRemove(target, path)
+		return target, err
+	case "replace":
+		if operation.Value == nil {
+			return nil, errors.New("replace needs a value")
+		}
+		target, _, err = pointerRemove(target, path)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(target, path, value)
+	case "move", "copy":
+		from, err := parsePointer(operation.From)
+		if err != nil {
+			return nil, err
+		}
+
+		moved, err := pointerGet(target, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if operation.Op == "move" {
+			if len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+				return nil, errors.New("cannot move a value into itself")
+			}
+			target, _, err = pointerRemove(target, from)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			moved = deepCopy(moved)
+		}
+
+		return pointerAdd(target, path, moved)
+	case "test":
+		current, err := pointerGet(target, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(current, value) {
+			return nil, errors.New("test failed at " + operation.Path)
+		}
+		return target, nil
+	}
+
+	return nil, errors.New("unknown operation " + operation.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into its unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("invalid pointer " + pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, appending bool) (int, error) {
+	if appending && token == "-" {
+		return length, nil
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > length || index == length && !appending || token != strconv.Itoa(index) {
+		return 0, errors.New("invalid array index " + token)
+	}
+
+	return index, nil
+}
+
+func pointerGet(target interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := target.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, errors.New("missing member " + token)
+			}
+			target = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			target = node[index]
+		default:
+			return nil, errors.New("cannot descend into " + token)
+		}
+	}
+
+	return target, nil
+}
+
+func pointerAdd(target interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := pointerGet(target, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+	case []interface{}:
+		index, err := arrayIndex(last, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+
+		node = append(node, nil)
+		copy(node[index+1:], node[index:])
+		node[index] = value
+
+		return replaceAt(target, path[:len(path)-1], node)
+	default:
+		return nil, errors.New("cannot add to " + last)
+	}
+
+	return target, nil
+}
+
+func pointerRemove(target interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, target, nil
+	}
+
+	parent, err := pointerGet(target, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		value, ok := node[last]
+		if !ok {
+			return nil, nil, errors.New("missing member " + last)
+		}
+		delete(node, last)
+
+		return target, value, nil
+	case []interface{}:
+		index, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		value := node[index]
+		node = append(node[:index:index], node[index+1:]...)
+		target, err = replaceAt(target, path[:len(path)-1], node)
+
+		return target, value, err
+	}
+
+	return nil, nil, errors.New("cannot remove " + last)
+}
+
+// replaceAt swaps the value at path, arrays being values that change when
+// they grow or shrink.
+func replaceAt(target interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := pointerGet(target, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+	case []interface{}:
+		index, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[index] = value
+	}
+
+	return target, nil
+}
+
+func deepCopy(value interface{}) interface{} {
+	data, _ := json.Marshal(value)
+	copied, _ := decodeJSON(data)
+
+	return copied
+}
+
+// jsonEqual compares JSON values, numbers by value rather than spelling.
+func jsonEqual(a interface{}, b interface{}) bool {
+	left, _ := json.Marshal(normalizeNumbers(a))
+	right, _ := json.Marshal(normalizeNumbers(b))
+
+	return bytes.Equal(left, right)
+}
+
+func normalizeNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		number, err := v.Float64()
+		if err != nil {
+			return v.String()
+		}
+		return number
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = normalizeNumbers(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = normalizeNumbers(item)
+		}
+		return result
+	}
+
+	return value
+}
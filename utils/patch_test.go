@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// sameJSON compares two JSON texts regardless of member order.
+func sameJSON(t *testing.T, got []byte, expected string) bool {
+	t.Helper()
+
+	var a, b interface{}
+	if err := json.Unmarshal(got, &a); err != nil {
+		t.Fatalf("invalid result %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(expected), &b); err != nil {
+		t.Fatalf("invalid expectation %s: %v", expected, err)
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// TestJSONPatch runs the examples of RFC 6902 appendix A. An empty result
+// means the patch fails.
+func TestJSONPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		patch    string
+		result   string
+	}{
+		{"A.1 adding an object member",
+			`{"foo": "bar"}`,
+			`[{"op": "add", "path": "/baz", "value": "qux"}]`,
+			`{"baz": "qux", "foo": "bar"}`},
+		{"A.2 adding an array element",
+			`{"foo": ["bar", "baz"]}`,
+			`[{"op": "add", "path": "/foo/1", "value": "qux"}]`,
+			`{"foo": ["bar", "qux", "baz"]}`},
+		{"A.3 removing an object member",
+			`{"baz": "qux", "foo": "bar"}`,
+			`[{"op": "remove", "path": "/baz"}]`,
+			`{"foo": "bar"}`},
+		{"A.4 removing an array element",
+			`{"foo": ["bar", "qux", "baz"]}`,
+			`[{"op": "remove", "path": "/foo/1"}]`,
+			`{"foo": ["bar", "baz"]}`},
+		{"A.5 replacing a value",
+			`{"baz": "qux", "foo": "bar"}`,
+			`[{"op": "replace", "path": "/baz", "value": "boo"}]`,
+			`{"baz": "boo", "foo": "bar"}`},
+		{"A.6 moving a value",
+			`{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			`[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			`{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`},
+		{"A.7 moving an array element",
+			`{"foo": ["all", "grass", "cows", "eat"]}`,
+			`[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`,
+			`{"foo": ["all", "cows", "eat", "grass"]}`},
+		{"A.8 testing a value: success",
+			`{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			`[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`,
+			`{"baz": "qux", "foo": ["a", 2, "c"]}`},
+		{"A.9 testing a value: error",
+			`{"baz": "qux"}`,
+			`[{"op": "test", "path": "/baz", "value": "bar"}]`,
+			``},
+		{"A.10 adding a nested member object",
+			`{"foo": "bar"}`,
+			`[{"op": "add", "path": "/child", "value": {"grandchild": {}}}]`,
+			`{"foo": "bar", "child": {"grandchild": {}}}`},
+		{"A.11 ignoring unrecognized elements",
+			`{"foo": "bar"}`,
+			`[{"op": "add", "path": "/baz", "value": "qux", "xyz": 123}]`,
+			`{"foo": "bar", "baz": "qux"}`},
+		{"A.12 adding to a nonexistent target",
+			`{"foo": "bar"}`,
+			`[{"op": "add", "path": "/baz/bat", "value": "qux"}]`,
+			``},
+		{"A.13 invalid JSON patch document",
+			`{"foo": "bar"}`,
+			`[{"op": "add", "path": "/baz", "value": "qux", "op": "remove"}]`,
+			``},
+		{"A.14 ~ escape ordering",
+			`{"/": 9, "~1": 10}`,
+			`[{"op": "test", "path": "/~01", "value": 10}]`,
+			`{"/": 9, "~1": 10}`},
+		{"A.15 comparing strings and numbers",
+			`{"/": 9, "~1": 10}`,
+			`[{"op": "test", "path": "/~01", "value": "10"}]`,
+			``},
+		{"A.16 adding an array value",
+			`{"foo": ["bar"]}`,
+			`[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`,
+			`{"foo": ["bar", ["abc", "def"]]}`},
+	}
+
+	for _, test := range tests {
+		result, err := JSONPatch([]byte(test.document), []byte(test.patch))
+		if test.result == "" {
+			if !errors.Is(err, ErrInvalidPatch) {
+				t.Errorf("%s: expected an invalid patch, got %s, %v", test.name, result, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !sameJSON(t, result, test.result) {
+			t.Errorf("%s: got %s, expected %s", test.name, result, test.result)
+		}
+	}
+}
+
+// TestMergePatch runs the examples of RFC 7396 appendix A.
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		document string
+		patch    string
+		result   string
+	}{
+		{`{"a": "b"}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "b"}`, `{"b": "c"}`, `{"a": "b", "b": "c"}`},
+		{`{"a": "b"}`, `{"a": null}`, `{}`},
+		{`{"a": "b", "b": "c"}`, `{"a": null}`, `{"b": "c"}`},
+		{`{"a": ["b"]}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "c"}`, `{"a": ["b"]}`, `{"a": ["b"]}`},
+		{`{"a": {"b": "c"}}`, `{"a": {"b": "d", "c": null}}`, `{"a": {"b": "d"}}`},
+		{`{"a": [{"b": "c"}]}`, `{"a": [1]}`, `{"a": [1]}`},
+		{`["a", "b"]`, `["c", "d"]`, `["c", "d"]`},
+		{`{"a": "b"}`, `["c"]`, `["c"]`},
+		{`{"a": "foo"}`, `null`, `null`},
+		{`{"a": "foo"}`, `"bar"`, `"bar"`},
+		{`{"e": null}`, `{"a": 1}`, `{"e": null, "a": 1}`},
+		{`[1, 2]`, `{"a": "b", "c": null}`, `{"a": "b"}`},
+		{`{}`, `{"a": {"bb": {"ccc": null}}}`, `{"a": {"bb": {}}}`},
+	}
+
+	for _, test := range tests {
+		result, err := MergePatch([]byte(test.document), []byte(test.patch))
+		if err != nil {
+			t.Errorf("%s merged with %s: %v", test.document, test.patch, err)
+			continue
+		}
+		if !sameJSON(t, result, test.result) {
+			t.Errorf("%s merged with %s: got %s, expected %s", test.document, test.patch, result, test.result)
+		}
+	}
+
+	if _, err := MergePatch([]byte(`{"a": "b"}`), []byte(`{"a":`)); !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("expected an invalid patch, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP as described in RFC 6238 with the parameters every authenticator app
+// supports: HMAC-SHA1, 6 digits and a 30 seconds period.
+const (
+	TotpPeriod = 30
+	TotpDigits = 6
+	totpSkew   = 1 // Steps accepted before and after the current one
+)
+
+func GenerateTotpSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+func TotpStep(t time.Time) int64 {
+	return t.Unix() / TotpPeriod
+}
+
+func TotpCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", value%1000000), nil
+}
+
+// ValidateTotp returns the step the code matched so callers can refuse to
+// accept the same code twice.
+func ValidateTotp(secret string, code string, t time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != TotpDigits {
+		return 0, false
+	}
+
+	current := TotpStep(t)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := TotpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+func TotpUri(issuer string, account string, secret string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(TotpDigits))
+	values.Set("period", fmt.Sprint(TotpPeriod))
+
+	label := url.PathEscape(issuer + ":" + account)
+
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}